@@ -26,6 +26,7 @@ import (
 	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/statestore"
 	"github.com/elastic/beats/v7/libbeat/statestore/backend"
+	"github.com/elastic/beats/v7/libbeat/statestore/backend/boltstore"
 	"github.com/elastic/beats/v7/libbeat/statestore/backend/es"
 	"github.com/elastic/beats/v7/libbeat/statestore/backend/memlog"
 	"github.com/elastic/elastic-agent-libs/logp"
@@ -48,9 +49,6 @@ type filebeatStore struct {
 
 func openStateStore(ctx context.Context, info beat.Info, logger *logp.Logger, cfg config.Registry) (*filebeatStore, error) {
 	var (
-		reg backend.Registry
-		err error
-
 		esreg    *es.Registry
 		notifier *es.Notifier
 	)
@@ -60,10 +58,7 @@ func openStateStore(ctx context.Context, info beat.Info, logger *logp.Logger, cf
 		esreg = es.New(ctx, logger, notifier)
 	}
 
-	reg, err = memlog.New(logger, memlog.Settings{
-		Root:     paths.Resolve(paths.Data, cfg.Path),
-		FileMode: cfg.Permissions,
-	})
+	reg, err := OpenRegistryBackend(logger, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -82,6 +77,22 @@ func openStateStore(ctx context.Context, info beat.Info, logger *logp.Logger, cf
 	return store, nil
 }
 
+// OpenRegistryBackend opens the backend storing the registry, as set in
+// registry.backend.
+func OpenRegistryBackend(logger *logp.Logger, cfg config.Registry) (backend.Registry, error) {
+	root := paths.Resolve(paths.Data, cfg.Path)
+	if cfg.Backend == config.RegistryBackendBbolt {
+		return boltstore.New(logger, boltstore.Settings{
+			Root:     root,
+			FileMode: cfg.Permissions,
+		})
+	}
+	return memlog.New(logger, memlog.Settings{
+		Root:     root,
+		FileMode: cfg.Permissions,
+	})
+}
+
 func (s *filebeatStore) Close() {
 	s.registry.Close()
 }
@@ -0,0 +1,553 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/elastic/beats/v7/filebeat/beater"
+	"github.com/elastic/beats/v7/filebeat/config"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/cmd/instance"
+	"github.com/elastic/beats/v7/libbeat/cmd/instance/locks"
+	"github.com/elastic/beats/v7/libbeat/common/cli"
+	"github.com/elastic/beats/v7/libbeat/statestore"
+	"github.com/elastic/beats/v7/libbeat/statestore/backend"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// registryEntry is a registry key-value pair, as printed by the dump
+// command and read by the import command.
+type registryEntry struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// registryFilter selects registry entries by key, input ID or path.
+type registryFilter struct {
+	keys    []string
+	inputID string
+	path    string
+}
+
+func genRegistryCmd(settings instance.Settings) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "registry",
+		Short: "Inspect and edit the registry",
+		Long: "Inspect and edit the file states in the registry. Filebeat must be stopped while\n" +
+			"using these commands.",
+	}
+
+	command.AddCommand(genRegistryListCmd(settings))
+	command.AddCommand(genRegistryDumpCmd(settings))
+	command.AddCommand(genRegistryImportCmd(settings))
+	command.AddCommand(genRegistryDeleteCmd(settings))
+	command.AddCommand(genRegistryResetCmd(settings))
+	command.AddCommand(genRegistryCompactCmd(settings))
+
+	return command
+}
+
+func genRegistryListCmd(settings instance.Settings) *cobra.Command {
+	var filter registryFilter
+	command := &cobra.Command{
+		Use:   "list",
+		Short: "List the registry entries with their input ID, path and offset",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			return withRegistryStore(settings, func(store *statestore.Store, _ backend.Store) error {
+				entries, err := readRegistryEntries(store, filter)
+				if err != nil {
+					return err
+				}
+				for _, entry := range entries {
+					offset := "-"
+					if o, ok := entry.offset(); ok {
+						offset = fmt.Sprint(o)
+					}
+					fmt.Printf("%s\t%s\t%s\t%s\n", entry.Key, entry.inputID(), entry.source(), offset) //nolint:forbidigo // command output
+				}
+				return nil
+			})
+		}),
+	}
+	filter.addFlags(command)
+	return command
+}
+
+func genRegistryDumpCmd(settings instance.Settings) *cobra.Command {
+	var filter registryFilter
+	var output string
+	command := &cobra.Command{
+		Use:   "dump",
+		Short: "Dump the registry entries as JSON, one entry per line",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			return withRegistryStore(settings, func(store *statestore.Store, _ backend.Store) error {
+				var out io.Writer = os.Stdout
+				if output != "" {
+					file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+					if err != nil {
+						return err
+					}
+					defer file.Close()
+					out = file
+				}
+				return dumpRegistry(store, filter, out)
+			})
+		}),
+	}
+	filter.addFlags(command)
+	command.Flags().StringVarP(&output, "output", "o", "", "File to write the entries to, instead of stdout")
+	return command
+}
+
+func genRegistryImportCmd(settings instance.Settings) *cobra.Command {
+	var overwrite bool
+	command := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import registry entries from a file written by the dump command",
+		Args:  cobra.ExactArgs(1),
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			entries, err := readRegistryDump(args[0])
+			if err != nil {
+				return err
+			}
+			return withRegistryStore(settings, func(store *statestore.Store, _ backend.Store) error {
+				imported, skipped, err := importRegistry(store, entries, overwrite)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%d entries imported, %d existing entries skipped\n", imported, skipped) //nolint:forbidigo // command output
+				return nil
+			})
+		}),
+	}
+	command.Flags().BoolVar(&overwrite, "overwrite", false, "Overwrite entries that already exist in the registry")
+	return command
+}
+
+func genRegistryDeleteCmd(settings instance.Settings) *cobra.Command {
+	var filter registryFilter
+	var all bool
+	command := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete the selected registry entries, so the files are read again from the beginning",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			if filter.empty() && !all {
+				return errors.New("select entries with --key, --input-id or --path, or use --all")
+			}
+			return withRegistryStore(settings, func(store *statestore.Store, _ backend.Store) error {
+				deleted, err := deleteRegistryEntries(store, filter)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%d entries deleted\n", deleted) //nolint:forbidigo // command output
+				return nil
+			})
+		}),
+	}
+	filter.addFlags(command)
+	command.Flags().BoolVar(&all, "all", false, "Delete all entries")
+	return command
+}
+
+func genRegistryResetCmd(settings instance.Settings) *cobra.Command {
+	var filter registryFilter
+	var all bool
+	var offset int64
+	command := &cobra.Command{
+		Use:   "reset",
+		Short: "Set the offset of the selected registry entries",
+		Long: "Set the offset of the selected registry entries, 0 by default, so the files are\n" +
+			"read again from that offset. The other fields of the entries are kept.",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			if filter.empty() && !all {
+				return errors.New("select entries with --key, --input-id or --path, or use --all")
+			}
+			if offset < 0 {
+				return errors.New("offset can't be negative")
+			}
+			return withRegistryStore(settings, func(store *statestore.Store, _ backend.Store) error {
+				reset, err := resetRegistryEntries(store, filter, offset)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%d entries updated\n", reset) //nolint:forbidigo // command output
+				return nil
+			})
+		}),
+	}
+	filter.addFlags(command)
+	command.Flags().BoolVar(&all, "all", false, "Reset all entries")
+	command.Flags().Int64Var(&offset, "offset", 0, "The new offset")
+	return command
+}
+
+func genRegistryCompactCmd(settings instance.Settings) *cobra.Command {
+	return &cobra.Command{
+		Use:   "compact",
+		Short: "Rewrite the registry files to release the space used by old states",
+		Long: "Rewrite the registry files to release the space used by old states. For the memlog\n" +
+			"backend this writes a new checkpoint and truncates the log, for the bbolt backend\n" +
+			"this rewrites the database.",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			return withRegistryStore(settings, func(_ *statestore.Store, store backend.Store) error {
+				return compactRegistry(store)
+			})
+		}),
+	}
+}
+
+// withRegistryStore opens the registry of the Beat, and calls fn with its
+// store. It fails if the registry is in use by a running Beat.
+func withRegistryStore(settings instance.Settings, fn func(*statestore.Store, backend.Store) error) error {
+	b, err := instance.NewInitializedBeat(settings)
+	if err != nil {
+		return fmt.Errorf("error initializing beat: %w", err)
+	}
+
+	cfg := struct {
+		Registry config.Registry `config:"registry"`
+	}{Registry: config.DefaultConfig.Registry}
+	beatConfig, err := b.BeatConfig()
+	if err != nil {
+		return err
+	}
+	if err := beatConfig.Unpack(&cfg); err != nil {
+		return fmt.Errorf("error reading registry configuration: %w", err)
+	}
+	return withRegistry(b.Info, cfg.Registry, fn)
+}
+
+// withRegistry opens the registry with the given configuration, and calls fn
+// with its store. It fails if the registry is in use by a running Beat.
+func withRegistry(info beat.Info, cfg config.Registry, fn func(*statestore.Store, backend.Store) error) error {
+	// A running Beat holds the lock, and keeps its own copy of the states.
+	lock := locks.New(info)
+	if err := lock.Lock(); err != nil {
+		return fmt.Errorf("the registry is in use, stop %s first: %w", info.Beat, err)
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	logger := logp.NewLogger("registry")
+	reg, err := beater.OpenRegistryBackend(logger, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open the registry: %w", err)
+	}
+	defer reg.Close()
+
+	backendStore, err := reg.Access(info.Beat)
+	if err != nil {
+		return fmt.Errorf("failed to open the registry: %w", err)
+	}
+	// The frontend store closes the backend store once it's released.
+	registry := statestore.NewRegistry(&accessedRegistry{Registry: reg, store: backendStore})
+	store, err := registry.Get(info.Beat)
+	if err != nil {
+		backendStore.Close()
+		return err
+	}
+	defer store.Close()
+
+	return fn(store, backendStore)
+}
+
+// accessedRegistry returns a store that was already accessed, so the same
+// store can be used through the statestore frontend and directly.
+type accessedRegistry struct {
+	backend.Registry
+	store backend.Store
+}
+
+func (r *accessedRegistry) Access(_ string) (backend.Store, error) {
+	return r.store, nil
+}
+
+// dumpRegistry writes the entries selected by the filter to out, as JSON, one
+// entry per line.
+func dumpRegistry(store *statestore.Store, filter registryFilter, out io.Writer) error {
+	entries, err := readRegistryEntries(store, filter)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importRegistry writes the entries to the store. Existing entries are
+// skipped, unless overwrite is set.
+func importRegistry(store *statestore.Store, entries []registryEntry, overwrite bool) (imported, skipped int, err error) {
+	for _, entry := range entries {
+		if !overwrite {
+			exists, err := store.Has(entry.Key)
+			if err != nil {
+				return imported, skipped, err
+			}
+			if exists {
+				skipped++
+				continue
+			}
+		}
+		if err := store.Set(entry.Key, entry.Value); err != nil {
+			return imported, skipped, fmt.Errorf("failed to import key '%v': %w", entry.Key, err)
+		}
+		imported++
+	}
+	return imported, skipped, nil
+}
+
+// deleteRegistryEntries removes the entries selected by the filter.
+func deleteRegistryEntries(store *statestore.Store, filter registryFilter) (int, error) {
+	entries, err := readRegistryEntries(store, filter)
+	if err != nil {
+		return 0, err
+	}
+	for i, entry := range entries {
+		if err := store.Remove(entry.Key); err != nil {
+			return i, fmt.Errorf("failed to delete key '%v': %w", entry.Key, err)
+		}
+	}
+	return len(entries), nil
+}
+
+// resetRegistryEntries sets the offset of the entries selected by the filter.
+// Entries without an offset are left untouched.
+func resetRegistryEntries(store *statestore.Store, filter registryFilter, offset int64) (int, error) {
+	entries, err := readRegistryEntries(store, filter)
+	if err != nil {
+		return 0, err
+	}
+	reset := 0
+	for _, entry := range entries {
+		if !entry.setOffset(offset) {
+			continue
+		}
+		if err := store.Set(entry.Key, entry.Value); err != nil {
+			return reset, fmt.Errorf("failed to update key '%v': %w", entry.Key, err)
+		}
+		reset++
+	}
+	return reset, nil
+}
+
+// compactRegistry releases the space used by old states, if the backend
+// supports it.
+func compactRegistry(store backend.Store) error {
+	switch s := store.(type) {
+	case interface{ Checkpoint() error }:
+		return s.Checkpoint()
+	case interface{ Compact() error }:
+		return s.Compact()
+	default:
+		return errors.New("the registry backend does not support compaction")
+	}
+}
+
+// readRegistryEntries returns the entries selected by the filter. Entries
+// are read before they are modified, as stores can't be updated while
+// iterating them.
+func readRegistryEntries(store *statestore.Store, filter registryFilter) ([]registryEntry, error) {
+	var entries []registryEntry
+	err := store.Each(func(key string, dec statestore.ValueDecoder) (bool, error) {
+		entry := registryEntry{Key: key}
+		if err := dec.Decode(&entry.Value); err != nil {
+			return false, fmt.Errorf("failed to decode key '%v': %w", key, err)
+		}
+		match, err := filter.match(entry)
+		if err != nil {
+			return false, err
+		}
+		if match {
+			entries = append(entries, entry)
+		}
+		return true, nil
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, err
+}
+
+// readRegistryDump reads the entries written by the dump command.
+func readRegistryDump(path string) ([]registryEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []registryEntry
+	dec := json.NewDecoder(bufio.NewReader(file))
+	// Inode, device and offset values may not be representable as float64.
+	dec.UseNumber()
+	for {
+		var entry registryEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid registry entry %d in %v: %w", len(entries)+1, path, err)
+		}
+		if entry.Key == "" || entry.Value == nil {
+			return nil, fmt.Errorf("invalid registry entry %d in %v: key and value are required", len(entries)+1, path)
+		}
+		value, err := decodeNumbers(entry.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid registry entry %d in %v: %w", len(entries)+1, path, err)
+		}
+		entry.Value, _ = value.(map[string]interface{})
+		entries = append(entries, entry)
+	}
+}
+
+// decodeNumbers replaces the json.Number values in v by integers if they
+// are, so identifiers and offsets keep their exact value, and by floats
+// otherwise.
+func decodeNumbers(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return u, nil
+		}
+		return v.Float64()
+	case map[string]interface{}:
+		for key, value := range v {
+			decoded, err := decodeNumbers(value)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = decoded
+		}
+	case []interface{}:
+		for i, value := range v {
+			decoded, err := decodeNumbers(value)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = decoded
+		}
+	}
+	return v, nil
+}
+
+func (f *registryFilter) addFlags(command *cobra.Command) {
+	command.Flags().StringArrayVar(&f.keys, "key", nil, "Select the entry with this key, can be repeated")
+	command.Flags().StringVar(&f.inputID, "input-id", "", "Select the entries of the filestream input with this ID")
+	command.Flags().StringVar(&f.path, "path", "", "Select the entries of the files matching this glob pattern")
+}
+
+func (f registryFilter) empty() bool {
+	return len(f.keys) == 0 && f.inputID == "" && f.path == ""
+}
+
+func (f registryFilter) match(entry registryEntry) (bool, error) {
+	if len(f.keys) > 0 {
+		found := false
+		for _, key := range f.keys {
+			found = found || key == entry.Key
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	if f.inputID != "" && entry.inputID() != f.inputID {
+		return false, nil
+	}
+	if f.path != "" {
+		match, err := filepath.Match(f.path, entry.source())
+		if err != nil {
+			return false, fmt.Errorf("invalid path pattern: %w", err)
+		}
+		return match, nil
+	}
+	return true, nil
+}
+
+// inputID returns the ID of the filestream input owning the entry. Filestream
+// keys have the format filestream::<input ID>::<file identity>.
+func (e registryEntry) inputID() string {
+	parts := strings.SplitN(e.Key, "::", 3)
+	if len(parts) < 3 || parts[0] != "filestream" {
+		return ""
+	}
+	return parts[1]
+}
+
+// source returns the path of the file of the entry. Filestream keeps it in
+// the meta field, the log input at the top level.
+func (e registryEntry) source() string {
+	if meta, ok := e.Value["meta"].(map[string]interface{}); ok {
+		if source, ok := meta["source"].(string); ok {
+			return source
+		}
+	}
+	source, _ := e.Value["source"].(string)
+	return source
+}
+
+// offset returns the offset of the entry. Filestream keeps it in the cursor
+// field, the log input at the top level.
+func (e registryEntry) offset() (int64, bool) {
+	value := e.Value["offset"]
+	if cursor, ok := e.Value["cursor"].(map[string]interface{}); ok {
+		value = cursor["offset"]
+	}
+	switch v := value.(type) {
+	case float64:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), true //nolint:gosec // offsets fit in int64
+	case int:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// setOffset updates the offset of the entry, it returns false if the entry
+// has no offset.
+func (e registryEntry) setOffset(offset int64) bool {
+	if _, ok := e.offset(); !ok {
+		return false
+	}
+	if cursor, ok := e.Value["cursor"].(map[string]interface{}); ok {
+		cursor["offset"] = offset
+	} else {
+		e.Value["offset"] = offset
+	}
+	return true
+}
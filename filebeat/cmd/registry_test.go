@@ -0,0 +1,293 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/filebeat/config"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/cmd/instance/locks"
+	"github.com/elastic/beats/v7/libbeat/statestore"
+	"github.com/elastic/beats/v7/libbeat/statestore/backend"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/paths"
+)
+
+func TestRegistryFilter(t *testing.T) {
+	filestream := registryEntry{
+		Key: "filestream::my-input::native::123-45",
+		Value: map[string]interface{}{
+			"cursor": map[string]interface{}{"offset": float64(1000)},
+			"meta":   map[string]interface{}{"source": "/var/log/app.log"},
+		},
+	}
+	logInput := registryEntry{
+		Key: "filebeat::logs::native::9-9",
+		Value: map[string]interface{}{
+			"source": "/var/log/old.log",
+			"offset": float64(77),
+		},
+	}
+
+	tests := map[string]struct {
+		filter registryFilter
+		match  []bool
+	}{
+		"no filter":      {filter: registryFilter{}, match: []bool{true, true}},
+		"key":            {filter: registryFilter{keys: []string{logInput.Key}}, match: []bool{false, true}},
+		"input id":       {filter: registryFilter{inputID: "my-input"}, match: []bool{true, false}},
+		"path":           {filter: registryFilter{path: "/var/log/*.log"}, match: []bool{true, true}},
+		"path no match":  {filter: registryFilter{path: "/var/log/app*"}, match: []bool{true, false}},
+		"input and path": {filter: registryFilter{inputID: "my-input", path: "/tmp/*"}, match: []bool{false, false}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			for i, entry := range []registryEntry{filestream, logInput} {
+				match, err := tc.filter.match(entry)
+				require.NoError(t, err)
+				assert.Equal(t, tc.match[i], match, entry.Key)
+			}
+		})
+	}
+
+	_, err := registryFilter{path: "["}.match(filestream)
+	assert.ErrorContains(t, err, "invalid path pattern")
+}
+
+func TestRegistryEntryOffset(t *testing.T) {
+	filestream := registryEntry{
+		Key: "filestream::my-input::native::123-45",
+		Value: map[string]interface{}{
+			"cursor": map[string]interface{}{"offset": float64(1000)},
+		},
+	}
+	logInput := registryEntry{
+		Key:   "filebeat::logs::native::9-9",
+		Value: map[string]interface{}{"offset": float64(77)},
+	}
+	noOffset := registryEntry{Key: "other", Value: map[string]interface{}{}}
+
+	for _, entry := range []registryEntry{filestream, logInput} {
+		require.True(t, entry.setOffset(10))
+		offset, ok := entry.offset()
+		assert.True(t, ok)
+		assert.Equal(t, int64(10), offset)
+	}
+	assert.Equal(t, int64(10), filestream.Value["cursor"].(map[string]interface{})["offset"])
+	assert.False(t, noOffset.setOffset(10))
+	assert.Empty(t, noOffset.Value)
+}
+
+func testRegistryEntries() []registryEntry {
+	return []registryEntry{
+		{
+			Key: "filestream::my-input::native::9007199254740993-45",
+			Value: map[string]interface{}{
+				"cursor": map[string]interface{}{"offset": int64(1) << 60},
+				"meta": map[string]interface{}{
+					"source":          "/var/log/app.log",
+					"identifier_name": "native",
+				},
+				"inode": uint64(123456789),
+			},
+		},
+		{
+			Key: "filestream::other-input::native::7-8",
+			Value: map[string]interface{}{
+				"cursor": map[string]interface{}{"offset": int64(10)},
+				"meta":   map[string]interface{}{"source": "/var/log/other.log"},
+			},
+		},
+	}
+}
+
+// withTestRegistry opens the registry in the data path of the test with the
+// given backend.
+func withTestRegistry(t *testing.T, backendName string, fn func(*statestore.Store, backend.Store) error) error {
+	t.Helper()
+	cfg := config.DefaultConfig.Registry
+	cfg.Backend = backendName
+	return withRegistry(testBeatInfo(t), cfg, fn)
+}
+
+func testBeatInfo(t *testing.T) beat.Info {
+	return beat.Info{Beat: "filebeat", Logger: logptest.NewTestingLogger(t, "")}
+}
+
+func setTestDataPath(t *testing.T) {
+	t.Helper()
+	origDataPath := paths.Paths.Data
+	paths.Paths.Data = t.TempDir()
+	t.Cleanup(func() { paths.Paths.Data = origDataPath })
+}
+
+func readAllEntries(t *testing.T, backendName string) map[string]registryEntry {
+	t.Helper()
+	entries := map[string]registryEntry{}
+	require.NoError(t, withTestRegistry(t, backendName, func(store *statestore.Store, _ backend.Store) error {
+		all, err := readRegistryEntries(store, registryFilter{})
+		for _, entry := range all {
+			entries[entry.Key] = entry
+		}
+		return err
+	}))
+	return entries
+}
+
+var testBackends = []string{config.RegistryBackendMemlog, config.RegistryBackendBbolt}
+
+func TestRegistryDumpImportRoundTrip(t *testing.T) {
+	for _, backendName := range testBackends {
+		t.Run(backendName, func(t *testing.T) {
+			setTestDataPath(t)
+			require.NoError(t, withTestRegistry(t, backendName, func(store *statestore.Store, _ backend.Store) error {
+				_, _, err := importRegistry(store, testRegistryEntries(), false)
+				return err
+			}))
+
+			dump := filepath.Join(t.TempDir(), "dump.ndjson")
+			file, err := os.Create(dump)
+			require.NoError(t, err)
+			require.NoError(t, withTestRegistry(t, backendName, func(store *statestore.Store, _ backend.Store) error {
+				return dumpRegistry(store, registryFilter{}, file)
+			}))
+			require.NoError(t, file.Close())
+
+			// Import into an empty registry.
+			setTestDataPath(t)
+			entries, err := readRegistryDump(dump)
+			require.NoError(t, err)
+			require.Len(t, entries, 2)
+			require.NoError(t, withTestRegistry(t, backendName, func(store *statestore.Store, _ backend.Store) error {
+				imported, skipped, err := importRegistry(store, entries, false)
+				assert.Equal(t, 2, imported)
+				assert.Zero(t, skipped)
+				return err
+			}))
+
+			imported := readAllEntries(t, backendName)
+			require.Len(t, imported, 2)
+			entry := imported["filestream::my-input::native::9007199254740993-45"]
+			assert.EqualValues(t, 123456789, entry.Value["inode"])
+			offset, ok := entry.offset()
+			require.True(t, ok)
+			assert.Equal(t, int64(1)<<60, offset)
+			assert.Equal(t, "/var/log/app.log", entry.source())
+
+			// Existing entries are only replaced with overwrite.
+			require.NoError(t, withTestRegistry(t, backendName, func(store *statestore.Store, _ backend.Store) error {
+				imported, skipped, err := importRegistry(store, entries, false)
+				assert.Zero(t, imported)
+				assert.Equal(t, 2, skipped)
+				return err
+			}))
+		})
+	}
+}
+
+func TestRegistryResetAndDeletePersist(t *testing.T) {
+	for _, backendName := range testBackends {
+		t.Run(backendName, func(t *testing.T) {
+			setTestDataPath(t)
+			require.NoError(t, withTestRegistry(t, backendName, func(store *statestore.Store, _ backend.Store) error {
+				_, _, err := importRegistry(store, testRegistryEntries(), false)
+				return err
+			}))
+
+			require.NoError(t, withTestRegistry(t, backendName, func(store *statestore.Store, _ backend.Store) error {
+				reset, err := resetRegistryEntries(store, registryFilter{inputID: "my-input"}, 5)
+				assert.Equal(t, 1, reset)
+				return err
+			}))
+			entries := readAllEntries(t, backendName)
+			offset, _ := entries["filestream::my-input::native::9007199254740993-45"].offset()
+			assert.Equal(t, int64(5), offset)
+			offset, _ = entries["filestream::other-input::native::7-8"].offset()
+			assert.Equal(t, int64(10), offset)
+
+			require.NoError(t, withTestRegistry(t, backendName, func(store *statestore.Store, _ backend.Store) error {
+				deleted, err := deleteRegistryEntries(store, registryFilter{path: "/var/log/other.log"})
+				assert.Equal(t, 1, deleted)
+				return err
+			}))
+			entries = readAllEntries(t, backendName)
+			assert.Len(t, entries, 1)
+			assert.NotContains(t, entries, "filestream::other-input::native::7-8")
+		})
+	}
+}
+
+func TestRegistryCompact(t *testing.T) {
+	for _, backendName := range testBackends {
+		t.Run(backendName, func(t *testing.T) {
+			setTestDataPath(t)
+			require.NoError(t, withTestRegistry(t, backendName, func(store *statestore.Store, _ backend.Store) error {
+				_, _, err := importRegistry(store, testRegistryEntries(), false)
+				if err != nil {
+					return err
+				}
+				_, err = deleteRegistryEntries(store, registryFilter{inputID: "other-input"})
+				return err
+			}))
+
+			require.NoError(t, withTestRegistry(t, backendName, func(_ *statestore.Store, store backend.Store) error {
+				return compactRegistry(store)
+			}))
+			entries := readAllEntries(t, backendName)
+			assert.Len(t, entries, 1)
+			assert.Contains(t, entries, "filestream::my-input::native::9007199254740993-45")
+		})
+	}
+}
+
+func TestRegistryRefusesLockedRegistry(t *testing.T) {
+	setTestDataPath(t)
+	lock := locks.NewWithRetry(testBeatInfo(t), 1, 0)
+	require.NoError(t, lock.Lock())
+	defer func() { _ = lock.Unlock() }()
+
+	called := false
+	err := withTestRegistry(t, config.RegistryBackendMemlog, func(*statestore.Store, backend.Store) error {
+		called = true
+		return nil
+	})
+	assert.ErrorContains(t, err, "the registry is in use")
+	assert.False(t, called)
+}
+
+func TestReadRegistryDumpKeepsPrecision(t *testing.T) {
+	// The values are above 2^53, so they can't be represented exactly as float64.
+	dump := filepath.Join(t.TempDir(), "dump.ndjson")
+	require.NoError(t, os.WriteFile(dump, []byte(
+		`{"key":"a","value":{"inode":18446744073709551615,"cursor":{"offset":9007199254740993},"ratio":0.5}}`+"\n"), 0o600))
+
+	entries, err := readRegistryDump(dump)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, uint64(18446744073709551615), entries[0].Value["inode"])
+	assert.Equal(t, 0.5, entries[0].Value["ratio"])
+	offset, ok := entries[0].offset()
+	require.True(t, ok)
+	assert.Equal(t, int64(9007199254740993), offset)
+}
@@ -56,6 +56,23 @@ type Registry struct {
 	FlushTimeout  time.Duration `config:"flush"`
 	CleanInterval time.Duration `config:"cleanup_interval"`
 	MigrateFile   string        `config:"migrate_file"`
+	Backend       string        `config:"backend"`
+}
+
+// Registry backends that can be set in registry.backend.
+const (
+	RegistryBackendMemlog = "memlog"
+	RegistryBackendBbolt  = "bbolt"
+)
+
+func (r *Registry) Validate() error {
+	switch r.Backend {
+	case "", RegistryBackendMemlog, RegistryBackendBbolt:
+		return nil
+	default:
+		return fmt.Errorf("unknown registry backend '%v', use '%v' or '%v'",
+			r.Backend, RegistryBackendMemlog, RegistryBackendBbolt)
+	}
 }
 
 var DefaultConfig = Config{
@@ -65,6 +82,7 @@ var DefaultConfig = Config{
 		MigrateFile:   "",
 		CleanInterval: 5 * time.Minute,
 		FlushTimeout:  time.Second,
+		Backend:       RegistryBackendMemlog,
 	},
 	ShutdownTimeout:    0,
 	OverwritePipelines: false,
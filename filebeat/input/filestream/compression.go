@@ -0,0 +1,252 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package filestream
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/elastic/beats/v7/libbeat/reader"
+)
+
+const (
+	compressionGzip  = "gzip"
+	compressionZstd  = "zstd"
+	compressionBzip2 = "bzip2"
+
+	// magicSize is the number of bytes needed to detect all the supported
+	// compression formats.
+	magicSize = 4
+)
+
+var errCompressedFileCompleted = errors.New("compressed file has been read completely")
+
+var compressionMagics = []struct {
+	format string
+	magic  []byte
+}{
+	{format: compressionGzip, magic: []byte{0x1f, 0x8b}},
+	{format: compressionZstd, magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{format: compressionBzip2, magic: []byte("BZh")},
+}
+
+// compressionConfig configures the detection of compressed files.
+type compressionConfig struct {
+	// Enabled turns on the detection of gzip, zstd and bzip2 files by their
+	// magic bytes.
+	Enabled bool `config:"enabled"`
+	// Extensions limits the detection to files with one of these extensions.
+	// If it is empty, the header of every file is checked.
+	Extensions []string `config:"extensions"`
+}
+
+func (c *compressionConfig) Validate() error {
+	for _, ext := range c.Extensions {
+		if !strings.HasPrefix(ext, ".") || len(ext) == 1 {
+			return fmt.Errorf("invalid compression extension %q, it must start with a dot", ext)
+		}
+	}
+	return nil
+}
+
+// detect returns the compression format of a file, or an empty string if
+// the file is not compressed or compression detection is disabled.
+func (c compressionConfig) detect(path string, f io.ReaderAt) (string, error) {
+	if !c.Enabled || !c.matchExtension(path) {
+		return "", nil
+	}
+
+	header := make([]byte, magicSize)
+	n, err := f.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read header of %s: %w", path, err)
+	}
+	return detectCompression(header[:n]), nil
+}
+
+func (c compressionConfig) matchExtension(path string) bool {
+	if len(c.Extensions) == 0 {
+		return true
+	}
+	ext := filepath.Ext(path)
+	for _, e := range c.Extensions {
+		if strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectCompression returns the compression format whose magic bytes the
+// header starts with.
+func detectCompression(header []byte) string {
+	for _, m := range compressionMagics {
+		if !bytes.HasPrefix(header, m.magic) {
+			continue
+		}
+		// The bzip2 magic is followed by the block size, from 1 to 9.
+		if m.format == compressionBzip2 && (len(header) < 4 || header[3] < '1' || header[3] > '9') {
+			continue
+		}
+		return m.format
+	}
+	return ""
+}
+
+func newDecompressor(format string, r io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case compressionGzip:
+		return gzip.NewReader(r)
+	case compressionZstd:
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case compressionBzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	default:
+		return nil, fmt.Errorf("unknown compression format %q", format)
+	}
+}
+
+// decompressedFile reads the content of a compressed file. The offsets of
+// compressed files count decompressed bytes, so resuming a file means
+// decompressing it again and discarding everything before the offset.
+type decompressedFile struct {
+	file   *os.File
+	format string
+
+	// mu guards dec, as the reader can be closed while it is being read.
+	mu     sync.Mutex
+	dec    io.ReadCloser
+	closed bool
+
+	// offset is the number of decompressed bytes read so far.
+	offset int64
+	// complete is set once the end of the compressed stream has been
+	// reached. A file that ends in the middle of the stream is still
+	// being written.
+	complete bool
+}
+
+func newDecompressedFile(f *os.File, format string) (*decompressedFile, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	dec, err := newDecompressor(format, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise %s decompression of %s: %w", format, f.Name(), err)
+	}
+	return &decompressedFile{file: f, format: format, dec: dec}, nil
+}
+
+// Read reads decompressed data. It returns io.EOF at the end of the file,
+// whether or not the compressed stream is complete.
+func (d *decompressedFile) Read(buf []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return 0, ErrClosed
+	}
+
+	n, err := d.dec.Read(buf)
+	d.offset += int64(n)
+	switch {
+	case errors.Is(err, io.EOF):
+		d.complete = true
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		err = io.EOF
+	}
+	return n, err
+}
+
+// skip discards the first offset bytes of the decompressed content. It
+// returns false if the file holds less than offset bytes.
+func (d *decompressedFile) skip(offset int64) (bool, error) {
+	n, err := io.CopyN(io.Discard, d, offset)
+	if errors.Is(err, io.EOF) {
+		return n == offset, nil
+	}
+	return err == nil, err
+}
+
+// Close releases the decompressor, the file itself is closed by its reader.
+func (d *decompressedFile) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	return d.dec.Close()
+}
+
+// lookaheadReader reads one message ahead of the one it returns, so the
+// last message of a compressed file is known when it is published.
+type lookaheadReader struct {
+	reader       reader.Reader
+	decompressed *decompressedFile
+
+	next    reader.Message
+	nextErr error
+	started bool
+}
+
+func newLookaheadReader(r reader.Reader, d *decompressedFile) *lookaheadReader {
+	return &lookaheadReader{reader: r, decompressed: d}
+}
+
+func (r *lookaheadReader) Next() (reader.Message, error) {
+	if !r.started {
+		r.started = true
+		r.next, r.nextErr = r.reader.Next()
+	}
+	msg, err := r.next, r.nextErr
+	if err != nil {
+		return msg, err
+	}
+	r.next, r.nextErr = r.reader.Next()
+	return msg, nil
+}
+
+// completed returns true if the last returned message is the last one of a
+// compressed stream that has been read to its end.
+func (r *lookaheadReader) completed() bool {
+	return errors.Is(r.nextErr, io.EOF) && r.decompressed.isComplete()
+}
+
+func (r *lookaheadReader) Close() error {
+	return r.reader.Close()
+}
+
+func (d *decompressedFile) isComplete() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.complete
+}
@@ -0,0 +1,306 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package filestream
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/common/file"
+	"github.com/elastic/beats/v7/libbeat/reader/readfile/encoding"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func compress(t *testing.T, format string, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	switch format {
+	case compressionGzip:
+		w := gzip.NewWriter(&buf)
+		_, err := w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	case compressionZstd:
+		w, err := zstd.NewWriter(&buf)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	case compressionBzip2:
+		// The standard library has no bzip2 writer, the file was created with
+		// `printf 'first line\nsecond line\n' | bzip2 -9`.
+		require.Equal(t, "first line\nsecond line\n", string(data), "only the test lines are available in bzip2")
+		return bzip2TestLines
+	default:
+		t.Fatalf("unknown format %q", format)
+	}
+	return buf.Bytes()
+}
+
+var bzip2TestLines = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x8b, 0x13,
+	0xe1, 0x84, 0x00, 0x00, 0x04, 0xd1, 0x80, 0x00, 0x10, 0x40, 0x00, 0x0f,
+	0x25, 0x9c, 0x00, 0x20, 0x00, 0x21, 0xa1, 0x32, 0x31, 0x94, 0x20, 0x1a,
+	0x00, 0x91, 0x2a, 0x31, 0x95, 0x68, 0xcb, 0x04, 0x82, 0xfd, 0x57, 0xf1,
+	0x77, 0x24, 0x53, 0x85, 0x09, 0x08, 0xb1, 0x3e, 0x18, 0x40,
+}
+
+func writeTempFile(t *testing.T, name string, data []byte) *os.File {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestDetectCompression(t *testing.T) {
+	lines := []byte("first line\nsecond line\n")
+
+	testCases := map[string]struct {
+		header   []byte
+		expected string
+	}{
+		"gzip":           {header: compress(t, compressionGzip, lines), expected: compressionGzip},
+		"zstd":           {header: compress(t, compressionZstd, lines), expected: compressionZstd},
+		"bzip2":          {header: compress(t, compressionBzip2, lines), expected: compressionBzip2},
+		"plain":          {header: lines},
+		"empty":          {header: []byte{}},
+		"bzip2 no level": {header: []byte("BZh0")},
+		"short gzip":     {header: []byte{0x1f}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, detectCompression(tc.header))
+		})
+	}
+}
+
+func TestCompressionConfigDetect(t *testing.T) {
+	data := compress(t, compressionGzip, []byte("line\n"))
+	f := writeTempFile(t, "app.log.gz", data)
+
+	testCases := map[string]struct {
+		config   compressionConfig
+		expected string
+	}{
+		"disabled":            {config: compressionConfig{}},
+		"enabled":             {config: compressionConfig{Enabled: true}, expected: compressionGzip},
+		"matching extension":  {config: compressionConfig{Enabled: true, Extensions: []string{".zst", ".GZ"}}, expected: compressionGzip},
+		"different extension": {config: compressionConfig{Enabled: true, Extensions: []string{".zst"}}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			format, err := tc.config.detect(f.Name(), f)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, format)
+		})
+	}
+}
+
+func TestCompressionConfigValidate(t *testing.T) {
+	testCases := map[string]struct {
+		extensions []string
+		valid      bool
+	}{
+		"extension":    {extensions: []string{".gz", ".zst"}, valid: true},
+		"missing dot":  {extensions: []string{"gz"}},
+		"only the dot": {extensions: []string{"."}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c := conf.MustNewConfigFrom(map[string]any{
+				"paths":                  []string{"/var/log/*.gz"},
+				"compression.enabled":    true,
+				"compression.extensions": tc.extensions,
+			})
+			cfg := defaultConfig()
+			err := c.Unpack(&cfg)
+			if tc.valid {
+				require.NoError(t, err)
+				assert.Equal(t, tc.extensions, cfg.Compression.Extensions)
+			} else {
+				require.ErrorContains(t, err, "invalid compression extension")
+			}
+		})
+	}
+}
+
+func TestDecompressedFile(t *testing.T) {
+	lines := []byte("first line\nsecond line\n")
+
+	for _, format := range []string{compressionGzip, compressionZstd, compressionBzip2} {
+		t.Run(format, func(t *testing.T) {
+			f := writeTempFile(t, "app.log", compress(t, format, lines))
+
+			t.Run("read", func(t *testing.T) {
+				d, err := newDecompressedFile(f, format)
+				require.NoError(t, err)
+				defer d.Close()
+
+				content, err := io.ReadAll(d)
+				require.NoError(t, err)
+				assert.Equal(t, lines, content)
+				assert.True(t, d.isComplete())
+				assert.EqualValues(t, len(lines), d.offset)
+			})
+
+			t.Run("skip", func(t *testing.T) {
+				d, err := newDecompressedFile(f, format)
+				require.NoError(t, err)
+				defer d.Close()
+
+				found, err := d.skip(int64(len("first line\n")))
+				require.NoError(t, err)
+				require.True(t, found)
+
+				content, err := io.ReadAll(d)
+				require.NoError(t, err)
+				assert.Equal(t, "second line\n", string(content))
+			})
+
+			t.Run("skip past the end", func(t *testing.T) {
+				d, err := newDecompressedFile(f, format)
+				require.NoError(t, err)
+				defer d.Close()
+
+				found, err := d.skip(int64(len(lines) + 1))
+				require.NoError(t, err)
+				assert.False(t, found)
+			})
+		})
+	}
+}
+
+func TestDecompressedFileIncomplete(t *testing.T) {
+	lines := []byte(strings.Repeat("a line that is being compressed\n", 100))
+	data := compress(t, compressionGzip, lines)
+	// Drop the footer, as if the file was still being written.
+	f := writeTempFile(t, "app.log.gz", data[:len(data)-8])
+
+	d, err := newDecompressedFile(f, compressionGzip)
+	require.NoError(t, err)
+	defer d.Close()
+
+	_, err = io.ReadAll(d)
+	require.NoError(t, err, "an incomplete stream must end with io.EOF")
+	assert.False(t, d.isComplete())
+}
+
+func TestFingerprintOfCompressedFile(t *testing.T) {
+	dir := t.TempDir()
+	lines := []byte(strings.Repeat("a line to fingerprint\n", 100))
+	plainPath := filepath.Join(dir, "app.log")
+	require.NoError(t, os.WriteFile(plainPath, lines, 0o600))
+	compressedPath := filepath.Join(dir, "app.log.1.gz")
+	require.NoError(t, os.WriteFile(compressedPath, compress(t, compressionGzip, lines), 0o600))
+
+	cfg := defaultFileScannerConfig()
+	s, err := newFileScanner([]string{plainPath}, cfg)
+	require.NoError(t, err)
+	s.compression = compressionConfig{Enabled: true}
+
+	plain, err := s.toFileDescriptor(&ingestTarget{filename: plainPath, originalFilename: plainPath, info: statFile(t, plainPath)})
+	require.NoError(t, err)
+	compressed, err := s.toFileDescriptor(&ingestTarget{filename: compressedPath, originalFilename: compressedPath, info: statFile(t, compressedPath)})
+	require.NoError(t, err)
+
+	assert.Empty(t, plain.Compression)
+	assert.Equal(t, compressionGzip, compressed.Compression)
+	assert.Equal(t, plain.Fingerprint, compressed.Fingerprint,
+		"a compressed file must have the fingerprint of its content")
+
+	short := filepath.Join(dir, "short.log.gz")
+	require.NoError(t, os.WriteFile(short, compress(t, compressionGzip, []byte("short\n")), 0o600))
+	_, err = s.toFileDescriptor(&ingestTarget{filename: short, originalFilename: short, info: statFile(t, short)})
+	require.ErrorIs(t, err, errFileTooSmall)
+}
+
+func statFile(t *testing.T, path string) file.ExtendedFileInfo {
+	t.Helper()
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	return file.ExtendFileInfo(fi)
+}
+
+func TestOpenCompressedFile(t *testing.T) {
+	lines := []byte("first line\nsecond line\n")
+	compressed := writeTempFile(t, "app.log.gz", compress(t, compressionGzip, lines))
+	plain := writeTempFile(t, "app.log", lines)
+
+	encodingFactory, ok := encoding.FindEncoding("")
+	require.True(t, ok)
+	inp := &filestream{
+		encodingFactory: encodingFactory,
+		compression:     compressionConfig{Enabled: true},
+	}
+	log := logptest.NewTestingLogger(t, "")
+
+	t.Run("resume at offset", func(t *testing.T) {
+		f, d, _, truncated, err := inp.openFile(log, compressed.Name(), state{Offset: int64(len("first line\n"))})
+		require.NoError(t, err)
+		defer f.Close()
+		defer d.Close()
+
+		assert.False(t, truncated)
+		content, err := io.ReadAll(d)
+		require.NoError(t, err)
+		assert.Equal(t, "second line\n", string(content))
+	})
+
+	t.Run("offset past the end", func(t *testing.T) {
+		f, d, _, truncated, err := inp.openFile(log, compressed.Name(), state{Offset: 100})
+		require.NoError(t, err)
+		defer f.Close()
+		defer d.Close()
+
+		assert.True(t, truncated)
+		content, err := io.ReadAll(d)
+		require.NoError(t, err)
+		assert.Equal(t, lines, content)
+	})
+
+	t.Run("completed", func(t *testing.T) {
+		_, _, _, _, err := inp.openFile(log, compressed.Name(), state{Offset: int64(len(lines)), Completed: true})
+		require.ErrorIs(t, err, errCompressedFileCompleted)
+	})
+
+	t.Run("plain file", func(t *testing.T) {
+		f, d, _, _, err := inp.openFile(log, plain.Name(), state{Completed: true})
+		require.NoError(t, err)
+		defer f.Close()
+		assert.Nil(t, d)
+	})
+}
@@ -36,11 +36,12 @@ import (
 type config struct {
 	Reader readerConfig `config:",inline"`
 
-	ID           string          `config:"id"`
-	Paths        []string        `config:"paths"`
-	Close        closerConfig    `config:"close"`
-	FileWatcher  *conf.Namespace `config:"prospector"`
-	FileIdentity *conf.Namespace `config:"file_identity"`
+	ID           string            `config:"id"`
+	Paths        []string          `config:"paths"`
+	Close        closerConfig      `config:"close"`
+	Compression  compressionConfig `config:"compression"`
+	FileWatcher  *conf.Namespace   `config:"prospector"`
+	FileIdentity *conf.Namespace   `config:"file_identity"`
 
 	// -1 means that registry will never be cleaned
 	CleanInactive  time.Duration      `config:"clean_inactive" validate:"min=-1"`
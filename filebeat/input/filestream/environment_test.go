@@ -64,7 +64,8 @@ type inputTestingEnvironment struct {
 
 type registryEntry struct {
 	Cursor struct {
-		Offset int `json:"offset"`
+		Offset    int  `json:"offset"`
+		Completed bool `json:"completed"`
 	} `json:"cursor"`
 	Meta any `json:"meta,omitempty"`
 }
@@ -41,9 +41,12 @@ var (
 
 // logFile contains all log related data
 type logFile struct {
-	file      *os.File
-	log       *logp.Logger
-	readerCtx ctxtool.CancelContext
+	file   *os.File
+	reader io.Reader
+	// decompressed is set when reading a compressed file
+	decompressed *decompressedFile
+	log          *logp.Logger
+	readerCtx    ctxtool.CancelContext
 
 	closeAfterInterval time.Duration
 	closeOnEOF         bool
@@ -77,6 +80,7 @@ func newFileReader(
 
 	l := &logFile{
 		file:               f,
+		reader:             f,
 		log:                log,
 		closeAfterInterval: closerConfig.Reader.AfterInterval,
 		closeOnEOF:         closerConfig.Reader.OnEOF,
@@ -96,13 +100,23 @@ func newFileReader(
 	return l, nil
 }
 
+// readDecompressed makes the reader return the decompressed content of a
+// compressed file. Compressed files are not appended to, so the reader is
+// closed when EOF is reached.
+func (f *logFile) readDecompressed(d *decompressedFile) {
+	f.reader = d
+	f.decompressed = d
+	f.offset = d.offset
+	f.closeOnEOF = true
+}
+
 // Read reads from the reader and updates the offset
 // The total number of bytes read is returned.
 func (f *logFile) Read(buf []byte) (int, error) {
 	totalN := 0
 
 	for f.readerCtx.Err() == nil {
-		n, err := f.file.Read(buf)
+		n, err := f.reader.Read(buf)
 		if n > 0 {
 			f.offset += int64(n)
 			f.lastTimeRead = time.Now()
@@ -232,6 +246,9 @@ func isSameFile(path string, info os.FileInfo) bool {
 // errorChecks determines the cause for EOF errors, and how the EOF event should be handled
 // based on the config options.
 func (f *logFile) errorChecks(err error) error {
+	if errors.Is(err, ErrClosed) {
+		return err
+	}
 	if !errors.Is(err, io.EOF) {
 		f.log.Error("Unexpected state reading from %s; error: %s", f.file.Name(), err)
 		return err
@@ -266,6 +283,9 @@ func (f *logFile) handleEOF() error {
 // Close
 func (f *logFile) Close() error {
 	f.readerCtx.Cancel()
+	if f.decompressed != nil {
+		_ = f.decompressed.Close()
+	}
 	err := f.file.Close()
 	_ = f.tg.Stop() // Wait until all resources are released for sure.
 	return err
@@ -70,7 +70,7 @@ type fileWatcher struct {
 	events  chan loginp.FSEvent
 }
 
-func newFileWatcher(paths []string, ns *conf.Namespace) (loginp.FSWatcher, error) {
+func newFileWatcher(paths []string, ns *conf.Namespace, compression compressionConfig) (loginp.FSWatcher, error) {
 	var config *conf.C
 	if ns == nil {
 		config = conf.NewConfig()
@@ -78,10 +78,10 @@ func newFileWatcher(paths []string, ns *conf.Namespace) (loginp.FSWatcher, error
 		config = ns.Config()
 	}
 
-	return newScannerWatcher(paths, config)
+	return newScannerWatcher(paths, config, compression)
 }
 
-func newScannerWatcher(paths []string, c *conf.C) (loginp.FSWatcher, error) {
+func newScannerWatcher(paths []string, c *conf.C, compression compressionConfig) (loginp.FSWatcher, error) {
 	config := defaultFileWatcherConfig()
 	err := c.Unpack(&config)
 	if err != nil {
@@ -91,6 +91,7 @@ func newScannerWatcher(paths []string, c *conf.C) (loginp.FSWatcher, error) {
 	if err != nil {
 		return nil, err
 	}
+	scanner.compression = compression
 	return &fileWatcher{
 		log:     logp.NewLogger(watcherDebugKey),
 		cfg:     config,
@@ -293,6 +294,9 @@ type fileScanner struct {
 	log        *logp.Logger
 	hasher     hash.Hash
 	readBuffer []byte
+	// compression detects compressed files, whose fingerprint is computed
+	// from their decompressed content.
+	compression compressionConfig
 }
 
 func newFileScanner(paths []string, config fileScannerConfig) (*fileScanner, error) {
@@ -477,7 +481,43 @@ func (s *fileScanner) toFileDescriptor(it *ingestTarget) (fd loginp.FileDescript
 	fd.Filename = it.filename
 	fd.Info = it.info
 
-	if s.cfg.Fingerprint.Enabled {
+	if !s.cfg.Fingerprint.Enabled && !s.compression.Enabled {
+		return fd, nil
+	}
+
+	file, err := os.Open(it.originalFilename)
+	if err != nil {
+		return fd, fmt.Errorf("failed to open %q for fingerprinting: %w", it.originalFilename, err)
+	}
+	defer file.Close()
+
+	fd.Compression, err = s.compression.detect(it.originalFilename, file)
+	if err != nil {
+		return fd, err
+	}
+
+	if !s.cfg.Fingerprint.Enabled {
+		return fd, nil
+	}
+
+	var r io.Reader = file
+	if fd.Compression != "" {
+		// The fingerprint of a compressed file is computed from its content,
+		// so it matches the fingerprint of the file before it was compressed.
+		dec, err := newDecompressor(fd.Compression, file)
+		if err != nil {
+			return fd, fmt.Errorf("failed to decompress %q for fingerprinting: %w", fd.Filename, err)
+		}
+		defer dec.Close()
+		r = dec
+
+		if s.cfg.Fingerprint.Offset != 0 {
+			_, err = io.CopyN(io.Discard, r, s.cfg.Fingerprint.Offset)
+			if err != nil {
+				return fd, fmt.Errorf("decompressed content of %q is too short for fingerprinting: %w", fd.Filename, errFileTooSmall)
+			}
+		}
+	} else {
 		fileSize := it.info.Size()
 		// we should not open the file if we know it's too small
 		minSize := s.cfg.Fingerprint.Offset + s.cfg.Fingerprint.Length
@@ -485,31 +525,29 @@ func (s *fileScanner) toFileDescriptor(it *ingestTarget) (fd loginp.FileDescript
 			return fd, fmt.Errorf("filesize of %q is %d bytes, expected at least %d bytes for fingerprinting: %w", fd.Filename, fileSize, minSize, errFileTooSmall)
 		}
 
-		file, err := os.Open(it.originalFilename)
-		if err != nil {
-			return fd, fmt.Errorf("failed to open %q for fingerprinting: %w", it.originalFilename, err)
-		}
-		defer file.Close()
-
 		if s.cfg.Fingerprint.Offset != 0 {
 			_, err = file.Seek(s.cfg.Fingerprint.Offset, io.SeekStart)
 			if err != nil {
 				return fd, fmt.Errorf("failed to seek %q for fingerprinting: %w", fd.Filename, err)
 			}
 		}
+	}
 
-		s.hasher.Reset()
-		lr := io.LimitReader(file, s.cfg.Fingerprint.Length)
-		written, err := io.CopyBuffer(s.hasher, lr, s.readBuffer)
-		if err != nil {
-			return fd, fmt.Errorf("failed to compute hash for first %d bytes of %q: %w", s.cfg.Fingerprint.Length, fd.Filename, err)
-		}
-		if written != s.cfg.Fingerprint.Length {
-			return fd, fmt.Errorf("failed to read %d bytes from %q to compute fingerprint, read only %d", written, fd.Filename, s.cfg.Fingerprint.Length)
-		}
-
-		fd.Fingerprint = hex.EncodeToString(s.hasher.Sum(nil))
+	s.hasher.Reset()
+	lr := io.LimitReader(r, s.cfg.Fingerprint.Length)
+	written, err := io.CopyBuffer(s.hasher, lr, s.readBuffer)
+	if fd.Compression != "" && (errors.Is(err, io.ErrUnexpectedEOF) || (err == nil && written < s.cfg.Fingerprint.Length)) {
+		// The compressed file is still being written or too short.
+		return fd, fmt.Errorf("decompressed content of %q is %d bytes, expected at least %d bytes for fingerprinting: %w", fd.Filename, written, s.cfg.Fingerprint.Length, errFileTooSmall)
 	}
+	if err != nil {
+		return fd, fmt.Errorf("failed to compute hash for first %d bytes of %q: %w", s.cfg.Fingerprint.Length, fd.Filename, err)
+	}
+	if written != s.cfg.Fingerprint.Length {
+		return fd, fmt.Errorf("failed to read %d bytes from %q to compute fingerprint, read only %d", written, fd.Filename, s.cfg.Fingerprint.Length)
+	}
+
+	fd.Fingerprint = hex.EncodeToString(s.hasher.Sum(nil))
 
 	return fd, nil
 }
@@ -50,6 +50,12 @@ const pluginName = "filestream"
 
 type state struct {
 	Offset int64 `json:"offset" struct:"offset"`
+	// Completed is set once a compressed file has been read to its end.
+	// Compressed files are not expected to change, so they are not read again.
+	Completed bool `json:"completed,omitempty" struct:"completed,omitempty"`
+	// CSVHeader is the header row read by the csv parser. It is needed when
+	// the file is read again from an offset past the header row.
+	CSVHeader []string `json:"csv_header,omitempty" struct:"csv_header,omitempty"`
 }
 
 type fileMeta struct {
@@ -63,6 +69,7 @@ type filestream struct {
 	readerConfig    readerConfig
 	encodingFactory encoding.EncodingFactory
 	closerConfig    closerConfig
+	compression     compressionConfig
 	parsers         parser.Config
 	takeOver        takeOverConfig
 }
@@ -105,6 +112,7 @@ func configure(cfg *conf.C) (loginp.Prospector, loginp.Harvester, error) {
 		readerConfig:    config.Reader,
 		encodingFactory: encodingFactory,
 		closerConfig:    config.Close,
+		compression:     config.Compression,
 		parsers:         config.Reader.Parsers,
 		takeOver:        config.TakeOver,
 	}
@@ -120,7 +128,7 @@ func (inp *filestream) Test(src loginp.Source, ctx input.TestContext) error {
 		return fmt.Errorf("not file source")
 	}
 
-	reader, _, err := inp.open(ctx.Logger, ctx.Cancelation, fs, 0)
+	reader, _, err := inp.open(ctx.Logger, ctx.Cancelation, fs, state{}, &parser.State{})
 	if err != nil {
 		return err
 	}
@@ -142,7 +150,12 @@ func (inp *filestream) Run(
 	log := ctx.Logger.With("path", fs.newPath).With("state-id", src.Name())
 	state := initState(log, cursor, fs)
 
-	r, truncated, err := inp.open(log, ctx.Cancelation, fs, state.Offset)
+	parserState := &parser.State{}
+	r, truncated, err := inp.open(log, ctx.Cancelation, fs, state, parserState)
+	if errors.Is(err, errCompressedFileCompleted) {
+		log.Debugf("Compressed file %s has already been read completely", fs.newPath)
+		return nil
+	}
 	if err != nil {
 		log.Errorf("File could not be opened for reading: %v", err)
 		return err
@@ -150,6 +163,7 @@ func (inp *filestream) Run(
 
 	if truncated {
 		state.Offset = 0
+		state.CSVHeader = nil
 	}
 
 	metrics.FilesActive.Inc()
@@ -168,7 +182,7 @@ func (inp *filestream) Run(
 
 	// The caller of Run already reports the error and filters out errors that
 	// must not be reported, like 'context cancelled'.
-	return inp.readFromSource(ctx, log, r, fs.newPath, state, publisher, metrics)
+	return inp.readFromSource(ctx, log, r, fs.newPath, state, parserState, publisher, metrics)
 }
 
 func initState(log *logp.Logger, c loginp.Cursor, s fileSource) state {
@@ -189,20 +203,25 @@ func (inp *filestream) open(
 	log *logp.Logger,
 	canceler input.Canceler,
 	fs fileSource,
-	offset int64,
+	s state,
+	parserState *parser.State,
 ) (reader.Reader, bool, error) {
 
-	f, encoding, truncated, err := inp.openFile(log, fs.newPath, offset)
+	f, decompressed, encoding, truncated, err := inp.openFile(log, fs.newPath, s)
 	if err != nil {
 		return nil, truncated, err
 	}
 
+	offset := s.Offset
 	if truncated {
 		offset = 0
 	}
 
 	ok := false // used for cleanup
 	defer cleanup.IfNot(&ok, cleanup.IgnoreError(f.Close))
+	if decompressed != nil {
+		defer cleanup.IfNot(&ok, cleanup.IgnoreError(decompressed.Close))
+	}
 
 	log.Debug("newLogFileReader with config.MaxBytes:", inp.readerConfig.MaxBytes)
 
@@ -225,6 +244,9 @@ func (inp *filestream) open(
 	if err != nil {
 		return nil, truncated, err
 	}
+	if decompressed != nil {
+		logReader.readDecompressed(decompressed)
+	}
 
 	dbgReader, err := debug.AppendReaders(logReader)
 	if err != nil {
@@ -252,10 +274,18 @@ func (inp *filestream) open(
 
 	r = readfile.NewFilemeta(r, fs.newPath, fs.desc.Info, fs.desc.Fingerprint, offset)
 
-	r = inp.parsers.Create(r)
+	// The header row has only been read if the file is resumed.
+	if offset > 0 {
+		parserState.CSV.Header = s.CSVHeader
+	}
+	r = inp.parsers.CreateWithState(r, parserState)
 
 	r = readfile.NewLimitReader(r, inp.readerConfig.MaxBytes)
 
+	if decompressed != nil {
+		r = newLookaheadReader(r, decompressed)
+	}
+
 	ok = true // no need to close the file
 	return r, truncated, nil
 }
@@ -266,40 +296,57 @@ func (inp *filestream) open(
 // the file system is scanned.
 //
 // openFile will also detect and hadle file truncation. If a file is truncated
-// then the 3rd return value is true.
+// then the 4th return value is true.
+//
+// If the file is compressed, the 2nd return value reads its decompressed
+// content, positioned at the offset of the state.
 func (inp *filestream) openFile(
 	log *logp.Logger,
 	path string,
-	offset int64,
-) (*os.File, encoding.Encoding, bool, error) {
+	s state,
+) (*os.File, *decompressedFile, encoding.Encoding, bool, error) {
 	fi, err := os.Stat(path)
 	if err != nil {
-		return nil, nil, false, fmt.Errorf("failed to stat source file %s: %w", path, err)
+		return nil, nil, nil, false, fmt.Errorf("failed to stat source file %s: %w", path, err)
 	}
 
 	// it must be checked if the file is not a named pipe before we try to open it
 	// if it is a named pipe os.OpenFile fails, so there is no need to try opening it.
 	if fi.Mode()&os.ModeNamedPipe != 0 {
-		return nil, nil, false, fmt.Errorf("failed to open file %s, named pipes are not supported", fi.Name())
+		return nil, nil, nil, false, fmt.Errorf("failed to open file %s, named pipes are not supported", fi.Name())
 	}
 
 	f, err := file.ReadOpen(path)
 	if err != nil {
-		return nil, nil, false, fmt.Errorf("failed opening %s: %w", path, err)
+		return nil, nil, nil, false, fmt.Errorf("failed opening %s: %w", path, err)
 	}
 	ok := false
 	defer cleanup.IfNot(&ok, cleanup.IgnoreError(f.Close))
 
 	fi, err = f.Stat()
 	if err != nil {
-		return nil, nil, false, fmt.Errorf("failed to stat source file %s: %w", path, err)
+		return nil, nil, nil, false, fmt.Errorf("failed to stat source file %s: %w", path, err)
 	}
 
 	err = checkFileBeforeOpening(fi)
 	if err != nil {
-		return nil, nil, false, err
+		return nil, nil, nil, false, err
 	}
 
+	format, err := inp.compression.detect(path, f)
+	if err != nil {
+		return nil, nil, nil, false, err
+	}
+	if format != "" {
+		decompressed, encoding, truncated, err := inp.openDecompressed(log, f, format, s)
+		if err != nil {
+			return nil, nil, nil, truncated, err
+		}
+		ok = true // no need to close the file
+		return f, decompressed, encoding, truncated, nil
+	}
+
+	offset := s.Offset
 	truncated := false
 	if fi.Size() < offset {
 		// if the file was truncated we need to reset the offset and notify
@@ -309,20 +356,79 @@ func (inp *filestream) openFile(
 		offset = 0
 	}
 	err = inp.initFileOffset(f, offset)
+	if err != nil {
+		return nil, nil, nil, truncated, err
+	}
+
+	encoding, err := inp.initEncoding(f)
+	if err != nil {
+		return nil, nil, nil, truncated, err
+	}
+
+	ok = true // no need to close the file
+	return f, nil, encoding, truncated, nil
+}
+
+// openDecompressed decompresses the file up to the offset of the state.
+// If the decompressed content is shorter than the offset, the file is
+// handled as truncated and read from the beginning.
+func (inp *filestream) openDecompressed(
+	log *logp.Logger,
+	f *os.File,
+	format string,
+	s state,
+) (*decompressedFile, encoding.Encoding, bool, error) {
+	if s.Completed {
+		return nil, nil, false, errCompressedFileCompleted
+	}
+
+	d, err := newDecompressedFile(f, format)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	ok := false
+	// d is replaced if the file is truncated, the current one is closed.
+	defer func() {
+		if !ok && d != nil {
+			_ = d.Close()
+		}
+	}()
+
+	log.Debugf("Reading %s compressed file %s from offset %d", format, f.Name(), s.Offset)
+	found, err := d.skip(s.Offset)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to decompress %s: %w", f.Name(), err)
+	}
+
+	truncated := false
+	if !found {
+		truncated = true
+		log.Infof("Compressed file is shorter than the offset. Reading file from offset 0. Path=%s", f.Name())
+		_ = d.Close()
+		d, err = newDecompressedFile(f, format)
+		if err != nil {
+			return nil, nil, truncated, err
+		}
+	}
+
+	encoding, err := inp.initEncoding(d)
 	if err != nil {
 		return nil, nil, truncated, err
 	}
 
-	encoding, err := inp.encodingFactory(f)
+	ok = true
+	return d, encoding, truncated, nil
+}
+
+func (inp *filestream) initEncoding(r io.Reader) (encoding.Encoding, error) {
+	encoding, err := inp.encodingFactory(r)
 	if err != nil {
 		if errors.Is(err, transform.ErrShortSrc) {
-			return nil, nil, truncated, fmt.Errorf("initialising encoding for '%v' failed due to file being too short", f)
+			return nil, fmt.Errorf("initialising encoding for '%v' failed due to file being too short", r)
 		}
-		return nil, nil, truncated, fmt.Errorf("initialising encoding for '%v' failed: %w", f, err)
+		return nil, fmt.Errorf("initialising encoding for '%v' failed: %w", r, err)
 	}
-
-	ok = true // no need to close the file
-	return f, encoding, truncated, nil
+	return encoding, nil
 }
 
 func checkFileBeforeOpening(fi os.FileInfo) error {
@@ -350,6 +456,7 @@ func (inp *filestream) readFromSource(
 	r reader.Reader,
 	path string,
 	s state,
+	parserState *parser.State,
 	p loginp.Publisher,
 	metrics *loginp.Metrics,
 ) error {
@@ -378,6 +485,10 @@ func (inp *filestream) readFromSource(
 		}
 
 		s.Offset += int64(message.Bytes) + int64(message.Offset)
+		if la, ok := r.(*lookaheadReader); ok {
+			s.Completed = la.completed()
+		}
+		s.CSVHeader = parserState.CSV.Header
 
 		flags, err := message.Fields.GetValue("log.flags")
 		if err == nil {
@@ -1161,3 +1161,84 @@ func TestRotatingCloseInactiveLowWriteRate(t *testing.T) {
 	cancelInput()
 	env.waitUntilInputStops()
 }
+
+func TestFilestreamCompressedFiles(t *testing.T) {
+	env := newInputTestingEnvironment(t)
+
+	testlines := []byte("first log line\nsecond log line\nthird log line\n")
+	env.mustWriteToFile("test.log.gz", compress(t, compressionGzip, testlines))
+	env.mustWriteToFile("test.log.zst", compress(t, compressionZstd, testlines))
+
+	id := "fake-ID-" + uuid.Must(uuid.NewV4()).String()
+	inp := env.mustCreateInput(map[string]interface{}{
+		"id":                                     id,
+		"paths":                                  []string{env.abspath("test.log.*")},
+		"prospector.scanner.check_interval":      "1ms",
+		"prospector.scanner.fingerprint.enabled": false,
+		"file_identity.native":                   map[string]any{},
+		"compression.enabled":                    true,
+	})
+
+	ctx, cancelInput := context.WithCancel(context.Background())
+	env.startInput(ctx, id, inp)
+
+	env.waitUntilEventCount(6)
+	env.requireOffsetInRegistry("test.log.gz", id, len(testlines))
+	env.requireOffsetInRegistry("test.log.zst", id, len(testlines))
+
+	cancelInput()
+	env.waitUntilInputStops()
+
+	for _, name := range []string{"test.log.gz", "test.log.zst"} {
+		fi, err := os.Stat(env.abspath(name))
+		require.NoError(t, err)
+		entry, err := env.getRegistryState(getIDFromPath(env.abspath(name), id, fi))
+		require.NoError(t, err)
+		require.True(t, entry.Cursor.Completed, "%s must be marked as completed", name)
+	}
+}
+
+func TestFilestreamRotatedFileCompressed(t *testing.T) {
+	env := newInputTestingEnvironment(t)
+
+	testlogName := "test.log"
+	id := "fake-ID-" + uuid.Must(uuid.NewV4()).String()
+	inp := env.mustCreateInput(map[string]interface{}{
+		"id":                                     id,
+		"paths":                                  []string{env.abspath(testlogName) + "*"},
+		"prospector.scanner.check_interval":      "1ms",
+		"prospector.scanner.fingerprint.enabled": true,
+		"file_identity.fingerprint":              map[string]any{},
+		"compression.enabled":                    true,
+	})
+
+	// The fingerprint needs at least 1KB of data.
+	line := "a log line that will be rotated"
+	testlines := []byte(strings.Repeat(line+"\n", 50))
+	env.mustWriteToFile(testlogName, testlines)
+
+	ctx, cancelInput := context.WithCancel(context.Background())
+	env.startInput(ctx, id, inp)
+	env.waitUntilEventCount(50)
+
+	// The line written just before the file was compressed is only read
+	// from the compressed file, the other lines are not read again.
+	newline := "a line written before compression"
+	compressed := compress(t, compressionGzip, append(testlines, []byte(newline+"\n")...))
+	env.mustWriteToFile("rotated.gz.tmp", compressed)
+	env.mustRemoveFile(testlogName)
+	env.mustRenameFile("rotated.gz.tmp", testlogName+".1.gz")
+
+	env.waitUntilEventCount(51)
+	time.Sleep(100 * time.Millisecond)
+	env.waitUntilEventCount(51)
+
+	cancelInput()
+	env.waitUntilInputStops()
+
+	expected := make([]string, 0, 51)
+	for i := 0; i < 50; i++ {
+		expected = append(expected, line)
+	}
+	env.requireEventsReceived(append(expected, newline))
+}
@@ -639,3 +639,41 @@ SetAdCodeMiddleware.default_ad_code route
 	cancelInput()
 	env.waitUntilInputStops()
 }
+
+func TestParsersCSVMultilineRecord(t *testing.T) {
+	env := newInputTestingEnvironment(t)
+
+	testlogName := "test.csv"
+	id := uuid.Must(uuid.NewV4()).String()
+	inp := env.mustCreateInput(map[string]interface{}{
+		"id":                                     "fake-ID",
+		"paths":                                  []string{env.abspath(testlogName)},
+		"prospector.scanner.check_interval":      "1ms",
+		"file_identity.native":                   map[string]any{},
+		"prospector.scanner.fingerprint.enabled": false,
+		"parsers": []map[string]interface{}{
+			{
+				"csv": map[string]interface{}{
+					"header": true,
+				},
+			},
+		},
+	})
+
+	testlines := []byte("id,text\n1,\"first line\nsecond line\"\n2,third line\n")
+	env.mustWriteToFile(testlogName, testlines)
+
+	ctx, cancelInput := context.WithCancel(context.Background())
+	env.startInput(ctx, id, inp)
+
+	env.waitUntilEventCount(2)
+	env.requireOffsetInRegistry(testlogName, "fake-ID", len(testlines))
+
+	env.requireEventContents(0, "csv.id", "1")
+	env.requireEventContents(0, "csv.text", "first line\nsecond line")
+	env.requireEventContents(1, "csv.id", "2")
+	env.requireEventContents(1, "csv.text", "third line")
+
+	cancelInput()
+	env.waitUntilInputStops()
+}
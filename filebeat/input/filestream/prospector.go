@@ -339,7 +339,10 @@ func (p *fileProspector) onFSEvent(
 		}
 
 		if p.isFileIgnored(log, event, ignoreSince) {
-			err := updater.ResetCursor(src, state{Offset: event.Descriptor.Info.Size()})
+			err := updater.ResetCursor(src, state{
+				Offset:    event.Descriptor.Info.Size(),
+				Completed: event.Descriptor.Compression != "",
+			})
 			if err != nil {
 				log.Errorf("setting cursor for ignored file: %v", err)
 			}
@@ -439,6 +442,14 @@ func (p *fileProspector) onRename(log *logp.Logger, ctx input.Context, fe loginp
 			srcToClose := p.identifier.GetSource(fe)
 			hg.Stop(srcToClose)
 		}
+
+		// A file that has been compressed after it was rotated keeps its
+		// fingerprint, the lines that were not read yet from the original
+		// file are read from the compressed one.
+		if fe.Descriptor.Compression != "" {
+			log.Debugf("Restarting harvester as file %s has been replaced by compressed file %s", fe.OldPath, fe.NewPath)
+			hg.Restart(ctx, src)
+		}
 	}
 }
 
@@ -171,6 +171,7 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/health v1.29.2
 	github.com/aws/smithy-go v1.22.1
 	github.com/dgraph-io/badger/v4 v4.6.0
+	github.com/dlclark/regexp2 v1.4.0
 	github.com/elastic/bayeux v1.0.5
 	github.com/elastic/ebpfevents v0.7.0
 	github.com/elastic/elastic-agent-autodiscover v0.9.2
@@ -203,6 +204,7 @@ require (
 	github.com/microsoft/go-mssqldb v1.7.2
 	github.com/microsoft/wmi v0.25.1
 	github.com/open-telemetry/opentelemetry-collector-contrib/exporter/elasticsearchexporter v0.125.0
+	github.com/oschwald/maxminddb-golang v1.13.1
 	github.com/otiai10/copy v1.12.0
 	github.com/pierrec/lz4/v4 v4.1.22
 	github.com/pkg/xattr v0.4.9
@@ -494,7 +496,6 @@ require (
 require (
 	cloud.google.com/go/storage v1.49.0
 	github.com/PaloAltoNetworks/pango v0.10.2
-	github.com/dlclark/regexp2 v1.4.0 // indirect
 	github.com/google/gofuzz v1.2.0 // indirect
 	github.com/moby/term v0.5.0 // indirect
 	github.com/yuin/gopher-lua v1.1.1 // indirect
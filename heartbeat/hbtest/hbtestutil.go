@@ -39,11 +39,18 @@ import (
 
 	"github.com/elastic/beats/v7/heartbeat/ecserr"
 	"github.com/elastic/beats/v7/heartbeat/monitors/active/dialchain/tlsmeta"
+	"github.com/elastic/beats/v7/heartbeat/monitors/plugin"
+	"github.com/elastic/beats/v7/heartbeat/monitors/stdfields"
+	"github.com/elastic/beats/v7/heartbeat/monitors/wrappers"
 	"github.com/elastic/beats/v7/heartbeat/monitors/wrappers/summarizer/summarizertesthelper"
 	"github.com/elastic/beats/v7/heartbeat/monitors/wrappers/wraputil"
 
 	"github.com/elastic/beats/v7/heartbeat/hbtestllext"
+	"github.com/elastic/beats/v7/heartbeat/scheduler/schedule"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/go-lookslike"
@@ -304,3 +311,45 @@ func StartHTTPSServer(t *testing.T, tlsCert tls.Certificate) (host string, port
 	require.NoError(t, err)
 	return host, port, cert, srv.Close
 }
+
+// RunMonitor creates a monitor of type typ checking a single endpoint, runs
+// its job once and returns the event.
+func RunMonitor(t *testing.T, create plugin.PluginMake, typ string, cfg mapstr.M) *beat.Event {
+	t.Helper()
+	config, err := conf.NewConfigFrom(cfg)
+	require.NoError(t, err)
+
+	p, err := create(typ, config)
+	require.NoError(t, err)
+	require.Equal(t, 1, p.Endpoints)
+
+	sched := schedule.MustParse("@every 1s")
+	job := wrappers.WrapCommon(p.Jobs, stdfields.StdMonitorFields{ID: "test", Type: typ, Schedule: sched, Timeout: 1}, nil)[0]
+
+	event := &beat.Event{}
+	_, err = job(event)
+	require.NoError(t, err)
+
+	return event
+}
+
+// ClosedAddr returns the address of a local TCP port nothing listens on,
+// for testing refused connections.
+func ClosedAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	return addr
+}
+
+// InvalidConfigs checks creating a monitor of type typ fails with each of
+// the configurations.
+func InvalidConfigs(t *testing.T, create plugin.PluginMake, typ string, cfgs []mapstr.M) {
+	t.Helper()
+	for _, cfg := range cfgs {
+		_, err := create(typ, conf.MustNewConfigFrom(cfg))
+		assert.Error(t, err, cfg)
+	}
+}
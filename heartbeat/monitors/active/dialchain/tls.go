@@ -18,6 +18,7 @@
 package dialchain
 
 import (
+	"context"
 	cryptoTLS "crypto/tls"
 	"fmt"
 	"net"
@@ -33,6 +34,24 @@ import (
 // The layer will update the active event with the TLS RTT and
 // crypto/cert details.
 func TLSLayer(cfg *tlscommon.TLSConfig, to time.Duration) Layer {
+	return tlsLayer(func(next transport.Dialer) transport.Dialer {
+		return transport.TLSDialer(next, cfg, to)
+	})
+}
+
+// ALPNTLSLayer configures a TLS layer like TLSLayer, offering the given
+// application protocols during the handshake. HTTP/2 based protocols like
+// gRPC require the server to select "h2".
+func ALPNTLSLayer(cfg *tlscommon.TLSConfig, to time.Duration, protos []string) Layer {
+	return tlsLayer(func(next transport.Dialer) transport.Dialer {
+		dialer, _ := transport.TLSDialerH2(next, cfg, to)
+		return makeDialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address, &cryptoTLS.Config{NextProtos: protos})
+		})
+	})
+}
+
+func tlsLayer(makeTLSDialer func(next transport.Dialer) transport.Dialer) Layer {
 	return func(event *beat.Event, next transport.Dialer) (transport.Dialer, error) {
 		var timer timer
 
@@ -40,7 +59,7 @@ func TLSLayer(cfg *tlscommon.TLSConfig, to time.Duration) Layer {
 		// This gets us the timestamp for when the TLS layer will start the handshake.
 		next = startTimerAfterDial(&timer, next)
 
-		dialer := transport.TLSDialer(next, cfg, to)
+		dialer := makeTLSDialer(next)
 		return afterDial(dialer, func(conn net.Conn) (net.Conn, error) {
 			tlsConn, ok := conn.(*cryptoTLS.Conn)
 			if !ok {
@@ -0,0 +1,109 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dns
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/elastic/beats/v7/heartbeat/monitors"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+)
+
+// Transports used to send the queries.
+const (
+	transportUDP = "udp"
+	transportTCP = "tcp"
+	transportTLS = "tls"
+)
+
+type config struct {
+	// DNS servers to query, as host, host:port or transport://host:port.
+	Hosts []string `config:"hosts" validate:"required"`
+
+	Mode monitors.IPSettings `config:",inline"`
+
+	Query     string `config:"query" validate:"required"`
+	QueryType string `config:"query_type"`
+	Transport string `config:"transport"`
+
+	// RecursionDesired sets the RD flag of the queries.
+	RecursionDesired bool `config:"recursion_desired"`
+	// DNSSEC sets the DO flag of the queries to request the DNSSEC records.
+	DNSSEC bool `config:"dnssec"`
+
+	// configure tls for the tls transport
+	TLS *tlscommon.Config `config:"ssl"`
+
+	Timeout time.Duration `config:"timeout"`
+
+	Check checkConfig `config:"check"`
+}
+
+type checkConfig struct {
+	// RCode is the expected response code.
+	RCode string `config:"rcode"`
+	// Answers must all be in the data of the answer records.
+	Answers []string `config:"answers"`
+	// AuthenticatedData requires the AD flag in the response, telling the
+	// resolver validated the answers with DNSSEC.
+	AuthenticatedData bool `config:"authenticated_data"`
+}
+
+func defaultConfig() config {
+	return config{
+		Mode:             monitors.DefaultIPSettings,
+		QueryType:        "A",
+		Transport:        transportUDP,
+		RecursionDesired: true,
+		Timeout:          16 * time.Second,
+		Check: checkConfig{
+			RCode: dns.RcodeToString[dns.RcodeSuccess],
+		},
+	}
+}
+
+func (c *config) Validate() error {
+	c.QueryType = strings.ToUpper(c.QueryType)
+	if _, ok := dns.StringToType[c.QueryType]; !ok {
+		return fmt.Errorf("unknown query_type '%s'", c.QueryType)
+	}
+
+	if err := validateTransport(c.Transport); err != nil {
+		return err
+	}
+
+	c.Check.RCode = strings.ToUpper(c.Check.RCode)
+	if _, ok := dns.StringToRcode[c.Check.RCode]; !ok {
+		return fmt.Errorf("unknown check.rcode '%s'", c.Check.RCode)
+	}
+
+	return nil
+}
+
+func validateTransport(transport string) error {
+	switch transport {
+	case transportUDP, transportTCP, transportTLS:
+		return nil
+	default:
+		return fmt.Errorf("'%s' is not a supported transport, supported transports are udp, tcp and tls", transport)
+	}
+}
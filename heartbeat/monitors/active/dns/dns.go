@@ -0,0 +1,185 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dns
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/v7/heartbeat/monitors"
+	"github.com/elastic/beats/v7/heartbeat/monitors/jobs"
+	"github.com/elastic/beats/v7/heartbeat/monitors/plugin"
+	"github.com/elastic/beats/v7/heartbeat/monitors/wrappers/wraputil"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+)
+
+func init() {
+	plugin.Register("dns", create, "synthetics/dns")
+}
+
+var debugf = logp.MakeDebug("dns")
+
+// defaultPorts are the ports of the DNS servers by transport.
+var defaultPorts = map[string]string{
+	transportUDP: "53",
+	transportTCP: "53",
+	transportTLS: "853",
+}
+
+func create(
+	name string,
+	cfg *conf.C,
+) (p plugin.Plugin, err error) {
+	return createWithResolver(cfg, monitors.NewStdResolver())
+}
+
+// Custom resolver is useful for tests against hostnames locally where we don't want to depend on any
+// hostnames existing in test environments
+func createWithResolver(
+	cfg *conf.C,
+	resolver monitors.Resolver,
+) (p plugin.Plugin, err error) {
+	jf, err := newJobFactory(cfg, resolver)
+	if err != nil {
+		return plugin.Plugin{}, err
+	}
+
+	js, err := jf.makeJobs()
+	if err != nil {
+		return plugin.Plugin{}, err
+	}
+
+	return plugin.Plugin{Jobs: js, Endpoints: len(jf.servers)}, nil
+}
+
+// jobFactory provides a common context to the jobs querying each DNS server.
+type jobFactory struct {
+	config    config
+	tlsConfig *tlscommon.TLSConfig
+	servers   []*url.URL
+	resolver  monitors.Resolver
+}
+
+func newJobFactory(commonCfg *conf.C, resolver monitors.Resolver) (*jobFactory, error) {
+	jf := &jobFactory{config: defaultConfig(), resolver: resolver}
+	err := jf.loadConfig(commonCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return jf, nil
+}
+
+// loadConfig parses the YAML config and populates the jobFactory fields.
+func (jf *jobFactory) loadConfig(commonCfg *conf.C) error {
+	var err error
+	if err = commonCfg.Unpack(&jf.config); err != nil {
+		return err
+	}
+
+	for _, host := range jf.config.Hosts {
+		u, err := makeServerURL(host, jf.config.Transport)
+		if err != nil {
+			return err
+		}
+		jf.servers = append(jf.servers, u)
+
+		if u.Scheme == transportTLS && jf.tlsConfig == nil {
+			tlsCfg := jf.config.TLS
+			if tlsCfg == nil {
+				tlsCfg = &tlscommon.Config{}
+			}
+			jf.tlsConfig, err = tlscommon.LoadTLSConfig(tlsCfg)
+			if err != nil {
+				return err
+			}
+			if jf.tlsConfig == nil {
+				return fmt.Errorf("the tls transport of '%s' requires ssl to be enabled", host)
+			}
+		}
+	}
+
+	return nil
+}
+
+// makeServerURL parses a DNS server given as host, host:port or
+// transport://host:port. The scheme of the URL is the transport.
+func makeServerURL(host, transport string) (*url.URL, error) {
+	u, err := url.Parse(host)
+	// Bare hosts like 'localhost' are parsed as the URL path, and
+	// host:port as scheme:opaque.
+	if err != nil || u.Host == "" {
+		u = &url.URL{Scheme: transport, Host: host}
+	}
+	if err := validateTransport(u.Scheme); err != nil {
+		return nil, fmt.Errorf("invalid DNS server '%s': %w", host, err)
+	}
+
+	switch {
+	case net.ParseIP(u.Host) != nil:
+		// Bare IPv6 addresses have no brackets.
+		u.Host = net.JoinHostPort(u.Host, defaultPorts[u.Scheme])
+	case u.Port() == "":
+		if strings.HasSuffix(u.Host, ":") {
+			return nil, fmt.Errorf("invalid DNS server '%s': empty port", host)
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), defaultPorts[u.Scheme])
+	default:
+		if port, err := strconv.ParseUint(u.Port(), 10, 16); err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid DNS server '%s': bad port '%s'", host, u.Port())
+		}
+	}
+
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+// makeJobs returns the actual schedulable jobs for this monitor.
+func (jf *jobFactory) makeJobs() ([]jobs.Job, error) {
+	var js []jobs.Job
+	for _, server := range jf.servers {
+		job, err := jf.makeServerJob(server)
+		if err != nil {
+			return nil, err
+		}
+		js = append(js, wraputil.WithURLField(server, job))
+	}
+
+	return js, nil
+}
+
+// makeServerJob makes a job resolving the IPs of the server and querying
+// each of them, depending on the mode.
+func (jf *jobFactory) makeServerJob(server *url.URL) (jobs.Job, error) {
+	return monitors.MakeByHostJob(
+		server.Hostname(),
+		jf.config.Mode,
+		jf.resolver,
+		monitors.MakePingIPFactory(
+			func(event *beat.Event, ip *net.IPAddr) error {
+				// use address from resolved IP
+				ipPort := net.JoinHostPort(ip.String(), server.Port())
+
+				return jf.query(event, ipPort, server)
+			}))
+}
@@ -0,0 +1,278 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dns
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/go-lookslike"
+	"github.com/elastic/go-lookslike/isdef"
+	"github.com/elastic/go-lookslike/testslike"
+	"github.com/elastic/go-lookslike/validator"
+
+	"github.com/elastic/beats/v7/heartbeat/hbtest"
+	"github.com/elastic/beats/v7/heartbeat/hbtestllext"
+)
+
+// testZone is served by the test servers.
+var testZone = map[string][]string{
+	"example.com. A":        {"example.com. 300 IN A 192.0.2.1", "example.com. 300 IN A 192.0.2.2"},
+	"example.com. MX":       {"example.com. 300 IN MX 10 mail.example.com."},
+	"example.com. TXT":      {`example.com. 300 IN TXT "v=spf1 " "-all"`},
+	"www.example.com. A":    {"www.example.com. 300 IN CNAME example.com.", "example.com. 300 IN A 192.0.2.1"},
+	"signed.example.com. A": {"signed.example.com. 300 IN A 192.0.2.3"},
+}
+
+func handleQuery(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.RecursionAvailable = true
+
+	q := req.Question[0]
+	records, ok := testZone[q.Name+" "+dns.TypeToString[q.Qtype]]
+	if !ok {
+		resp.Rcode = dns.RcodeNameError
+	}
+	for _, record := range records {
+		rr, _ := dns.NewRR(record)
+		resp.Answer = append(resp.Answer, rr)
+	}
+	resp.AuthenticatedData = req.AuthenticatedData && q.Name == "signed.example.com."
+	if opt := req.IsEdns0(); opt != nil {
+		resp.SetEdns0(opt.UDPSize(), opt.Do())
+	}
+	_ = w.WriteMsg(resp)
+}
+
+// startServer starts a DNS server for the transport, returning its address.
+func startServer(t *testing.T, transport string, tlsCert *tls.Certificate) string {
+	t.Helper()
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		Handler:           dns.HandlerFunc(handleQuery),
+		NotifyStartedFunc: func() { close(started) },
+	}
+
+	var addr string
+	switch transport {
+	case transportUDP:
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		server.PacketConn = pc
+		addr = pc.LocalAddr().String()
+	case transportTCP:
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		server.Listener = l
+		addr = l.Addr().String()
+	case transportTLS:
+		//nolint:gosec // it's a test, sec issues don't apply
+		l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*tlsCert}})
+		require.NoError(t, err)
+		server.Listener = l
+		server.Net = "tcp-tls"
+		addr = l.Addr().String()
+	}
+
+	go func() { _ = server.ActivateAndServe() }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+	<-started
+
+	return addr
+}
+
+func TestQueryTransports(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(nil)
+	defer tlsServer.Close()
+	certFile := hbtest.CertToTempFile(t, tlsServer.Certificate())
+	defer os.Remove(certFile.Name())
+
+	for _, transport := range []string{transportUDP, transportTCP, transportTLS} {
+		t.Run(transport, func(t *testing.T) {
+			addr := startServer(t, transport, &tlsServer.TLS.Certificates[0])
+			event := hbtest.RunMonitor(t, create, "dns", mapstr.M{
+				"hosts":         transport + "://" + addr,
+				"query":         "example.com",
+				"timeout":       "1s",
+				"ssl":           mapstr.M{"certificate_authorities": certFile.Name()},
+				"check.answers": []string{"192.0.2.2"},
+			})
+
+			networkTransport := transportTCP
+			if transport == transportUDP {
+				networkTransport = transportUDP
+			}
+			validators := []validator.Validator{
+				hbtest.BaseChecks("127.0.0.1", "up", "dns"),
+				hbtest.SummaryStateChecks(1, 0),
+				lookslike.MustCompile(mapstr.M{
+					"network.transport": networkTransport,
+					"dns": mapstr.M{
+						"id":            isdef.KeyPresent,
+						"type":          "answer",
+						"op_code":       "QUERY",
+						"response_code": "NOERROR",
+						"header_flags":  []string{"RD", "RA"},
+						"question": mapstr.M{
+							"name":  "example.com",
+							"type":  "A",
+							"class": "IN",
+						},
+						"answers_count": 2,
+						"answers": []mapstr.M{
+							{"name": "example.com", "type": "A", "class": "IN", "ttl": uint32(300), "data": "192.0.2.1"},
+							{"name": "example.com", "type": "A", "class": "IN", "ttl": uint32(300), "data": "192.0.2.2"},
+						},
+						"resolved_ip":  []string{"192.0.2.1", "192.0.2.2"},
+						"rtt.query.us": hbtestllext.IsInt64,
+					},
+					networkTransport + ".rtt.connect.us": hbtestllext.IsInt64,
+				}),
+			}
+			if transport == transportTLS {
+				validators = append(validators, hbtest.TLSChecks(0, 0, tlsServer.Certificate()))
+			}
+
+			testslike.Test(t, lookslike.Compose(validators...), event.Fields)
+			assert.NotContains(t, event.Fields, "error")
+		})
+	}
+}
+
+func TestChecks(t *testing.T) {
+	addr := startServer(t, transportUDP, nil)
+
+	tests := map[string]struct {
+		cfg    mapstr.M
+		status string
+		errMsg string
+	}{
+		"cname and answer": {
+			cfg:    mapstr.M{"query": "www.example.com", "check.answers": []string{"EXAMPLE.COM.", "192.0.2.1"}},
+			status: "up",
+		},
+		"mx": {
+			cfg:    mapstr.M{"query": "example.com", "query_type": "mx", "check.answers": []string{"10 mail.example.com"}},
+			status: "up",
+		},
+		"txt": {
+			cfg:    mapstr.M{"query": "example.com", "query_type": "TXT", "check.answers": []string{"v=spf1 -all"}},
+			status: "up",
+		},
+		"missing answer": {
+			cfg:    mapstr.M{"query": "example.com", "check.answers": []string{"192.0.2.1", "192.0.2.9"}},
+			status: "down",
+			errMsg: "answers [192.0.2.9] not found in response",
+		},
+		"expected nxdomain": {
+			cfg:    mapstr.M{"query": "missing.example.com", "check.rcode": "nxdomain"},
+			status: "up",
+		},
+		"unexpected rcode": {
+			cfg:    mapstr.M{"query": "missing.example.com"},
+			status: "down",
+			errMsg: "received response code 'NXDOMAIN', expected 'NOERROR'",
+		},
+		"authenticated": {
+			cfg:    mapstr.M{"query": "signed.example.com", "dnssec": true, "check.authenticated_data": true},
+			status: "up",
+		},
+		"not authenticated": {
+			cfg:    mapstr.M{"query": "example.com", "check.authenticated_data": true},
+			status: "down",
+			errMsg: "response is not authenticated",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := mapstr.M{"hosts": addr, "timeout": "1s"}
+			cfg.DeepUpdate(tc.cfg)
+			event := hbtest.RunMonitor(t, create, "dns", cfg)
+
+			validators := []validator.Validator{
+				hbtest.BaseChecks("127.0.0.1", tc.status, "dns"),
+			}
+			if tc.errMsg != "" {
+				validators = append(validators, hbtest.ErrorChecks(tc.errMsg, "validate"))
+			}
+			testslike.Test(t, lookslike.Compose(validators...), event.Fields)
+		})
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	addr := hbtest.ClosedAddr(t)
+	event := hbtest.RunMonitor(t, create, "dns", mapstr.M{
+		"hosts":     addr,
+		"transport": "tcp",
+		"query":     "example.com",
+		"timeout":   "1s",
+	})
+	testslike.Test(t, lookslike.Compose(
+		hbtest.BaseChecks("127.0.0.1", "down", "dns"),
+		hbtest.SummaryStateChecks(0, 1),
+	), event.Fields)
+}
+
+func TestMakeServerURL(t *testing.T) {
+	tests := []struct {
+		host, transport string
+		want            string
+	}{
+		{"8.8.8.8", "udp", "udp://8.8.8.8:53"},
+		{"8.8.8.8:5353", "tcp", "tcp://8.8.8.8:5353"},
+		{"dns.google", "tls", "tls://dns.google:853"},
+		{"localhost:53", "udp", "udp://localhost:53"},
+		{"tls://1.1.1.1", "udp", "tls://1.1.1.1:853"},
+		{"tcp://[2001:db8::1]:54", "udp", "tcp://[2001:db8::1]:54"},
+		{"2001:db8::1", "udp", "udp://[2001:db8::1]:53"},
+		{"[2001:db8::1]", "tcp", "tcp://[2001:db8::1]:53"},
+	}
+	for _, tc := range tests {
+		u, err := makeServerURL(tc.host, tc.transport)
+		if assert.NoError(t, err, tc.host) {
+			assert.Equal(t, tc.want, u.String(), tc.host)
+		}
+	}
+
+	for _, host := range []string{"https://8.8.8.8", "8.8.8.8:", "8.8.8.8:99999"} {
+		_, err := makeServerURL(host, "udp")
+		assert.Error(t, err, host)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	hbtest.InvalidConfigs(t, create, "dns", []mapstr.M{
+		{"hosts": "8.8.8.8"},
+		{"query": "example.com"},
+		{"hosts": "8.8.8.8", "query": "example.com", "query_type": "BOGUS"},
+		{"hosts": "8.8.8.8", "query": "example.com", "transport": "quic"},
+		{"hosts": "8.8.8.8", "query": "example.com", "check.rcode": "BOGUS"},
+	})
+}
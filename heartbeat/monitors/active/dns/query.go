@@ -0,0 +1,260 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/elastic/beats/v7/heartbeat/eventext"
+	"github.com/elastic/beats/v7/heartbeat/look"
+	"github.com/elastic/beats/v7/heartbeat/monitors/active/dialchain"
+	"github.com/elastic/beats/v7/heartbeat/reason"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// query sends the query to the server at dialAddr and checks the response.
+// server is the configured URL of the server, its scheme being the
+// transport and its hostname being used to validate the TLS certificates.
+func (jf *jobFactory) query(event *beat.Event, dialAddr string, server *url.URL) error {
+	network := "udp"
+	if server.Scheme != transportUDP {
+		network = "tcp"
+	}
+
+	dc := &dialchain.DialerChain{
+		Net: dialchain.CreateNetDialer(jf.config.Timeout),
+	}
+	dc.AddLayer(dialchain.ConstAddrLayer(dialAddr))
+	if server.Scheme == transportTLS {
+		// Make the TLS layer validate the certificates against the hostname
+		// of the server instead of the resolved IP.
+		dc.AddLayer(dialchain.TLSLayer(jf.tlsConfig, jf.config.Timeout))
+		dc.AddLayer(dialchain.ConstAddrLayer(server.Host))
+	}
+
+	dialer, err := dc.Build(event)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	deadline := start.Add(jf.config.Timeout)
+
+	conn, err := dialer.Dial(network, dialAddr)
+	if err != nil {
+		debugf("dial failed with: %v", err)
+		return reason.IOFailed(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		debugf("setting connection deadline failed with: %v", err)
+		return reason.IOFailed(err)
+	}
+
+	msg := jf.newMsg()
+	eventext.MergeEventFields(event, mapstr.M{
+		"network": mapstr.M{"transport": network},
+		"dns": mapstr.M{
+			"id": msg.Id,
+			"question": mapstr.M{
+				"name":  strings.TrimSuffix(msg.Question[0].Name, "."),
+				"type":  jf.config.QueryType,
+				"class": dns.ClassToString[dns.ClassINET],
+			},
+		},
+	})
+
+	resp, rtt, err := exchange(conn, msg)
+	if err != nil {
+		debugf("query failed with: %v", err)
+		return reason.IOFailed(err)
+	}
+
+	eventext.MergeEventFields(event, mapstr.M{
+		"dns": responseFields(resp, rtt),
+	})
+
+	if err := jf.check(resp); err != nil {
+		return reason.MakeValidateError(err)
+	}
+
+	return nil
+}
+
+// newMsg builds the query of the monitor.
+func (jf *jobFactory) newMsg() *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(jf.config.Query), dns.StringToType[jf.config.QueryType])
+	msg.RecursionDesired = jf.config.RecursionDesired
+	// Setting the AD flag asks for it in the response (RFC 6840).
+	msg.AuthenticatedData = jf.config.Check.AuthenticatedData
+	if jf.config.DNSSEC {
+		msg.SetEdns0(dns.DefaultMsgSize, true)
+	}
+	return msg
+}
+
+// exchange sends msg over conn and reads the response, returning the round
+// trip time of the query.
+func exchange(conn net.Conn, msg *dns.Msg) (*dns.Msg, time.Duration, error) {
+	co := &dns.Conn{Conn: conn, UDPSize: dns.DefaultMsgSize}
+
+	start := time.Now()
+	if err := co.WriteMsg(msg); err != nil {
+		return nil, 0, err
+	}
+	resp, err := co.ReadMsg()
+	if err != nil {
+		return nil, 0, err
+	}
+	rtt := time.Since(start)
+
+	if resp.Id != msg.Id {
+		return nil, 0, dns.ErrId
+	}
+	return resp, rtt, nil
+}
+
+// check validates the response against the configured expectations.
+func (jf *jobFactory) check(resp *dns.Msg) error {
+	var errs []error
+
+	if rcode := dns.RcodeToString[resp.Rcode]; rcode != jf.config.Check.RCode {
+		errs = append(errs, fmt.Errorf("received response code '%s', expected '%s'", rcode, jf.config.Check.RCode))
+	}
+
+	if jf.config.Check.AuthenticatedData && !resp.AuthenticatedData {
+		errs = append(errs, errors.New("response is not authenticated (AD flag not set)"))
+	}
+
+	if len(jf.config.Check.Answers) > 0 {
+		found := map[string]bool{}
+		for _, rr := range resp.Answer {
+			found[normalize(rrData(rr))] = true
+		}
+		var missing []string
+		for _, expected := range jf.config.Check.Answers {
+			if !found[normalize(expected)] {
+				missing = append(missing, expected)
+			}
+		}
+		if len(missing) > 0 {
+			errs = append(errs, fmt.Errorf("answers %v not found in response", missing))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// responseFields returns the ECS dns fields of the response.
+func responseFields(resp *dns.Msg, rtt time.Duration) mapstr.M {
+	var flags []string
+	for _, f := range []struct {
+		name string
+		set  bool
+	}{
+		{"AA", resp.Authoritative},
+		{"TC", resp.Truncated},
+		{"RD", resp.RecursionDesired},
+		{"RA", resp.RecursionAvailable},
+		{"AD", resp.AuthenticatedData},
+		{"CD", resp.CheckingDisabled},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	if opt := resp.IsEdns0(); opt != nil && opt.Do() {
+		flags = append(flags, "DO")
+	}
+
+	answers := make([]mapstr.M, 0, len(resp.Answer))
+	var resolvedIPs []string
+	for _, rr := range resp.Answer {
+		hdr := rr.Header()
+		answers = append(answers, mapstr.M{
+			"name":  strings.TrimSuffix(hdr.Name, "."),
+			"type":  dns.TypeToString[hdr.Rrtype],
+			"class": dns.ClassToString[hdr.Class],
+			"ttl":   hdr.Ttl,
+			"data":  rrData(rr),
+		})
+		switch v := rr.(type) {
+		case *dns.A:
+			resolvedIPs = append(resolvedIPs, v.A.String())
+		case *dns.AAAA:
+			resolvedIPs = append(resolvedIPs, v.AAAA.String())
+		}
+	}
+
+	fields := mapstr.M{
+		"type":          "answer",
+		"op_code":       dns.OpcodeToString[resp.Opcode],
+		"response_code": dns.RcodeToString[resp.Rcode],
+		"header_flags":  flags,
+		"answers":       answers,
+		"answers_count": len(resp.Answer),
+		"rtt": mapstr.M{
+			"query": look.RTT(rtt),
+		},
+	}
+	if len(resolvedIPs) > 0 {
+		fields["resolved_ip"] = resolvedIPs
+	}
+	return fields
+}
+
+// rrData returns the data of a record in the format of the expected
+// answers.
+func rrData(rr dns.RR) string {
+	switch v := rr.(type) {
+	case *dns.A:
+		return v.A.String()
+	case *dns.AAAA:
+		return v.AAAA.String()
+	case *dns.CNAME:
+		return strings.TrimSuffix(v.Target, ".")
+	case *dns.MX:
+		return fmt.Sprintf("%d %s", v.Preference, strings.TrimSuffix(v.Mx, "."))
+	case *dns.TXT:
+		return strings.Join(v.Txt, "")
+	case *dns.SRV:
+		return fmt.Sprintf("%d %d %d %s", v.Priority, v.Weight, v.Port, strings.TrimSuffix(v.Target, "."))
+	case *dns.SOA:
+		return fmt.Sprintf("%s %s %d %d %d %d %d",
+			strings.TrimSuffix(v.Ns, "."), strings.TrimSuffix(v.Mbox, "."),
+			v.Serial, v.Refresh, v.Retry, v.Expire, v.Minttl)
+	default:
+		return strings.TrimSpace(strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+}
+
+// normalize makes the comparison of answers case insensitive and ignores
+// the trailing dots of the names.
+func normalize(data string) string {
+	return strings.ToLower(strings.TrimSuffix(data, "."))
+}
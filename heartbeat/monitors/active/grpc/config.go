@@ -0,0 +1,146 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/elastic/beats/v7/heartbeat/monitors"
+	"github.com/elastic/elastic-agent-libs/transport"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+)
+
+// Methods of the health service used to check the servers.
+const (
+	healthMethodCheck = "check"
+	healthMethodWatch = "watch"
+)
+
+type config struct {
+	// gRPC servers to check, as host:port or scheme://host:port.
+	Hosts []string `config:"hosts" validate:"required"`
+
+	Mode monitors.IPSettings `config:",inline"`
+
+	Socks5 transport.ProxyConfig `config:",inline"`
+
+	// configure tls
+	TLS *tlscommon.Config `config:"ssl"`
+
+	Timeout time.Duration `config:"timeout"`
+
+	// Metadata sent with every RPC.
+	Metadata map[string]string `config:"metadata"`
+
+	Health healthConfig `config:"health"`
+	Call   *callConfig  `config:"call"`
+}
+
+type healthConfig struct {
+	Enabled bool `config:"enabled"`
+	// Service is the name of the checked service, the empty name checking
+	// the overall health of the server.
+	Service string `config:"service"`
+	// Method is the RPC of the health service to call, check or watch.
+	Method string `config:"method"`
+	// Status is the expected serving status.
+	Status string `config:"status"`
+}
+
+type callConfig struct {
+	// Method is the full name of the unary method to invoke, like
+	// package.Service/Method.
+	Method string `config:"method" validate:"required"`
+	// Request is the JSON encoded request message.
+	Request string `config:"request"`
+
+	Check callCheckConfig `config:"check"`
+}
+
+type callCheckConfig struct {
+	JSON []*jsonCheck `config:"json"`
+}
+
+type jsonCheck struct {
+	Description string `config:"description"`
+	Expression  string `config:"expression" validate:"required"`
+}
+
+func defaultConfig() config {
+	return config{
+		Mode:    monitors.DefaultIPSettings,
+		Timeout: 16 * time.Second,
+		Health: healthConfig{
+			Enabled: true,
+			Method:  healthMethodCheck,
+			Status:  healthpb.HealthCheckResponse_SERVING.String(),
+		},
+	}
+}
+
+func (c *config) Validate() error {
+	if c.Socks5.URL != "" {
+		if c.Mode.Mode != monitors.PingAny && !c.Socks5.LocalResolve {
+			return errors.New("ping all ips only supported if proxy_use_local_resolver is enabled`")
+		}
+	}
+
+	if !c.Health.Enabled && c.Call == nil {
+		return errors.New("at least one of health or call must be configured")
+	}
+
+	return nil
+}
+
+func (c *healthConfig) Validate() error {
+	c.Method = strings.ToLower(c.Method)
+	switch c.Method {
+	case healthMethodCheck, healthMethodWatch:
+	default:
+		return fmt.Errorf("'%s' is not a supported health method, supported methods are check and watch", c.Method)
+	}
+
+	c.Status = strings.ToUpper(c.Status)
+	if _, ok := healthpb.HealthCheckResponse_ServingStatus_value[c.Status]; !ok {
+		return fmt.Errorf("unknown health status '%s'", c.Status)
+	}
+
+	return nil
+}
+
+func (c *callConfig) Validate() error {
+	if _, _, err := splitMethod(c.Method); err != nil {
+		return err
+	}
+	return nil
+}
+
+// splitMethod splits the full name of a method, like package.Service/Method
+// or /package.Service/Method, into its service and method names.
+func splitMethod(fullName string) (service, method string, err error) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullName, "/"), "/")
+	if !ok || service == "" || method == "" || strings.Contains(method, "/") {
+		return "", "", fmt.Errorf("invalid method '%s', expected format is package.Service/Method", fullName)
+	}
+	return service, method, nil
+}
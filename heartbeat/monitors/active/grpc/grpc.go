@@ -0,0 +1,312 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grpc
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/PaesslerAG/gval"
+	"github.com/PaesslerAG/jsonpath"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/elastic/beats/v7/heartbeat/monitors"
+	"github.com/elastic/beats/v7/heartbeat/monitors/active/dialchain"
+	"github.com/elastic/beats/v7/heartbeat/monitors/active/dialchain/tlsmeta"
+	"github.com/elastic/beats/v7/heartbeat/monitors/jobs"
+	"github.com/elastic/beats/v7/heartbeat/monitors/plugin"
+	"github.com/elastic/beats/v7/heartbeat/monitors/wrappers/wraputil"
+	"github.com/elastic/beats/v7/heartbeat/reason"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+)
+
+func init() {
+	plugin.Register("grpc", create, "synthetics/grpc")
+}
+
+var debugf = logp.MakeDebug("grpc")
+
+// Schemes of the servers, telling whether TLS is used.
+const (
+	schemePlain = "grpc"
+	schemeTLS   = "grpcs"
+)
+
+func create(
+	name string,
+	cfg *conf.C,
+) (p plugin.Plugin, err error) {
+	return createWithResolver(cfg, monitors.NewStdResolver())
+}
+
+// Custom resolver is useful for tests against hostnames locally where we don't want to depend on any
+// hostnames existing in test environments
+func createWithResolver(
+	cfg *conf.C,
+	resolver monitors.Resolver,
+) (p plugin.Plugin, err error) {
+	jf, err := newJobFactory(cfg, resolver)
+	if err != nil {
+		return plugin.Plugin{}, err
+	}
+
+	js, err := jf.makeJobs()
+	if err != nil {
+		return plugin.Plugin{}, err
+	}
+
+	return plugin.Plugin{Jobs: js, Endpoints: len(jf.servers)}, nil
+}
+
+// jobFactory provides a common context to the jobs checking each gRPC server.
+type jobFactory struct {
+	config    config
+	tlsConfig *tlscommon.TLSConfig
+	servers   []*url.URL
+	resolver  monitors.Resolver
+
+	// callService and callMethod are the names of the invoked method.
+	callService, callMethod string
+	callChecks              []compiledCheck
+}
+
+// compiledCheck is a JSON check of the response of the invoked method.
+type compiledCheck struct {
+	description string
+	source      string
+	eval        gval.Evaluable
+}
+
+func newJobFactory(commonCfg *conf.C, resolver monitors.Resolver) (*jobFactory, error) {
+	jf := &jobFactory{config: defaultConfig(), resolver: resolver}
+	err := jf.loadConfig(commonCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return jf, nil
+}
+
+// loadConfig parses the YAML config and populates the jobFactory fields.
+func (jf *jobFactory) loadConfig(commonCfg *conf.C) error {
+	var err error
+	if err = commonCfg.Unpack(&jf.config); err != nil {
+		return err
+	}
+
+	jf.tlsConfig, err = tlscommon.LoadTLSConfig(jf.config.TLS)
+	if err != nil {
+		return err
+	}
+
+	defaultScheme := schemePlain
+	if jf.tlsConfig != nil {
+		defaultScheme = schemeTLS
+	}
+
+	for _, host := range jf.config.Hosts {
+		u, err := makeServerURL(host, defaultScheme)
+		if err != nil {
+			return err
+		}
+		jf.servers = append(jf.servers, u)
+
+		if u.Scheme == schemeTLS && jf.tlsConfig == nil {
+			// grpcs servers are checked with the default TLS settings
+			// if ssl is not configured.
+			jf.tlsConfig, err = tlscommon.LoadTLSConfig(&tlscommon.Config{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if call := jf.config.Call; call != nil {
+		jf.callService, jf.callMethod, err = splitMethod(call.Method)
+		if err != nil {
+			return err
+		}
+
+		for _, check := range call.Check.JSON {
+			eval, err := gval.Full(jsonpath.PlaceholderExtension()).NewEvaluable(check.Expression)
+			if err != nil {
+				return fmt.Errorf("could not compile gval expression '%s': %w", check.Expression, err)
+			}
+			jf.callChecks = append(jf.callChecks, compiledCheck{
+				description: check.Description,
+				source:      check.Expression,
+				eval:        eval,
+			})
+		}
+	}
+
+	return nil
+}
+
+// makeServerURL parses a gRPC server given as host:port or
+// scheme://host:port, the scheme being grpc or grpcs.
+func makeServerURL(host, defaultScheme string) (*url.URL, error) {
+	u, err := url.Parse(host)
+	// host:port is parsed as scheme:opaque.
+	if err != nil || u.Host == "" {
+		u = &url.URL{Scheme: defaultScheme, Host: host}
+	}
+
+	switch u.Scheme {
+	case schemePlain, schemeTLS:
+	default:
+		return nil, fmt.Errorf("'%s' is not a supported scheme in '%s', supported schemes are grpc and grpcs", u.Scheme, host)
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("could not parse gRPC server '%s'", host)
+	}
+	if port, err := strconv.ParseUint(u.Port(), 10, 16); err != nil || port == 0 {
+		return nil, fmt.Errorf("gRPC server '%s' missing port number", host)
+	}
+
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+// makeJobs returns the actual schedulable jobs for this monitor.
+func (jf *jobFactory) makeJobs() ([]jobs.Job, error) {
+	var js []jobs.Job
+	for _, server := range jf.servers {
+		job, err := jf.makeServerJob(server)
+		if err != nil {
+			return nil, err
+		}
+		js = append(js, wraputil.WithURLField(server, job))
+	}
+
+	return js, nil
+}
+
+// makeServerJob makes a job checking a single server.
+func (jf *jobFactory) makeServerJob(server *url.URL) (jobs.Job, error) {
+	// Rely on the SOCKS5 proxy to resolve the IP of the server, unless
+	// asked to resolve it locally.
+	if jf.config.Socks5.URL != "" && !jf.config.Socks5.LocalResolve {
+		return jobs.MakeSimpleJob(func(event *beat.Event) error {
+			return jf.check(event, server.Host, server)
+		}), nil
+	}
+
+	return monitors.MakeByHostJob(
+		server.Hostname(),
+		jf.config.Mode,
+		jf.resolver,
+		monitors.MakePingIPFactory(
+			func(event *beat.Event, ip *net.IPAddr) error {
+				// use address from resolved IP
+				ipPort := net.JoinHostPort(ip.String(), server.Port())
+
+				return jf.check(event, ipPort, server)
+			}))
+}
+
+// check connects to the server at dialAddr and runs the health check and
+// the call of the monitor. server is the configured URL of the server, its
+// hostname being used to validate the TLS certificates.
+func (jf *jobFactory) check(event *beat.Event, dialAddr string, server *url.URL) error {
+	dc := &dialchain.DialerChain{
+		Net: dialchain.CreateNetDialer(jf.config.Timeout),
+	}
+	if jf.config.Socks5.URL != "" {
+		dc.AddLayer(dialchain.SOCKS5Layer(&jf.config.Socks5))
+	}
+	dc.AddLayer(dialchain.ConstAddrLayer(dialAddr))
+	if server.Scheme == schemeTLS {
+		// Make the TLS layer validate the certificates against the hostname
+		// of the server instead of the resolved IP.
+		dc.AddLayer(dialchain.ALPNTLSLayer(jf.tlsConfig, jf.config.Timeout, []string{"h2"}))
+		dc.AddLayer(dialchain.ConstAddrLayer(server.Host))
+	}
+
+	dialer, err := dc.Build(event)
+	if err != nil {
+		return err
+	}
+
+	conn, err := dialer.Dial("tcp", dialAddr)
+	if err != nil {
+		debugf("dial failed with: %v", err)
+		var certErr x509.CertificateInvalidError
+		if errors.As(err, &certErr) {
+			tlsFields := tlsmeta.CertFields(certErr.Cert, nil)
+			event.Fields.DeepUpdate(mapstr.M{"tls": tlsFields})
+		}
+		return reason.IOFailed(err)
+	}
+
+	// The TLS layer already secured the connection, so the client is
+	// given the dialed connection as is.
+	client, err := grpc.NewClient(
+		"passthrough:///"+server.Host,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(singleConnDialer(conn)),
+		grpc.WithUserAgent("Elastic-Heartbeat"),
+	)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), jf.config.Timeout)
+	defer cancel()
+	if len(jf.config.Metadata) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(jf.config.Metadata))
+	}
+
+	if jf.config.Health.Enabled {
+		if err := jf.checkHealth(ctx, event, client); err != nil {
+			return err
+		}
+	}
+
+	if jf.config.Call != nil {
+		return jf.invoke(ctx, event, client)
+	}
+
+	return nil
+}
+
+// singleConnDialer hands the connection dialed through the dialer chain to
+// the gRPC client, failing any attempt of the client to reconnect.
+func singleConnDialer(conn net.Conn) func(context.Context, string) (net.Conn, error) {
+	var used atomic.Bool
+	return func(context.Context, string) (net.Conn, error) {
+		if used.Swap(true) {
+			return nil, errors.New("connection to the server was closed")
+		}
+		return conn, nil
+	}
+}
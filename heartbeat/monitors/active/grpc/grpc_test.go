@@ -0,0 +1,320 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/go-lookslike"
+	"github.com/elastic/go-lookslike/testslike"
+	"github.com/elastic/go-lookslike/validator"
+
+	"github.com/elastic/beats/v7/heartbeat/ecserr"
+	"github.com/elastic/beats/v7/heartbeat/hbtest"
+	"github.com/elastic/beats/v7/heartbeat/hbtestllext"
+)
+
+// testService answers the unary calls with the username of the request
+// metadata.
+type testService struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (testService) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	if s := req.GetResponseStatus(); s != nil {
+		return nil, status.Error(codes.Code(s.GetCode()), s.GetMessage())
+	}
+
+	resp := &testpb.SimpleResponse{ServerId: "test-server"}
+	if md, ok := metadata.FromIncomingContext(ctx); ok && req.GetFillUsername() {
+		if users := md.Get("username"); len(users) > 0 {
+			resp.Username = users[0]
+		}
+	}
+	return resp, nil
+}
+
+// startServer starts a gRPC server with the health, reflection and test
+// services, returning its address.
+func startServer(t *testing.T, opts ...grpc.ServerOption) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer(opts...)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("grpc.testing.TestService", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("down.Service", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	testpb.RegisterTestServiceServer(server, testService{})
+	reflection.Register(server)
+
+	go func() { _ = server.Serve(l) }()
+	t.Cleanup(server.Stop)
+
+	return l.Addr().String()
+}
+
+func TestHealthUp(t *testing.T) {
+	addr := startServer(t)
+
+	for _, method := range []string{healthMethodCheck, healthMethodWatch} {
+		t.Run(method, func(t *testing.T) {
+			event := hbtest.RunMonitor(t, create, "grpc", mapstr.M{
+				"hosts":   addr,
+				"timeout": "1s",
+				"health":  mapstr.M{"service": "grpc.testing.TestService", "method": method},
+			})
+
+			testslike.Test(t, lookslike.Compose(
+				hbtest.BaseChecks("127.0.0.1", "up", "grpc"),
+				hbtest.SummaryStateChecks(1, 0),
+				lookslike.MustCompile(mapstr.M{
+					"url.full": "grpc://" + addr,
+					"grpc": mapstr.M{
+						"health": mapstr.M{
+							"service":     "grpc.testing.TestService",
+							"status":      "SERVING",
+							"status_code": "OK",
+						},
+						"rtt.health.us": hbtestllext.IsInt64,
+					},
+					"tcp.rtt.connect.us": hbtestllext.IsInt64,
+				}),
+			), event.Fields)
+			assert.NotContains(t, event.Fields, "error")
+		})
+	}
+}
+
+func TestTLS(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(nil)
+	defer tlsServer.Close()
+	certFile := hbtest.CertToTempFile(t, tlsServer.Certificate())
+	defer os.Remove(certFile.Name())
+
+	creds := credentials.NewTLS(&tls.Config{Certificates: tlsServer.TLS.Certificates})
+	addr := startServer(t, grpc.Creds(creds))
+
+	event := hbtest.RunMonitor(t, create, "grpc", mapstr.M{
+		"hosts":   addr,
+		"timeout": "1s",
+		"ssl":     mapstr.M{"certificate_authorities": certFile.Name()},
+		"call": mapstr.M{
+			"method": "grpc.testing.TestService/UnaryCall",
+		},
+	})
+
+	testslike.Test(t, lookslike.Compose(
+		hbtest.BaseChecks("127.0.0.1", "up", "grpc"),
+		hbtest.SummaryStateChecks(1, 0),
+		hbtest.TLSChecks(0, 0, tlsServer.Certificate()),
+		lookslike.MustCompile(mapstr.M{
+			"url.full":              "grpcs://" + addr,
+			"grpc.health":           mapstr.M{"service": "", "status": "SERVING"},
+			"grpc.call.method":      "/grpc.testing.TestService/UnaryCall",
+			"grpc.rtt.call.us":      hbtestllext.IsInt64,
+			"grpc.rtt.health":       mapstr.M{"us": hbtestllext.IsInt64},
+			"tcp.rtt.connect":       mapstr.M{"us": hbtestllext.IsInt64},
+			"grpc.call.status_code": "OK",
+		}),
+	), event.Fields)
+}
+
+func TestChecks(t *testing.T) {
+	addr := startServer(t)
+
+	tests := map[string]struct {
+		cfg     mapstr.M
+		status  string
+		errType string
+		errMsg  string
+	}{
+		"not serving": {
+			cfg:     mapstr.M{"health.service": "down.Service"},
+			status:  "down",
+			errType: "validate",
+			errMsg:  "service 'down.Service' is NOT_SERVING, expecting SERVING",
+		},
+		"expected not serving": {
+			cfg:    mapstr.M{"health.service": "down.Service", "health.status": "not_serving"},
+			status: "up",
+		},
+		"unknown service": {
+			cfg:     mapstr.M{"health.service": "unknown.Service"},
+			status:  "down",
+			errType: "validate",
+			errMsg:  "rpc error: code = NotFound desc = unknown service",
+		},
+		"watch unknown service": {
+			cfg:     mapstr.M{"health.service": "unknown.Service", "health.method": "watch"},
+			status:  "down",
+			errType: "validate",
+			errMsg:  "service 'unknown.Service' is SERVICE_UNKNOWN, expecting SERVING",
+		},
+		"call matches": {
+			cfg: mapstr.M{
+				"metadata": mapstr.M{"username": "heartbeat"},
+				"call": mapstr.M{
+					"method":  "grpc.testing.TestService/UnaryCall",
+					"request": `{"fillUsername": true}`,
+					"check.json": []mapstr.M{
+						{"description": "username", "expression": `$.username == "heartbeat"`},
+						{"description": "server", "expression": `$.serverId == "test-server"`},
+					},
+				},
+			},
+			status: "up",
+		},
+		"call does not match": {
+			cfg: mapstr.M{
+				"call": mapstr.M{
+					"method": "grpc.testing.TestService/UnaryCall",
+					"check.json": []mapstr.M{
+						{"description": "username", "expression": `$.username == "heartbeat"`},
+					},
+				},
+			},
+			status:  "down",
+			errType: "validate",
+			errMsg:  `JSON response did not match 1 expressions 'rule 'username'($.username == "heartbeat") not matched.'. Received JSON: {"serverId":"test-server"}`,
+		},
+		"call error": {
+			cfg: mapstr.M{
+				"call": mapstr.M{
+					"method":  "grpc.testing.TestService/UnaryCall",
+					"request": `{"responseStatus": {"code": 7, "message": "denied"}}`,
+				},
+			},
+			status:  "down",
+			errType: "validate",
+			errMsg:  "rpc error: code = PermissionDenied desc = denied",
+		},
+		"invalid request": {
+			cfg: mapstr.M{
+				"call": mapstr.M{
+					"method":  "grpc.testing.TestService/UnaryCall",
+					"request": `{"bogus": 1}`,
+				},
+			},
+			status:  "down",
+			errType: "validate",
+		},
+		"unknown method": {
+			cfg:     mapstr.M{"call.method": "grpc.testing.TestService/Bogus"},
+			status:  "down",
+			errType: "validate",
+			errMsg:  "service 'grpc.testing.TestService' has no method 'Bogus'",
+		},
+		"streaming method": {
+			cfg:     mapstr.M{"call.method": "grpc.testing.TestService/FullDuplexCall"},
+			status:  "down",
+			errType: "validate",
+			errMsg:  "method 'grpc.testing.TestService/FullDuplexCall' is a streaming method, only unary methods are supported",
+		},
+		"call without health": {
+			cfg: mapstr.M{
+				"health.enabled": false,
+				"call.method":    "grpc.testing.TestService/UnaryCall",
+			},
+			status: "up",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := mapstr.M{"hosts": addr, "timeout": "1s"}
+			cfg.DeepUpdate(tc.cfg)
+			event := hbtest.RunMonitor(t, create, "grpc", cfg)
+
+			validators := []validator.Validator{
+				hbtest.BaseChecks("127.0.0.1", tc.status, "grpc"),
+			}
+			if tc.errMsg != "" {
+				validators = append(validators, hbtest.ErrorChecks(tc.errMsg, tc.errType))
+			} else if tc.errType != "" {
+				validators = append(validators, lookslike.MustCompile(mapstr.M{"error.type": tc.errType}))
+			}
+			testslike.Test(t, lookslike.Compose(validators...), event.Fields)
+		})
+	}
+}
+
+func TestConnectionRefused(t *testing.T) {
+	addr := hbtest.ClosedAddr(t)
+	event := hbtest.RunMonitor(t, create, "grpc", mapstr.M{"hosts": addr, "timeout": "1s"})
+	testslike.Test(t, lookslike.Compose(
+		hbtest.BaseChecks("127.0.0.1", "down", "grpc"),
+		hbtest.SummaryStateChecks(0, 1),
+		hbtest.ECSErrCodeChecks(ecserr.CODE_NET_COULD_NOT_CONNECT, "connection refused"),
+	), event.Fields)
+}
+
+func TestMakeServerURL(t *testing.T) {
+	tests := []struct {
+		host, scheme string
+		want         string
+	}{
+		{"localhost:50051", "grpc", "grpc://localhost:50051"},
+		{"localhost:443", "grpcs", "grpcs://localhost:443"},
+		{"grpcs://10.0.0.1:8443", "grpc", "grpcs://10.0.0.1:8443"},
+		{"grpc://[2001:db8::1]:50051", "grpcs", "grpc://[2001:db8::1]:50051"},
+	}
+	for _, tc := range tests {
+		u, err := makeServerURL(tc.host, tc.scheme)
+		if assert.NoError(t, err, tc.host) {
+			assert.Equal(t, tc.want, u.String(), tc.host)
+		}
+	}
+
+	for _, host := range []string{"localhost", "https://localhost:443", "localhost:", "localhost:99999"} {
+		_, err := makeServerURL(host, "grpc")
+		assert.Error(t, err, host)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	hbtest.InvalidConfigs(t, create, "grpc", []mapstr.M{
+		{"health.service": "svc"},
+		{"hosts": "localhost:50051", "health.method": "poll"},
+		{"hosts": "localhost:50051", "health.status": "BOGUS"},
+		{"hosts": "localhost:50051", "health.enabled": false},
+		{"hosts": "localhost:50051", "call.method": "NoMethod"},
+		{"hosts": "localhost:50051", "call.method": "svc/"},
+		{"hosts": "localhost:50051", "call": mapstr.M{"method": "svc/M", "check.json": []mapstr.M{{"expression": "$.a =="}}}},
+	})
+}
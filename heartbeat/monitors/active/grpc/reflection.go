@@ -0,0 +1,179 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// reflectionV1AlphaMethod is the reflection RPC of the servers predating the
+// v1 version of the reflection service. Both versions use the same messages
+// on the wire, so the v1 messages are used with either.
+const reflectionV1AlphaMethod = "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo"
+
+var reflectionStreamDesc = &grpc.StreamDesc{
+	StreamName:    "ServerReflectionInfo",
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// resolveMethod looks up the descriptor of a unary method with the server
+// reflection service of the server.
+func resolveMethod(ctx context.Context, client *grpc.ClientConn, service, method string) (protoreflect.MethodDescriptor, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	symbolReq := &reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service},
+	}
+	stream, resp, err := openReflection(ctx, client, reflectionpb.ServerReflection_ServerReflectionInfo_FullMethodName, symbolReq)
+	if status.Code(err) == codes.Unimplemented {
+		stream, resp, err = openReflection(ctx, client, reflectionV1AlphaMethod, symbolReq)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]*descriptorpb.FileDescriptorProto{}
+	if err := addFiles(files, resp); err != nil {
+		return nil, err
+	}
+
+	// The servers send the dependencies of a file along with it, unless
+	// they were already sent on the stream, but don't have to.
+	for missing := missingDeps(files); len(missing) > 0; missing = missingDeps(files) {
+		for _, name := range missing {
+			resp, err := reflect(stream, &reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := addFiles(files, resp); err != nil {
+				return nil, err
+			}
+			if _, ok := files[name]; !ok {
+				return nil, fmt.Errorf("server reflection did not return file '%s'", name)
+			}
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range files {
+		set.File = append(set.File, fd)
+	}
+	registry, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptors returned by server reflection: %w", err)
+	}
+
+	desc, err := registry.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service '%s' not found: %w", service, err)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("service '%s' has no method '%s'", service, method)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("method '%s/%s' is a streaming method, only unary methods are supported", service, method)
+	}
+
+	return md, nil
+}
+
+// openReflection opens a reflection stream and sends it the first request.
+// Servers without the reflection service only fail on the first response.
+func openReflection(
+	ctx context.Context,
+	client *grpc.ClientConn,
+	fullMethod string,
+	req *reflectionpb.ServerReflectionRequest,
+) (grpc.ClientStream, *reflectionpb.ServerReflectionResponse, error) {
+	stream, err := client.NewStream(ctx, reflectionStreamDesc, fullMethod)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := reflect(stream, req)
+	return stream, resp, err
+}
+
+// reflect sends a request on the reflection stream and reads its response.
+func reflect(stream grpc.ClientStream, req *reflectionpb.ServerReflectionRequest) (*reflectionpb.ServerReflectionResponse, error) {
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+
+	resp := &reflectionpb.ServerReflectionResponse{}
+	if err := stream.RecvMsg(resp); err != nil {
+		return nil, err
+	}
+	if errResp := resp.GetErrorResponse(); errResp != nil {
+		return nil, status.Error(codes.Code(errResp.GetErrorCode()), errResp.GetErrorMessage())
+	}
+
+	return resp, nil
+}
+
+// addFiles adds the file descriptors of a reflection response to files.
+func addFiles(files map[string]*descriptorpb.FileDescriptorProto, resp *reflectionpb.ServerReflectionResponse) error {
+	fdResp := resp.GetFileDescriptorResponse()
+	if fdResp == nil {
+		return errors.New("server reflection did not return file descriptors")
+	}
+
+	for _, raw := range fdResp.GetFileDescriptorProto() {
+		fd := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(raw, fd); err != nil {
+			return fmt.Errorf("invalid file descriptor returned by server reflection: %w", err)
+		}
+		files[fd.GetName()] = fd
+	}
+
+	return nil
+}
+
+// missingDeps returns the dependencies of the files not in files yet.
+func missingDeps(files map[string]*descriptorpb.FileDescriptorProto) []string {
+	var missing []string
+	seen := map[string]bool{}
+	for _, fd := range files {
+		for _, dep := range fd.GetDependency() {
+			if _, ok := files[dep]; !ok && !seen[dep] {
+				seen[dep] = true
+				missing = append(missing, dep)
+			}
+		}
+	}
+	return missing
+}
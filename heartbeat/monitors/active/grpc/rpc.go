@@ -0,0 +1,180 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/elastic/beats/v7/heartbeat/eventext"
+	"github.com/elastic/beats/v7/heartbeat/look"
+	"github.com/elastic/beats/v7/heartbeat/reason"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// checkHealth calls the health service of the server and checks the
+// serving status of the configured service.
+func (jf *jobFactory) checkHealth(ctx context.Context, event *beat.Event, client *grpc.ClientConn) error {
+	cfg := &jf.config.Health
+	eventext.MergeEventFields(event, mapstr.M{
+		"grpc": mapstr.M{"health": mapstr.M{"service": cfg.Service}},
+	})
+
+	start := time.Now()
+	resp, err := servingStatus(ctx, healthpb.NewHealthClient(client), cfg)
+	eventext.MergeEventFields(event, mapstr.M{
+		"grpc": mapstr.M{
+			"health": mapstr.M{"status_code": status.Code(err).String()},
+			"rtt":    mapstr.M{"health": look.RTT(time.Since(start))},
+		},
+	})
+	if err != nil {
+		debugf("health check failed with: %v", err)
+		return rpcFailed(err)
+	}
+
+	got := resp.GetStatus().String()
+	eventext.MergeEventFields(event, mapstr.M{
+		"grpc": mapstr.M{"health": mapstr.M{"status": got}},
+	})
+	if got != cfg.Status {
+		return reason.ValidateFailed(fmt.Errorf("service '%s' is %s, expecting %s", cfg.Service, got, cfg.Status))
+	}
+
+	return nil
+}
+
+// servingStatus gets the serving status of the service with the Check or the Watch
+// RPC. Watch streams the changes of the status, starting with the current
+// one, so only its first response is read.
+func servingStatus(ctx context.Context, client healthpb.HealthClient, cfg *healthConfig) (*healthpb.HealthCheckResponse, error) {
+	req := &healthpb.HealthCheckRequest{Service: cfg.Service}
+	if cfg.Method == healthMethodCheck {
+		return client.Check(ctx, req)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.Watch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return stream.Recv()
+}
+
+// invoke calls the configured unary method, encoding the request and
+// decoding the response with the descriptors of the server reflection
+// service, and checks the response.
+func (jf *jobFactory) invoke(ctx context.Context, event *beat.Event, client *grpc.ClientConn) error {
+	cfg := jf.config.Call
+	fullMethod := "/" + jf.callService + "/" + jf.callMethod
+	eventext.MergeEventFields(event, mapstr.M{
+		"grpc": mapstr.M{"call": mapstr.M{"method": fullMethod}},
+	})
+
+	md, err := resolveMethod(ctx, client, jf.callService, jf.callMethod)
+	if err != nil {
+		debugf("method lookup failed with: %v", err)
+		if _, ok := status.FromError(err); ok {
+			return rpcFailed(fmt.Errorf("could not resolve method '%s' with server reflection: %w", fullMethod, err))
+		}
+		return reason.ValidateFailed(err)
+	}
+
+	req := dynamicpb.NewMessage(md.Input())
+	if cfg.Request != "" {
+		if err := protojson.Unmarshal([]byte(cfg.Request), req); err != nil {
+			return reason.ValidateFailed(fmt.Errorf("could not encode request of method '%s': %w", fullMethod, err))
+		}
+	}
+	resp := dynamicpb.NewMessage(md.Output())
+
+	start := time.Now()
+	err = client.Invoke(ctx, fullMethod, req, resp)
+	eventext.MergeEventFields(event, mapstr.M{
+		"grpc": mapstr.M{
+			"call": mapstr.M{"status_code": status.Code(err).String()},
+			"rtt":  mapstr.M{"call": look.RTT(time.Since(start))},
+		},
+	})
+	if err != nil {
+		debugf("call failed with: %v", err)
+		return rpcFailed(err)
+	}
+
+	if len(jf.callChecks) == 0 {
+		return nil
+	}
+
+	body, err := protojson.Marshal(resp)
+	if err != nil {
+		return reason.ValidateFailed(fmt.Errorf("could not decode response of method '%s': %w", fullMethod, err))
+	}
+	if err := jf.checkResponse(ctx, body); err != nil {
+		return reason.MakeValidateError(err)
+	}
+
+	return nil
+}
+
+// checkResponse runs the JSON checks against the JSON encoded response.
+func (jf *jobFactory) checkResponse(ctx context.Context, body []byte) error {
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return fmt.Errorf("could not parse JSON: %w", err)
+	}
+
+	var failures []string
+	for _, check := range jf.callChecks {
+		if ok, err := check.eval.EvalBool(ctx, decoded); err != nil || !ok {
+			failures = append(failures, fmt.Sprintf("rule '%s'(%s) not matched.", check.description, check.source))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf(
+			"JSON response did not match %d expressions '%s'. Received JSON: %s",
+			len(failures),
+			strings.Join(failures, ","),
+			body,
+		)
+	}
+
+	return nil
+}
+
+// rpcFailed reports a failed RPC, as an IO failure if the server could not
+// be reached in time, and as a validation failure otherwise.
+func rpcFailed(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+		return reason.IOFailed(err)
+	default:
+		return reason.ValidateFailed(err)
+	}
+}
@@ -0,0 +1,346 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/elastic/beats/v7/heartbeat/eventext"
+	"github.com/elastic/beats/v7/heartbeat/monitors/jobs"
+	"github.com/elastic/beats/v7/heartbeat/monitors/plugin"
+	"github.com/elastic/beats/v7/heartbeat/monitors/wrappers/wraputil"
+	"github.com/elastic/beats/v7/heartbeat/reason"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// createSteps makes a new HTTP steps monitor, running a sequence of
+// requests sharing the variables extracted from the responses.
+func createSteps(
+	name string,
+	cfg *conf.C,
+) (p plugin.Plugin, err error) {
+	config := defaultStepsConfig()
+	if err := cfg.Unpack(&config); err != nil {
+		return plugin.Plugin{}, err
+	}
+
+	transport, err := newRoundTripper(&config.Transport)
+	if err != nil {
+		return plugin.Plugin{}, err
+	}
+
+	steps := make([]*step, len(config.Steps))
+	for i := range config.Steps {
+		steps[i], err = newStep(i, &config.Steps[i])
+		if err != nil {
+			return plugin.Plugin{}, fmt.Errorf("invalid step '%s': %w", config.Steps[i].Name, err)
+		}
+	}
+
+	sf := &stepsFactory{config: &config, transport: transport, steps: steps}
+	return plugin.Plugin{Jobs: []jobs.Job{sf.makeJob()}, Endpoints: 1}, nil
+}
+
+type stepsFactory struct {
+	config    *stepsConfig
+	transport http.RoundTripper
+	steps     []*step
+}
+
+// step is a compiled step of the monitor.
+type step struct {
+	index     int
+	config    *stepConfig
+	url       *template.Template
+	headers   map[string]*template.Template
+	body      *template.Template
+	extract   []extractor
+	validator multiValidator
+}
+
+type extractor struct {
+	config *extractConfig
+	regex  *regexp.Regexp
+}
+
+func newStep(index int, config *stepConfig) (*step, error) {
+	s := &step{index: index, config: config, headers: map[string]*template.Template{}}
+
+	var err error
+	if s.url, err = parseTemplate("url", config.Request.URL); err != nil {
+		return nil, err
+	}
+	for k, v := range config.Request.Headers {
+		if s.headers[k], err = parseTemplate("header "+k, v); err != nil {
+			return nil, err
+		}
+	}
+	if s.body, err = parseTemplate("body", config.Request.Body); err != nil {
+		return nil, err
+	}
+
+	for i := range config.Extract {
+		e := extractor{config: &config.Extract[i]}
+		if e.config.Regex != "" {
+			e.regex = regexp.MustCompile(e.config.Regex)
+		}
+		s.extract = append(s.extract, e)
+	}
+
+	if s.validator, err = makeValidateResponse(&config.Check.Response); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template in %s: %w", name, err)
+	}
+	return t, nil
+}
+
+func render(t *template.Template, vars map[string]string) (string, error) {
+	var buf strings.Builder
+	if err := t.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// makeJob returns the job running the first step, each step continuing with
+// the next one until a step fails.
+func (sf *stepsFactory) makeJob() jobs.Job {
+	return func(event *beat.Event) ([]jobs.Job, error) {
+		vars := make(map[string]string, len(sf.config.Variables))
+		for k, v := range sf.config.Variables {
+			vars[k] = v
+		}
+		// Cookies are kept between the steps of a run, like in a browser session.
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return sf.stepJob(0, vars, jar)(event)
+	}
+}
+
+func (sf *stepsFactory) stepJob(i int, vars map[string]string, jar http.CookieJar) jobs.Job {
+	return func(event *beat.Event) ([]jobs.Job, error) {
+		err := sf.execStep(event, sf.steps[i], vars, jar)
+		if err != nil || i == len(sf.steps)-1 {
+			return nil, err
+		}
+		return []jobs.Job{sf.stepJob(i+1, vars, jar)}, nil
+	}
+}
+
+// execStep sends the request of the step, checks the response and extracts
+// the variables into vars.
+func (sf *stepsFactory) execStep(event *beat.Event, s *step, vars map[string]string, jar http.CookieJar) error {
+	eventext.MergeEventFields(event, mapstr.M{
+		"http_steps": mapstr.M{
+			"step": mapstr.M{
+				"index": s.index + 1,
+				"name":  s.config.Name,
+			},
+		},
+	})
+
+	// The request of the step, including reading the response, is bounded
+	// by the monitor timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), sf.config.Transport.Timeout)
+	defer cancel()
+
+	req, body, err := s.buildRequest(ctx, vars)
+	if err != nil {
+		return reason.ValidateFailed(fmt.Errorf("could not make http request: %w", err))
+	}
+	eventext.MergeEventFields(event, mapstr.M{
+		"url":  wraputil.URLFields(req.URL),
+		"http": mapstr.M{"request": mapstr.M{"method": req.Method}},
+	})
+
+	var redirects []string
+	client := &http.Client{
+		CheckRedirect: makeCheckRedirect(sf.config.MaxRedirects, &redirects),
+		Transport:     sf.transport,
+		Jar:           jar,
+		Timeout:       sf.config.Transport.Timeout,
+	}
+
+	// The extractors run as the last body validator, after the checks of
+	// the step passed.
+	extracted := map[string]string{}
+	validator := s.validator
+	if len(s.extract) > 0 {
+		validator.bodyValidators = append(append([]bodyValidator(nil), s.validator.bodyValidators...), s.extractValidator(extracted))
+	}
+
+	_, err = execPing(event, client, req, body, sf.config.Transport.Timeout, validator, sf.config.Response)
+	if len(redirects) > 0 {
+		eventext.MergeEventFields(event, mapstr.M{"http": mapstr.M{"response": mapstr.M{"redirects": redirects}}})
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(extracted) > 0 {
+		names := make([]string, 0, len(extracted))
+		for _, e := range s.extract {
+			names = append(names, e.config.Name)
+			vars[e.config.Name] = extracted[e.config.Name]
+		}
+		eventext.MergeEventFields(event, mapstr.M{"http_steps": mapstr.M{"extracted": names}})
+	}
+
+	return nil
+}
+
+// buildRequest renders the request of the step with the variables.
+func (s *step) buildRequest(ctx context.Context, vars map[string]string) (*http.Request, []byte, error) {
+	rawURL, err := render(s.url, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := render(s.body, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, s.config.Request.Method, rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Close = true
+
+	for k, t := range s.headers {
+		v, err := render(t, vars)
+		if err != nil {
+			return nil, nil, err
+		}
+		// defining the Host header isn't enough. See https://github.com/golang/go/issues/7682
+		if k == "Host" {
+			req.Host = v
+		}
+		req.Header.Add(k, v)
+	}
+
+	return req, []byte(body), nil
+}
+
+// extractValidator returns a body validator extracting the variables of
+// the step into extracted.
+func (s *step) extractValidator(extracted map[string]string) bodyValidator {
+	return func(resp *http.Response, body string) error {
+		var decoded interface{}
+		for _, e := range s.extract {
+			var value string
+			switch {
+			case e.config.JSON != "":
+				if decoded == nil {
+					dec := json.NewDecoder(strings.NewReader(body))
+					dec.UseNumber()
+					if err := dec.Decode(&decoded); err != nil {
+						return fmt.Errorf("could not extract '%s': could not parse JSON body: %w", e.config.Name, err)
+					}
+				}
+				v, err := jsonPath(decoded, e.config.JSON)
+				if err != nil {
+					return fmt.Errorf("could not extract '%s': %w", e.config.Name, err)
+				}
+				value = v
+			case e.config.Header != "":
+				if _, ok := resp.Header[http.CanonicalHeaderKey(e.config.Header)]; !ok {
+					return fmt.Errorf("could not extract '%s': header '%s' not found", e.config.Name, e.config.Header)
+				}
+				value = resp.Header.Get(e.config.Header)
+			default:
+				value = body
+			}
+
+			if e.regex != nil {
+				m := e.regex.FindStringSubmatch(value)
+				if m == nil {
+					return fmt.Errorf("could not extract '%s': regex '%s' did not match", e.config.Name, e.config.Regex)
+				}
+				// Use the first capture group, if any.
+				value = m[0]
+				if len(m) > 1 {
+					value = m[1]
+				}
+			}
+
+			extracted[e.config.Name] = value
+		}
+		return nil
+	}
+}
+
+// jsonPath returns the value at the dotted path in a decoded JSON document.
+// Array elements are selected by their index.
+func jsonPath(doc interface{}, path string) (string, error) {
+	v := doc
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			child, ok := node[key]
+			if !ok {
+				return "", fmt.Errorf("key '%s' of '%s' not found", key, path)
+			}
+			v = child
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("index '%s' of '%s' not found", key, path)
+			}
+			v = node[i]
+		default:
+			return "", fmt.Errorf("key '%s' of '%s' not found", key, path)
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", fmt.Errorf("'%s' is null", path)
+	case map[string]interface{}, []interface{}:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		_ = enc.Encode(v)
+		return strings.TrimSuffix(buf.String(), "\n"), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
@@ -0,0 +1,133 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
+)
+
+// variableName matches the names of the variables usable in the templates.
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type stepsConfig struct {
+	Steps        []stepConfig      `config:"steps" validate:"required"`
+	Variables    map[string]string `config:"variables"`
+	MaxRedirects int               `config:"max_redirects"`
+	Response     responseConfig    `config:"response"`
+
+	Transport httpcommon.HTTPTransportSettings `config:",inline"`
+}
+
+type stepConfig struct {
+	Name    string            `config:"name" validate:"required"`
+	Request stepRequestConfig `config:"request"`
+	Extract []extractConfig   `config:"extract"`
+	Check   stepCheckConfig   `config:"check"`
+}
+
+type stepRequestConfig struct {
+	Method  string            `config:"method"`
+	URL     string            `config:"url" validate:"required"`
+	Headers map[string]string `config:"headers"`
+	Body    string            `config:"body"`
+}
+
+type stepCheckConfig struct {
+	Response responseParameters `config:"response"`
+}
+
+// extractConfig extracts a variable from the response of a step, either
+// from a JSON body, a header or the body matched by a regular expression.
+type extractConfig struct {
+	Name   string `config:"name" validate:"required"`
+	JSON   string `config:"json"`
+	Header string `config:"header"`
+	Regex  string `config:"regex"`
+}
+
+func defaultStepsConfig() stepsConfig {
+	cfg := stepsConfig{
+		MaxRedirects: 0,
+		Response: responseConfig{
+			IncludeBody:         "on_error",
+			IncludeBodyMaxBytes: 2048,
+			IncludeHeaders:      true,
+		},
+		Transport: httpcommon.DefaultHTTPTransportSettings(),
+	}
+	cfg.Transport.Timeout = 16 * time.Second
+
+	return cfg
+}
+
+// Validate validates of the stepsConfig object is valid or not
+func (c *stepsConfig) Validate() error {
+	for name := range c.Variables {
+		if !variableName.MatchString(name) {
+			return fmt.Errorf("invalid variable name '%s', names must be letters, digits and underscores", name)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, step := range c.Steps {
+		if names[step.Name] {
+			return fmt.Errorf("duplicate step name '%s'", step.Name)
+		}
+		names[step.Name] = true
+	}
+
+	return nil
+}
+
+// Validate validates of the stepRequestConfig object is valid or not
+func (r *stepRequestConfig) Validate() error {
+	if r.Method == "" {
+		r.Method = "GET"
+	}
+	r.Method = strings.ToUpper(r.Method)
+
+	return (&requestParameters{Method: r.Method}).Validate()
+}
+
+// Validate validates of the extractConfig object is valid or not
+func (e *extractConfig) Validate() error {
+	if !variableName.MatchString(e.Name) {
+		return fmt.Errorf("invalid variable name '%s', names must be letters, digits and underscores", e.Name)
+	}
+
+	if e.JSON != "" && e.Header != "" {
+		return fmt.Errorf("only one of 'json' or 'header' can be specified to extract '%s'", e.Name)
+	}
+	if e.JSON == "" && e.Header == "" && e.Regex == "" {
+		return errors.New("one of 'json', 'header' or 'regex' is required to extract '" + e.Name + "'")
+	}
+
+	if e.Regex != "" {
+		if _, err := regexp.Compile(e.Regex); err != nil {
+			return fmt.Errorf("invalid regex to extract '%s': %w", e.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,262 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package http
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/go-lookslike"
+	"github.com/elastic/go-lookslike/testslike"
+	"github.com/elastic/go-lookslike/validator"
+
+	"github.com/elastic/beats/v7/heartbeat/ecserr"
+	"github.com/elastic/beats/v7/heartbeat/hbtest"
+	"github.com/elastic/beats/v7/heartbeat/monitors/jobs"
+	"github.com/elastic/beats/v7/heartbeat/monitors/stdfields"
+	"github.com/elastic/beats/v7/heartbeat/monitors/wrappers"
+	"github.com/elastic/beats/v7/heartbeat/scheduler/schedule"
+	"github.com/elastic/beats/v7/libbeat/beat"
+)
+
+// apiHandler serves a login endpoint issuing a token and a session cookie,
+// and endpoints requiring both.
+func apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || string(body) != `{"user":"alice","password":"secret"}` {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "req-7")
+		_, _ = io.WriteString(w, `{"auth": {"token": "t0k3n", "expires": 3600}, "items": [{"id": 42}]}`)
+	})
+	mux.HandleFunc("/items/", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session"); err != nil || r.Header.Get("Authorization") != "Bearer t0k3n" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "item %s: widget (ref=REF-99)", r.URL.Path[len("/items/"):])
+	})
+	return mux
+}
+
+func runSteps(t *testing.T, cfg mapstr.M) []*beat.Event {
+	t.Helper()
+	p, err := createSteps("http_steps", conf.MustNewConfigFrom(cfg))
+	require.NoError(t, err)
+	require.Equal(t, 1, p.Endpoints)
+
+	sched := schedule.MustParse("@every 1s")
+	js := wrappers.WrapCommon(p.Jobs, stdfields.StdMonitorFields{ID: "test", Type: "http_steps", Schedule: sched, Timeout: 1}, nil)
+
+	events, err := jobs.ExecJobsAndConts(t, js)
+	require.NoError(t, err)
+	return events
+}
+
+func loginSteps(serverURL string) mapstr.M {
+	return mapstr.M{
+		"variables": mapstr.M{"base": serverURL, "user": "alice"},
+		"steps": []mapstr.M{
+			{
+				"name": "login",
+				"request": mapstr.M{
+					"method":  "post",
+					"url":     "{{.base}}/login",
+					"headers": mapstr.M{"Content-Type": "application/json"},
+					"body":    `{"user":"{{.user}}","password":"secret"}`,
+				},
+				"extract": []mapstr.M{
+					{"name": "token", "json": "auth.token"},
+					{"name": "item_id", "json": "items.0.id"},
+					{"name": "request_id", "header": "X-Request-Id", "regex": `req-(\d+)`},
+				},
+				"check.response.json": []mapstr.M{{"description": "expires", "expression": "auth.expires > 0"}},
+			},
+			{
+				"name": "get item",
+				"request": mapstr.M{
+					"url":     "{{.base}}/items/{{.item_id}}?req={{.request_id}}",
+					"headers": mapstr.M{"Authorization": "Bearer {{.token}}"},
+				},
+				"extract":                         []mapstr.M{{"name": "ref", "regex": `ref=([A-Z0-9-]+)`}},
+				"check.response.status":           []int{200},
+				"check.response.body":             "widget",
+				"check.response.headers":          mapstr.M{"Content-Type": "text/plain; charset=utf-8"},
+				"response.include_body":           "always",
+				"response.include_body_max_bytes": 100,
+			},
+		},
+	}
+}
+
+func TestStepsUp(t *testing.T) {
+	server := httptest.NewServer(apiHandler())
+	defer server.Close()
+
+	events := runSteps(t, loginSteps(server.URL))
+	require.Len(t, events, 2)
+
+	testslike.Test(t, lookslike.Compose(
+		lookslike.MustCompile(mapstr.M{
+			"monitor.status": "up",
+			"http_steps": mapstr.M{
+				"step":      mapstr.M{"index": 1, "name": "login"},
+				"extracted": []string{"token", "item_id", "request_id"},
+			},
+			"http.request.method":       "POST",
+			"http.response.status_code": 200,
+			"url.path":                  "/login",
+		}),
+	), events[0].Fields)
+
+	testslike.Test(t, lookslike.Compose(
+		hbtest.BaseChecks("", "up", "http_steps"),
+		hbtest.SummaryStateChecks(2, 0),
+		lookslike.MustCompile(mapstr.M{
+			"http_steps": mapstr.M{
+				"step":      mapstr.M{"index": 2, "name": "get item"},
+				"extracted": []string{"ref"},
+			},
+			"http.request.method":       "GET",
+			"http.response.status_code": 200,
+			"url.path":                  "/items/42",
+			"url.query":                 "req=7",
+		}),
+	), events[1].Fields)
+
+	_, err := events[0].GetValue("summary")
+	assert.Error(t, err, "only the last step has the summary")
+}
+
+func TestStepsDown(t *testing.T) {
+	server := httptest.NewServer(apiHandler())
+	defer server.Close()
+
+	tests := map[string]struct {
+		update func(steps []mapstr.M)
+		events int
+		errors validator.Validator
+	}{
+		"failed check": {
+			update: func(steps []mapstr.M) {
+				steps[0]["request"].(mapstr.M)["body"] = `{"user":"bob"}`
+			},
+			events: 1,
+			errors: hbtest.ECSErrChecks(ecserr.NewBadHTTPStatusErr(401)),
+		},
+		"failed extraction": {
+			update: func(steps []mapstr.M) {
+				steps[0]["extract"] = []mapstr.M{{"name": "token", "json": "auth.missing"}}
+			},
+			events: 1,
+			errors: hbtest.ErrorChecks("could not extract 'token': key 'missing' of 'auth.missing' not found", "validate"),
+		},
+		"missing variable": {
+			update: func(steps []mapstr.M) {
+				steps[0]["extract"] = nil
+			},
+			events: 2,
+			errors: hbtest.ErrorChecks("could not make http request", "validate"),
+		},
+		"failed second step": {
+			update: func(steps []mapstr.M) {
+				steps[1]["check.response.body"] = "gadget"
+			},
+			events: 2,
+			errors: hbtest.ErrorChecks("pattern mismatch", "validate"),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := loginSteps(server.URL)
+			tc.update(cfg["steps"].([]mapstr.M))
+			events := runSteps(t, cfg)
+			require.Len(t, events, tc.events)
+
+			testslike.Test(t, lookslike.Compose(
+				hbtest.BaseChecks("", "down", "http_steps"),
+				hbtest.SummaryStateChecks(uint16(tc.events-1), 1),
+				tc.errors,
+			), events[len(events)-1].Fields)
+		})
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	doc := map[string]interface{}{
+		"a": map[string]interface{}{"b": "c", "n": nil},
+		"l": []interface{}{true, map[string]interface{}{"x": "<y>"}},
+	}
+
+	tests := map[string]string{
+		"a.b": "c",
+		"l.0": "true",
+		"l.1": `{"x":"<y>"}`,
+		"a":   `{"b":"c","n":null}`,
+	}
+	for path, want := range tests {
+		got, err := jsonPath(doc, path)
+		if assert.NoError(t, err, path) {
+			assert.Equal(t, want, got, path)
+		}
+	}
+
+	for _, path := range []string{"a.x", "l.2", "l.x", "a.b.c", "a.n"} {
+		_, err := jsonPath(doc, path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestStepsConfigValidation(t *testing.T) {
+	step := func(extra mapstr.M) mapstr.M {
+		s := mapstr.M{"name": "s", "request.url": "http://localhost"}
+		s.DeepUpdate(extra)
+		return s
+	}
+
+	configs := map[string]mapstr.M{
+		"no steps":          {},
+		"no url":            {"steps": []mapstr.M{{"name": "s"}}},
+		"bad method":        {"steps": []mapstr.M{step(mapstr.M{"request.method": "FETCH"})}},
+		"duplicate names":   {"steps": []mapstr.M{step(nil), step(nil)}},
+		"bad variable name": {"variables": mapstr.M{"a-b": "c"}, "steps": []mapstr.M{step(nil)}},
+		"bad template":      {"steps": []mapstr.M{step(mapstr.M{"request.url": "{{.base"})}},
+		"bad extract name":  {"steps": []mapstr.M{step(mapstr.M{"extract": []mapstr.M{{"name": "a b", "json": "x"}}})}},
+		"json and header":   {"steps": []mapstr.M{step(mapstr.M{"extract": []mapstr.M{{"name": "a", "json": "x", "header": "y"}}})}},
+		"no source":         {"steps": []mapstr.M{step(mapstr.M{"extract": []mapstr.M{{"name": "a"}}})}},
+		"bad regex":         {"steps": []mapstr.M{step(mapstr.M{"extract": []mapstr.M{{"name": "a", "regex": "("}}})}},
+	}
+	for name, cfg := range configs {
+		_, err := createSteps("http_steps", conf.MustNewConfigFrom(cfg))
+		assert.Error(t, err, name)
+	}
+}
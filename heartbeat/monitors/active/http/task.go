@@ -232,7 +232,7 @@ func execPing(
 	validator multiValidator,
 	responseConfig responseConfig,
 ) (end time.Time, err error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
 	defer cancel()
 
 	req = attachRequestBody(&ctx, req, reqBody)
@@ -0,0 +1,200 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tlscert
+
+import (
+	"context"
+	"crypto/dsa" //nolint:staticcheck // DSA keys are reported as weak
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/elastic/beats/v7/heartbeat/eventext"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// checkCertificates runs the checks of the certificates presented by the
+// server, the first one being the server certificate. It returns the chains
+// verified against the roots, and the failures of all the checks.
+func (jf *jobFactory) checkCertificates(
+	ctx context.Context,
+	event *beat.Event,
+	certs []*x509.Certificate,
+	serverName string,
+) ([][]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("server did not present any certificate")
+	}
+	leaf := certs[0]
+	now := time.Now()
+	cfg := &jf.config.Check
+
+	var errs []error
+	fields := mapstr.M{}
+
+	// Verify the chain even if not required, to get the issuer and the
+	// roots of the presented certificates.
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if jf.tlsConfig != nil {
+		opts.Roots = jf.tlsConfig.RootCAs
+	}
+	chains, err := leaf.Verify(opts)
+	fields["verified"] = err == nil
+	if err != nil && cfg.Chain {
+		errs = append(errs, fmt.Errorf("certificate chain is not trusted: %w", err))
+	}
+
+	chain := certs
+	if len(chains) > 0 {
+		chain = chains[0]
+	}
+
+	if cfg.Hostname {
+		err := leaf.VerifyHostname(serverName)
+		fields["hostname_match"] = err == nil
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	var weaknesses []string
+	var chainFields []mapstr.M
+	expiresAt := time.Time{}
+	for _, cert := range chain {
+		for _, weakness := range jf.weaknesses(cert) {
+			weaknesses = append(weaknesses, fmt.Sprintf("'%s' %s", cert.Subject, weakness))
+		}
+		if expiresAt.IsZero() || cert.NotAfter.Before(expiresAt) {
+			expiresAt = cert.NotAfter
+		}
+		chainFields = append(chainFields, certFields(cert))
+	}
+	fields["chain"] = chainFields
+	if len(weaknesses) > 0 {
+		fields["weaknesses"] = weaknesses
+		for _, weakness := range weaknesses {
+			errs = append(errs, fmt.Errorf("weak certificate: %s", weakness))
+		}
+	}
+
+	daysRemaining := int(math.Floor(expiresAt.Sub(now).Hours() / 24))
+	fields["days_remaining"] = daysRemaining
+	if cfg.ExpiryWarningDays > 0 && daysRemaining < cfg.ExpiryWarningDays {
+		if expiresAt.Before(now) {
+			errs = append(errs, fmt.Errorf("certificate chain expired on %s", expiresAt.UTC().Format(time.RFC3339)))
+		} else {
+			errs = append(errs, fmt.Errorf("certificate chain expires in %d days, on %s, less than the %d days of the warning",
+				daysRemaining, expiresAt.UTC().Format(time.RFC3339), cfg.ExpiryWarningDays))
+		}
+	}
+
+	if jf.config.Revocation.OCSP.Enabled || jf.config.Revocation.CRL.Enabled {
+		revocationFields, err := jf.checkRevocation(ctx, chain)
+		if len(revocationFields) > 0 {
+			fields["revocation"] = revocationFields
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	certChain := make([]string, 0, len(certs))
+	for _, cert := range certs {
+		certChain = append(certChain, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	eventext.MergeEventFields(event, mapstr.M{
+		"tls_cert": fields,
+		"tls": mapstr.M{
+			"server": mapstr.M{"certificate_chain": certChain},
+		},
+	})
+
+	return chains, errors.Join(errs...)
+}
+
+// weaknesses returns the weak key and signature algorithm of cert.
+func (jf *jobFactory) weaknesses(cert *x509.Certificate) []string {
+	var weaknesses []string
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if size := key.N.BitLen(); size < jf.config.Check.MinRSAKeySize {
+			weaknesses = append(weaknesses, fmt.Sprintf("has an RSA key of %d bits, less than %d", size, jf.config.Check.MinRSAKeySize))
+		}
+	case *ecdsa.PublicKey:
+		if size := key.Curve.Params().BitSize; size < jf.config.Check.MinECKeySize {
+			weaknesses = append(weaknesses, fmt.Sprintf("has an EC key of %d bits, less than %d", size, jf.config.Check.MinECKeySize))
+		}
+	case *dsa.PublicKey:
+		weaknesses = append(weaknesses, "has a DSA key")
+	}
+
+	// The signature of the self-signed roots is not used to trust them.
+	if !isSelfSigned(cert) && jf.weakSignatureAlgorithms[cert.SignatureAlgorithm] {
+		weaknesses = append(weaknesses, fmt.Sprintf("is signed with %s", cert.SignatureAlgorithm))
+	}
+
+	return weaknesses
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return cert.CheckSignatureFrom(cert) == nil
+}
+
+// certFields returns the inventory fields of a certificate of the chain.
+func certFields(cert *x509.Certificate) mapstr.M {
+	fields := mapstr.M{
+		"subject":              cert.Subject.String(),
+		"issuer":               cert.Issuer.String(),
+		"serial_number":        cert.SerialNumber.String(),
+		"not_before":           cert.NotBefore,
+		"not_after":            cert.NotAfter,
+		"sha256":               fmt.Sprintf("%x", sha256.Sum256(cert.Raw)),
+		"signature_algorithm":  cert.SignatureAlgorithm.String(),
+		"public_key_algorithm": cert.PublicKeyAlgorithm.String(),
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		fields["public_key_size"] = key.N.BitLen()
+	case *ecdsa.PublicKey:
+		fields["public_key_size"] = key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		fields["public_key_size"] = len(key) * 8
+	case *dsa.PublicKey:
+		fields["public_key_size"] = key.P.BitLen()
+	}
+
+	return fields
+}
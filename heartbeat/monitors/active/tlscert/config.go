@@ -0,0 +1,167 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tlscert
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/elastic/beats/v7/heartbeat/monitors"
+	"github.com/elastic/elastic-agent-libs/transport"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+)
+
+type config struct {
+	// Hosts to check, as host or host:port. Hosts without a port are
+	// checked on all the ports, 443 by default.
+	Hosts []string `config:"hosts" validate:"required"`
+	Ports []uint16 `config:"ports"`
+
+	Mode monitors.IPSettings `config:",inline"`
+
+	Socks5 transport.ProxyConfig `config:",inline"`
+
+	// ServerName overrides the hostname sent with SNI and matched against
+	// the certificates.
+	ServerName string `config:"server_name"`
+
+	// configure tls, the certificate authorities being the roots used to
+	// verify the certificate chains
+	TLS *tlscommon.Config `config:"ssl"`
+
+	Timeout time.Duration `config:"timeout"`
+
+	Check      checkConfig      `config:"check"`
+	Revocation revocationConfig `config:"revocation"`
+}
+
+type checkConfig struct {
+	// ExpiryWarningDays marks the monitor down when a certificate of the
+	// chain expires within that many days, 0 disabling the check.
+	ExpiryWarningDays int `config:"expiry_warning_days" validate:"min=0"`
+	// Chain requires the chain to be trusted by the roots.
+	Chain bool `config:"chain"`
+	// Hostname requires the server certificate to match the hostname.
+	Hostname bool `config:"hostname"`
+
+	MinRSAKeySize           int      `config:"min_rsa_key_size" validate:"min=0"`
+	MinECKeySize            int      `config:"min_ec_key_size" validate:"min=0"`
+	WeakSignatureAlgorithms []string `config:"weak_signature_algorithms"`
+}
+
+type revocationConfig struct {
+	OCSP ocspConfig `config:"ocsp"`
+	CRL  crlConfig  `config:"crl"`
+}
+
+type ocspConfig struct {
+	Enabled bool `config:"enabled"`
+	// Responder overrides the OCSP responder of the certificates.
+	Responder string `config:"responder"`
+}
+
+type crlConfig struct {
+	Enabled bool `config:"enabled"`
+	// URLs override the CRL distribution points of the certificates.
+	URLs []string `config:"urls"`
+}
+
+func defaultConfig() config {
+	return config{
+		Mode:    monitors.DefaultIPSettings,
+		Timeout: 16 * time.Second,
+		Check: checkConfig{
+			ExpiryWarningDays: 30,
+			Chain:             true,
+			Hostname:          true,
+			MinRSAKeySize:     2048,
+			MinECKeySize:      256,
+			WeakSignatureAlgorithms: []string{
+				x509.MD5WithRSA.String(),
+				x509.SHA1WithRSA.String(),
+				x509.DSAWithSHA1.String(),
+				x509.DSAWithSHA256.String(),
+				x509.ECDSAWithSHA1.String(),
+			},
+		},
+	}
+}
+
+func (c *config) Validate() error {
+	if c.Socks5.URL != "" {
+		if c.Mode.Mode != monitors.PingAny && !c.Socks5.LocalResolve {
+			return errors.New("ping all ips only supported if proxy_use_local_resolver is enabled`")
+		}
+	}
+
+	return nil
+}
+
+func (c *checkConfig) Validate() error {
+	for _, name := range c.WeakSignatureAlgorithms {
+		if _, ok := signatureAlgorithms[name]; !ok {
+			return fmt.Errorf("unknown signature algorithm '%s'", name)
+		}
+	}
+	return nil
+}
+
+func (c *ocspConfig) Validate() error {
+	return validateURL(c.Responder)
+}
+
+func (c *crlConfig) Validate() error {
+	for _, u := range c.URLs {
+		if err := validateURL(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("'%s' is not an http or https URL", raw)
+	}
+	return nil
+}
+
+// signatureAlgorithms maps the names of the signature algorithms, as used
+// in the configuration and in the events, to the algorithms.
+var signatureAlgorithms = func() map[string]x509.SignatureAlgorithm {
+	algs := map[string]x509.SignatureAlgorithm{}
+	for alg := x509.SignatureAlgorithm(1); alg < 256; alg++ {
+		name := alg.String()
+		// Unknown and unsupported algorithms are named after their number.
+		if _, err := strconv.Atoi(name); err != nil {
+			algs[name] = alg
+		}
+	}
+	return algs
+}()
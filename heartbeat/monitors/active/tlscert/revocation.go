@@ -0,0 +1,218 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tlscert
+
+import (
+	"bytes"
+	"context"
+	cryptoTLS "crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/transport"
+)
+
+// maxRevocationResponseSize limits the size of the OCSP responses and CRLs.
+const maxRevocationResponseSize = 10 * 1024 * 1024
+
+// Revocation statuses of the server certificate.
+const (
+	revocationGood    = "good"
+	revocationRevoked = "revoked"
+	revocationUnknown = "unknown"
+)
+
+// checkRevocation checks the server certificate, the first of the chain,
+// isn't revoked with OCSP and CRLs.
+func (jf *jobFactory) checkRevocation(ctx context.Context, chain []*x509.Certificate) (mapstr.M, error) {
+	if len(chain) < 2 {
+		return nil, errors.New("could not check revocation: issuer certificate not found")
+	}
+	leaf, issuer := chain[0], chain[1]
+
+	fields := mapstr.M{}
+	var errs []error
+	if jf.config.Revocation.OCSP.Enabled {
+		ocspFields, err := jf.checkOCSP(ctx, leaf, issuer)
+		fields["ocsp"] = ocspFields
+		if err != nil {
+			errs = append(errs, fmt.Errorf("OCSP check failed: %w", err))
+		}
+	}
+	if jf.config.Revocation.CRL.Enabled {
+		crlFields, err := jf.checkCRL(ctx, leaf, issuer)
+		fields["crl"] = crlFields
+		if err != nil {
+			errs = append(errs, fmt.Errorf("CRL check failed: %w", err))
+		}
+	}
+
+	return fields, errors.Join(errs...)
+}
+
+// checkOCSP asks the OCSP responder for the status of leaf.
+func (jf *jobFactory) checkOCSP(ctx context.Context, leaf, issuer *x509.Certificate) (mapstr.M, error) {
+	responder := jf.config.Revocation.OCSP.Responder
+	if responder == "" {
+		if len(leaf.OCSPServer) == 0 {
+			return nil, errors.New("certificate has no OCSP responder")
+		}
+		responder = leaf.OCSPServer[0]
+	}
+	fields := mapstr.M{"responder": responder}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return fields, fmt.Errorf("could not create OCSP request: %w", err)
+	}
+	body, err := jf.fetch(ctx, http.MethodPost, responder, "application/ocsp-request", req)
+	if err != nil {
+		return fields, err
+	}
+
+	resp, err := ocsp.ParseResponseForCert(body, leaf, issuer)
+	if err != nil {
+		return fields, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		fields["status"] = revocationGood
+	case ocsp.Revoked:
+		fields["status"] = revocationRevoked
+		fields["revoked_at"] = resp.RevokedAt
+		return fields, fmt.Errorf("certificate was revoked on %s", resp.RevokedAt.UTC().Format(time.RFC3339))
+	default:
+		fields["status"] = revocationUnknown
+		return fields, errors.New("responder does not know the certificate")
+	}
+
+	return fields, nil
+}
+
+// checkCRL looks for leaf in the CRLs of the issuer.
+func (jf *jobFactory) checkCRL(ctx context.Context, leaf, issuer *x509.Certificate) (mapstr.M, error) {
+	urls := jf.config.Revocation.CRL.URLs
+	if len(urls) == 0 {
+		urls = leaf.CRLDistributionPoints
+	}
+	if len(urls) == 0 {
+		return nil, errors.New("certificate has no CRL distribution point")
+	}
+	fields := mapstr.M{"urls": urls}
+
+	for _, u := range urls {
+		body, err := jf.fetch(ctx, http.MethodGet, u, "", nil)
+		if err != nil {
+			return fields, err
+		}
+
+		// CRLs are served as DER, but also found in PEM.
+		if block, _ := pem.Decode(body); block != nil {
+			body = block.Bytes
+		}
+		crl, err := x509.ParseRevocationList(body)
+		if err != nil {
+			return fields, fmt.Errorf("invalid CRL from '%s': %w", u, err)
+		}
+		if err := crl.CheckSignatureFrom(issuer); err != nil {
+			return fields, fmt.Errorf("CRL from '%s' is not signed by the issuer: %w", u, err)
+		}
+		if !crl.NextUpdate.IsZero() && crl.NextUpdate.Before(time.Now()) {
+			return fields, fmt.Errorf("CRL from '%s' is outdated since %s", u, crl.NextUpdate.UTC().Format(time.RFC3339))
+		}
+
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+				fields["status"] = revocationRevoked
+				fields["revoked_at"] = entry.RevocationTime
+				return fields, fmt.Errorf("certificate was revoked on %s", entry.RevocationTime.UTC().Format(time.RFC3339))
+			}
+		}
+	}
+
+	fields["status"] = revocationGood
+	return fields, nil
+}
+
+// newRevocationClient returns the client of the OCSP responders and the CRL
+// distribution points. Like the hosts, they are reached through the SOCKS5
+// proxy, and their certificates are verified with the ssl settings.
+func (jf *jobFactory) newRevocationClient() (*http.Client, error) {
+	dialer, err := transport.ProxyDialer(logp.NewLogger("tls_cert"), &jf.config.Socks5, transport.NetDialer(jf.config.Timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Timeout: jf.config.Timeout,
+		Transport: &http.Transport{
+			DialContext: dialer.DialContext,
+			DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				host, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				conn, err := dialer.DialContext(ctx, network, addr)
+				if err != nil {
+					return nil, err
+				}
+				tlsConn := cryptoTLS.Client(conn, jf.tlsConfig.BuildModuleClientConfig(host))
+				if err := tlsConn.HandshakeContext(ctx); err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return tlsConn, nil
+			},
+		},
+	}, nil
+}
+
+// fetch sends a request to an OCSP responder or a CRL distribution point
+// and returns the body of the response.
+func (jf *jobFactory) fetch(ctx context.Context, method, url, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := jf.revocationClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("'%s' returned status code %d", url, resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxRevocationResponseSize))
+}
@@ -0,0 +1,267 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tlscert
+
+import (
+	"context"
+	cryptoTLS "crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/v7/heartbeat/monitors"
+	"github.com/elastic/beats/v7/heartbeat/monitors/active/dialchain"
+	"github.com/elastic/beats/v7/heartbeat/monitors/active/dialchain/tlsmeta"
+	"github.com/elastic/beats/v7/heartbeat/monitors/jobs"
+	"github.com/elastic/beats/v7/heartbeat/monitors/plugin"
+	"github.com/elastic/beats/v7/heartbeat/monitors/wrappers/wraputil"
+	"github.com/elastic/beats/v7/heartbeat/reason"
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+)
+
+func init() {
+	plugin.Register("tls_cert", create, "synthetics/tls_cert")
+}
+
+var debugf = logp.MakeDebug("tls_cert")
+
+// defaultPort is the port of the hosts if neither the host nor the ports
+// option give one.
+const defaultPort = 443
+
+func create(
+	name string,
+	cfg *conf.C,
+) (p plugin.Plugin, err error) {
+	return createWithResolver(cfg, monitors.NewStdResolver())
+}
+
+// Custom resolver is useful for tests against hostnames locally where we don't want to depend on any
+// hostnames existing in test environments
+func createWithResolver(
+	cfg *conf.C,
+	resolver monitors.Resolver,
+) (p plugin.Plugin, err error) {
+	jf, err := newJobFactory(cfg, resolver)
+	if err != nil {
+		return plugin.Plugin{}, err
+	}
+
+	js, err := jf.makeJobs()
+	if err != nil {
+		return plugin.Plugin{}, err
+	}
+
+	return plugin.Plugin{Jobs: js, Endpoints: len(jf.endpoints)}, nil
+}
+
+// jobFactory provides a common context to the jobs checking the
+// certificates of each host and port.
+type jobFactory struct {
+	config    config
+	tlsConfig *tlscommon.TLSConfig
+	endpoints []*url.URL
+	resolver  monitors.Resolver
+
+	revocationClient *http.Client
+
+	weakSignatureAlgorithms map[x509.SignatureAlgorithm]bool
+}
+
+func newJobFactory(commonCfg *conf.C, resolver monitors.Resolver) (*jobFactory, error) {
+	jf := &jobFactory{config: defaultConfig(), resolver: resolver}
+	err := jf.loadConfig(commonCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return jf, nil
+}
+
+// loadConfig parses the YAML config and populates the jobFactory fields.
+func (jf *jobFactory) loadConfig(commonCfg *conf.C) error {
+	var err error
+	if err = commonCfg.Unpack(&jf.config); err != nil {
+		return err
+	}
+
+	jf.tlsConfig, err = tlscommon.LoadTLSConfig(jf.config.TLS)
+	if err != nil {
+		return err
+	}
+
+	jf.endpoints, err = makeEndpoints(jf.config.Hosts, jf.config.Ports)
+	if err != nil {
+		return err
+	}
+
+	jf.revocationClient, err = jf.newRevocationClient()
+	if err != nil {
+		return err
+	}
+
+	jf.weakSignatureAlgorithms = map[x509.SignatureAlgorithm]bool{}
+	for _, name := range jf.config.Check.WeakSignatureAlgorithms {
+		jf.weakSignatureAlgorithms[signatureAlgorithms[name]] = true
+	}
+
+	return nil
+}
+
+// makeEndpoints returns the URL of every host and port to check.
+func makeEndpoints(hosts []string, ports []uint16) ([]*url.URL, error) {
+	if len(ports) == 0 {
+		ports = []uint16{defaultPort}
+	}
+
+	var endpoints []*url.URL
+	for _, host := range hosts {
+		hostname, port, err := net.SplitHostPort(host)
+		if err != nil {
+			// No port, which also is the case of bare IPv6 addresses.
+			hostname = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+			for _, p := range ports {
+				endpoints = append(endpoints, makeEndpoint(hostname, p))
+			}
+			continue
+		}
+
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || p == 0 || hostname == "" {
+			return nil, fmt.Errorf("invalid host '%s'", host)
+		}
+		endpoints = append(endpoints, makeEndpoint(hostname, uint16(p)))
+	}
+
+	return endpoints, nil
+}
+
+func makeEndpoint(hostname string, port uint16) *url.URL {
+	return &url.URL{
+		Scheme: "ssl",
+		Host:   net.JoinHostPort(hostname, strconv.Itoa(int(port))),
+	}
+}
+
+// makeJobs returns the actual schedulable jobs for this monitor.
+func (jf *jobFactory) makeJobs() ([]jobs.Job, error) {
+	var js []jobs.Job
+	for _, endpoint := range jf.endpoints {
+		job, err := jf.makeEndpointJob(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		js = append(js, wraputil.WithURLField(endpoint, job))
+	}
+
+	return js, nil
+}
+
+// makeEndpointJob makes a job checking the certificates of a single
+// host and port.
+func (jf *jobFactory) makeEndpointJob(endpoint *url.URL) (jobs.Job, error) {
+	// Rely on the SOCKS5 proxy to resolve the IP of the host, unless
+	// asked to resolve it locally.
+	if jf.config.Socks5.URL != "" && !jf.config.Socks5.LocalResolve {
+		return jobs.MakeSimpleJob(func(event *beat.Event) error {
+			return jf.check(event, endpoint.Host, endpoint)
+		}), nil
+	}
+
+	return monitors.MakeByHostJob(
+		endpoint.Hostname(),
+		jf.config.Mode,
+		jf.resolver,
+		monitors.MakePingIPFactory(
+			func(event *beat.Event, ip *net.IPAddr) error {
+				// use address from resolved IP
+				ipPort := net.JoinHostPort(ip.String(), endpoint.Port())
+
+				return jf.check(event, ipPort, endpoint)
+			}))
+}
+
+// check connects to the endpoint at dialAddr, gets the certificates of the
+// server with a TLS handshake, and checks them.
+func (jf *jobFactory) check(event *beat.Event, dialAddr string, endpoint *url.URL) error {
+	dc := &dialchain.DialerChain{
+		Net: dialchain.CreateNetDialer(jf.config.Timeout),
+	}
+	if jf.config.Socks5.URL != "" {
+		dc.AddLayer(dialchain.SOCKS5Layer(&jf.config.Socks5))
+	}
+	dc.AddLayer(dialchain.ConstAddrLayer(dialAddr))
+
+	dialer, err := dc.Build(event)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	deadline := start.Add(jf.config.Timeout)
+
+	conn, err := dialer.Dial("tcp", dialAddr)
+	if err != nil {
+		debugf("dial failed with: %v", err)
+		return reason.IOFailed(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		debugf("setting connection deadline failed with: %v", err)
+		return reason.IOFailed(err)
+	}
+
+	serverName := jf.config.ServerName
+	if serverName == "" {
+		serverName = endpoint.Hostname()
+	}
+
+	// The certificates are verified by the checks, after the handshake,
+	// so that the invalid certificates are reported too.
+	tlsCfg := jf.tlsConfig.BuildModuleClientConfig(serverName)
+	tlsCfg.InsecureSkipVerify = true //nolint:gosec // the certificates are verified by the checks
+	tlsCfg.VerifyConnection = nil
+
+	tlsConn := cryptoTLS.Client(conn, tlsCfg)
+	handshakeStart := time.Now()
+	if err := tlsConn.Handshake(); err != nil {
+		debugf("handshake failed with: %v", err)
+		return reason.IOFailed(err)
+	}
+	handshakeDuration := time.Since(handshakeStart)
+
+	connState := tlsConn.ConnectionState()
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	connState.VerifiedChains, err = jf.checkCertificates(ctx, event, connState.PeerCertificates, serverName)
+	tlsmeta.AddTLSMetadata(event.Fields, connState, handshakeDuration)
+	if err != nil {
+		return reason.MakeValidateError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,495 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package tlscert
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	cryptoTLS "crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/armon/go-socks5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/go-lookslike"
+	"github.com/elastic/go-lookslike/isdef"
+	"github.com/elastic/go-lookslike/testslike"
+	"github.com/elastic/go-lookslike/validator"
+
+	"github.com/elastic/beats/v7/heartbeat/hbtest"
+	"github.com/elastic/beats/v7/heartbeat/hbtestllext"
+)
+
+// testCA issues the certificates of the test servers.
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	file string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue returns a server certificate for localhost, customized by modify.
+func (ca *testCA) issue(t *testing.T, key crypto.Signer, modify func(*x509.Certificate)) cryptoTLS.Certificate {
+	t.Helper()
+
+	if key == nil {
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(0, 0, 90),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if modify != nil {
+		modify(tmpl)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cryptoTLS.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// startServer starts a TLS server presenting cert, returning its address.
+func startServer(t *testing.T, cert cryptoTLS.Certificate) string {
+	t.Helper()
+
+	l, err := cryptoTLS.Listen("tcp", "127.0.0.1:0", &cryptoTLS.Config{Certificates: []cryptoTLS.Certificate{cert}}) //nolint:gosec // it's a test
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = conn.(*cryptoTLS.Conn).Handshake()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestValidCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, nil, nil)
+	addr := startServer(t, cert)
+
+	event := hbtest.RunMonitor(t, create, "tls_cert", mapstr.M{
+		"hosts":   addr,
+		"timeout": "1s",
+		"ssl":     mapstr.M{"certificate_authorities": ca.file},
+	})
+
+	testslike.Test(t, lookslike.Compose(
+		hbtest.BaseChecks("127.0.0.1", "up", "tls_cert"),
+		hbtest.SummaryStateChecks(1, 0),
+		hbtest.TLSChecks(0, 0, cert.Leaf),
+		lookslike.MustCompile(mapstr.M{
+			"url.full": "ssl://" + addr,
+			"tls_cert": mapstr.M{
+				"verified":       true,
+				"hostname_match": true,
+				"days_remaining": 89,
+				"chain": []mapstr.M{
+					{
+						"subject":              "CN=localhost",
+						"issuer":               "CN=Test Root CA",
+						"serial_number":        cert.Leaf.SerialNumber.String(),
+						"not_before":           isdef.KeyPresent,
+						"not_after":            cert.Leaf.NotAfter,
+						"sha256":               isdef.IsNonEmptyString,
+						"signature_algorithm":  "ECDSA-SHA256",
+						"public_key_algorithm": "ECDSA",
+						"public_key_size":      256,
+					},
+					{
+						"subject": "CN=Test Root CA",
+						"issuer":  "CN=Test Root CA",
+					},
+				},
+			},
+			"tls.server.certificate_chain": []string{base64.StdEncoding.EncodeToString(cert.Leaf.Raw)},
+			"tcp.rtt.connect.us":           hbtestllext.IsInt64,
+		}),
+	), event.Fields)
+	assert.NotContains(t, event.Fields, "error")
+}
+
+func TestChecks(t *testing.T) {
+	ca := newTestCA(t)
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		cert   cryptoTLS.Certificate
+		cfg    mapstr.M
+		status string
+		errMsg string
+	}{
+		"untrusted": {
+			cert:   ca.issue(t, nil, nil),
+			cfg:    mapstr.M{"ssl": nil},
+			status: "down",
+			errMsg: "certificate chain is not trusted",
+		},
+		"untrusted without chain check": {
+			cert:   ca.issue(t, nil, nil),
+			cfg:    mapstr.M{"ssl": nil, "check.chain": false},
+			status: "up",
+		},
+		"hostname mismatch": {
+			cert:   ca.issue(t, nil, nil),
+			cfg:    mapstr.M{"server_name": "www.example.com"},
+			status: "down",
+			errMsg: "x509: certificate is valid for localhost, not www.example.com",
+		},
+		"expiring": {
+			cert:   ca.issue(t, nil, func(c *x509.Certificate) { c.NotAfter = time.Now().AddDate(0, 0, 10) }),
+			status: "down",
+			errMsg: "certificate chain expires in 9 days",
+		},
+		"expiring after warning": {
+			cert:   ca.issue(t, nil, func(c *x509.Certificate) { c.NotAfter = time.Now().AddDate(0, 0, 10) }),
+			cfg:    mapstr.M{"check.expiry_warning_days": 7},
+			status: "up",
+		},
+		"expired": {
+			cert:   ca.issue(t, nil, func(c *x509.Certificate) { c.NotAfter = time.Now().Add(-time.Minute) }),
+			cfg:    mapstr.M{"check.chain": false},
+			status: "down",
+			errMsg: "certificate chain expired on",
+		},
+		"weak key": {
+			cert:   ca.issue(t, weakKey, nil),
+			status: "down",
+			errMsg: "weak certificate: 'CN=localhost' has an RSA key of 1024 bits, less than 2048",
+		},
+		"allowed key size": {
+			cert:   ca.issue(t, weakKey, nil),
+			cfg:    mapstr.M{"check.min_rsa_key_size": 1024},
+			status: "up",
+		},
+		"weak signature algorithm": {
+			cert:   ca.issue(t, nil, nil),
+			cfg:    mapstr.M{"check.weak_signature_algorithms": []string{"ECDSA-SHA256"}},
+			status: "down",
+			errMsg: "weak certificate: 'CN=localhost' is signed with ECDSA-SHA256",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			addr := startServer(t, tc.cert)
+			cfg := mapstr.M{
+				"hosts":   addr,
+				"timeout": "1s",
+				"ssl":     mapstr.M{"certificate_authorities": ca.file},
+			}
+			cfg.DeepUpdate(tc.cfg)
+			event := hbtest.RunMonitor(t, create, "tls_cert", cfg)
+
+			validators := []validator.Validator{
+				hbtest.BaseChecks("127.0.0.1", tc.status, "tls_cert"),
+			}
+			if tc.errMsg != "" {
+				validators = append(validators, hbtest.ErrorChecks(tc.errMsg, "validate"))
+			}
+			testslike.Test(t, lookslike.Compose(validators...), event.Fields)
+		})
+	}
+}
+
+func TestRevocation(t *testing.T) {
+	ca := newTestCA(t)
+	good := ca.issue(t, nil, nil)
+	revoked := ca.issue(t, nil, nil)
+	revokedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		tmpl := ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+		if req.SerialNumber.Cmp(revoked.Leaf.SerialNumber) == 0 {
+			tmpl.Status = ocsp.Revoked
+			tmpl.RevokedAt = revokedAt
+		}
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, tmpl, ca.key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(resp)
+	}))
+	defer responder.Close()
+
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: revoked.Leaf.SerialNumber, RevocationTime: revokedAt},
+		},
+	}, ca.cert, ca.key)
+	require.NoError(t, err)
+	crlHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(crl)
+	})
+	crlServer := httptest.NewServer(crlHandler)
+	defer crlServer.Close()
+	// The HTTPS server is only trusted with the certificate authorities of
+	// the monitor.
+	crlTLSServer := httptest.NewUnstartedServer(crlHandler)
+	crlTLSServer.TLS = &cryptoTLS.Config{Certificates: []cryptoTLS.Certificate{ca.issue(t, nil, nil)}} //nolint:gosec // it's a test
+	crlTLSServer.StartTLS()
+	defer crlTLSServer.Close()
+
+	tests := map[string]struct {
+		cert       cryptoTLS.Certificate
+		revocation mapstr.M
+		status     string
+		errMsg     string
+		fields     mapstr.M
+	}{
+		"ocsp good": {
+			cert:       good,
+			revocation: mapstr.M{"ocsp": mapstr.M{"enabled": true, "responder": responder.URL}},
+			status:     "up",
+			fields:     mapstr.M{"ocsp": mapstr.M{"status": "good", "responder": responder.URL}},
+		},
+		"ocsp revoked": {
+			cert:       revoked,
+			revocation: mapstr.M{"ocsp": mapstr.M{"enabled": true, "responder": responder.URL}},
+			status:     "down",
+			errMsg:     "OCSP check failed: certificate was revoked on " + revokedAt.UTC().Format(time.RFC3339),
+			fields:     mapstr.M{"ocsp": mapstr.M{"status": "revoked", "revoked_at": isdef.KeyPresent}},
+		},
+		"ocsp without responder": {
+			cert:       good,
+			revocation: mapstr.M{"ocsp.enabled": true},
+			status:     "down",
+			errMsg:     "OCSP check failed: certificate has no OCSP responder",
+		},
+		"crl good": {
+			cert:       good,
+			revocation: mapstr.M{"crl": mapstr.M{"enabled": true, "urls": []string{crlServer.URL}}},
+			status:     "up",
+			fields:     mapstr.M{"crl": mapstr.M{"status": "good", "urls": []string{crlServer.URL}}},
+		},
+		"crl over https": {
+			cert:       good,
+			revocation: mapstr.M{"crl": mapstr.M{"enabled": true, "urls": []string{crlTLSServer.URL}}},
+			status:     "up",
+			fields:     mapstr.M{"crl": mapstr.M{"status": "good", "urls": []string{crlTLSServer.URL}}},
+		},
+		"crl revoked": {
+			cert:       revoked,
+			revocation: mapstr.M{"crl": mapstr.M{"enabled": true, "urls": []string{crlServer.URL}}},
+			status:     "down",
+			errMsg:     "CRL check failed: certificate was revoked on " + revokedAt.UTC().Format(time.RFC3339),
+			fields:     mapstr.M{"crl.status": "revoked"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			addr := startServer(t, tc.cert)
+			event := hbtest.RunMonitor(t, create, "tls_cert", mapstr.M{
+				"hosts":      addr,
+				"timeout":    "1s",
+				"ssl":        mapstr.M{"certificate_authorities": ca.file},
+				"revocation": tc.revocation,
+			})
+
+			validators := []validator.Validator{
+				hbtest.BaseChecks("127.0.0.1", tc.status, "tls_cert"),
+			}
+			if tc.errMsg != "" {
+				validators = append(validators, hbtest.ErrorChecks(tc.errMsg, "validate"))
+			}
+			if tc.fields != nil {
+				validators = append(validators, lookslike.MustCompile(mapstr.M{"tls_cert.revocation": tc.fields}))
+			}
+			testslike.Test(t, lookslike.Compose(validators...), event.Fields)
+		})
+	}
+}
+
+func TestRevocationThroughProxy(t *testing.T) {
+	ca := newTestCA(t)
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}, ca.cert, ca.key)
+	require.NoError(t, err)
+	crlServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(crl)
+	}))
+	defer crlServer.Close()
+
+	proxied := make(chan string, 10)
+	proxy, err := socks5.New(&socks5.Config{Rules: proxyRecorder(proxied)})
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() { _ = proxy.Serve(l) }()
+
+	addr := startServer(t, ca.issue(t, nil, nil))
+	event := hbtest.RunMonitor(t, create, "tls_cert", mapstr.M{
+		"hosts":                    addr,
+		"timeout":                  "1s",
+		"ssl":                      mapstr.M{"certificate_authorities": ca.file},
+		"proxy_url":                "socks5://" + l.Addr().String(),
+		"proxy_use_local_resolver": true,
+		"revocation":               mapstr.M{"crl": mapstr.M{"enabled": true, "urls": []string{crlServer.URL}}},
+	})
+	testslike.Test(t, lookslike.MustCompile(mapstr.M{
+		"monitor.status":                 "up",
+		"tls_cert.revocation.crl.status": "good",
+	}), event.Fields)
+
+	close(proxied)
+	var destinations []string
+	for dest := range proxied {
+		destinations = append(destinations, dest)
+	}
+	assert.Contains(t, destinations, crlServer.Listener.Addr().String())
+}
+
+// proxyRecorder is a SOCKS5 rule set allowing all the connections, and
+// sending their destination to the channel.
+type proxyRecorder chan<- string
+
+func (r proxyRecorder) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	r <- req.DestAddr.String()
+	return ctx, true
+}
+
+func TestConnectionRefused(t *testing.T) {
+	addr := hbtest.ClosedAddr(t)
+	event := hbtest.RunMonitor(t, create, "tls_cert", mapstr.M{"hosts": addr, "timeout": "1s"})
+	testslike.Test(t, lookslike.Compose(
+		hbtest.BaseChecks("127.0.0.1", "down", "tls_cert"),
+		hbtest.SummaryStateChecks(0, 1),
+	), event.Fields)
+}
+
+func TestMakeEndpoints(t *testing.T) {
+	endpoints, err := makeEndpoints([]string{"example.com", "10.0.0.1:8443", "2001:db8::1", "[2001:db8::2]:636"}, nil)
+	require.NoError(t, err)
+	var urls []string
+	for _, u := range endpoints {
+		urls = append(urls, u.String())
+	}
+	assert.Equal(t, []string{
+		"ssl://example.com:443",
+		"ssl://10.0.0.1:8443",
+		"ssl://[2001:db8::1]:443",
+		"ssl://[2001:db8::2]:636",
+	}, urls)
+
+	endpoints, err = makeEndpoints([]string{"example.com", "example.org:993"}, []uint16{443, 8443})
+	require.NoError(t, err)
+	urls = nil
+	for _, u := range endpoints {
+		urls = append(urls, u.String())
+	}
+	assert.Equal(t, []string{"ssl://example.com:443", "ssl://example.com:8443", "ssl://example.org:993"}, urls)
+
+	for _, host := range []string{"example.com:", "example.com:99999", ":443"} {
+		_, err := makeEndpoints([]string{host}, nil)
+		assert.Error(t, err, host)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	hbtest.InvalidConfigs(t, create, "tls_cert", []mapstr.M{
+		{"ports": []int{443}},
+		{"hosts": "example.com", "check.weak_signature_algorithms": []string{"BOGUS"}},
+		{"hosts": "example.com", "check.expiry_warning_days": -1},
+		{"hosts": "example.com", "revocation.ocsp.responder": "ldap://ocsp.example.com"},
+		{"hosts": "example.com", "revocation.crl.urls": []string{"ftp://crl.example.com"}},
+	})
+}
@@ -270,6 +270,13 @@ func (m *Monitor) Stop() {
 		}
 	}
 
+	// Stopped monitors should no longer block the monitors depending on them.
+	// Monitors that never started, such as those built for config checks, never
+	// recorded a state, and may share their ID with a running monitor.
+	if m.state == MON_STARTED {
+		monitorstate.DefaultDependencyRegistry.Remove(m.stdFields.ID)
+	}
+
 	m.stats.StopMonitor(int64(m.endpoints))
 	m.state = MON_STOPPED
 	m.updateStatus(status.Stopped, "")
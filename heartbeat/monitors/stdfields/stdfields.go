@@ -44,6 +44,9 @@ type StdMonitorFields struct {
 	Origin             string        `config:"origin"`
 	LegacyServiceName  string        `config:"service_name"`
 	MaxAttempts        uint16        `config:"max_attempts"`
+	// IDs of other monitors this monitor depends on. While any of them is
+	// down, failed checks of this monitor are reported as blocked.
+	DependsOn []string `config:"depends_on"`
 	// Used by zip_url and local monitors
 	// kibana originating monitors only run one journey at a time
 	// and just use the `fields` syntax / manually set monitor IDs
@@ -88,5 +91,14 @@ func ConfigToStdMonitorFields(conf *config.C) (StdMonitorFields, error) {
 		sFields.ParsedMainteWin = append(sFields.ParsedMainteWin, maintwin.ParsedMaintWin{Rule: parsed, Duration: mw.Duration})
 	}
 
+	for _, parentID := range sFields.DependsOn {
+		if parentID == "" {
+			return StdMonitorFields{}, fmt.Errorf("depends_on for monitor (id:%s name:%s) must not contain empty IDs", sFields.ID, sFields.Name)
+		}
+		if parentID == sFields.ID {
+			return StdMonitorFields{}, fmt.Errorf("monitor (id:%s name:%s) cannot depend on itself", sFields.ID, sFields.Name)
+		}
+	}
+
 	return sFields, nil
 }
@@ -65,3 +65,38 @@ func TestLegacyServiceNameConfig(t *testing.T) {
 	}
 
 }
+
+func TestDependsOnConfig(t *testing.T) {
+	configWith := func(dependsOn ...string) mapstr.M {
+		return mapstr.M{
+			"type":       "http",
+			"id":         "myId",
+			"schedule":   "@every 1s",
+			"depends_on": dependsOn,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		cfg     mapstr.M
+		wantErr bool
+	}{
+		{"valid parents", configWith("router", "auth"), false},
+		{"self reference", configWith("router", "myId"), true},
+		{"empty id", configWith(""), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := conf.NewConfigFrom(tt.cfg)
+			require.NoError(t, err)
+			f, err := ConfigToStdMonitorFields(c)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, []string{"router", "auth"}, f.DependsOn)
+		})
+	}
+}
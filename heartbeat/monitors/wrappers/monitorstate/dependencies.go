@@ -0,0 +1,94 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package monitorstate
+
+import (
+	"sync"
+
+	"github.com/elastic/beats/v7/heartbeat/monitors/stdfields"
+)
+
+// DefaultDependencyRegistry is shared by all trackers created with NewTracker, letting
+// every monitor running in this process see the states of the monitors it depends on.
+var DefaultDependencyRegistry = NewDependencyRegistry()
+
+// DependencyRegistry holds the latest state of each monitor, keyed by monitor ID,
+// for the purpose of resolving `depends_on` relationships between monitors.
+type DependencyRegistry struct {
+	states map[string]*State
+	mtx    sync.RWMutex
+}
+
+func NewDependencyRegistry() *DependencyRegistry {
+	return &DependencyRegistry{states: map[string]*State{}}
+}
+
+// Update stores the latest state for the monitor with the given ID. The state
+// should be a copy that is not mutated afterwards.
+func (r *DependencyRegistry) Update(id string, state *State) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.states[id] = state
+}
+
+// Remove forgets the monitor with the given ID, which should happen when it is
+// stopped so that it no longer blocks its dependents.
+func (r *DependencyRegistry) Remove(id string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	delete(r.states, id)
+}
+
+// BlockedBy returns the IDs of the down monitors that are blocking the given monitor,
+// either directly or through a chain of blocked dependencies. Monitors whose
+// dependencies are unknown, up, or flapping are not blocked. A monitor is never
+// blocked by itself, which keeps dependency cycles from hiding the actual failure.
+func (r *DependencyRegistry) BlockedBy(sf stdfields.StdMonitorFields) (blockedBy []string) {
+	if len(sf.DependsOn) == 0 {
+		return nil
+	}
+
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	seen := map[string]bool{}
+	add := func(id string) {
+		if id == sf.ID || seen[id] {
+			return
+		}
+		seen[id] = true
+		blockedBy = append(blockedBy, id)
+	}
+
+	for _, parentID := range sf.DependsOn {
+		parent, ok := r.states[parentID]
+		if !ok {
+			continue
+		}
+		switch parent.Status {
+		case StatusDown:
+			add(parentID)
+		case StatusBlocked:
+			for _, rootID := range parent.BlockedBy {
+				add(rootID)
+			}
+		}
+	}
+
+	return blockedBy
+}
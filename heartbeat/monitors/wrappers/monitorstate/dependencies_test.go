@@ -0,0 +1,86 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package monitorstate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/heartbeat/monitors/stdfields"
+)
+
+func depSf(id string, dependsOn ...string) stdfields.StdMonitorFields {
+	return stdfields.StdMonitorFields{ID: id, Type: "http", DependsOn: dependsOn}
+}
+
+func depTracker(reg *DependencyRegistry) *Tracker {
+	mst := NewTracker(NilStateLoader, false)
+	mst.dependencies = reg
+	return mst
+}
+
+func TestBlockedByDependencyChain(t *testing.T) {
+	reg := NewDependencyRegistry()
+	router, auth, app := depSf("router"), depSf("auth", "router"), depSf("app", "auth", "router")
+	mst := depTracker(reg)
+
+	// Unknown dependencies never block
+	require.Empty(t, mst.BlockedBy(app))
+
+	mst.RecordStatus(router, StatusUp, true)
+	require.Empty(t, mst.BlockedBy(auth))
+
+	mst.RecordStatus(router, StatusDown, true)
+	require.Equal(t, []string{"router"}, mst.BlockedBy(auth))
+
+	ms := mst.RecordBlocked(auth, mst.BlockedBy(auth))
+	require.Equal(t, StatusBlocked, ms.Status)
+	require.Equal(t, []string{"router"}, ms.BlockedBy)
+	requireMSCounts(t, ms, 0, 1)
+
+	// Blocked dependencies propagate their root cause, which is only listed once
+	require.Equal(t, []string{"router"}, mst.BlockedBy(app))
+
+	mst.RecordStatus(router, StatusUp, true)
+	require.Empty(t, mst.BlockedBy(auth))
+
+	ms = mst.RecordStatus(auth, StatusDown, true)
+	require.Equal(t, StatusDown, ms.Status)
+	require.Nil(t, ms.BlockedBy)
+	require.Equal(t, StatusBlocked, ms.Ends.Status)
+	require.Equal(t, []string{"router"}, ms.Ends.BlockedBy)
+	require.Equal(t, []string{"auth"}, mst.BlockedBy(app))
+
+	reg.Remove("auth")
+	require.Empty(t, mst.BlockedBy(app))
+}
+
+func TestBlockedByDependencyCycle(t *testing.T) {
+	reg := NewDependencyRegistry()
+	a, b := depSf("a", "b"), depSf("b", "a")
+	mstA, mstB := depTracker(reg), depTracker(reg)
+
+	mstA.RecordStatus(a, StatusDown, true)
+	blockedBy := mstB.BlockedBy(b)
+	require.Equal(t, []string{"a"}, blockedBy)
+	mstB.RecordBlocked(b, blockedBy)
+
+	// a is the root cause of b being blocked, so a must not be blocked by b
+	require.Empty(t, mstA.BlockedBy(a))
+}
@@ -37,6 +37,9 @@ const (
 	StatusUp       StateStatus = "up"
 	StatusDown     StateStatus = "down"
 	StatusFlapping StateStatus = "flap"
+	// StatusBlocked is used instead of StatusDown when a monitor fails while
+	// one of the monitors it depends on is down
+	StatusBlocked StateStatus = "blocked"
 	// Nil, essentially
 	StatusEmpty StateStatus = ""
 )
@@ -71,6 +74,8 @@ type State struct {
 	// FlapHistory retains enough info so we can resume our flap
 	// computation if loading from ES or another source
 	FlapHistory []StateStatus `json:"flap_history"`
+	// BlockedBy lists the IDs of the down monitors responsible for a blocked state
+	BlockedBy []string `json:"blocked_by,omitempty"`
 	// Ends is a pointer to the prior state if this is the start of a new state
 	Ends            *State `json:"ends"`
 	flappingEnabled bool
@@ -171,6 +176,10 @@ func (s *State) copy() *State {
 	copied := *s
 	copied.FlapHistory = make([]StateStatus, len(s.FlapHistory))
 	copy(copied.FlapHistory, s.FlapHistory)
+	if s.BlockedBy != nil {
+		copied.BlockedBy = make([]string, len(s.BlockedBy))
+		copy(copied.BlockedBy, s.BlockedBy)
+	}
 	return &copied
 }
 
@@ -42,6 +42,7 @@ func NewTracker(sl StateLoader, flappingEnabled bool) *Tracker {
 		mtx:             sync.Mutex{},
 		stateLoader:     sl,
 		flappingEnabled: flappingEnabled,
+		dependencies:    DefaultDependencyRegistry,
 	}
 }
 
@@ -50,6 +51,7 @@ type Tracker struct {
 	mtx             sync.Mutex
 	stateLoader     StateLoader
 	flappingEnabled bool
+	dependencies    *DependencyRegistry
 }
 
 // StateLoader has signature as loadLastESState, useful for test mocking, and maybe for a future impl
@@ -57,6 +59,22 @@ type Tracker struct {
 type StateLoader func(stdfields.StdMonitorFields) (*State, error)
 
 func (t *Tracker) RecordStatus(sf stdfields.StdMonitorFields, newStatus StateStatus, isFinalAttempt bool) (ms *State) {
+	return t.record(sf, newStatus, isFinalAttempt, nil)
+}
+
+// RecordBlocked records a failed check of a monitor whose dependencies are down,
+// blockedBy being the IDs of those dependencies as returned by BlockedBy.
+// Blocked checks are always final.
+func (t *Tracker) RecordBlocked(sf stdfields.StdMonitorFields, blockedBy []string) (ms *State) {
+	return t.record(sf, StatusBlocked, true, blockedBy)
+}
+
+// BlockedBy returns the IDs of the down monitors blocking the given monitor, if any.
+func (t *Tracker) BlockedBy(sf stdfields.StdMonitorFields) []string {
+	return t.dependencies.BlockedBy(sf)
+}
+
+func (t *Tracker) record(sf stdfields.StdMonitorFields, newStatus StateStatus, isFinalAttempt bool, blockedBy []string) (ms *State) {
 	//note: the return values have no concurrency controls, they may be unsafely read unless
 	//copied to the stack, copying the structs before  returning
 	t.mtx.Lock()
@@ -70,6 +88,9 @@ func (t *Tracker) RecordStatus(sf stdfields.StdMonitorFields, newStatus StateSta
 	} else {
 		state.recordCheck(sf, newStatus, isFinalAttempt)
 	}
+	state.BlockedBy = blockedBy
+
+	t.dependencies.Update(sf.ID, state.copy())
 	// return a copy since the state itself is a pointer that is frequently mutated
 	return state.copy()
 }
@@ -32,6 +32,7 @@ type JobSummary struct {
 	Down         uint16                   `json:"down"`
 	Status       monitorstate.StateStatus `json:"status"`
 	RetryGroup   string                   `json:"retry_group"`
+	BlockedBy    []string                 `json:"blocked_by,omitempty"`
 }
 
 func NewJobSummary(attempt uint16, maxAttempts uint16, retryGroup string) *JobSummary {
@@ -161,10 +161,29 @@ func (ssp *commonSSP) BeforeSummary(event *beat.Event) BeforeSummaryActions {
 	retry := curCheckDown && // the current check is down
 		lastStateUpOrEmpty && // we were previously up or had no previous state, if we were previously down we just check once
 		hasAttemptsRemaining // and we are configured to actually make multiple attempts
+
+	// A failing check is reported as blocked while any monitor it depends on is down.
+	// There's no point in retrying it until those recover.
+	var blockedBy []string
+	if curCheckDown {
+		blockedBy = ssp.stateTracker.BlockedBy(ssp.sf)
+	}
+	if len(blockedBy) > 0 {
+		retry = false
+		ssp.js.Status = monitorstate.StatusBlocked
+		ssp.js.BlockedBy = blockedBy
+		_, _ = event.PutValue("monitor.status", string(monitorstate.StatusBlocked))
+	}
+
 	// if we aren't retrying this is the final attempt
 	ssp.js.FinalAttempt = !retry
 
-	ms := ssp.stateTracker.RecordStatus(ssp.sf, ssp.js.Status, ssp.js.FinalAttempt)
+	var ms *monitorstate.State
+	if len(blockedBy) > 0 {
+		ms = ssp.stateTracker.RecordBlocked(ssp.sf, blockedBy)
+	} else {
+		ms = ssp.stateTracker.RecordStatus(ssp.sf, ssp.js.Status, ssp.js.FinalAttempt)
+	}
 
 	// dereference the pointer since the pointer is pointed at the next step
 	// after this
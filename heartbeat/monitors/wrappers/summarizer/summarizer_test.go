@@ -376,6 +376,61 @@ func TestRetryLightweightMonitorDuration(t *testing.T) {
 	require.GreaterOrEqual(t, look.RTTMS(retryElapsed), rcvdDuration)
 }
 
+func TestSummarizerBlockedByDependency(t *testing.T) {
+	t.Parallel()
+
+	parentSf := stdfields.StdMonitorFields{ID: "summarizer-blocked-parent", Type: "tcp", MaxAttempts: 1}
+	sf := stdfields.StdMonitorFields{ID: "summarizer-blocked-child", Type: "http", MaxAttempts: 2, DependsOn: []string{parentSf.ID}}
+	t.Cleanup(func() {
+		monitorstate.DefaultDependencyRegistry.Remove(parentSf.ID)
+		monitorstate.DefaultDependencyRegistry.Remove(sf.ID)
+	})
+
+	parentTracker := monitorstate.NewTracker(monitorstate.NilStateLoader, false)
+	tracker := monitorstate.NewTracker(monitorstate.NilStateLoader, false)
+
+	job := func(event *beat.Event) (j []jobs.Job, retErr error) {
+		event.Fields = mapstr.M{"monitor": mapstr.M{"id": sf.ID}}
+		return nil, fmt.Errorf("dummyerr")
+	}
+	run := func() []*beat.Event {
+		s := NewSummarizer(job, sf, tracker)
+		s.retryDelay = 2 * time.Millisecond
+		events, _ := jobs.ExecJobAndConts(t, s.Wrap(job))
+		return events
+	}
+
+	parentTracker.RecordStatus(parentSf, monitorstate.StatusDown, true)
+
+	// While the parent is down, failures are blocked and not retried
+	events := run()
+	require.Len(t, events, 1)
+	status, _ := events[0].GetValue("monitor.status")
+	require.Equal(t, string(monitorstate.StatusBlocked), status)
+	require.NotNil(t, events[0].Fields["error"])
+
+	summaryIface, _ := events[0].GetValue("summary")
+	summary := summaryIface.(*jobsummary.JobSummary)
+	require.Equal(t, monitorstate.StatusBlocked, summary.Status)
+	require.Equal(t, []string{parentSf.ID}, summary.BlockedBy)
+	require.True(t, summary.FinalAttempt)
+
+	stateIface, _ := events[0].GetValue("state")
+	state := stateIface.(*monitorstate.State)
+	require.Equal(t, monitorstate.StatusBlocked, state.Status)
+	require.Equal(t, []string{parentSf.ID}, state.BlockedBy)
+
+	// Once the parent recovers, failures are reported as down again
+	parentTracker.RecordStatus(parentSf, monitorstate.StatusUp, true)
+	events = run()
+	status, _ = events[len(events)-1].GetValue("monitor.status")
+	require.Equal(t, string(monitorstate.StatusDown), status)
+	stateIface, _ = events[len(events)-1].GetValue("state")
+	state = stateIface.(*monitorstate.State)
+	require.Equal(t, monitorstate.StatusDown, state.Status)
+	require.Nil(t, state.BlockedBy)
+}
+
 type MockPlugin struct {
 	eachEvent       func(e *beat.Event, err error)
 	beforeSummary   func(e *beat.Event)
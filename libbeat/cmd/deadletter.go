@@ -0,0 +1,200 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/elastic/beats/v7/libbeat/cmd/instance"
+	"github.com/elastic/beats/v7/libbeat/cmd/instance/locks"
+	"github.com/elastic/beats/v7/libbeat/common/cli"
+	"github.com/elastic/beats/v7/libbeat/idxmgmt"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/publisher/pipeline"
+)
+
+func genDeadLetterCmd(settings instance.Settings) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "dead-letter",
+		Short: "Manage events stored in the dead letter queue",
+	}
+
+	command.AddCommand(genListDeadLetterCmd(settings))
+	command.AddCommand(genInspectDeadLetterCmd(settings))
+	command.AddCommand(genReplayDeadLetterCmd(settings))
+
+	return command
+}
+
+func genListDeadLetterCmd(settings instance.Settings) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List dead letter files",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			_, files, err := deadLetterFiles(settings)
+			if err != nil {
+				return err
+			}
+			for _, path := range files {
+				var count int
+				var first, last time.Time
+				err := pipeline.ReadDeadLetterFile(path, func(r pipeline.DeadLetterRecord) error {
+					if count == 0 {
+						first = r.Timestamp
+					}
+					last = r.Timestamp
+					count++
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%s\t%d events\t%s\t%s\n", path, count, //nolint:forbidigo // command output
+					first.Format(time.RFC3339), last.Format(time.RFC3339))
+			}
+			return nil
+		}),
+	}
+}
+
+func genInspectDeadLetterCmd(settings instance.Settings) *cobra.Command {
+	var limit int
+	command := &cobra.Command{
+		Use:   "inspect [file...]",
+		Short: "Print dead letters as JSON, one per line",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			files := args
+			if len(files) == 0 {
+				var err error
+				if _, files, err = deadLetterFiles(settings); err != nil {
+					return err
+				}
+			}
+
+			errLimit := errors.New("limit reached")
+			printed := 0
+			enc := json.NewEncoder(os.Stdout)
+			for _, path := range files {
+				err := pipeline.ReadDeadLetterFile(path, func(r pipeline.DeadLetterRecord) error {
+					if limit > 0 && printed >= limit {
+						return errLimit
+					}
+					printed++
+					return enc.Encode(r)
+				})
+				if errors.Is(err, errLimit) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	}
+	command.Flags().IntVar(&limit, "limit", 0, "Maximum number of dead letters to print, 0 for all")
+	return command
+}
+
+func genReplayDeadLetterCmd(settings instance.Settings) *cobra.Command {
+	var keep bool
+	var bulkSize, attempts int
+	command := &cobra.Command{
+		Use:   "replay",
+		Short: "Publish dead letters again to the configured output",
+		Long: "Publish dead letters again to the configured output. Files are deleted once\n" +
+			"all their events are acknowledged, unless --keep is set. The Beat must be stopped.",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			b, files, err := deadLetterFiles(settings)
+			if err != nil {
+				return err
+			}
+
+			// The dead letter queue must not be written while it's replayed.
+			lock := locks.New(b.Info)
+			if err := lock.Lock(); err != nil {
+				return err
+			}
+			defer func() { _ = lock.Unlock() }()
+
+			im, _ := idxmgmt.DefaultSupport(b.Info, nil)
+			group, err := outputs.Load(im, b.Info, nil, b.Config.Output.Name(), b.Config.Output.Config())
+			if err != nil {
+				return fmt.Errorf("error initializing output: %w", err)
+			}
+			if len(group.Clients) == 0 {
+				return errors.New("no output configured")
+			}
+			client := group.Clients[0]
+			defer client.Close()
+
+			ctx := cmd.Context()
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if c, ok := client.(outputs.Connectable); ok {
+				if err := c.Connect(ctx); err != nil {
+					return fmt.Errorf("failed to connect to the output: %w", err)
+				}
+			}
+
+			for _, path := range files {
+				acked, rejected, dropped, err := pipeline.ReplayDeadLetterFile(ctx, client, path, bulkSize, attempts, time.Second)
+				fmt.Printf("%s: %d events published, %d rejected, %d dropped\n", path, acked, rejected, dropped) //nolint:forbidigo // command output
+				if err != nil {
+					return fmt.Errorf("failed to replay %v: %w", path, err)
+				}
+				// The file is kept if the output didn't accept all its events.
+				if rejected == 0 && dropped == 0 && !keep {
+					if err := os.Remove(path); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}),
+	}
+	command.Flags().BoolVar(&keep, "keep", false, "Keep dead letter files after replaying them")
+	command.Flags().IntVar(&bulkSize, "bulk-max-size", 50, "Number of events published at once")
+	command.Flags().IntVar(&attempts, "max-attempts", 3, "Number of attempts to publish events the output asks to retry")
+	return command
+}
+
+func deadLetterFiles(settings instance.Settings) (*instance.Beat, []string, error) {
+	b, err := instance.NewInitializedBeat(settings)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error initializing beat: %w", err)
+	}
+
+	cfg, err := pipeline.ReadDeadLetterConfig(b.Config.Pipeline.DeadLetterQueue)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Output.IsSet() {
+		return nil, nil, errors.New("the dead letter queue publishes to an output, it has no files")
+	}
+	files, err := pipeline.DeadLetterFiles(cfg.DirectoryPath())
+	return b, files, err
+}
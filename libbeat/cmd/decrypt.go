@@ -0,0 +1,142 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/elastic/beats/v7/libbeat/cmd/instance"
+	"github.com/elastic/beats/v7/libbeat/common/cli"
+	"github.com/elastic/beats/v7/libbeat/processors/protect_fields"
+)
+
+func genDecryptCmd(settings instance.Settings) *cobra.Command {
+	var keyNames, keyFiles []string
+	command := &cobra.Command{
+		Use:   "decrypt [value...]",
+		Short: "Decrypt values encrypted by the protect_fields processor",
+		Long: "Decrypt the values given as arguments, one per line. Without arguments, events\n" +
+			"are read from stdin as JSON, one per line, and printed with all their encrypted\n" +
+			"values decrypted. The base64 encoded keys are read from the keystore entries\n" +
+			"given with --key, or from the files given with --key-file.",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			keys, err := decryptionKeys(settings, keyNames, keyFiles)
+			if err != nil {
+				return err
+			}
+			d, err := protect_fields.NewDecrypter(keys...)
+			if err != nil {
+				return err
+			}
+
+			enc := json.NewEncoder(os.Stdout)
+			if len(args) > 0 {
+				for _, arg := range args {
+					value, err := d.Decrypt(arg)
+					if err != nil {
+						return err
+					}
+					if s, ok := value.(string); ok {
+						fmt.Println(s) //nolint:forbidigo // command output
+						continue
+					}
+					if err := enc.Encode(value); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			scanner := bufio.NewScanner(os.Stdin)
+			scanner.Buffer(nil, 100*1024*1024)
+			for line := 1; scanner.Scan(); line++ {
+				var event map[string]interface{}
+				dec := json.NewDecoder(strings.NewReader(scanner.Text()))
+				dec.UseNumber()
+				if err := dec.Decode(&event); err != nil {
+					return fmt.Errorf("failed to decode event on line %d: %w", line, err)
+				}
+				if _, err := d.DecryptAll(event); err != nil {
+					fmt.Fprintf(os.Stderr, "line %d: %v\n", line, err)
+				}
+				if err := enc.Encode(event); err != nil {
+					return err
+				}
+			}
+			return scanner.Err()
+		}),
+	}
+	command.Flags().StringArrayVar(&keyNames, "key", nil, "Keystore entry holding a decryption key, can be repeated")
+	command.Flags().StringArrayVar(&keyFiles, "key-file", nil, "File holding a decryption key, can be repeated")
+	return command
+}
+
+// decryptionKeys reads the base64 encoded keys from the keystore entries
+// and the files.
+func decryptionKeys(settings instance.Settings, keyNames, keyFiles []string) ([][]byte, error) {
+	if len(keyNames) == 0 && len(keyFiles) == 0 {
+		return nil, errors.New("at least one key must be given with --key or --key-file")
+	}
+
+	var encoded []string
+	if len(keyNames) > 0 {
+		store, err := getKeystore(settings)
+		if err != nil {
+			return nil, err
+		}
+		if store == nil {
+			return nil, errors.New("the keystore is not available")
+		}
+		for _, name := range keyNames {
+			secret, err := store.Retrieve(name)
+			if err != nil {
+				return nil, fmt.Errorf("failed to retrieve key %q from the keystore: %w", name, err)
+			}
+			value, err := secret.Get()
+			if err != nil {
+				return nil, err
+			}
+			encoded = append(encoded, string(value))
+		}
+	}
+	for _, path := range keyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		encoded = append(encoded, string(data))
+	}
+
+	keys := make([][]byte, 0, len(encoded))
+	for _, s := range encoded {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("keys must be base64 encoded: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
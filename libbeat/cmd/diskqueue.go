@@ -0,0 +1,350 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/elastic/beats/v7/libbeat/cmd/instance"
+	"github.com/elastic/beats/v7/libbeat/cmd/instance/locks"
+	"github.com/elastic/beats/v7/libbeat/common/cli"
+	"github.com/elastic/beats/v7/libbeat/outputs/codec/json"
+	"github.com/elastic/beats/v7/libbeat/publisher/pipeline"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue/diskqueue"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue/hybridqueue"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/paths"
+)
+
+func genDiskQueueCmd(settings instance.Settings) *cobra.Command {
+	var path string
+	command := &cobra.Command{
+		Use:   "diskqueue",
+		Short: "Inspect and repair the disk queue",
+		Long: "Inspect and repair the segment files of the disk queue, or of the disk buffer\n" +
+			"of the hybrid queue. The Beat should be stopped while using these commands.",
+	}
+	command.PersistentFlags().StringVar(&path, "path", "", "Queue directory to use instead of the configured one")
+
+	command.AddCommand(genListDiskQueueCmd(settings, &path))
+	command.AddCommand(genVerifyDiskQueueCmd(settings, &path))
+	command.AddCommand(genCatDiskQueueCmd(settings, &path))
+	command.AddCommand(genTruncateDiskQueueCmd(settings, &path))
+	command.AddCommand(genExportDiskQueueCmd(settings, &path))
+
+	return command
+}
+
+func genListDiskQueueCmd(settings instance.Settings, path *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the queue segments, their frames and size",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			_, queueSettings, err := diskQueueSettings(settings, *path)
+			if err != nil {
+				return err
+			}
+			if state, err := diskqueue.ReadQueueState(queueSettings); err == nil {
+				fmt.Printf("read position: segment %d, frame %d, offset %d\n", //nolint:forbidigo // command output
+					state.SegmentID, state.FrameIndex, state.ByteIndex)
+			}
+			segments, err := diskqueue.InspectSegments(queueSettings)
+			if err != nil {
+				return err
+			}
+			for _, segment := range segments {
+				printSegmentInfo(segment)
+			}
+			return nil
+		}),
+	}
+}
+
+func genVerifyDiskQueueCmd(settings instance.Settings, path *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "Validate the checksums of all frames, and report invalid segments",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			_, queueSettings, err := diskQueueSettings(settings, *path)
+			if err != nil {
+				return err
+			}
+			segments, err := diskqueue.InspectSegments(queueSettings)
+			if err != nil {
+				return err
+			}
+			invalid := 0
+			for _, segment := range segments {
+				if segment.Err != nil {
+					printSegmentInfo(segment)
+					invalid++
+				}
+			}
+			if invalid > 0 {
+				return fmt.Errorf("%d of %d segments are invalid", invalid, len(segments))
+			}
+			fmt.Printf("%d segments are valid\n", len(segments)) //nolint:forbidigo // command output
+			return nil
+		}),
+	}
+}
+
+func genCatDiskQueueCmd(settings instance.Settings, path *string) *cobra.Command {
+	var limit int
+	command := &cobra.Command{
+		Use:   "cat [segment-id...]",
+		Short: "Decode and print the events of the queue, one JSON document per line",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			b, queueSettings, err := diskQueueSettings(settings, *path)
+			if err != nil {
+				return err
+			}
+			ids, err := segmentIDArgs(queueSettings, args)
+			if err != nil {
+				return err
+			}
+
+			errLimit := errors.New("limit reached")
+			printed := 0
+			encoder := json.New(b.Info.Version, json.Config{})
+			for _, id := range ids {
+				info, err := diskqueue.ScanSegment(queueSettings, id, func(frame diskqueue.Frame) error {
+					if limit > 0 && printed >= limit {
+						return errLimit
+					}
+					printed++
+					return writeDiskQueueEvent(os.Stdout, encoder, b.Info.Beat, frame)
+				})
+				if errors.Is(err, errLimit) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if info.Err != nil {
+					fmt.Fprintf(os.Stderr, "segment %d: %v\n", id, info.Err)
+				}
+			}
+			return nil
+		}),
+	}
+	command.Flags().IntVar(&limit, "limit", 0, "Maximum number of events to print, 0 for all")
+	return command
+}
+
+func genTruncateDiskQueueCmd(settings instance.Settings, path *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "truncate [segment-id...]",
+		Short: "Remove the corrupt data after the last valid frame of segments",
+		Long: "Remove the corrupt data after the last valid frame of the given segments, or of\n" +
+			"all segments if none is given, and update their frame count. The Beat must be stopped.",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			b, queueSettings, err := diskQueueSettings(settings, *path)
+			if err != nil {
+				return err
+			}
+			ids, err := segmentIDArgs(queueSettings, args)
+			if err != nil {
+				return err
+			}
+
+			// The queue must not be written while it's repaired.
+			lock := locks.New(b.Info)
+			if err := lock.Lock(); err != nil {
+				return err
+			}
+			defer func() { _ = lock.Unlock() }()
+
+			for _, id := range ids {
+				info, err := diskqueue.ScanSegment(queueSettings, id, nil)
+				if err != nil {
+					return err
+				}
+				if info.Err == nil {
+					continue
+				}
+				size := info.Size
+				info, err = diskqueue.TruncateSegment(queueSettings, id)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("segment %d: truncated to %d frames, removed %d bytes\n", //nolint:forbidigo // command output
+					id, info.Frames, size-info.Size)
+			}
+			return nil
+		}),
+	}
+}
+
+func genExportDiskQueueCmd(settings instance.Settings, path *string) *cobra.Command {
+	var output string
+	command := &cobra.Command{
+		Use:   "export",
+		Short: "Export the pending events of the queue to NDJSON",
+		Long: "Export the events of the queue that were not acknowledged yet to NDJSON, one\n" +
+			"event per line, so they can be replayed.",
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			b, queueSettings, err := diskQueueSettings(settings, *path)
+			if err != nil {
+				return err
+			}
+
+			var out io.Writer = os.Stdout
+			if output != "" {
+				file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				out = file
+			}
+
+			exported := 0
+			encoder := json.New(b.Info.Version, json.Config{})
+			invalid, err := diskqueue.ScanPendingEvents(queueSettings, func(frame diskqueue.Frame) error {
+				exported++
+				return writeDiskQueueEvent(out, encoder, b.Info.Beat, frame)
+			})
+			if err != nil {
+				return err
+			}
+			for _, segment := range invalid {
+				fmt.Fprintf(os.Stderr, "segment %d: %v\n", segment.ID, segment.Err)
+			}
+			fmt.Fprintf(os.Stderr, "%d events exported\n", exported)
+			return nil
+		}),
+	}
+	command.Flags().StringVarP(&output, "output", "o", "", "File to write the events to, instead of stdout")
+	return command
+}
+
+// diskQueueSettings returns the settings of the configured disk queue, or
+// of the disk buffer of the hybrid queue. If path is set, it's used as the
+// queue directory.
+func diskQueueSettings(settings instance.Settings, path string) (*instance.Beat, diskqueue.Settings, error) {
+	b, err := instance.NewInitializedBeat(settings)
+	if err != nil {
+		return nil, diskqueue.Settings{}, fmt.Errorf("error initializing beat: %w", err)
+	}
+
+	queueConfig, err := configuredQueue(b)
+	if err != nil {
+		return nil, diskqueue.Settings{}, err
+	}
+	queueSettings := diskqueue.DefaultSettings()
+	switch queueConfig.Name() {
+	case diskqueue.QueueType:
+		queueSettings, err = diskqueue.SettingsForUserConfig(queueConfig.Config())
+	case hybridqueue.QueueType:
+		var hybridSettings hybridqueue.Settings
+		hybridSettings, err = hybridqueue.SettingsForUserConfig(queueConfig.Config())
+		queueSettings = hybridSettings.Disk
+		if queueSettings.Path == "" {
+			queueSettings.Path = paths.Resolve(paths.Data, "hybridqueue")
+		}
+	default:
+		if path == "" {
+			return nil, diskqueue.Settings{}, errors.New(
+				"the disk queue is not configured, use --path to set its directory")
+		}
+	}
+	if err != nil {
+		return nil, diskqueue.Settings{}, err
+	}
+	if path != "" {
+		queueSettings.Path = path
+	}
+	return b, queueSettings, nil
+}
+
+// configuredQueue returns the queue namespace of the beat, which is either
+// set at the top level or under the configured output.
+func configuredQueue(b *instance.Beat) (config.Namespace, error) {
+	if b.Config.Pipeline.Queue.IsSet() {
+		return b.Config.Pipeline.Queue, nil
+	}
+	if !b.Config.Output.IsSet() || !b.Config.Output.Config().Enabled() {
+		return config.Namespace{}, nil
+	}
+	var outputConfig pipeline.Config
+	if err := b.Config.Output.Config().Unpack(&outputConfig); err != nil {
+		return config.Namespace{}, fmt.Errorf("error unpacking output queue settings: %w", err)
+	}
+	return outputConfig.Queue, nil
+}
+
+// segmentIDArgs parses the segment ids given as arguments, if none are
+// given it returns all the segments of the queue.
+func segmentIDArgs(queueSettings diskqueue.Settings, args []string) ([]uint64, error) {
+	if len(args) == 0 {
+		segments, err := diskqueue.InspectSegments(queueSettings)
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]uint64, len(segments))
+		for i, segment := range segments {
+			ids[i] = segment.ID
+		}
+		return ids, nil
+	}
+	ids := make([]uint64, len(args))
+	for i, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment id %q", arg)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func printSegmentInfo(segment diskqueue.SegmentInfo) {
+	status := "ok"
+	if segment.Err != nil {
+		status = segment.Err.Error()
+	}
+	options := ""
+	if segment.Compressed {
+		options += " compressed"
+	}
+	if segment.Encrypted {
+		options += fmt.Sprintf(" encrypted(key_id=%s)", segment.KeyID)
+	}
+	fmt.Printf("%s\tversion %d%s\t%d bytes\t%d frames (%d in header)\t%s\n", //nolint:forbidigo // command output
+		segment.Path, segment.Version, options, segment.Size,
+		segment.Frames, segment.HeaderFrameCount, status)
+}
+
+func writeDiskQueueEvent(out io.Writer, encoder *json.Encoder, index string, frame diskqueue.Frame) error {
+	serialized, err := encoder.Encode(index, &frame.Event.Content)
+	if err != nil {
+		return fmt.Errorf("couldn't encode frame %d of segment %d: %w", frame.Index, frame.SegmentID, err)
+	}
+	if _, err := out.Write(serialized); err != nil {
+		return err
+	}
+	_, err = out.Write([]byte("\n"))
+	return err
+}
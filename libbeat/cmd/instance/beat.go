@@ -64,6 +64,7 @@ import (
 	"github.com/elastic/beats/v7/libbeat/publisher/pipeline"
 	"github.com/elastic/beats/v7/libbeat/publisher/processing"
 	"github.com/elastic/beats/v7/libbeat/publisher/queue/diskqueue"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue/hybridqueue"
 	"github.com/elastic/beats/v7/libbeat/version"
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/file"
@@ -384,6 +385,7 @@ func (b *Beat) createBeater(bt beat.Creator) (beat.Beater, error) {
 		WaitClose:      time.Second,
 		Processors:     b.processors,
 		InputQueueSize: b.InputQueueSize,
+		IndexManager:   b.IdxSupporter,
 	}
 	publisher, err = pipeline.LoadWithSettings(b.Info, monitors, b.Config.Pipeline, outputFactory, settings)
 	if err != nil {
@@ -1560,15 +1562,20 @@ func (bc *beatConfig) Validate() error {
 			return fmt.Errorf("top level queue and output level queue settings defined, only one is allowed")
 		}
 		// elastic-agent doesn't support disk queue yet
-		if bc.Management.Enabled() && outputPC.Queue.Config().Enabled() && outputPC.Queue.Name() == diskqueue.QueueType {
+		if bc.Management.Enabled() && outputPC.Queue.Config().Enabled() && usesDiskQueue(outputPC.Queue.Name()) {
 			return fmt.Errorf("disk queue is not supported when management is enabled")
 		}
 	}
 
 	// elastic-agent doesn't support disk queue yet
-	if bc.Management.Enabled() && bc.Pipeline.Queue.Config().Enabled() && bc.Pipeline.Queue.Name() == diskqueue.QueueType {
+	if bc.Management.Enabled() && bc.Pipeline.Queue.Config().Enabled() && usesDiskQueue(bc.Pipeline.Queue.Name()) {
 		return fmt.Errorf("disk queue is not supported when management is enabled")
 	}
 
 	return nil
 }
+
+// usesDiskQueue returns whether the queue type stores events on disk.
+func usesDiskQueue(queueType string) bool {
+	return queueType == diskqueue.QueueType || queueType == hybridqueue.QueueType
+}
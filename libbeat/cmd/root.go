@@ -41,6 +41,9 @@ type BeatsRootCmd struct {
 	ExportCmd     *cobra.Command
 	TestCmd       *cobra.Command
 	KeystoreCmd   *cobra.Command
+	DeadLetterCmd *cobra.Command
+	DiskQueueCmd  *cobra.Command
+	DecryptCmd    *cobra.Command
 }
 
 // GenRootCmdWithSettings returns the root command to use for your beat. It take the
@@ -72,6 +75,9 @@ func GenRootCmdWithSettings(beatCreator beat.Creator, settings instance.Settings
 	rootCmd.TestCmd = genTestCmd(settings, beatCreator)
 	rootCmd.SetupCmd = genSetupCmd(settings, beatCreator)
 	rootCmd.KeystoreCmd = genKeystoreCmd(settings)
+	rootCmd.DeadLetterCmd = genDeadLetterCmd(settings)
+	rootCmd.DiskQueueCmd = genDiskQueueCmd(settings)
+	rootCmd.DecryptCmd = genDecryptCmd(settings)
 	rootCmd.VersionCmd = GenVersionCmd(settings)
 	rootCmd.CompletionCmd = genCompletionCmd(settings, rootCmd)
 
@@ -108,6 +114,9 @@ func GenRootCmdWithSettings(beatCreator beat.Creator, settings instance.Settings
 	if rootCmd.KeystoreCmd != nil {
 		rootCmd.AddCommand(rootCmd.KeystoreCmd)
 	}
+	rootCmd.AddCommand(rootCmd.DeadLetterCmd)
+	rootCmd.AddCommand(rootCmd.DiskQueueCmd)
+	rootCmd.AddCommand(rootCmd.DecryptCmd)
 
 	return rootCmd
 }
@@ -37,39 +37,41 @@ import (
 // TODO: Replace this with a proper solution that uses the metric type from
 // where it is defined. See: https://github.com/elastic/beats/issues/5433
 var gauges = map[string]bool{
-	"libbeat.output.events.active":         true,
-	"libbeat.pipeline.events.active":       true,
-	"libbeat.pipeline.clients":             true,
-	"libbeat.pipeline.queue.max_events":    true,
-	"libbeat.pipeline.queue.max_bytes":     true,
-	"libbeat.pipeline.queue.filled.events": true,
-	"libbeat.pipeline.queue.filled.bytes":  true,
-	"libbeat.pipeline.queue.filled.pct":    true,
-	"libbeat.config.module.running":        true,
-	"registrar.states.current":             true,
-	"filebeat.events.active":               true,
-	"filebeat.harvester.running":           true,
-	"filebeat.harvester.open_files":        true,
-	"beat.memstats.memory_total":           true,
-	"beat.memstats.memory_alloc":           true,
-	"beat.memstats.rss":                    true,
-	"beat.memstats.gc_next":                true,
-	"beat.info.uptime.ms":                  true,
-	"beat.cgroup.memory.mem.usage.bytes":   true,
-	"beat.cpu.user.ticks":                  true,
-	"beat.cpu.system.ticks":                true,
-	"beat.cpu.total.value":                 true,
-	"beat.cpu.total.ticks":                 true,
-	"beat.handles.open":                    true,
-	"beat.handles.limit.hard":              true,
-	"beat.handles.limit.soft":              true,
-	"beat.runtime.goroutines":              true,
-	"system.load.1":                        true,
-	"system.load.5":                        true,
-	"system.load.15":                       true,
-	"system.load.norm.1":                   true,
-	"system.load.norm.5":                   true,
-	"system.load.norm.15":                  true,
+	"libbeat.output.events.active":                true,
+	"libbeat.pipeline.events.active":              true,
+	"libbeat.pipeline.clients":                    true,
+	"libbeat.pipeline.queue.max_events":           true,
+	"libbeat.pipeline.queue.max_bytes":            true,
+	"libbeat.pipeline.queue.filled.events":        true,
+	"libbeat.pipeline.queue.filled.bytes":         true,
+	"libbeat.pipeline.queue.filled.pct":           true,
+	"libbeat.pipeline.queue.spill.active":         true,
+	"libbeat.pipeline.queue.spill.pending.events": true,
+	"libbeat.config.module.running":               true,
+	"registrar.states.current":                    true,
+	"filebeat.events.active":                      true,
+	"filebeat.harvester.running":                  true,
+	"filebeat.harvester.open_files":               true,
+	"beat.memstats.memory_total":                  true,
+	"beat.memstats.memory_alloc":                  true,
+	"beat.memstats.rss":                           true,
+	"beat.memstats.gc_next":                       true,
+	"beat.info.uptime.ms":                         true,
+	"beat.cgroup.memory.mem.usage.bytes":          true,
+	"beat.cpu.user.ticks":                         true,
+	"beat.cpu.system.ticks":                       true,
+	"beat.cpu.total.value":                        true,
+	"beat.cpu.total.ticks":                        true,
+	"beat.handles.open":                           true,
+	"beat.handles.limit.hard":                     true,
+	"beat.handles.limit.soft":                     true,
+	"beat.runtime.goroutines":                     true,
+	"system.load.1":                               true,
+	"system.load.5":                               true,
+	"system.load.15":                              true,
+	"system.load.norm.1":                          true,
+	"system.load.norm.5":                          true,
+	"system.load.norm.15":                         true,
 }
 
 // isGauge returns true when the given metric key name represents a gauge value.
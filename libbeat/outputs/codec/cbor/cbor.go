@@ -0,0 +1,104 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cbor
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/go-structform"
+	"github.com/elastic/go-structform/cborl"
+	"github.com/elastic/go-structform/gotype"
+)
+
+// tagDateTime is the CBOR tag for RFC 3339 date/time strings (RFC 8949, section 3.4.1).
+const tagDateTime = 0xc0
+
+// Encoder encodes events as CBOR maps. Timestamps are encoded as tagged
+// RFC 3339 strings with nanosecond precision, so no information is lost.
+type Encoder struct {
+	buf     bytes.Buffer
+	folder  *gotype.Iterator
+	version string
+}
+
+// visitor reports all objects as having an unknown length, as the length
+// reported for structs with inlined fields (like the event's fields) is the
+// number of struct fields. Objects are serialized as indefinite length maps.
+type visitor struct {
+	*cborl.Visitor
+}
+
+func (v visitor) OnObjectStart(_ int, baseType structform.BaseType) error {
+	return v.Visitor.OnObjectStart(-1, baseType)
+}
+
+func init() {
+	codec.RegisterType("cbor", func(info beat.Info, _ *config.C) (codec.Codec, error) {
+		return New(info.Version), nil
+	})
+}
+
+// New creates a new CBOR encoder reporting the given beat version in the
+// event metadata.
+func New(version string) *Encoder {
+	e := &Encoder{version: version}
+	e.reset()
+	return e
+}
+
+func (e *Encoder) reset() {
+	visitor := visitor{cborl.NewVisitor(&e.buf)}
+
+	var err error
+	e.folder, err = gotype.NewIterator(visitor,
+		gotype.Folders(
+			func(t *time.Time, _ structform.ExtVisitor) error {
+				return e.encodeTime(visitor, *t)
+			},
+			func(t *common.Time, _ structform.ExtVisitor) error {
+				return e.encodeTime(visitor, time.Time(*t))
+			},
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (e *Encoder) encodeTime(visitor visitor, t time.Time) error {
+	if err := e.buf.WriteByte(tagDateTime); err != nil {
+		return err
+	}
+	return visitor.OnString(t.Format(time.RFC3339Nano))
+}
+
+// Encode serializes the event into a CBOR map.
+func (e *Encoder) Encode(index string, event *beat.Event) ([]byte, error) {
+	e.buf.Reset()
+	err := e.folder.Fold(codec.MakeEvent(index, e.version, event))
+	if err != nil {
+		e.reset()
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
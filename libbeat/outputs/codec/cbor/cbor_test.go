@@ -0,0 +1,84 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package cbor
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ugorji "github.com/ugorji/go/codec"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestCBORTimestamps(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 10, 11, 12, 123456789, time.UTC)
+	event := beat.Event{
+		Timestamp: ts,
+		Fields:    mapstr.M{"created": common.Time(ts)},
+	}
+
+	data, err := New("1").Encode("x", &event)
+	require.NoError(t, err)
+
+	// Timestamps are tagged (0xc0) RFC 3339 strings with nanosecond precision.
+	expected := "\xbf" +
+		"\x6a@timestamp\xc0\x78\x1e2024-05-01T10:11:12.123456789Z" +
+		"\x69@metadata\xbf\x64beat\x61x\x64type\x64_doc\x67version\x611\xff" +
+		"\x67created\xc0\x78\x1e2024-05-01T10:11:12.123456789Z" +
+		"\xff"
+	assert.Equal(t, expected, string(data))
+}
+
+func TestCBORCodec(t *testing.T) {
+	event := beat.Event{
+		Meta: mapstr.M{"pipeline": "p1"},
+		Fields: mapstr.M{
+			"message":  "hello",
+			"negative": -42,
+			"ratio":    0.5,
+			"tags":     []string{"a", "b"},
+			"nested":   mapstr.M{"ok": true},
+		},
+	}
+
+	data, err := New("1.2.3").Encode("test", &event)
+	require.NoError(t, err)
+
+	var h ugorji.CborHandle
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	var out map[string]interface{}
+	require.NoError(t, ugorji.NewDecoderBytes(data, &h).Decode(&out))
+
+	assert.Equal(t, map[string]interface{}{
+		"beat":     "test",
+		"type":     "_doc",
+		"version":  "1.2.3",
+		"pipeline": "p1",
+	}, out["@metadata"])
+	assert.Equal(t, "hello", out["message"])
+	assert.EqualValues(t, -42, out["negative"])
+	assert.Equal(t, 0.5, out["ratio"])
+	assert.Equal(t, []interface{}{"a", "b"}, out["tags"])
+	assert.Equal(t, map[string]interface{}{"ok": true}, out["nested"])
+}
@@ -15,7 +15,7 @@
 // specific language governing permissions and limitations
 // under the License.
 
-package json
+package codec
 
 import (
 	"time"
@@ -26,24 +26,26 @@ import (
 
 // Event describes the event structure for events
 // (in-)directly send to logstash
-type event struct {
+type Event struct {
 	Timestamp time.Time `struct:"@timestamp"`
-	Meta      meta      `struct:"@metadata"`
+	Meta      EventMeta `struct:"@metadata"`
 	Fields    mapstr.M  `struct:",inline"`
 }
 
-// Meta defines common event metadata to be stored in '@metadata'
-type meta struct {
+// EventMeta defines common event metadata to be stored in '@metadata'
+type EventMeta struct {
 	Beat    string                 `struct:"beat"`
 	Type    string                 `struct:"type"`
 	Version string                 `struct:"version"`
 	Fields  map[string]interface{} `struct:",inline"`
 }
 
-func makeEvent(index, version string, in *beat.Event) event {
-	return event{
+// MakeEvent creates the serializable representation of an event, with the
+// beat name and version reported in the @metadata field.
+func MakeEvent(index, version string, in *beat.Event) Event {
+	return Event{
 		Timestamp: in.Timestamp,
-		Meta: meta{
+		Meta: EventMeta{
 			Beat:    index,
 			Version: version,
 			Type:    "_doc",
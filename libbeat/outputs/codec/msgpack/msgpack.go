@@ -0,0 +1,79 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package msgpack
+
+import (
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/go-structform"
+	"github.com/elastic/go-structform/gotype"
+)
+
+// Encoder encodes events as MessagePack maps. Timestamps are encoded using
+// the MessagePack timestamp extension type, so no precision is lost.
+type Encoder struct {
+	visitor visitor
+	folder  *gotype.Iterator
+	version string
+}
+
+func init() {
+	codec.RegisterType("msgpack", func(info beat.Info, _ *config.C) (codec.Codec, error) {
+		return New(info.Version), nil
+	})
+}
+
+// New creates a new MessagePack encoder reporting the given beat version in
+// the event metadata.
+func New(version string) *Encoder {
+	e := &Encoder{version: version}
+	e.reset()
+	return e
+}
+
+func (e *Encoder) reset() {
+	var err error
+	e.folder, err = gotype.NewIterator(&e.visitor,
+		gotype.Folders(
+			func(t *time.Time, _ structform.ExtVisitor) error {
+				return e.visitor.OnTime(*t)
+			},
+			func(t *common.Time, _ structform.ExtVisitor) error {
+				return e.visitor.OnTime(time.Time(*t))
+			},
+		),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Encode serializes the event into a MessagePack map.
+func (e *Encoder) Encode(index string, event *beat.Event) ([]byte, error) {
+	e.visitor.reset()
+	err := e.folder.Fold(codec.MakeEvent(index, e.version, event))
+	if err != nil {
+		e.reset()
+		return nil, err
+	}
+	return e.visitor.buf, nil
+}
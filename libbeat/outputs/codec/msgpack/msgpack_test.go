@@ -0,0 +1,123 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package msgpack
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ugorji "github.com/ugorji/go/codec"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func decode(t *testing.T, data []byte) map[string]interface{} {
+	t.Helper()
+
+	var h ugorji.MsgpackHandle
+	h.RawToString = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+
+	var out map[string]interface{}
+	require.NoError(t, ugorji.NewDecoderBytes(data, &h).Decode(&out))
+	return out
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 10, 11, 12, 123456789, time.UTC)
+	event := beat.Event{
+		Timestamp: ts,
+		Meta:      mapstr.M{"pipeline": "p1"},
+		Fields: mapstr.M{
+			"message":  "hello",
+			"negative": -1234567,
+			"large":    uint64(math.MaxUint64),
+			"ratio":    0.25,
+			"ok":       true,
+			"missing":  nil,
+			"created":  common.Time(ts),
+			"tags":     []string{"a", "b"},
+			"nested":   mapstr.M{"long": strings.Repeat("x", 300)},
+		},
+	}
+
+	enc := New("1.2.3")
+	data, err := enc.Encode("test", &event)
+	require.NoError(t, err)
+
+	out := decode(t, data)
+	assert.Equal(t, ts, out["@timestamp"].(time.Time).UTC())
+	assert.Equal(t, ts, out["created"].(time.Time).UTC())
+	assert.Equal(t, map[string]interface{}{
+		"beat":     "test",
+		"type":     "_doc",
+		"version":  "1.2.3",
+		"pipeline": "p1",
+	}, out["@metadata"])
+	assert.Equal(t, "hello", out["message"])
+	assert.EqualValues(t, -1234567, out["negative"])
+	assert.EqualValues(t, uint64(math.MaxUint64), out["large"])
+	assert.Equal(t, 0.25, out["ratio"])
+	assert.Equal(t, true, out["ok"])
+	assert.Contains(t, out, "missing")
+	assert.Nil(t, out["missing"])
+	assert.Equal(t, []interface{}{"a", "b"}, out["tags"])
+	assert.Equal(t, map[string]interface{}{"long": strings.Repeat("x", 300)}, out["nested"])
+}
+
+func TestMsgpackContainerHeaders(t *testing.T) {
+	fields := mapstr.M{}
+	for i := 0; i < 20; i++ {
+		fields[string(rune('a'+i))] = []int{i, i, i, i, i, i, i, i, i, i, i, i, i, i, i, i, i}
+	}
+	event := beat.Event{Fields: fields}
+
+	data, err := New("1.2.3").Encode("test", &event)
+	require.NoError(t, err)
+
+	out := decode(t, data)
+	assert.Len(t, out, len(fields)+2)
+	assert.Len(t, out["a"], 17)
+}
+
+func TestMsgpackTimestampFormats(t *testing.T) {
+	cases := map[string]time.Time{
+		"timestamp32": time.Unix(1700000000, 0),
+		"timestamp64": time.Unix(1700000000, 42),
+		"timestamp96": time.Date(1900, 1, 1, 0, 0, 0, 7, time.UTC),
+	}
+
+	for name, ts := range cases {
+		t.Run(name, func(t *testing.T) {
+			var v visitor
+			require.NoError(t, v.OnTime(ts))
+
+			var h ugorji.MsgpackHandle
+			var out time.Time
+			require.NoError(t, ugorji.NewDecoderBytes(v.buf, &h).Decode(&out))
+			assert.True(t, ts.Equal(out), "expected %v, got %v", ts, out)
+		})
+	}
+}
@@ -0,0 +1,310 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+
+	structform "github.com/elastic/go-structform"
+)
+
+// visitor is a structform.Visitor serializing into MessagePack.
+//
+// MessagePack requires the number of elements to be known upfront for maps
+// and arrays, but the event structure reports objects of unknown length
+// (e.g. inlined fields). Containers are therefore written with a placeholder
+// header that is replaced by the smallest matching header once the container
+// is finished.
+type visitor struct {
+	buf   []byte
+	stack []container
+}
+
+type container struct {
+	start int
+	count int
+	isMap bool
+}
+
+// placeholderLen is the size of the largest map/array header (map32/array32).
+const placeholderLen = 5
+
+const (
+	codeNil     = 0xc0
+	codeFalse   = 0xc2
+	codeTrue    = 0xc3
+	codeExt8    = 0xc7
+	codeFloat32 = 0xca
+	codeFloat64 = 0xcb
+	codeUint8   = 0xcc
+	codeUint16  = 0xcd
+	codeUint32  = 0xce
+	codeUint64  = 0xcf
+	codeInt8    = 0xd0
+	codeInt16   = 0xd1
+	codeInt32   = 0xd2
+	codeInt64   = 0xd3
+	codeFixExt4 = 0xd6
+	codeFixExt8 = 0xd7
+	codeStr8    = 0xd9
+	codeStr16   = 0xda
+	codeStr32   = 0xdb
+	codeArray16 = 0xdc
+	codeArray32 = 0xdd
+	codeMap16   = 0xde
+	codeMap32   = 0xdf
+
+	fixMap   = 0x80
+	fixArray = 0x90
+	fixStr   = 0xa0
+
+	// extTimestamp is the predefined timestamp extension type.
+	extTimestamp = 0xff
+)
+
+var errUnbalanced = errors.New("unbalanced msgpack container")
+
+func (v *visitor) reset() {
+	v.buf = v.buf[:0]
+	v.stack = v.stack[:0]
+}
+
+// value must be called before every value written, so the enclosing array
+// can keep track of its element count.
+func (v *visitor) value() {
+	if n := len(v.stack); n > 0 && !v.stack[n-1].isMap {
+		v.stack[n-1].count++
+	}
+}
+
+func (v *visitor) OnObjectStart(_ int, _ structform.BaseType) error {
+	v.value()
+	v.push(true)
+	return nil
+}
+
+func (v *visitor) OnObjectFinished() error {
+	return v.pop(true)
+}
+
+func (v *visitor) OnKey(s string) error {
+	n := len(v.stack)
+	if n == 0 || !v.stack[n-1].isMap {
+		return errors.New("msgpack key outside of object")
+	}
+	v.stack[n-1].count++
+	v.writeString(s)
+	return nil
+}
+
+func (v *visitor) OnKeyRef(s []byte) error {
+	return v.OnKey(string(s))
+}
+
+func (v *visitor) OnArrayStart(_ int, _ structform.BaseType) error {
+	v.value()
+	v.push(false)
+	return nil
+}
+
+func (v *visitor) OnArrayFinished() error {
+	return v.pop(false)
+}
+
+func (v *visitor) push(isMap bool) {
+	v.stack = append(v.stack, container{start: len(v.buf), isMap: isMap})
+	v.buf = append(v.buf, make([]byte, placeholderLen)...)
+}
+
+func (v *visitor) pop(isMap bool) error {
+	n := len(v.stack)
+	if n == 0 || v.stack[n-1].isMap != isMap {
+		return errUnbalanced
+	}
+	c := v.stack[n-1]
+	v.stack = v.stack[:n-1]
+
+	var hdr [placeholderLen]byte
+	var h []byte
+	switch {
+	case c.count < 16:
+		if isMap {
+			hdr[0] = fixMap | byte(c.count)
+		} else {
+			hdr[0] = fixArray | byte(c.count)
+		}
+		h = hdr[:1]
+	case c.count <= math.MaxUint16:
+		hdr[0] = codeArray16
+		if isMap {
+			hdr[0] = codeMap16
+		}
+		binary.BigEndian.PutUint16(hdr[1:], uint16(c.count))
+		h = hdr[:3]
+	default:
+		hdr[0] = codeArray32
+		if isMap {
+			hdr[0] = codeMap32
+		}
+		binary.BigEndian.PutUint32(hdr[1:], uint32(c.count)) //nolint:gosec // containers are bounded by the event size
+		h = hdr[:5]
+	}
+
+	copy(v.buf[c.start:], h)
+	if shift := placeholderLen - len(h); shift > 0 {
+		body := c.start + placeholderLen
+		copy(v.buf[body-shift:], v.buf[body:])
+		v.buf = v.buf[:len(v.buf)-shift]
+	}
+	return nil
+}
+
+func (v *visitor) OnNil() error {
+	v.value()
+	v.buf = append(v.buf, codeNil)
+	return nil
+}
+
+func (v *visitor) OnBool(b bool) error {
+	v.value()
+	if b {
+		v.buf = append(v.buf, codeTrue)
+	} else {
+		v.buf = append(v.buf, codeFalse)
+	}
+	return nil
+}
+
+func (v *visitor) OnString(s string) error {
+	v.value()
+	v.writeString(s)
+	return nil
+}
+
+func (v *visitor) OnStringRef(s []byte) error {
+	return v.OnString(string(s))
+}
+
+func (v *visitor) writeString(s string) {
+	l := len(s)
+	switch {
+	case l < 32:
+		v.buf = append(v.buf, fixStr|byte(l))
+	case l <= math.MaxUint8:
+		v.buf = append(v.buf, codeStr8, byte(l))
+	case l <= math.MaxUint16:
+		v.buf = append(v.buf, codeStr16)
+		v.buf = binary.BigEndian.AppendUint16(v.buf, uint16(l))
+	default:
+		v.buf = append(v.buf, codeStr32)
+		v.buf = binary.BigEndian.AppendUint32(v.buf, uint32(l)) //nolint:gosec // strings are bounded by the event size
+	}
+	v.buf = append(v.buf, s...)
+}
+
+func (v *visitor) OnInt8(i int8) error   { return v.OnInt64(int64(i)) }
+func (v *visitor) OnInt16(i int16) error { return v.OnInt64(int64(i)) }
+func (v *visitor) OnInt32(i int32) error { return v.OnInt64(int64(i)) }
+func (v *visitor) OnInt(i int) error     { return v.OnInt64(int64(i)) }
+
+func (v *visitor) OnInt64(i int64) error {
+	if i >= 0 {
+		return v.OnUint64(uint64(i))
+	}
+
+	v.value()
+	switch {
+	case i >= -32:
+		v.buf = append(v.buf, byte(i)) //nolint:gosec // negative fixint
+	case i >= math.MinInt8:
+		v.buf = append(v.buf, codeInt8, byte(i)) //nolint:gosec // two's complement
+	case i >= math.MinInt16:
+		v.buf = append(v.buf, codeInt16)
+		v.buf = binary.BigEndian.AppendUint16(v.buf, uint16(i)) //nolint:gosec // two's complement
+	case i >= math.MinInt32:
+		v.buf = append(v.buf, codeInt32)
+		v.buf = binary.BigEndian.AppendUint32(v.buf, uint32(i)) //nolint:gosec // two's complement
+	default:
+		v.buf = append(v.buf, codeInt64)
+		v.buf = binary.BigEndian.AppendUint64(v.buf, uint64(i)) //nolint:gosec // two's complement
+	}
+	return nil
+}
+
+func (v *visitor) OnByte(b byte) error     { return v.OnUint64(uint64(b)) }
+func (v *visitor) OnUint8(u uint8) error   { return v.OnUint64(uint64(u)) }
+func (v *visitor) OnUint16(u uint16) error { return v.OnUint64(uint64(u)) }
+func (v *visitor) OnUint32(u uint32) error { return v.OnUint64(uint64(u)) }
+func (v *visitor) OnUint(u uint) error     { return v.OnUint64(uint64(u)) }
+
+func (v *visitor) OnUint64(u uint64) error {
+	v.value()
+	switch {
+	case u <= 0x7f:
+		v.buf = append(v.buf, byte(u))
+	case u <= math.MaxUint8:
+		v.buf = append(v.buf, codeUint8, byte(u))
+	case u <= math.MaxUint16:
+		v.buf = append(v.buf, codeUint16)
+		v.buf = binary.BigEndian.AppendUint16(v.buf, uint16(u))
+	case u <= math.MaxUint32:
+		v.buf = append(v.buf, codeUint32)
+		v.buf = binary.BigEndian.AppendUint32(v.buf, uint32(u))
+	default:
+		v.buf = append(v.buf, codeUint64)
+		v.buf = binary.BigEndian.AppendUint64(v.buf, u)
+	}
+	return nil
+}
+
+func (v *visitor) OnFloat32(f float32) error {
+	v.value()
+	v.buf = append(v.buf, codeFloat32)
+	v.buf = binary.BigEndian.AppendUint32(v.buf, math.Float32bits(f))
+	return nil
+}
+
+func (v *visitor) OnFloat64(f float64) error {
+	v.value()
+	v.buf = append(v.buf, codeFloat64)
+	v.buf = binary.BigEndian.AppendUint64(v.buf, math.Float64bits(f))
+	return nil
+}
+
+// OnTime writes the timestamp using the MessagePack timestamp extension
+// type, choosing the smallest of the timestamp 32/64/96 formats.
+func (v *visitor) OnTime(t time.Time) error {
+	v.value()
+	sec, nsec := t.Unix(), uint32(t.Nanosecond()) //nolint:gosec // nanoseconds are always < 1e9
+	switch {
+	case sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32:
+		v.buf = append(v.buf, codeFixExt4, extTimestamp)
+		v.buf = binary.BigEndian.AppendUint32(v.buf, uint32(sec))
+	case sec>>34 == 0:
+		v.buf = append(v.buf, codeFixExt8, extTimestamp)
+		v.buf = binary.BigEndian.AppendUint64(v.buf, uint64(nsec)<<34|uint64(sec))
+	default:
+		v.buf = append(v.buf, codeExt8, 12, extTimestamp)
+		v.buf = binary.BigEndian.AppendUint32(v.buf, nsec)
+		v.buf = binary.BigEndian.AppendUint64(v.buf, uint64(sec)) //nolint:gosec // two's complement
+	}
+	return nil
+}
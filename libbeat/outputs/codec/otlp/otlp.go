@@ -0,0 +1,107 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/otelbeat/otelmap"
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// metadataAttribute is the log record attribute holding the event metadata.
+const metadataAttribute = "@metadata"
+
+// Encoder encodes every event as an OTLP ExportLogsServiceRequest protobuf
+// message holding a single LogRecord. The event fields are stored as a map in
+// the record body, the event metadata in the @metadata attribute.
+type Encoder struct {
+	version   string
+	marshaler plog.ProtoMarshaler
+}
+
+func init() {
+	codec.RegisterType("otlp", func(info beat.Info, _ *config.C) (codec.Codec, error) {
+		return New(info.Version), nil
+	})
+}
+
+// New creates a new OTLP logs encoder reporting the given beat version as
+// the instrumentation scope version.
+func New(version string) *Encoder {
+	return &Encoder{version: version}
+}
+
+// Encode serializes the event into an OTLP logs protobuf message.
+func (e *Encoder) Encode(index string, event *beat.Event) ([]byte, error) {
+	logs := plog.NewLogs()
+	scopeLogs := logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty()
+	scopeLogs.Scope().SetName(index)
+	scopeLogs.Scope().SetVersion(e.version)
+	record := scopeLogs.LogRecords().AppendEmpty()
+
+	// The conversion to pcommon types happens in place, don't modify the
+	// event that might still be retried or published elsewhere.
+	fields := event.Fields.Clone()
+	fields["@timestamp"] = event.Timestamp
+
+	record.SetTimestamp(pcommon.NewTimestampFromTime(event.Timestamp))
+	observed := record.Timestamp()
+	if created, err := fields.GetValue("event.created"); err == nil {
+		switch created := created.(type) {
+		case time.Time:
+			observed = pcommon.NewTimestampFromTime(created)
+		case common.Time:
+			observed = pcommon.NewTimestampFromTime(time.Time(created))
+		}
+	}
+	record.SetObservedTimestamp(observed)
+
+	if level, err := fields.GetValue("log.level"); err == nil {
+		if level, ok := level.(string); ok {
+			record.SetSeverityText(level)
+		}
+	}
+
+	meta := mapstr.M{
+		"beat":    index,
+		"type":    "_doc",
+		"version": e.version,
+	}
+	for k, v := range event.Meta.Clone() {
+		meta[k] = v
+	}
+	otelmap.ConvertNonPrimitive(meta)
+	if err := record.Attributes().PutEmptyMap(metadataAttribute).FromRaw(meta); err != nil {
+		return nil, err
+	}
+
+	otelmap.ConvertNonPrimitive(fields)
+	if err := record.Body().SetEmptyMap().FromRaw(fields); err != nil {
+		return nil, err
+	}
+
+	return e.marshaler.MarshalLogs(logs)
+}
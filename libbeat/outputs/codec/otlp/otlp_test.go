@@ -0,0 +1,82 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/plog"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestOTLPCodec(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 10, 11, 12, 123456789, time.UTC)
+	created := ts.Add(-time.Second)
+	event := beat.Event{
+		Timestamp: ts,
+		Meta:      mapstr.M{"pipeline": "p1"},
+		Fields: mapstr.M{
+			"message": "hello",
+			"log":     mapstr.M{"level": "warn"},
+			"event":   mapstr.M{"created": created},
+			"tags":    []string{"a", "b"},
+			"count":   3,
+		},
+	}
+
+	data, err := New("1.2.3").Encode("test", &event)
+	require.NoError(t, err)
+
+	// The event itself must not be modified.
+	assert.Equal(t, created, event.Fields["event"].(mapstr.M)["created"])
+	assert.NotContains(t, event.Fields, "@timestamp")
+
+	var unmarshaler plog.ProtoUnmarshaler
+	logs, err := unmarshaler.UnmarshalLogs(data)
+	require.NoError(t, err)
+	require.Equal(t, 1, logs.LogRecordCount())
+
+	scope := logs.ResourceLogs().At(0).ScopeLogs().At(0)
+	assert.Equal(t, "test", scope.Scope().Name())
+	assert.Equal(t, "1.2.3", scope.Scope().Version())
+
+	record := scope.LogRecords().At(0)
+	assert.Equal(t, ts, record.Timestamp().AsTime())
+	assert.Equal(t, created, record.ObservedTimestamp().AsTime())
+	assert.Equal(t, "warn", record.SeverityText())
+
+	meta, ok := record.Attributes().Get("@metadata")
+	require.True(t, ok)
+	assert.Equal(t, map[string]any{
+		"beat":     "test",
+		"type":     "_doc",
+		"version":  "1.2.3",
+		"pipeline": "p1",
+	}, meta.Map().AsRaw())
+
+	body := record.Body().Map().AsRaw()
+	assert.Equal(t, "hello", body["message"])
+	assert.Equal(t, int64(3), body["count"])
+	assert.Equal(t, []any{"a", "b"}, body["tags"])
+	assert.Equal(t, "2024-05-01T10:11:12.123Z", body["@timestamp"])
+}
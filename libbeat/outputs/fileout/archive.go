@@ -0,0 +1,375 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package fileout
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/elastic/elastic-agent-libs/file"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+
+	// rotatedExtension is the extension of the files written by the rotator.
+	rotatedExtension = ".ndjson"
+)
+
+var compressionExtensions = map[string]string{
+	compressionGzip: ".gz",
+	compressionZstd: ".zst",
+}
+
+// archiver post-processes the files rotated by the file.Rotator: it
+// compresses them, optionally renaming them after the rotation timestamp, and
+// enforces the retention policy on all rotated files. It runs in the
+// background, triggered after every published batch.
+type archiver struct {
+	log *logp.Logger
+
+	// prefix is the path of the output file, without the date, index and
+	// extension added by the rotator.
+	prefix          string
+	compression     string
+	rotatedFilename *PathFormatString
+	permissions     os.FileMode
+	maxFiles        uint
+	maxTotalSize    uint64
+	maxAge          time.Duration
+	now             func() time.Time
+
+	trigger chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// rotatedFile is a file written by the rotator, ordered like the rotator
+// does: by the date in the name, then by the index.
+type rotatedFile struct {
+	path  string
+	date  string
+	index int
+}
+
+// archivedFile is any rotated file, compressed or not, subject to retention.
+type archivedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func newArchiver(log *logp.Logger, prefix string, c fileOutConfig) *archiver {
+	compression := c.Compression
+	if compression == "" {
+		compression = compressionNone
+	}
+	return &archiver{
+		log:             log,
+		prefix:          prefix,
+		compression:     compression,
+		rotatedFilename: c.RotatedFilename,
+		permissions:     os.FileMode(c.Permissions),
+		maxFiles:        c.NumberOfFiles,
+		maxTotalSize:    uint64(c.Retention.MaxTotalSize),
+		maxAge:          c.Retention.MaxAge,
+		now:             time.Now,
+		trigger:         make(chan struct{}, 1),
+		done:            make(chan struct{}),
+	}
+}
+
+func (a *archiver) Start() {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		for {
+			select {
+			case <-a.done:
+				return
+			case <-a.trigger:
+				if err := a.run(); err != nil {
+					a.log.Errorf("Failed to archive rotated files: %+v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop waits for the running archival to finish and stops the archiver.
+func (a *archiver) Stop() {
+	close(a.done)
+	a.wg.Wait()
+}
+
+// Notify requests a new archival run, without blocking.
+func (a *archiver) Notify() {
+	select {
+	case a.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// run compresses the rotated files and applies retention. Retention is
+// applied even if some files can't be compressed, so that a failing file
+// can't make the rotated files fill up the disk.
+func (a *archiver) run() error {
+	var errs []error
+	if a.compression != compressionNone {
+		rotated, err := a.rotatedFiles()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, f := range rotated {
+			if err := a.compress(f.path); err != nil {
+				errs = append(errs, fmt.Errorf("failed to compress %v: %w", f.path, err))
+			}
+		}
+	}
+	if err := a.applyRetention(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// rotatedFiles returns the files closed by the rotator, oldest first. The
+// active file, which is always the newest one, is excluded.
+func (a *archiver) rotatedFiles() ([]rotatedFile, error) {
+	paths, err := filepath.Glob(a.prefix + "-*" + rotatedExtension)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]rotatedFile, 0, len(paths))
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(path, a.prefix+"-"), rotatedExtension)
+		date, index, _ := strings.Cut(name, "-")
+		if len(date) != len(file.DateFormat) {
+			continue
+		}
+		f := rotatedFile{path: path, date: date}
+		if index != "" {
+			if f.index, err = strconv.Atoi(index); err != nil {
+				continue
+			}
+		}
+		files = append(files, f)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].date != files[j].date {
+			return files[i].date < files[j].date
+		}
+		return files[i].index < files[j].index
+	})
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+	return files[:len(files)-1], nil
+}
+
+// archiveName returns the path of the compressed archive for a rotated file.
+func (a *archiver) archiveName(path string, rotatedAt time.Time) (string, error) {
+	ext := rotatedExtension + compressionExtensions[a.compression]
+	base := strings.TrimSuffix(path, rotatedExtension)
+	if a.rotatedFilename != nil {
+		name, err := a.rotatedFilename.Run(rotatedAt.UTC())
+		if err != nil {
+			return "", err
+		}
+		if err := checkRotatedFilename(name, filepath.Base(a.prefix)); err != nil {
+			return "", err
+		}
+		base = filepath.Join(filepath.Dir(a.prefix), name)
+	}
+
+	target := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			return target, nil
+		}
+		target = base + "-" + strconv.Itoa(i) + ext
+	}
+}
+
+// checkRotatedFilename checks a name generated from rotated_filename for the
+// output file with the given name. Archives must keep the output file name as
+// prefix, so they are found again when applying retention.
+func checkRotatedFilename(name, filename string) error {
+	if filepath.Base(name) != name || !strings.HasPrefix(name, filename+"-") {
+		return fmt.Errorf("rotated_filename %q must start with %q and not contain a path",
+			name, filename+"-")
+	}
+	return nil
+}
+
+func (a *archiver) compress(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	target, err := a.archiveName(path, info.ModTime())
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := target + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_EXCL|os.O_CREATE|os.O_WRONLY, a.permissions)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := a.copyCompressed(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	// Keep the rotation time, so retention by age applies to when the events
+	// were written, not when they were compressed.
+	if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return err
+	}
+
+	a.log.Debugf("Compressed rotated file %v to %v", path, target)
+	return os.Remove(path)
+}
+
+func (a *archiver) copyCompressed(dst io.Writer, src io.Reader) error {
+	var w io.WriteCloser
+	switch a.compression {
+	case compressionGzip:
+		w = gzip.NewWriter(dst)
+	case compressionZstd:
+		enc, err := zstd.NewWriter(dst)
+		if err != nil {
+			return err
+		}
+		w = enc
+	default:
+		return fmt.Errorf("unsupported compression %v", a.compression)
+	}
+
+	if _, err := io.Copy(w, src); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// applyRetention removes the oldest rotated files, compressed or not, until
+// the number of files, their total size and their age are within limits.
+func (a *archiver) applyRetention() error {
+	files, err := a.retainedFiles()
+	if err != nil {
+		return err
+	}
+
+	var total uint64
+	for _, f := range files {
+		total += uint64(f.size) //nolint:gosec // file sizes are never negative
+	}
+
+	now := a.now()
+	for len(files) > 0 {
+		oldest := files[0]
+		tooMany := a.maxFiles > 0 && uint(len(files)) > a.maxFiles
+		tooLarge := a.maxTotalSize > 0 && total > a.maxTotalSize
+		tooOld := a.maxAge > 0 && now.Sub(oldest.modTime) > a.maxAge
+		if !tooMany && !tooLarge && !tooOld {
+			return nil
+		}
+
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete %v during retention: %w", oldest.path, err)
+		}
+		a.log.Debugf("Deleted rotated file %v during retention", oldest.path)
+		total -= uint64(oldest.size) //nolint:gosec // file sizes are never negative
+		files = files[1:]
+	}
+	return nil
+}
+
+// retainedFiles returns all rotated and archived files, oldest first.
+func (a *archiver) retainedFiles() ([]archivedFile, error) {
+	rotated, err := a.rotatedFiles()
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(rotated))
+	for _, f := range rotated {
+		paths = append(paths, f.path)
+	}
+
+	if ext, ok := compressionExtensions[a.compression]; ok {
+		archives, err := filepath.Glob(a.prefix + "-*" + rotatedExtension + ext)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, archives...)
+	}
+
+	files := make([]archivedFile, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		files = append(files, archivedFile{path: path, size: info.Size(), modTime: info.ModTime()})
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	return files, nil
+}
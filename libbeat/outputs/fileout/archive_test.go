@@ -0,0 +1,210 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package fileout
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func newTestArchiver(t *testing.T, dir string, settings mapstr.M) *archiver {
+	t.Helper()
+	c, err := readConfig(config.MustNewConfigFrom(settings))
+	require.NoError(t, err)
+	return newArchiver(logptest.NewTestingLogger(t, ""), filepath.Join(dir, "out"), *c)
+}
+
+func writeRotated(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestArchiverCompression(t *testing.T) {
+	rotatedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for name, test := range map[string]struct {
+		settings mapstr.M
+		archive  string
+		read     func(io.Reader) (io.Reader, error)
+	}{
+		"gzip": {
+			settings: mapstr.M{"compression": "gzip"},
+			archive:  "out-20240102.ndjson.gz",
+			read: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		"zstd": {
+			settings: mapstr.M{"compression": "zstd"},
+			archive:  "out-20240102.ndjson.zst",
+			read: func(r io.Reader) (io.Reader, error) {
+				return zstd.NewReader(r)
+			},
+		},
+		"rotated_filename": {
+			settings: mapstr.M{"compression": "gzip", "rotated_filename": "out-%{+yyyyMMdd-HHmmss}"},
+			archive:  "out-20240102-030405.ndjson.gz",
+			read: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := newTestArchiver(t, dir, test.settings)
+
+			writeRotated(t, filepath.Join(dir, "out-20240102.ndjson"), "{\"a\":1}\n", rotatedAt)
+			writeRotated(t, filepath.Join(dir, "out-20240102-1.ndjson"), "{\"a\":2}\n", rotatedAt.Add(time.Hour))
+			require.NoError(t, a.run())
+
+			// The newest file is still written by the rotator.
+			assert.FileExists(t, filepath.Join(dir, "out-20240102-1.ndjson"))
+			assert.NoFileExists(t, filepath.Join(dir, "out-20240102.ndjson"))
+
+			f, err := os.Open(filepath.Join(dir, test.archive))
+			require.NoError(t, err)
+			defer f.Close()
+
+			info, err := f.Stat()
+			require.NoError(t, err)
+			assert.True(t, rotatedAt.Equal(info.ModTime()), "archive must keep the rotation time")
+
+			r, err := test.read(f)
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			_, err = io.Copy(&buf, r)
+			require.NoError(t, err)
+			assert.Equal(t, "{\"a\":1}\n", buf.String())
+		})
+	}
+}
+
+func TestArchiverNameCollision(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestArchiver(t, dir, mapstr.M{"compression": "gzip", "rotated_filename": "out-%{+yyyyMMdd}"})
+
+	day := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeRotated(t, filepath.Join(dir, "out-20240102.ndjson"), "1\n", day)
+	writeRotated(t, filepath.Join(dir, "out-20240102-1.ndjson"), "2\n", day.Add(time.Minute))
+	writeRotated(t, filepath.Join(dir, "out-20240102-2.ndjson"), "3\n", day.Add(2*time.Minute))
+	require.NoError(t, a.run())
+
+	assert.FileExists(t, filepath.Join(dir, "out-20240102.ndjson.gz"))
+	assert.FileExists(t, filepath.Join(dir, "out-20240102-1.ndjson.gz"))
+}
+
+func TestArchiverRejectsForeignRotatedFilename(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestArchiver(t, dir, mapstr.M{"compression": "gzip", "rotated_filename": "other-%{+yyyyMMdd}"})
+
+	writeRotated(t, filepath.Join(dir, "out-20240102.ndjson"), "1\n", time.Now())
+	writeRotated(t, filepath.Join(dir, "out-20240102-1.ndjson"), "2\n", time.Now())
+	assert.ErrorContains(t, a.run(), "must start with")
+	assert.FileExists(t, filepath.Join(dir, "out-20240102.ndjson"))
+}
+
+func TestArchiverRetentionWhenCompressionFails(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestArchiver(t, dir, mapstr.M{
+		"compression":      "gzip",
+		"rotated_filename": "other-%{+yyyyMMdd}",
+		"number_of_files":  2,
+	})
+
+	for _, day := range []string{"01", "02", "03", "04"} {
+		modTime, err := time.Parse("20060102", "202401"+day)
+		require.NoError(t, err)
+		writeRotated(t, filepath.Join(dir, "out-202401"+day+".ndjson"), "1\n", modTime)
+	}
+	assert.ErrorContains(t, a.run(), "must start with")
+
+	// The files that can't be compressed are still subject to retention.
+	assert.NoFileExists(t, filepath.Join(dir, "out-20240101.ndjson"))
+	assert.FileExists(t, filepath.Join(dir, "out-20240102.ndjson"))
+	assert.FileExists(t, filepath.Join(dir, "out-20240103.ndjson"))
+}
+
+func TestArchiverRetention(t *testing.T) {
+	now := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	for name, test := range map[string]struct {
+		settings mapstr.M
+		kept     []string
+	}{
+		"number_of_files": {
+			settings: mapstr.M{"compression": "gzip", "number_of_files": 2},
+			kept:     []string{"out-20240103.ndjson.gz", "out-20240104.ndjson.gz"},
+		},
+		"max_age": {
+			settings: mapstr.M{"compression": "none", "retention.max_age": "60h"},
+			kept:     []string{"out-20240108.ndjson", "out-20240109.ndjson"},
+		},
+		"max_total_size": {
+			settings: mapstr.M{"compression": "none", "retention.max_total_size": "30B"},
+			kept:     []string{"out-20240108.ndjson", "out-20240109.ndjson"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := newTestArchiver(t, dir, test.settings)
+			a.now = func() time.Time { return now }
+
+			switch a.compression {
+			case compressionNone:
+				for _, day := range []string{"06", "07", "08", "09"} {
+					modTime, err := time.Parse("20060102", "202401"+day)
+					require.NoError(t, err)
+					writeRotated(t, filepath.Join(dir, "out-202401"+day+".ndjson"), "{\"event\":1}\n", modTime)
+				}
+			default:
+				for _, day := range []string{"01", "02", "03", "04"} {
+					modTime, err := time.Parse("20060102", "202401"+day)
+					require.NoError(t, err)
+					writeRotated(t, filepath.Join(dir, "out-202401"+day+".ndjson.gz"), "archived", modTime)
+				}
+			}
+			// Active file, never subject to retention.
+			writeRotated(t, filepath.Join(dir, "out-20240110.ndjson"), "{\"event\":1}\n", now)
+			require.NoError(t, a.run())
+
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			var files []string
+			for _, e := range entries {
+				files = append(files, e.Name())
+			}
+			assert.ElementsMatch(t, append(test.kept, "out-20240110.ndjson"), files)
+		})
+	}
+}
@@ -19,7 +19,10 @@ package fileout
 
 import (
 	"fmt"
+	"path/filepath"
+	"time"
 
+	"github.com/elastic/beats/v7/libbeat/common/cfgtype"
 	"github.com/elastic/beats/v7/libbeat/outputs/codec"
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/file"
@@ -33,9 +36,18 @@ type fileOutConfig struct {
 	Codec           codec.Config      `config:"codec"`
 	Permissions     uint32            `config:"permissions"`
 	RotateOnStartup bool              `config:"rotate_on_startup"`
+	RotateEvery     time.Duration     `config:"rotate_every"`
+	Compression     string            `config:"compression"`
+	RotatedFilename *PathFormatString `config:"rotated_filename"`
+	Retention       retentionConfig   `config:"retention"`
 	Queue           config.Namespace  `config:"queue"`
 }
 
+type retentionConfig struct {
+	MaxTotalSize cfgtype.ByteSize `config:"max_total_size"`
+	MaxAge       time.Duration    `config:"max_age"`
+}
+
 func defaultConfig() fileOutConfig {
 	return fileOutConfig{
 		Path:            &PathFormatString{},
@@ -58,11 +70,51 @@ func readConfig(cfg *config.C) (*fileOutConfig, error) {
 	return &foConfig, nil
 }
 
+// checkRotatedFilename checks that rotated_filename generates valid archive
+// names for the output file with the given name. If the name is not known yet,
+// because it defaults to the beat name, only the absence of a path is checked.
+func (c *fileOutConfig) checkRotatedFilename(filename string) error {
+	name, err := c.RotatedFilename.Run(time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("invalid rotated_filename: %w", err)
+	}
+	if filename == "" {
+		if filepath.Base(name) != name {
+			return fmt.Errorf("rotated_filename %q must not contain a path", name)
+		}
+		return nil
+	}
+	return checkRotatedFilename(name, filename)
+}
+
 func (c *fileOutConfig) Validate() error {
 	if c.NumberOfFiles < 2 || c.NumberOfFiles > file.MaxBackupsLimit {
 		return fmt.Errorf("the number_of_files to keep should be between 2 and %v",
 			file.MaxBackupsLimit)
 	}
 
+	if c.RotateEvery != 0 && c.RotateEvery < time.Second {
+		return fmt.Errorf("rotate_every must be at least 1s, got %v", c.RotateEvery)
+	}
+
+	switch c.Compression {
+	case "", compressionNone, compressionGzip, compressionZstd:
+	default:
+		return fmt.Errorf("unsupported compression %q, expected one of none, gzip or zstd", c.Compression)
+	}
+
+	if c.RotatedFilename != nil && (c.Compression == "" || c.Compression == compressionNone) {
+		return fmt.Errorf("rotated_filename requires compression to be enabled")
+	}
+	if c.RotatedFilename != nil {
+		if err := c.checkRotatedFilename(c.Filename); err != nil {
+			return err
+		}
+	}
+
+	if c.Retention.MaxAge < 0 {
+		return fmt.Errorf("retention.max_age must not be negative")
+	}
+
 	return nil
 }
@@ -69,6 +69,63 @@ func TestConfig(t *testing.T) {
 				assert.Nil(t, err)
 			},
 		},
+		"config with rotation, compression and retention": {
+			config: config.MustNewConfigFrom(mapstr.M{
+				"rotate_every":             "1h",
+				"compression":              "zstd",
+				"rotated_filename":         "pb-%{+yyyyMMdd-HHmmss}",
+				"retention.max_total_size": "1GiB",
+				"retention.max_age":        "72h",
+			}),
+			assertion: func(t *testing.T, actual *fileOutConfig, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, time.Hour, actual.RotateEvery)
+				assert.Equal(t, "zstd", actual.Compression)
+				assert.EqualValues(t, 1<<30, actual.Retention.MaxTotalSize)
+				assert.Equal(t, 72*time.Hour, actual.Retention.MaxAge)
+
+				name, runErr := actual.RotatedFilename.Run(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+				assert.Nil(t, runErr)
+				assert.Equal(t, "pb-20240102-030405", name)
+			},
+		},
+		"invalid compression": {
+			config: config.MustNewConfigFrom(mapstr.M{"compression": "lz4"}),
+			assertion: func(t *testing.T, _ *fileOutConfig, err error) {
+				assert.ErrorContains(t, err, "unsupported compression")
+			},
+		},
+		"rotate_every below one second": {
+			config: config.MustNewConfigFrom(mapstr.M{"rotate_every": "500ms"}),
+			assertion: func(t *testing.T, _ *fileOutConfig, err error) {
+				assert.ErrorContains(t, err, "rotate_every must be at least 1s")
+			},
+		},
+		"rotated_filename without compression": {
+			config: config.MustNewConfigFrom(mapstr.M{"rotated_filename": "pb-%{+yyyyMMdd}"}),
+			assertion: func(t *testing.T, _ *fileOutConfig, err error) {
+				assert.ErrorContains(t, err, "rotated_filename requires compression")
+			},
+		},
+		"rotated_filename without the filename prefix": {
+			config: config.MustNewConfigFrom(mapstr.M{
+				"filename":         "pb",
+				"compression":      "gzip",
+				"rotated_filename": "other-%{+yyyyMMdd}",
+			}),
+			assertion: func(t *testing.T, _ *fileOutConfig, err error) {
+				assert.ErrorContains(t, err, `must start with "pb-"`)
+			},
+		},
+		"rotated_filename with a path": {
+			config: config.MustNewConfigFrom(mapstr.M{
+				"compression":      "gzip",
+				"rotated_filename": "archive/pb-%{+yyyyMMdd}",
+			}),
+			assertion: func(t *testing.T, _ *fileOutConfig, err error) {
+				assert.ErrorContains(t, err, "must not contain a path")
+			},
+		},
 		"config given with windows path": {
 			useWindowsPath: true,
 			config: config.MustNewConfigFrom(mapstr.M{
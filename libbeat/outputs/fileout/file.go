@@ -43,6 +43,7 @@ type fileOutput struct {
 	beat     beat.Info
 	observer outputs.Observer
 	rotator  *file.Rotator
+	archiver *archiver
 	codec    codec.Codec
 }
 
@@ -84,34 +85,49 @@ func (out *fileOutput) init(beat beat.Info, c fileOutConfig) error {
 
 	out.filePath = path
 
+	if c.RotatedFilename != nil {
+		if err := c.checkRotatedFilename(filepath.Base(path)); err != nil {
+			return err
+		}
+	}
+
 	var err error
+	out.codec, err = codec.CreateEncoder(beat, c.Codec)
+	if err != nil {
+		return err
+	}
+
 	out.rotator, err = file.NewFileRotator(
 		path,
 		file.MaxSizeBytes(c.RotateEveryKb*1024),
 		file.MaxBackups(c.NumberOfFiles),
 		file.Permissions(os.FileMode(c.Permissions)),
 		file.RotateOnStartup(c.RotateOnStartup),
+		file.Interval(c.RotateEvery),
 		file.WithLogger(beat.Logger.Named("rotator").With(logp.Namespace("rotator"))),
 	)
 	if err != nil {
 		return err
 	}
 
-	out.codec, err = codec.CreateEncoder(beat, c.Codec)
-	if err != nil {
-		return err
-	}
+	out.archiver = newArchiver(beat.Logger.Named("archiver"), path, c)
+	out.archiver.Start()
+	// Compress and apply retention to files rotated before a restart.
+	out.archiver.Notify()
 
 	out.log.Infof("Initialized file output. "+
-		"path=%v max_size_bytes=%v max_backups=%v permissions=%v",
-		path, c.RotateEveryKb*1024, c.NumberOfFiles, os.FileMode(c.Permissions))
+		"path=%v max_size_bytes=%v max_backups=%v permissions=%v rotate_every=%v compression=%v",
+		path, c.RotateEveryKb*1024, c.NumberOfFiles, os.FileMode(c.Permissions),
+		c.RotateEvery, out.archiver.compression)
 
 	return nil
 }
 
 // Implement Outputer
 func (out *fileOutput) Close() error {
-	return out.rotator.Close()
+	err := out.rotator.Close()
+	out.archiver.Stop()
+	return err
 }
 
 func (out *fileOutput) Publish(_ context.Context, batch publisher.Batch) error {
@@ -135,6 +151,7 @@ func (out *fileOutput) Publish(_ context.Context, batch publisher.Batch) error {
 			}
 			out.log.Debug("Failed event logged to event log file")
 			out.log.Debugw(fmt.Sprintf("Failed event: %v", event), logp.TypeKey, logp.EventType)
+			publisher.DeadLetter(batch, events[i:i+1], err)
 
 			dropped++
 			continue
@@ -149,6 +166,7 @@ func (out *fileOutput) Publish(_ context.Context, batch publisher.Batch) error {
 			} else {
 				out.log.Warnf("Writing event to file failed with: %+v", err)
 			}
+			publisher.DeadLetter(batch, events[i:i+1], err)
 
 			dropped++
 			continue
@@ -163,6 +181,8 @@ func (out *fileOutput) Publish(_ context.Context, batch publisher.Batch) error {
 
 	st.AckedEvents(len(events) - dropped)
 
+	out.archiver.Notify()
+
 	return nil
 }
 
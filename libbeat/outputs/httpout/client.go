@@ -0,0 +1,421 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/beats/v7/libbeat/version"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/testing"
+	"github.com/elastic/elastic-agent-libs/transport"
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+	"github.com/elastic/elastic-agent-libs/useragent"
+)
+
+var errPayloadTooLarge = errors.New("the http endpoint rejected the batch because it was too large")
+
+type clientSettings struct {
+	url              string
+	method           string
+	headers          map[string]string
+	username         string
+	password         string
+	bearerToken      string
+	format           string
+	compressionLevel int
+	retryOnStatus    map[int]struct{}
+	index            string
+	codec            codec.Codec
+	observer         outputs.Observer
+	transport        httpcommon.HTTPTransportSettings
+	userAgent        string
+}
+
+type client struct {
+	clientSettings
+
+	log  *logp.Logger
+	http *http.Client
+	buf  bytes.Buffer
+}
+
+// itemsResponse is the body of a 207 Multi-Status response, reporting the
+// status of every event in the request body, in request order.
+type itemsResponse struct {
+	Items []struct {
+		Status int    `json:"status"`
+		Error  string `json:"error"`
+	} `json:"items"`
+}
+
+func newClient(s clientSettings, log *logp.Logger) (*client, error) {
+	if _, err := url.Parse(s.url); err != nil {
+		return nil, fmt.Errorf("failed to parse http output URL: %w", err)
+	}
+
+	if s.userAgent == "" {
+		s.userAgent = useragent.UserAgent("Libbeat", version.GetDefaultVersion(), version.Commit(), version.BuildTime().String())
+	}
+
+	return &client{
+		clientSettings: s,
+		log:            log,
+	}, nil
+}
+
+func (c *client) Connect(_ context.Context) error {
+	if c.http != nil {
+		return nil
+	}
+
+	httpClient, err := c.transport.Client(
+		httpcommon.WithLogger(c.log),
+		httpcommon.WithIOStats(c.observer),
+		httpcommon.WithHeaderRoundTripper(map[string]string{"User-Agent": c.userAgent}),
+	)
+	if err != nil {
+		return err
+	}
+	c.http = httpClient
+	return nil
+}
+
+func (c *client) Close() error {
+	if c.http != nil {
+		c.http.CloseIdleConnections()
+		c.http = nil
+	}
+	return nil
+}
+
+func (c *client) String() string {
+	return "http(" + c.url + ")"
+}
+
+func (c *client) Publish(ctx context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	encoded, deadLettered, err := c.encodeBody(batch, events)
+	if err != nil {
+		// Failing to assemble the request body is an internal error that is
+		// not going to resolve itself on retry.
+		c.log.Errorf("Failed to create request body: %+v", err)
+		c.observer.PermanentErrors(len(events))
+		publisher.DeadLetter(batch, withoutIndexes(events, deadLettered), err)
+		batch.Drop()
+		return nil
+	}
+	c.observer.PermanentErrors(len(events) - len(encoded))
+	if len(encoded) == 0 {
+		batch.ACK()
+		return nil
+	}
+
+	begin := time.Now()
+	status, body, err := c.send(ctx)
+	c.observer.ReportLatency(time.Since(begin))
+	if err != nil {
+		c.log.Errorf("Failed to publish events: %+v", err)
+		c.observer.RetryableErrors(len(encoded))
+		batch.RetryEvents(encoded)
+		return err
+	}
+
+	return c.handleResponse(batch, encoded, status, body)
+}
+
+// encodeBody serializes the events into the request buffer, returning the
+// events that could be encoded. Events failing to encode are dead lettered
+// and dropped, their indexes are returned even if the body can't be
+// assembled, so they aren't dead lettered again.
+func (c *client) encodeBody(batch publisher.Batch, events []publisher.Event) (encoded []publisher.Event, deadLettered []int, err error) {
+	c.buf.Reset()
+
+	var w io.Writer = &c.buf
+	var gz *gzip.Writer
+	if c.compressionLevel > 0 {
+		gz, err = gzip.NewWriterLevel(&c.buf, c.compressionLevel)
+		if err != nil {
+			return nil, nil, err
+		}
+		w = gz
+	}
+
+	if c.format == formatJSONArray {
+		if _, err := w.Write([]byte("[")); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	encoded = make([]publisher.Event, 0, len(events))
+	for i := range events {
+		event := &events[i]
+		serialized, err := c.codec.Encode(c.index, &event.Content)
+		if err != nil {
+			c.log.Errorf("Failed to encode event: %+v", err)
+			c.log.Errorw(fmt.Sprintf("Failed event: %v", event.Content), logp.TypeKey, logp.EventType)
+			publisher.DeadLetter(batch, []publisher.Event{*event}, err)
+			deadLettered = append(deadLettered, i)
+			continue
+		}
+
+		if c.format == formatJSONArray && len(encoded) > 0 {
+			if _, err := w.Write([]byte(",")); err != nil {
+				return nil, deadLettered, err
+			}
+		}
+		if _, err := w.Write(serialized); err != nil {
+			return nil, deadLettered, err
+		}
+		if c.format == formatNDJSON {
+			if _, err := w.Write([]byte("\n")); err != nil {
+				return nil, deadLettered, err
+			}
+		}
+		encoded = append(encoded, *event)
+	}
+
+	if c.format == formatJSONArray {
+		if _, err := w.Write([]byte("]")); err != nil {
+			return nil, deadLettered, err
+		}
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, deadLettered, err
+		}
+	}
+	return encoded, deadLettered, nil
+}
+
+// withoutIndexes returns the events except those at the given, ascending,
+// indexes.
+func withoutIndexes(events []publisher.Event, indexes []int) []publisher.Event {
+	if len(indexes) == 0 {
+		return events
+	}
+	rest := make([]publisher.Event, 0, len(events)-len(indexes))
+	for i, event := range events {
+		if len(indexes) > 0 && indexes[0] == i {
+			indexes = indexes[1:]
+			continue
+		}
+		rest = append(rest, event)
+	}
+	return rest
+}
+
+func (c *client) send(ctx context.Context) (int, []byte, error) {
+	if c.http == nil {
+		return 0, nil, errors.New("http output client is not connected")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, c.method, c.url, bytes.NewReader(c.buf.Bytes()))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if c.format == formatJSONArray {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+	if c.compressionLevel > 0 {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+
+	switch {
+	case c.bearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	case c.username != "" || c.password != "":
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	c.observer.ReadBytes(len(body))
+	return resp.StatusCode, body, nil
+}
+
+// handleResponse applies the per-status-code policy to the events that have
+// been sent to the endpoint:
+//   - 2xx: all events are ACKed.
+//   - 207: the body is parsed as an items response and every event is handled
+//     according to its own status.
+//   - 413: the batch is split and retried, or dropped if it can't be split.
+//   - statuses listed in retry_on_status: all events are retried.
+//   - any other status: all events are dropped.
+func (c *client) handleResponse(batch publisher.Batch, events []publisher.Event, status int, body []byte) error {
+	switch {
+	case status == http.StatusMultiStatus:
+		return c.handleItems(batch, events, body)
+
+	case status >= 200 && status < 300:
+		c.observer.AckedEvents(len(events))
+		batch.ACK()
+		return nil
+
+	case status == http.StatusRequestEntityTooLarge:
+		if batch.SplitRetry() {
+			c.observer.BatchSplit()
+			c.observer.RetryableErrors(len(events))
+		} else {
+			publisher.DeadLetter(batch, events, errPayloadTooLarge)
+			batch.Drop()
+			c.observer.PermanentErrors(len(events))
+			c.log.Error(errPayloadTooLarge)
+		}
+		return nil
+
+	case c.isRetryable(status):
+		if status == http.StatusTooManyRequests {
+			c.observer.ErrTooMany(len(events))
+		}
+		c.observer.RetryableErrors(len(events))
+		batch.RetryEvents(events)
+		return fmt.Errorf("http endpoint responded with status %d: %s", status, truncate(body))
+
+	default:
+		c.log.Errorf("Dropping %d events, http endpoint responded with status %d: %s", len(events), status, truncate(body))
+		c.observer.PermanentErrors(len(events))
+		publisher.DeadLetter(batch, events, fmt.Errorf("http endpoint responded with status %d: %s", status, truncate(body)))
+		batch.Drop()
+		return nil
+	}
+}
+
+func (c *client) handleItems(batch publisher.Batch, events []publisher.Event, body []byte) error {
+	var resp itemsResponse
+	if err := json.Unmarshal(body, &resp); err != nil || len(resp.Items) != len(events) {
+		if err == nil {
+			err = fmt.Errorf("expected %d items, got %d", len(events), len(resp.Items))
+		}
+		c.observer.RetryableErrors(len(events))
+		batch.RetryEvents(events)
+		return fmt.Errorf("failed to parse multi-status response: %w", err)
+	}
+
+	var retry []publisher.Event
+	acked, dropped, tooMany := 0, 0, 0
+	for i, item := range resp.Items {
+		switch {
+		case item.Status >= 200 && item.Status < 300:
+			acked++
+		case c.isRetryable(item.Status):
+			if item.Status == http.StatusTooManyRequests {
+				tooMany++
+			}
+			retry = append(retry, events[i])
+		default:
+			dropped++
+			c.log.Errorw(fmt.Sprintf("Dropping event, http endpoint responded with status %d: %s", item.Status, item.Error), logp.TypeKey, logp.EventType)
+			publisher.DeadLetter(batch, events[i:i+1], fmt.Errorf("http endpoint responded with status %d: %s", item.Status, item.Error))
+		}
+	}
+
+	c.observer.AckedEvents(acked)
+	c.observer.PermanentErrors(dropped)
+	c.observer.ErrTooMany(tooMany)
+	if len(retry) == 0 {
+		batch.ACK()
+		return nil
+	}
+
+	c.observer.RetryableErrors(len(retry))
+	batch.RetryEvents(retry)
+	return fmt.Errorf("http endpoint failed to process %d of %d events", len(retry), len(events))
+}
+
+func (c *client) isRetryable(status int) bool {
+	_, ok := c.retryOnStatus[status]
+	return ok
+}
+
+func (c *client) Test(d testing.Driver) {
+	d.Run("http: "+c.url, func(d testing.Driver) {
+		u, err := url.Parse(c.url)
+		d.Fatal("parse url", err)
+
+		address := u.Host
+		if u.Port() == "" {
+			if u.Scheme == "https" {
+				address += ":443"
+			} else {
+				address += ":80"
+			}
+		}
+
+		d.Run("connection", func(d testing.Driver) {
+			netDialer := transport.TestNetDialer(d, c.transport.Timeout)
+			_, err = netDialer.Dial("tcp", address)
+			d.Fatal("dial up", err)
+		})
+
+		if u.Scheme != "https" {
+			d.Warn("TLS", "secure connection disabled")
+		} else {
+			d.Run("TLS", func(d testing.Driver) {
+				tls, err := tlscommon.LoadTLSConfig(c.transport.TLS)
+				if err != nil {
+					d.Fatal("load tls config", err)
+				}
+
+				netDialer := transport.NetDialer(c.transport.Timeout)
+				tlsDialer := transport.TestTLSDialer(d, netDialer, tls, c.transport.Timeout)
+				_, err = tlsDialer.Dial("tcp", address)
+				d.Fatal("dial up", err)
+			})
+		}
+	})
+}
+
+func truncate(body []byte) []byte {
+	const maxLen = 256
+	if len(body) > maxLen {
+		return body[:maxLen]
+	}
+	return body
+}
@@ -0,0 +1,305 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package httpout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	_ "github.com/elastic/beats/v7/libbeat/outputs/codec/json"
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func makeTestClient(t *testing.T, settings map[string]interface{}) *client {
+	t.Helper()
+
+	cfg, err := config.NewConfigFrom(settings)
+	require.NoError(t, err)
+
+	info := beat.Info{Beat: "libbeat", Version: "1.2.3", Logger: logptest.NewTestingLogger(t, "")}
+	group, err := makeHTTP(nil, info, outputs.NewNilObserver(), cfg)
+	require.NoError(t, err)
+	require.Len(t, group.Clients, 1)
+
+	c := group.Clients[0].(interface{ Client() outputs.NetworkClient }).Client().(*client) //nolint:errcheck //This is a test file
+	require.NoError(t, c.Connect(context.Background()))
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func testBatch(n int) *outest.Batch {
+	events := make([]beat.Event, n)
+	for i := range events {
+		events[i] = beat.Event{
+			Timestamp: time.Now(),
+			Fields:    mapstr.M{"message": "event", "n": i},
+		}
+	}
+	return outest.NewBatch(events...)
+}
+
+func TestMakeHTTP(t *testing.T) {
+	tests := map[string]struct {
+		config map[string]interface{}
+		valid  bool
+	}{
+		"no host": {
+			config: map[string]interface{}{},
+		},
+		"single host": {
+			config: map[string]interface{}{"hosts": []string{"localhost:8080"}},
+			valid:  true,
+		},
+		"invalid format": {
+			config: map[string]interface{}{"hosts": []string{"localhost:8080"}, "format": "xml"},
+		},
+		"json array with format codec": {
+			config: map[string]interface{}{
+				"hosts":  []string{"localhost:8080"},
+				"format": "json_array",
+				"codec":  map[string]interface{}{"format": map[string]interface{}{"string": "%{[message]}"}},
+			},
+		},
+		"bearer token and basic auth": {
+			config: map[string]interface{}{
+				"hosts":        []string{"localhost:8080"},
+				"bearer_token": "token",
+				"username":     "user",
+			},
+		},
+		"invalid method": {
+			config: map[string]interface{}{"hosts": []string{"localhost:8080"}, "method": "GET"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := config.NewConfigFrom(test.config)
+			require.NoError(t, err)
+			info := beat.Info{Beat: "libbeat", Logger: logptest.NewTestingLogger(t, "")}
+			_, err = makeHTTP(nil, info, outputs.NewNilObserver(), cfg)
+			assert.Equal(t, test.valid, err == nil, "unexpected result: %v", err)
+		})
+	}
+}
+
+func TestPublishBodyFormats(t *testing.T) {
+	tests := map[string]struct {
+		settings    map[string]interface{}
+		contentType string
+		check       func(t *testing.T, body []byte)
+	}{
+		"ndjson": {
+			settings:    map[string]interface{}{},
+			contentType: "application/x-ndjson",
+			check: func(t *testing.T, body []byte) {
+				lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+				assert.Len(t, lines, 3)
+				for _, line := range lines {
+					assert.True(t, json.Valid([]byte(line)), line)
+				}
+			},
+		},
+		"json array": {
+			settings:    map[string]interface{}{"format": "json_array"},
+			contentType: "application/json",
+			check: func(t *testing.T, body []byte) {
+				var events []map[string]interface{}
+				require.NoError(t, json.Unmarshal(body, &events))
+				assert.Len(t, events, 3)
+			},
+		},
+	}
+
+	for name, test := range tests {
+		for _, level := range []int{0, 5} {
+			t.Run(fmt.Sprintf("%s/compression_level=%d", name, level), func(t *testing.T) {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					assert.Equal(t, test.contentType, r.Header.Get("Content-Type"))
+					assert.Equal(t, "value", r.Header.Get("X-Custom"))
+					user, pass, ok := r.BasicAuth()
+					assert.True(t, ok)
+					assert.Equal(t, "user", user)
+					assert.Equal(t, "secret", pass)
+
+					var reader io.Reader = r.Body
+					if level > 0 {
+						assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+						gz, err := gzip.NewReader(r.Body)
+						require.NoError(t, err)
+						reader = gz
+					}
+					body, err := io.ReadAll(reader)
+					require.NoError(t, err)
+					test.check(t, body)
+				}))
+				defer server.Close()
+
+				settings := map[string]interface{}{
+					"hosts":             []string{server.URL},
+					"headers":           map[string]string{"X-Custom": "value"},
+					"username":          "user",
+					"password":          "secret",
+					"compression_level": level,
+				}
+				for k, v := range test.settings {
+					settings[k] = v
+				}
+				c := makeTestClient(t, settings)
+
+				batch := testBatch(3)
+				require.NoError(t, c.Publish(context.Background(), batch))
+				require.Len(t, batch.Signals, 1)
+				assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+			})
+		}
+	}
+}
+
+func TestPublishStatusHandling(t *testing.T) {
+	tests := map[string]struct {
+		status  int
+		body    string
+		signal  outest.BatchSignalTag
+		retried int
+		failed  bool
+	}{
+		"accepted": {
+			status: http.StatusAccepted,
+			signal: outest.BatchACK,
+		},
+		"retryable status": {
+			status:  http.StatusServiceUnavailable,
+			signal:  outest.BatchRetryEvents,
+			retried: 3,
+			failed:  true,
+		},
+		"permanent failure": {
+			status: http.StatusBadRequest,
+			signal: outest.BatchDrop,
+		},
+		"too large": {
+			status: http.StatusRequestEntityTooLarge,
+			signal: outest.BatchSplitRetry,
+		},
+		"partial failure": {
+			status:  http.StatusMultiStatus,
+			body:    `{"items":[{"status":200},{"status":429},{"status":400,"error":"bad event"}]}`,
+			signal:  outest.BatchRetryEvents,
+			retried: 1,
+			failed:  true,
+		},
+		"multi status all ok": {
+			status: http.StatusMultiStatus,
+			body:   `{"items":[{"status":201},{"status":200},{"status":400}]}`,
+			signal: outest.BatchACK,
+		},
+		"malformed multi status": {
+			status:  http.StatusMultiStatus,
+			body:    `{"items":[{"status":201}]}`,
+			signal:  outest.BatchRetryEvents,
+			retried: 3,
+			failed:  true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			c := makeTestClient(t, map[string]interface{}{"hosts": []string{server.URL}})
+			batch := testBatch(3)
+			err := c.Publish(context.Background(), batch)
+			assert.Equal(t, test.failed, err != nil, "unexpected publish result: %v", err)
+
+			require.NotEmpty(t, batch.Signals)
+			assert.Equal(t, test.signal, batch.Signals[0].Tag)
+			if test.signal == outest.BatchRetryEvents {
+				assert.Len(t, batch.Signals[0].Events, test.retried)
+			}
+		})
+	}
+}
+
+// failingCodec fails to encode the events with the given "n" field.
+type failingCodec struct {
+	fail int
+}
+
+func (c failingCodec) Encode(_ string, event *beat.Event) ([]byte, error) {
+	if n, _ := event.Fields.GetValue("n"); n == c.fail {
+		return nil, errors.New("encoding failed")
+	}
+	return json.Marshal(event.Fields)
+}
+
+// deadLetterBatch records the events dead lettered through it.
+type deadLetterBatch struct {
+	*outest.Batch
+	deadLettered []publisher.Event
+}
+
+func (b *deadLetterBatch) DeadLetter(events []publisher.Event, _ error) bool {
+	b.deadLettered = append(b.deadLettered, events...)
+	return true
+}
+
+func TestEncodeBodyReportsDeadLetters(t *testing.T) {
+	c := makeTestClient(t, map[string]interface{}{"hosts": []string{"http://localhost:1"}})
+	c.codec = failingCodec{fail: 1}
+
+	batch := &deadLetterBatch{Batch: testBatch(3)}
+	events := batch.Events()
+	encoded, deadLettered, err := c.encodeBody(batch, events)
+	require.NoError(t, err)
+	assert.Len(t, encoded, 2)
+	assert.Equal(t, []int{1}, deadLettered)
+	require.Len(t, batch.deadLettered, 1)
+
+	// Only the events that were not dead lettered yet remain to be.
+	rest := withoutIndexes(events, deadLettered)
+	require.Len(t, rest, 2)
+	for i, n := range []int{0, 2} {
+		value, _ := rest[i].Content.Fields.GetValue("n")
+		assert.Equal(t, n, value)
+	}
+}
@@ -0,0 +1,113 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpout
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
+)
+
+type httpConfig struct {
+	Protocol         string            `config:"protocol"`
+	Path             string            `config:"path"`
+	Method           string            `config:"method"`
+	Params           map[string]string `config:"parameters"`
+	Headers          map[string]string `config:"headers"`
+	Username         string            `config:"username"`
+	Password         string            `config:"password"`
+	BearerToken      string            `config:"bearer_token"`
+	Format           string            `config:"format"`
+	CompressionLevel int               `config:"compression_level" validate:"min=0, max=9"`
+	Codec            codec.Config      `config:"codec"`
+	LoadBalance      bool              `config:"loadbalance"`
+	BulkMaxSize      int               `config:"bulk_max_size"`
+	MaxRetries       int               `config:"max_retries"`
+	RetryOnStatus    []int             `config:"retry_on_status"`
+	Backoff          Backoff           `config:"backoff"`
+	Queue            config.Namespace  `config:"queue"`
+
+	Transport httpcommon.HTTPTransportSettings `config:",inline"`
+}
+
+type Backoff struct {
+	Init time.Duration
+	Max  time.Duration
+}
+
+const (
+	formatNDJSON    = "ndjson"
+	formatJSONArray = "json_array"
+)
+
+var defaultConfig = httpConfig{
+	Method:           http.MethodPost,
+	Format:           formatNDJSON,
+	CompressionLevel: 0,
+	LoadBalance:      true,
+	BulkMaxSize:      1600,
+	MaxRetries:       3,
+	RetryOnStatus: []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+	Backoff: Backoff{
+		Init: 1 * time.Second,
+		Max:  60 * time.Second,
+	},
+	Transport: httpcommon.DefaultHTTPTransportSettings(),
+}
+
+func (c *httpConfig) Validate() error {
+	switch strings.ToUpper(c.Method) {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return fmt.Errorf("http method %v not supported", c.Method)
+	}
+
+	switch c.Format {
+	case formatNDJSON:
+	case formatJSONArray:
+		// A JSON array body can only be assembled from JSON encoded events.
+		if name := c.Codec.Namespace.Name(); name != "" && name != "json" {
+			return fmt.Errorf("format %v requires the json codec, got %v", c.Format, name)
+		}
+	default:
+		return fmt.Errorf("http body format %v not supported", c.Format)
+	}
+
+	if c.BearerToken != "" && (c.Username != "" || c.Password != "") {
+		return fmt.Errorf("cannot set both bearer_token and username/password")
+	}
+
+	for _, status := range c.RetryOnStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid HTTP status code %v in retry_on_status", status)
+		}
+	}
+
+	return nil
+}
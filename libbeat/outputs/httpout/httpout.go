@@ -0,0 +1,103 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package httpout
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+func init() {
+	outputs.RegisterType("http", makeHTTP)
+}
+
+const logSelector = "http"
+
+func makeHTTP(
+	_ outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *config.C,
+) (outputs.Group, error) {
+	log := beat.Logger.Named(logSelector)
+
+	httpConfig := defaultConfig
+	if err := cfg.Unpack(&httpConfig); err != nil {
+		return outputs.Fail(err)
+	}
+
+	hosts, err := outputs.ReadHostList(cfg)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	params := url.Values{}
+	for k, v := range httpConfig.Params {
+		params.Add(k, v)
+	}
+
+	retryOn := make(map[int]struct{}, len(httpConfig.RetryOnStatus))
+	for _, status := range httpConfig.RetryOnStatus {
+		retryOn[status] = struct{}{}
+	}
+
+	clients := make([]outputs.NetworkClient, len(hosts))
+	for i, host := range hosts {
+		hostURL, err := common.MakeURL(httpConfig.Protocol, httpConfig.Path, host, 0)
+		if err != nil {
+			log.Errorf("Invalid host param set: %s, Error: %+v", host, err)
+			return outputs.Fail(err)
+		}
+
+		enc, err := codec.CreateEncoder(beat, httpConfig.Codec)
+		if err != nil {
+			return outputs.Fail(err)
+		}
+
+		var client outputs.NetworkClient
+		client, err = newClient(clientSettings{
+			url:              common.EncodeURLParams(hostURL, params),
+			method:           strings.ToUpper(httpConfig.Method),
+			headers:          httpConfig.Headers,
+			username:         httpConfig.Username,
+			password:         httpConfig.Password,
+			bearerToken:      httpConfig.BearerToken,
+			format:           httpConfig.Format,
+			compressionLevel: httpConfig.CompressionLevel,
+			retryOnStatus:    retryOn,
+			index:            beat.Beat,
+			codec:            enc,
+			observer:         observer,
+			transport:        httpConfig.Transport,
+			userAgent:        beat.UserAgent,
+		}, log)
+		if err != nil {
+			return outputs.Fail(err)
+		}
+
+		clients[i] = outputs.WithBackoff(client, httpConfig.Backoff.Init, httpConfig.Backoff.Max)
+	}
+
+	return outputs.SuccessNet(httpConfig.Queue, httpConfig.LoadBalance, httpConfig.BulkMaxSize, httpConfig.MaxRetries, nil, clients)
+}
@@ -0,0 +1,383 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/transport/httpcommon"
+)
+
+const (
+	// avroMagicByte starts every message framed for the schema registry,
+	// followed by the 4 bytes big-endian schema id.
+	avroMagicByte = 0
+
+	avroSubjectSuffix = "-value"
+	avroNamespace     = "co.elastic.beats"
+)
+
+type avroConfig struct {
+	SchemaRegistry schemaRegistryConfig `config:"schema_registry"`
+	Schema         string               `config:"schema"`
+	SchemaFile     string               `config:"schema_file"`
+	Topics         []avroTopicConfig    `config:"topics"`
+	AutoRegister   bool                 `config:"auto_register"`
+	DeriveSchema   bool                 `config:"derive_schema"`
+}
+
+type schemaRegistryConfig struct {
+	URL       string                           `config:"url"`
+	Username  string                           `config:"username"`
+	Password  string                           `config:"password"`
+	Transport httpcommon.HTTPTransportSettings `config:",inline"`
+}
+
+type avroTopicConfig struct {
+	Topic      string `config:"topic"       validate:"required"`
+	Schema     string `config:"schema"`
+	SchemaFile string `config:"schema_file"`
+}
+
+func defaultAvroConfig() avroConfig {
+	return avroConfig{
+		SchemaRegistry: schemaRegistryConfig{
+			Transport: httpcommon.DefaultHTTPTransportSettings(),
+		},
+		AutoRegister: true,
+	}
+}
+
+// Enabled reports whether events are encoded with Avro. Avro encoding is
+// enabled by configuring the schema registry.
+func (c *avroConfig) Enabled() bool {
+	return c.SchemaRegistry.URL != ""
+}
+
+func (c *avroConfig) Validate() error {
+	if !c.Enabled() {
+		if c.Schema != "" || c.SchemaFile != "" || len(c.Topics) != 0 || c.DeriveSchema {
+			return errors.New("avro.schema_registry.url is required to use avro encoding")
+		}
+		return nil
+	}
+
+	if c.Schema != "" && c.SchemaFile != "" {
+		return errors.New("avro.schema and avro.schema_file are mutually exclusive")
+	}
+	for _, t := range c.Topics {
+		if t.Schema != "" && t.SchemaFile != "" {
+			return fmt.Errorf("schema and schema_file are mutually exclusive for avro topic %q", t.Topic)
+		}
+		if t.Schema == "" && t.SchemaFile == "" {
+			return fmt.Errorf("one of schema or schema_file is required for avro topic %q", t.Topic)
+		}
+	}
+	if c.SchemaRegistry.Username != "" && c.SchemaRegistry.Password == "" {
+		return errors.New("avro.schema_registry.password must be set when username is configured")
+	}
+	return nil
+}
+
+// avroEncoder encodes events with Avro, framed with the id of their schema in
+// the schema registry. The schema of each topic is resolved once and cached:
+// it's the configured schema, registered under the `<topic>-value` subject,
+// or the latest schema of that subject, or one derived from the first event.
+type avroEncoder struct {
+	log      *logp.Logger
+	registry *schemaRegistry
+	backoff  backoffConfig
+
+	topicSchemas  map[string]string
+	defaultSchema string
+	autoRegister  bool
+	deriveSchema  bool
+
+	mu     sync.Mutex
+	topics map[string]*avroTopic
+	now    func() time.Time
+}
+
+type avroTopic struct {
+	schema *avroSchema
+	header []byte
+
+	// Resolution failures are cached until retryAt, so a failing registry
+	// isn't queried once per event.
+	err      error
+	failures int
+	retryAt  time.Time
+}
+
+func newAvroEncoder(log *logp.Logger, cfg avroConfig, backoff backoffConfig) (*avroEncoder, error) {
+	client, err := cfg.SchemaRegistry.Transport.Client(httpcommon.WithLogger(log))
+	if err != nil {
+		return nil, err
+	}
+
+	e := &avroEncoder{
+		log: log,
+		registry: &schemaRegistry{
+			url:      cfg.SchemaRegistry.URL,
+			username: cfg.SchemaRegistry.Username,
+			password: cfg.SchemaRegistry.Password,
+			client:   client,
+		},
+		backoff:      backoff,
+		topicSchemas: map[string]string{},
+		autoRegister: cfg.AutoRegister,
+		deriveSchema: cfg.DeriveSchema,
+		topics:       map[string]*avroTopic{},
+		now:          time.Now,
+	}
+
+	if e.defaultSchema, err = loadAvroSchema(cfg.Schema, cfg.SchemaFile); err != nil {
+		return nil, err
+	}
+	for _, t := range cfg.Topics {
+		if e.topicSchemas[t.Topic], err = loadAvroSchema(t.Schema, t.SchemaFile); err != nil {
+			return nil, fmt.Errorf("invalid avro schema for topic %q: %w", t.Topic, err)
+		}
+	}
+	return e, nil
+}
+
+// loadAvroSchema returns the schema configured inline or in a file, after
+// checking it's valid.
+func loadAvroSchema(schema, file string) (string, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read avro schema: %w", err)
+		}
+		schema = string(data)
+	}
+	if schema == "" {
+		return "", nil
+	}
+	if _, err := parseAvroSchema(schema); err != nil {
+		return "", err
+	}
+	return schema, nil
+}
+
+// Encode returns the event encoded with the schema of the topic. Events not
+// matching the schema fail with an avroMismatchError, schema registry
+// failures with a registryError.
+func (e *avroEncoder) Encode(topic string, event *beat.Event) ([]byte, error) {
+	doc := make(map[string]interface{}, len(event.Fields)+1)
+	for k, v := range event.Fields {
+		doc[k] = v
+	}
+	doc["@timestamp"] = event.Timestamp
+
+	t, err := e.resolve(topic, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, len(t.header), 512)
+	copy(buf, t.header)
+	return t.schema.encode(buf, doc, "")
+}
+
+func (e *avroEncoder) resolve(topic string, doc map[string]interface{}) (*avroTopic, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	t, ok := e.topics[topic]
+	if !ok {
+		t = &avroTopic{}
+		e.topics[topic] = t
+	}
+	if t.schema != nil {
+		return t, nil
+	}
+	if t.err != nil && e.now().Before(t.retryAt) {
+		return nil, t.err
+	}
+
+	schema, id, err := e.lookup(context.Background(), topic, doc)
+	if err != nil {
+		t.err = err
+		t.failures++
+		t.retryAt = e.now().Add(e.retryDelay(t.failures))
+		e.log.Errorf("Failed to resolve avro schema for topic %v (retrying after %v): %v",
+			topic, t.retryAt.Sub(e.now()), err)
+		return nil, err
+	}
+
+	e.log.Infof("Using avro schema id %v for topic %v", id, topic)
+	t.schema = schema
+	t.header = []byte{avroMagicByte, byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	t.err = nil
+	return t, nil
+}
+
+func (e *avroEncoder) retryDelay(failures int) time.Duration {
+	delay := e.backoff.Init
+	for i := 1; i < failures && delay < e.backoff.Max; i++ {
+		delay *= 2
+	}
+	if delay > e.backoff.Max {
+		delay = e.backoff.Max
+	}
+	return delay
+}
+
+func (e *avroEncoder) lookup(ctx context.Context, topic string, doc map[string]interface{}) (*avroSchema, int, error) {
+	subject := topic + avroSubjectSuffix
+
+	text := e.topicSchemas[topic]
+	if text == "" {
+		text = e.defaultSchema
+	}
+
+	if text == "" {
+		latest, err := e.registry.latest(ctx, subject)
+		switch {
+		case err == nil:
+			schema, err := parseAvroSchema(latest.Schema)
+			if err != nil {
+				return nil, 0, mismatch("", "schema of subject %q: %v", subject, err)
+			}
+			return schema, latest.ID, nil
+		case !errors.Is(err, errSubjectNotFound):
+			return nil, 0, err
+		case !e.deriveSchema:
+			return nil, 0, mismatch("", "no schema configured or registered for subject %q", subject)
+		}
+
+		text, err = deriveAvroSchema(topic, doc)
+		if err != nil {
+			return nil, 0, err
+		}
+		e.log.Infof("Derived avro schema for topic %v: %v", topic, text)
+	} else if !e.autoRegister {
+		id, err := e.registry.lookup(ctx, subject, text)
+		if errors.Is(err, errSubjectNotFound) {
+			return nil, 0, mismatch("", "schema is not registered for subject %q", subject)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		schema, err := parseAvroSchema(text)
+		return schema, id, err
+	}
+
+	id, err := e.registry.register(ctx, subject, text)
+	if err != nil {
+		return nil, 0, err
+	}
+	schema, err := parseAvroSchema(text)
+	return schema, id, err
+}
+
+// deriveAvroSchema derives a record schema from an event. All fields are
+// optional, so later events missing some of them still match.
+func deriveAvroSchema(topic string, doc map[string]interface{}) (string, error) {
+	fields := deriveAvroFields(doc, avroName(topic))
+	schema := map[string]interface{}{
+		"type":      "record",
+		"name":      avroName(topic),
+		"namespace": avroNamespace,
+		"fields":    fields,
+	}
+	data, err := json.Marshal(schema)
+	return string(data), err
+}
+
+func deriveAvroFields(m map[string]interface{}, path string) []interface{} {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	seen := map[string]bool{}
+	fields := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		name := avroName(k)
+		if seen[name] {
+			continue
+		}
+		typ := deriveAvroType(m[k], path+"_"+name)
+		if typ == nil {
+			continue
+		}
+		seen[name] = true
+		fields = append(fields, map[string]interface{}{
+			"name":    name,
+			"type":    []interface{}{"null", typ},
+			"default": nil,
+		})
+	}
+	return fields
+}
+
+func deriveAvroType(v interface{}, path string) interface{} {
+	switch val := v.(type) {
+	case bool:
+		return "boolean"
+	case int, int8, int16, int32, int64, uint8, uint16, uint32, uint, uint64:
+		return "long"
+	case float32, float64:
+		return "double"
+	case string:
+		return "string"
+	case []byte:
+		return "bytes"
+	case time.Time, common.Time:
+		return map[string]interface{}{"type": "long", "logicalType": "timestamp-millis"}
+	case mapstr.M:
+		return deriveAvroRecord(val, path)
+	case map[string]interface{}:
+		return deriveAvroRecord(val, path)
+	case []interface{}:
+		if len(val) == 0 {
+			return map[string]interface{}{"type": "array", "items": "string"}
+		}
+		items := deriveAvroType(val[0], path)
+		if items == nil {
+			return nil
+		}
+		return map[string]interface{}{"type": "array", "items": items}
+	case []string:
+		return map[string]interface{}{"type": "array", "items": "string"}
+	}
+	return nil
+}
+
+func deriveAvroRecord(m map[string]interface{}, path string) interface{} {
+	return map[string]interface{}{
+		"type":   "record",
+		"name":   path,
+		"fields": deriveAvroFields(m, path),
+	}
+}
@@ -0,0 +1,657 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafka
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// avroKind is the type of an Avro schema node.
+type avroKind int
+
+const (
+	avroNull avroKind = iota
+	avroBoolean
+	avroInt
+	avroLong
+	avroFloat
+	avroDouble
+	avroBytes
+	avroString
+	avroRecord
+	avroEnum
+	avroArray
+	avroMap
+	avroUnion
+	avroFixed
+)
+
+var avroPrimitives = map[string]avroKind{
+	"null":    avroNull,
+	"boolean": avroBoolean,
+	"int":     avroInt,
+	"long":    avroLong,
+	"float":   avroFloat,
+	"double":  avroDouble,
+	"bytes":   avroBytes,
+	"string":  avroString,
+}
+
+var avroKindNames = map[avroKind]string{
+	avroNull:    "null",
+	avroBoolean: "boolean",
+	avroInt:     "int",
+	avroLong:    "long",
+	avroFloat:   "float",
+	avroDouble:  "double",
+	avroBytes:   "bytes",
+	avroString:  "string",
+	avroRecord:  "record",
+	avroEnum:    "enum",
+	avroArray:   "array",
+	avroMap:     "map",
+	avroUnion:   "union",
+	avroFixed:   "fixed",
+}
+
+// avroSchema is a parsed Avro schema, able to encode events using the Avro
+// binary encoding.
+type avroSchema struct {
+	kind    avroKind
+	name    string
+	logical string
+
+	fields   []avroField   // record
+	symbols  []string      // enum
+	items    *avroSchema   // array items and map values
+	branches []*avroSchema // union
+	size     int           // fixed
+}
+
+type avroField struct {
+	name       string
+	schema     *avroSchema
+	hasDefault bool
+	def        interface{}
+}
+
+// avroMismatchError is returned when a value does not match the schema. The
+// event can never be encoded with this schema.
+type avroMismatchError struct {
+	path string
+	msg  string
+}
+
+func (e *avroMismatchError) Error() string {
+	if e.path == "" {
+		return "event does not match avro schema: " + e.msg
+	}
+	return fmt.Sprintf("event does not match avro schema at %q: %s", e.path, e.msg)
+}
+
+func mismatch(path string, format string, args ...interface{}) error {
+	return &avroMismatchError{path: path, msg: fmt.Sprintf(format, args...)}
+}
+
+func isAvroMismatch(err error) bool {
+	var e *avroMismatchError
+	return errors.As(err, &e)
+}
+
+// parseAvroSchema parses a schema in the Avro JSON schema format.
+func parseAvroSchema(text string) (*avroSchema, error) {
+	var raw interface{}
+	if err := json.Unmarshal([]byte(text), &raw); err != nil {
+		return nil, fmt.Errorf("invalid avro schema: %w", err)
+	}
+	p := avroParser{named: map[string]*avroSchema{}}
+	return p.parse(raw, "")
+}
+
+type avroParser struct {
+	named map[string]*avroSchema
+}
+
+func (p *avroParser) parse(raw interface{}, namespace string) (*avroSchema, error) {
+	switch v := raw.(type) {
+	case string:
+		if kind, ok := avroPrimitives[v]; ok {
+			return &avroSchema{kind: kind}, nil
+		}
+		if s, ok := p.named[fullName(v, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := p.named[v]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown avro type %q", v)
+
+	case []interface{}:
+		union := &avroSchema{kind: avroUnion}
+		for _, b := range v {
+			branch, err := p.parse(b, namespace)
+			if err != nil {
+				return nil, err
+			}
+			if branch.kind == avroUnion {
+				return nil, errors.New("avro unions must not contain unions")
+			}
+			union.branches = append(union.branches, branch)
+		}
+		return union, nil
+
+	case map[string]interface{}:
+		return p.parseComplex(v, namespace)
+	}
+	return nil, fmt.Errorf("invalid avro schema element %v", raw)
+}
+
+func (p *avroParser) parseComplex(v map[string]interface{}, namespace string) (*avroSchema, error) {
+	typ, ok := v["type"]
+	if !ok {
+		return nil, errors.New("avro schema is missing 'type'")
+	}
+	name, ok := typ.(string)
+	if !ok {
+		// {"type": {...}} or {"type": [...]} wraps another schema.
+		return p.parse(typ, namespace)
+	}
+
+	logical, _ := v["logicalType"].(string)
+	if kind, ok := avroPrimitives[name]; ok {
+		return &avroSchema{kind: kind, logical: logical}, nil
+	}
+
+	switch name {
+	case "array":
+		items, err := p.parse(v["items"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid avro array items: %w", err)
+		}
+		return &avroSchema{kind: avroArray, items: items}, nil
+
+	case "map":
+		values, err := p.parse(v["values"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid avro map values: %w", err)
+		}
+		return &avroSchema{kind: avroMap, items: values}, nil
+
+	case "record", "error", "enum", "fixed":
+		return p.parseNamed(name, v, namespace, logical)
+	}
+
+	return p.parse(name, namespace)
+}
+
+func (p *avroParser) parseNamed(typ string, v map[string]interface{}, namespace, logical string) (*avroSchema, error) {
+	name, _ := v["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("avro %v is missing 'name'", typ)
+	}
+	if ns, ok := v["namespace"].(string); ok {
+		namespace = ns
+	}
+	full := fullName(name, namespace)
+	if _, exists := p.named[full]; exists {
+		return nil, fmt.Errorf("avro type %q is defined twice", full)
+	}
+	if idx := strings.LastIndexByte(full, '.'); idx >= 0 {
+		namespace = full[:idx]
+	}
+
+	s := &avroSchema{name: full, logical: logical}
+	// Register before parsing the fields, records can be recursive.
+	p.named[full] = s
+
+	switch typ {
+	case "enum":
+		s.kind = avroEnum
+		symbols, _ := v["symbols"].([]interface{})
+		for _, sym := range symbols {
+			str, ok := sym.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid symbol %v in avro enum %q", sym, full)
+			}
+			s.symbols = append(s.symbols, str)
+		}
+
+	case "fixed":
+		s.kind = avroFixed
+		size, ok := v["size"].(float64)
+		if !ok || size < 0 {
+			return nil, fmt.Errorf("invalid size for avro fixed %q", full)
+		}
+		s.size = int(size)
+
+	default:
+		s.kind = avroRecord
+		fields, ok := v["fields"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("avro record %q is missing 'fields'", full)
+		}
+		for _, f := range fields {
+			fm, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid field in avro record %q", full)
+			}
+			fieldName, _ := fm["name"].(string)
+			if fieldName == "" {
+				return nil, fmt.Errorf("avro record %q has a field without name", full)
+			}
+			fieldSchema, err := p.parse(fm["type"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("invalid avro field %q in record %q: %w", fieldName, full, err)
+			}
+			def, hasDefault := fm["default"]
+			s.fields = append(s.fields, avroField{
+				name:       fieldName,
+				schema:     fieldSchema,
+				hasDefault: hasDefault,
+				def:        def,
+			})
+		}
+	}
+	return s, nil
+}
+
+func fullName(name, namespace string) string {
+	if strings.ContainsRune(name, '.') || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func (s *avroSchema) String() string {
+	if s.name != "" {
+		return s.name
+	}
+	return avroKindNames[s.kind]
+}
+
+// acceptsNull reports whether nil can be encoded with this schema.
+func (s *avroSchema) acceptsNull() bool {
+	if s.kind == avroNull {
+		return true
+	}
+	if s.kind == avroUnion {
+		for _, b := range s.branches {
+			if b.kind == avroNull {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// encode appends the Avro binary encoding of v to buf. On error the content
+// appended to buf is undefined.
+func (s *avroSchema) encode(buf []byte, v interface{}, path string) ([]byte, error) {
+	if t, ok := v.(common.Time); ok {
+		v = time.Time(t)
+	}
+
+	switch s.kind {
+	case avroNull:
+		if v != nil {
+			return buf, mismatch(path, "expected null, got %T", v)
+		}
+		return buf, nil
+
+	case avroBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return buf, mismatch(path, "expected boolean, got %T", v)
+		}
+		if b {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+
+	case avroInt, avroLong:
+		n, err := s.toInteger(v, path)
+		if err != nil {
+			return buf, err
+		}
+		if s.kind == avroInt && (n < math.MinInt32 || n > math.MaxInt32) {
+			return buf, mismatch(path, "value %v overflows int", n)
+		}
+		return binary.AppendVarint(buf, n), nil
+
+	case avroFloat:
+		f, ok := toFloat(v)
+		if !ok {
+			return buf, mismatch(path, "expected float, got %T", v)
+		}
+		return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(f))), nil
+
+	case avroDouble:
+		f, ok := toFloat(v)
+		if !ok {
+			return buf, mismatch(path, "expected double, got %T", v)
+		}
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f)), nil
+
+	case avroBytes:
+		var b []byte
+		switch val := v.(type) {
+		case []byte:
+			b = val
+		case string:
+			b = []byte(val)
+		default:
+			return buf, mismatch(path, "expected bytes, got %T", v)
+		}
+		buf = binary.AppendVarint(buf, int64(len(b)))
+		return append(buf, b...), nil
+
+	case avroString:
+		var str string
+		switch val := v.(type) {
+		case string:
+			str = val
+		case time.Time:
+			str = val.UTC().Format(time.RFC3339Nano)
+		case json.Number:
+			str = val.String()
+		default:
+			return buf, mismatch(path, "expected string, got %T", v)
+		}
+		buf = binary.AppendVarint(buf, int64(len(str)))
+		return append(buf, str...), nil
+
+	case avroEnum:
+		str, ok := v.(string)
+		if !ok {
+			return buf, mismatch(path, "expected enum %v, got %T", s, v)
+		}
+		for i, sym := range s.symbols {
+			if sym == str {
+				return binary.AppendVarint(buf, int64(i)), nil
+			}
+		}
+		return buf, mismatch(path, "%q is not a symbol of enum %v", str, s)
+
+	case avroFixed:
+		var b []byte
+		switch val := v.(type) {
+		case []byte:
+			b = val
+		case string:
+			b = []byte(val)
+		default:
+			return buf, mismatch(path, "expected fixed %v, got %T", s, v)
+		}
+		if len(b) != s.size {
+			return buf, mismatch(path, "expected %d bytes for fixed %v, got %d", s.size, s, len(b))
+		}
+		return append(buf, b...), nil
+
+	case avroArray:
+		rv := reflect.ValueOf(v)
+		if v == nil || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) {
+			return buf, mismatch(path, "expected array, got %T", v)
+		}
+		if rv.Len() > 0 {
+			buf = binary.AppendVarint(buf, int64(rv.Len()))
+			for i := 0; i < rv.Len(); i++ {
+				var err error
+				buf, err = s.items.encode(buf, rv.Index(i).Interface(), fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return buf, err
+				}
+			}
+		}
+		return append(buf, 0), nil
+
+	case avroMap:
+		m, ok := toStringMap(v)
+		if !ok {
+			return buf, mismatch(path, "expected map, got %T", v)
+		}
+		if len(m) > 0 {
+			buf = binary.AppendVarint(buf, int64(len(m)))
+			for k, val := range m {
+				buf = binary.AppendVarint(buf, int64(len(k)))
+				buf = append(buf, k...)
+				var err error
+				buf, err = s.items.encode(buf, val, joinPath(path, k))
+				if err != nil {
+					return buf, err
+				}
+			}
+		}
+		return append(buf, 0), nil
+
+	case avroRecord:
+		m, ok := toStringMap(v)
+		if !ok {
+			return buf, mismatch(path, "expected record %v, got %T", s, v)
+		}
+		for _, f := range s.fields {
+			val, found := lookupField(m, f.name)
+			if !found {
+				switch {
+				case f.hasDefault:
+					val = f.def
+				case f.schema.acceptsNull():
+					val = nil
+				default:
+					return buf, mismatch(joinPath(path, f.name), "missing required field")
+				}
+			}
+			var err error
+			buf, err = f.schema.encode(buf, val, joinPath(path, f.name))
+			if err != nil {
+				return buf, err
+			}
+		}
+		return buf, nil
+
+	case avroUnion:
+		return s.encodeUnion(buf, v, path)
+	}
+	return buf, fmt.Errorf("unsupported avro type %v", s)
+}
+
+func (s *avroSchema) encodeUnion(buf []byte, v interface{}, path string) ([]byte, error) {
+	start := len(buf)
+	var types []string
+	for i, b := range s.branches {
+		if (v == nil) != (b.kind == avroNull) {
+			types = append(types, b.String())
+			continue
+		}
+		out, err := b.encode(binary.AppendVarint(buf[:start], int64(i)), v, path)
+		if err == nil {
+			return out, nil
+		}
+		if !isAvroMismatch(err) {
+			return out, err
+		}
+		types = append(types, b.String())
+	}
+	return buf[:start], mismatch(path, "%T matches none of the union types [%s]", v, strings.Join(types, ", "))
+}
+
+func (s *avroSchema) toInteger(v interface{}, path string) (int64, error) {
+	if t, ok := v.(time.Time); ok {
+		switch s.logical {
+		case "timestamp-millis", "local-timestamp-millis":
+			return t.UnixMilli(), nil
+		case "timestamp-micros", "local-timestamp-micros":
+			return t.UnixMicro(), nil
+		case "date":
+			return int64(math.Floor(float64(t.Unix()) / 86400)), nil
+		}
+		return 0, mismatch(path, "time values require a timestamp or date logical type")
+	}
+
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int8:
+		return int64(n), nil
+	case int16:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case uint8:
+		return int64(n), nil
+	case uint16:
+		return int64(n), nil
+	case uint32:
+		return int64(n), nil
+	case uint:
+		if uint64(n) <= math.MaxInt64 {
+			return int64(n), nil
+		}
+	case uint64:
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+	case float32, float64:
+		f, _ := toFloat(n)
+		if f == math.Trunc(f) && f >= math.MinInt64 && f <= math.MaxInt64 {
+			return int64(f), nil
+		}
+		return 0, mismatch(path, "value %v is not an integer", f)
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+	}
+	return 0, mismatch(path, "expected %v, got %T", s.kind, v)
+}
+
+func (k avroKind) String() string {
+	return avroKindNames[k]
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toStringMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case mapstr.M:
+		return m, true
+	case map[string]interface{}:
+		return m, true
+	case nil:
+		return nil, false
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	m := make(map[string]interface{}, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		m[iter.Key().String()] = iter.Value().Interface()
+	}
+	return m, true
+}
+
+// lookupField finds the value of a record field in an event map. Avro names
+// only allow letters, digits and underscores, so fields like `@timestamp` or
+// `user-agent` are matched by their sanitized name when there is no exact
+// match.
+func lookupField(m map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := m[name]; ok {
+		return v, true
+	}
+	for k, v := range m {
+		if avroName(k) == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// avroName turns a field name into a valid Avro name.
+func avroName(name string) string {
+	name = strings.TrimLeft(name, "@")
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,191 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/common"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestAvroEncode(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for name, test := range map[string]struct {
+		schema string
+		value  interface{}
+		want   []byte
+	}{
+		"long zigzag": {
+			schema: `"long"`,
+			value:  -64,
+			want:   []byte{0x7f},
+		},
+		"int from float": {
+			schema: `"int"`,
+			value:  float64(64),
+			want:   []byte{0x80, 0x01},
+		},
+		"string": {
+			schema: `"string"`,
+			value:  "foo",
+			want:   []byte{0x06, 'f', 'o', 'o'},
+		},
+		"double": {
+			schema: `"double"`,
+			value:  1.5,
+			want:   []byte{0, 0, 0, 0, 0, 0, 0xf8, 0x3f},
+		},
+		"timestamp-millis": {
+			schema: `{"type": "long", "logicalType": "timestamp-millis"}`,
+			value:  common.Time(ts),
+			want:   []byte{0x90, 0xe2, 0x86, 0x82, 0x99, 0x63},
+		},
+		"array": {
+			schema: `{"type": "array", "items": "boolean"}`,
+			value:  []interface{}{true, false},
+			want:   []byte{0x04, 0x01, 0x00, 0x00},
+		},
+		"enum": {
+			schema: `{"type": "enum", "name": "level", "symbols": ["info", "error"]}`,
+			value:  "error",
+			want:   []byte{0x02},
+		},
+		"union null": {
+			schema: `["null", "string"]`,
+			value:  nil,
+			want:   []byte{0x00},
+		},
+		"union picks matching branch": {
+			schema: `["null", "long", "string"]`,
+			value:  "a",
+			want:   []byte{0x04, 0x02, 'a'},
+		},
+		"record with sanitized names and defaults": {
+			schema: `{
+				"type": "record", "name": "event",
+				"fields": [
+					{"name": "timestamp", "type": "string"},
+					{"name": "user_agent", "type": ["null", "string"]},
+					{"name": "count", "type": "long", "default": 1},
+					{"name": "host", "type": {"type": "record", "name": "host", "fields": [
+						{"name": "name", "type": "string"}
+					]}}
+				]
+			}`,
+			value: mapstr.M{
+				"@timestamp": "now",
+				"user-agent": "curl",
+				"host":       mapstr.M{"name": "h"},
+				"ignored":    true,
+			},
+			want: []byte{0x06, 'n', 'o', 'w', 0x02, 0x08, 'c', 'u', 'r', 'l', 0x02, 0x02, 'h'},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			schema, err := parseAvroSchema(test.schema)
+			require.NoError(t, err)
+
+			got, err := schema.encode(nil, test.value, "")
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestAvroEncodeMismatch(t *testing.T) {
+	for name, test := range map[string]struct {
+		schema string
+		value  interface{}
+		err    string
+	}{
+		"wrong type": {
+			schema: `{"type": "record", "name": "e", "fields": [{"name": "status", "type": "long"}]}`,
+			value:  mapstr.M{"status": "ok"},
+			err:    `at "status": expected long, got string`,
+		},
+		"missing required field": {
+			schema: `{"type": "record", "name": "e", "fields": [{"name": "message", "type": "string"}]}`,
+			value:  mapstr.M{},
+			err:    `at "message": missing required field`,
+		},
+		"int overflow": {
+			schema: `"int"`,
+			value:  int64(1) << 40,
+			err:    "overflows int",
+		},
+		"unknown enum symbol": {
+			schema: `{"type": "enum", "name": "level", "symbols": ["info"]}`,
+			value:  "debug",
+			err:    "is not a symbol of enum level",
+		},
+		"no union branch": {
+			schema: `["null", "long"]`,
+			value:  true,
+			err:    "matches none of the union types",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			schema, err := parseAvroSchema(test.schema)
+			require.NoError(t, err)
+
+			_, err = schema.encode(nil, test.value, "")
+			require.Error(t, err)
+			assert.True(t, isAvroMismatch(err))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestParseAvroSchemaErrors(t *testing.T) {
+	for name, schema := range map[string]string{
+		"invalid json":       `{`,
+		"unknown type":       `"uuid"`,
+		"record no fields":   `{"type": "record", "name": "e"}`,
+		"nested union":       `["null", ["string"]]`,
+		"duplicate name":     `{"type": "record", "name": "e", "fields": [{"name": "a", "type": {"type": "enum", "name": "e", "symbols": []}}]}`,
+		"field without name": `{"type": "record", "name": "e", "fields": [{"type": "string"}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseAvroSchema(schema)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseAvroSchemaRecursive(t *testing.T) {
+	schema, err := parseAvroSchema(`{
+		"type": "record", "name": "node", "namespace": "test",
+		"fields": [
+			{"name": "value", "type": "long"},
+			{"name": "next", "type": ["null", "node"]}
+		]
+	}`)
+	require.NoError(t, err)
+
+	got, err := schema.encode(nil, mapstr.M{"value": 1, "next": mapstr.M{"value": 2}}, "")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x02, 0x02, 0x04, 0x00}, got)
+}
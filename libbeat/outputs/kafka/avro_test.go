@@ -0,0 +1,281 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+//go:build !integration
+
+package kafka
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+	"github.com/elastic/beats/v7/libbeat/outputs/outil"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/sarama"
+	"github.com/elastic/sarama/mocks"
+)
+
+const testAvroSchema = `{
+	"type": "record", "name": "event",
+	"fields": [
+		{"name": "message", "type": "string"},
+		{"name": "status", "type": ["null", "long"], "default": null}
+	]
+}`
+
+// fakeRegistry is a minimal schema registry keeping schemas in memory.
+type fakeRegistry struct {
+	mu       sync.Mutex
+	subjects map[string]registeredSchema
+	requests int
+	status   int
+}
+
+func newFakeRegistry(t *testing.T) (*fakeRegistry, *httptest.Server) {
+	r := &fakeRegistry{subjects: map[string]registeredSchema{}}
+	server := httptest.NewServer(http.HandlerFunc(r.handle))
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func (r *fakeRegistry) handle(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+
+	if r.status != 0 {
+		w.WriteHeader(r.status)
+		return
+	}
+
+	w.Header().Set("Content-Type", schemaRegistryContentType)
+	switch {
+	case req.Method == http.MethodGet && len(req.URL.Path) > len("/versions/latest"):
+		subject := req.URL.Path[len("/subjects/") : len(req.URL.Path)-len("/versions/latest")]
+		s, ok := r.subjects[subject]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error_code": 40401, "message": "Subject not found."}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(s)
+
+	case req.Method == http.MethodPost:
+		var body registeredSchema
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		subject := req.URL.Path[len("/subjects/") : len(req.URL.Path)-len("/versions")]
+		s, ok := r.subjects[subject]
+		if !ok {
+			s = registeredSchema{ID: 100 + len(r.subjects), Schema: body.Schema}
+			r.subjects[subject] = s
+		}
+		_ = json.NewEncoder(w).Encode(map[string]int{"id": s.ID})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *fakeRegistry) requestCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests
+}
+
+func newTestAvroEncoder(t *testing.T, settings mapstr.M) *avroEncoder {
+	t.Helper()
+	cfg := defaultAvroConfig()
+	require.NoError(t, config.MustNewConfigFrom(settings).Unpack(&cfg))
+	e, err := newAvroEncoder(logptest.NewTestingLogger(t, ""), cfg, backoffConfig{Init: time.Second, Max: time.Minute})
+	require.NoError(t, err)
+	return e
+}
+
+func TestAvroEncoderRegistersConfiguredSchema(t *testing.T) {
+	registry, server := newFakeRegistry(t)
+	e := newTestAvroEncoder(t, mapstr.M{
+		"schema_registry.url": server.URL,
+		"schema":              testAvroSchema,
+	})
+
+	event := &beat.Event{Fields: mapstr.M{"message": "hi", "status": 200}}
+	for i := 0; i < 2; i++ {
+		value, err := e.Encode("logs", event)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0, 0, 0, 0, 100, 0x04, 'h', 'i', 0x02, 0x90, 0x03}, value)
+	}
+
+	assert.Equal(t, 1, registry.requestCount(), "schema ids must be cached")
+	assert.JSONEq(t, testAvroSchema, registry.subjects["logs-value"].Schema)
+}
+
+func TestAvroEncoderUsesLatestSchema(t *testing.T) {
+	registry, server := newFakeRegistry(t)
+	registry.subjects["logs-value"] = registeredSchema{ID: 7, Schema: testAvroSchema}
+	e := newTestAvroEncoder(t, mapstr.M{"schema_registry.url": server.URL})
+
+	value, err := e.Encode("logs", &beat.Event{Fields: mapstr.M{"message": "hi"}})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 7, 0x04, 'h', 'i', 0x00}, value)
+
+	_, err = e.Encode("other", &beat.Event{Fields: mapstr.M{"message": "hi"}})
+	assert.True(t, isAvroMismatch(err), "topics without schema are permanent failures: %v", err)
+}
+
+func TestAvroEncoderDerivesSchema(t *testing.T) {
+	registry, server := newFakeRegistry(t)
+	e := newTestAvroEncoder(t, mapstr.M{
+		"schema_registry.url": server.URL,
+		"derive_schema":       true,
+	})
+
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	event := &beat.Event{
+		Timestamp: ts,
+		Fields: mapstr.M{
+			"message": "hi",
+			"http":    mapstr.M{"status": 200},
+			"tags":    []string{"a"},
+		},
+	}
+	_, err := e.Encode("app-logs", event)
+	require.NoError(t, err)
+
+	derived, err := parseAvroSchema(registry.subjects["app-logs-value"].Schema)
+	require.NoError(t, err)
+	assert.Equal(t, "co.elastic.beats.app_logs", derived.name)
+	var names []string
+	for _, f := range derived.fields {
+		names = append(names, f.name)
+	}
+	assert.Equal(t, []string{"timestamp", "http", "message", "tags"}, names)
+
+	// Fields are optional, but must keep their type.
+	_, err = e.Encode("app-logs", &beat.Event{Timestamp: ts, Fields: mapstr.M{}})
+	assert.NoError(t, err)
+	_, err = e.Encode("app-logs", &beat.Event{Timestamp: ts, Fields: mapstr.M{"http": mapstr.M{"status": "ok"}}})
+	assert.True(t, isAvroMismatch(err))
+}
+
+func TestAvroEncoderRegistryUnavailable(t *testing.T) {
+	registry, server := newFakeRegistry(t)
+	registry.status = http.StatusServiceUnavailable
+	e := newTestAvroEncoder(t, mapstr.M{
+		"schema_registry.url": server.URL,
+		"schema":              testAvroSchema,
+	})
+	now := time.Now()
+	e.now = func() time.Time { return now }
+
+	event := &beat.Event{Fields: mapstr.M{"message": "hi"}}
+	_, err := e.Encode("logs", event)
+	assert.True(t, isRegistryError(err))
+	_, err = e.Encode("logs", event)
+	assert.True(t, isRegistryError(err))
+	assert.Equal(t, 1, registry.requestCount(), "failures must be cached until the backoff expired")
+
+	registry.mu.Lock()
+	registry.status = 0
+	registry.mu.Unlock()
+	now = now.Add(2 * time.Second)
+	_, err = e.Encode("logs", event)
+	assert.NoError(t, err)
+}
+
+type countingObserver struct {
+	outputs.Observer
+	permanent atomic.Int64
+	retryable atomic.Int64
+}
+
+func (o *countingObserver) PermanentErrors(n int) { o.permanent.Add(int64(n)) }
+func (o *countingObserver) RetryableErrors(n int) { o.retryable.Add(int64(n)) }
+
+func TestPublishAvro(t *testing.T) {
+	registry, server := newFakeRegistry(t)
+	logger := logptest.NewTestingLogger(t, "")
+
+	topic, err := outil.BuildSelectorFromConfig(config.MustNewConfigFrom(mapstr.M{"topic": "%{[topic]}"}), outil.Settings{
+		Key:              "topic",
+		EnableSingleOnly: true,
+		FailEmpty:        true,
+	})
+	require.NoError(t, err)
+
+	e := newTestAvroEncoder(t, mapstr.M{
+		"schema_registry.url": server.URL,
+		"topics": []mapstr.M{
+			{"topic": "logs", "schema": testAvroSchema},
+		},
+	})
+
+	cfg := sarama.NewConfig()
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+
+	observer := &countingObserver{Observer: outputs.NewNilObserver()}
+	c, err := newKafkaClient(observer, []string{"localhost:9092"}, "beat", nil, topic, nil, nil, e, cfg, logger)
+	require.NoError(t, err)
+
+	producer := mocks.NewAsyncProducer(t, cfg)
+	producer.ExpectInputAndSucceed()
+	c.producer = producer
+	c.wg.Add(2)
+	go c.successWorker(producer.Successes())
+	go c.errorWorker(producer.Errors())
+
+	done := make(chan outest.BatchSignal, 1)
+	batch := outest.NewBatch(
+		beat.Event{Fields: mapstr.M{"topic": "logs", "message": "ok"}},
+		beat.Event{Fields: mapstr.M{"topic": "logs", "status": 200}},
+	)
+	batch.OnSignal = func(sig outest.BatchSignal) { done <- sig }
+	require.NoError(t, c.Publish(t.Context(), batch))
+
+	sig := <-done
+	assert.Equal(t, outest.BatchACK, sig.Tag)
+	assert.EqualValues(t, 1, observer.permanent.Load(), "event without message must be dropped")
+
+	// The registry is down when the schema of "other" is resolved, so the
+	// event is retried.
+	registry.mu.Lock()
+	registry.status = http.StatusServiceUnavailable
+	registry.mu.Unlock()
+	batch = outest.NewBatch(beat.Event{Fields: mapstr.M{"topic": "other", "message": "ok"}})
+	batch.OnSignal = func(sig outest.BatchSignal) { done <- sig }
+	require.NoError(t, c.Publish(t.Context(), batch))
+
+	sig = <-done
+	assert.Equal(t, outest.BatchRetryEvents, sig.Tag)
+	assert.Len(t, sig.Events, 1)
+	assert.EqualValues(t, 1, observer.retryable.Load())
+
+	require.NoError(t, c.Close())
+}
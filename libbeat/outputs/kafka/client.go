@@ -30,6 +30,7 @@ import (
 
 	"github.com/elastic/sarama"
 
+	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/beats/v7/libbeat/common/fmtstr"
 	"github.com/elastic/beats/v7/libbeat/outputs"
 	"github.com/elastic/beats/v7/libbeat/outputs/codec"
@@ -48,6 +49,7 @@ type client struct {
 	key      *fmtstr.EventFormatString
 	index    string
 	codec    codec.Codec
+	avro     *avroEncoder
 	config   sarama.Config
 	mux      sync.Mutex
 	done     chan struct{}
@@ -95,6 +97,7 @@ func newKafkaClient(
 	topic outil.Selector,
 	headers []header,
 	writer codec.Codec,
+	avro *avroEncoder,
 	cfg *sarama.Config,
 	logger *logp.Logger,
 ) (*client, error) {
@@ -106,6 +109,7 @@ func newKafkaClient(
 		key:      key,
 		index:    strings.ToLower(index),
 		codec:    writer,
+		avro:     avro,
 		config:   *cfg,
 		done:     make(chan struct{}),
 	}
@@ -185,7 +189,13 @@ func (c *client) Publish(_ context.Context, batch publisher.Batch) error {
 		d := &events[i]
 		msg, err := c.getEventMessage(d)
 		if err != nil {
+			if isRegistryError(err) {
+				// The schema could not be resolved yet, retry the event later.
+				ref.fail(&message{data: *d}, err)
+				continue
+			}
 			c.log.Errorf("Dropping event: %+v", err)
+			publisher.DeadLetter(batch, []publisher.Event{*d}, err)
 			ref.done()
 			c.observer.PermanentErrors(1)
 			continue
@@ -241,7 +251,7 @@ func (c *client) getEventMessage(data *publisher.Event) (*message, error) {
 		}
 	}
 
-	serializedEvent, err := c.codec.Encode(c.index, event)
+	serializedEvent, err := c.encode(msg.topic, event)
 	if err != nil {
 		if c.log.IsDebug() {
 			c.log.Debug("failed event logged to event log file")
@@ -249,10 +259,7 @@ func (c *client) getEventMessage(data *publisher.Event) (*message, error) {
 		}
 		return nil, err
 	}
-
-	buf := make([]byte, len(serializedEvent))
-	copy(buf, serializedEvent)
-	msg.value = buf
+	msg.value = serializedEvent
 
 	// message timestamps have been added to kafka with version 0.10.0.0
 	if c.config.Version.IsAtLeast(sarama.V0_10_0_0) {
@@ -268,6 +275,22 @@ func (c *client) getEventMessage(data *publisher.Event) (*message, error) {
 	return msg, nil
 }
 
+// encode serializes the event into a buffer owned by the message.
+func (c *client) encode(topic string, event *beat.Event) ([]byte, error) {
+	if c.avro != nil {
+		return c.avro.Encode(topic, event)
+	}
+
+	serializedEvent, err := c.codec.Encode(c.index, event)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, len(serializedEvent))
+	copy(buf, serializedEvent)
+	return buf, nil
+}
+
 func (c *client) successWorker(ch <-chan *sarama.ProducerMessage) {
 	defer c.wg.Done()
 	defer c.log.Debug("Stop kafka ack worker")
@@ -385,16 +408,19 @@ func (r *msgRef) fail(msg *message, err error) {
 	case errors.Is(err, sarama.ErrInvalidMessage):
 		r.client.log.Errorf("Kafka (topic=%v): dropping invalid message", msg.topic)
 		r.client.observer.PermanentErrors(1)
+		publisher.DeadLetter(r.batch, []publisher.Event{msg.data}, err)
 
 	case errors.Is(err, sarama.ErrMessageSizeTooLarge) || errors.Is(err, sarama.ErrInvalidMessageSize):
 		r.client.log.Errorf("Kafka (topic=%v): dropping too large message of size %v.",
 			msg.topic,
 			len(msg.key)+len(msg.value))
 		r.client.observer.PermanentErrors(1)
+		publisher.DeadLetter(r.batch, []publisher.Event{msg.data}, err)
 
 	case isAuthError(err):
 		r.client.log.Errorf("Kafka (topic=%v): authorisation error: %s", msg.topic, err)
 		r.client.observer.PermanentErrors(1)
+		publisher.DeadLetter(r.batch, []publisher.Event{msg.data}, err)
 
 	case errors.Is(err, breaker.ErrBreakerOpen):
 		// Add this message to the failed list, but don't overwrite r.err since
@@ -74,6 +74,7 @@ type kafkaConfig struct {
 	Username           string                    `config:"username"`
 	Password           string                    `config:"password"`
 	Codec              codec.Config              `config:"codec"`
+	Avro               avroConfig                `config:"avro"`
 	Sasl               kafka.SaslConfig          `config:"sasl"`
 	EnableFAST         bool                      `config:"enable_krb5_fast"`
 	Queue              config.Namespace          `config:"queue"`
@@ -146,6 +147,7 @@ func defaultConfig() kafkaConfig {
 		ChanBufferSize: 256,
 		Username:       "",
 		Password:       "",
+		Avro:           defaultAvroConfig(),
 	}
 }
 
@@ -181,6 +183,10 @@ func (c *kafkaConfig) Validate() error {
 		}
 	}
 
+	if c.Avro.Enabled() && c.Codec.Namespace.IsSet() {
+		return errors.New("'codec' can not be used together with avro encoding")
+	}
+
 	if c.Topic == "" && len(c.Topics) == 0 {
 		return errors.New("either 'topic' or 'topics' must be defined")
 	}
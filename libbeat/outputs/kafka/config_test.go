@@ -43,6 +43,13 @@ func TestConfigAcceptValid(t *testing.T) {
 			"version":     "1.0.0",
 			"topic":       "foo",
 		},
+		"avro with schema registry": mapstr.M{
+			"topic": "foo",
+			"avro": mapstr.M{
+				"schema_registry.url": "http://localhost:8081",
+				"schema":              `"string"`,
+			},
+		},
 	}
 
 	for name, test := range tests {
@@ -76,6 +83,26 @@ func TestConfigInvalid(t *testing.T) {
 		},
 		// The default config does not set `topic` nor `topics`.
 		"No topics or topic provided": mapstr.M{},
+		"avro schema without schema registry": mapstr.M{
+			"topic": "foo",
+			"avro": mapstr.M{
+				"schema": `"string"`,
+			},
+		},
+		"avro with codec": mapstr.M{
+			"topic":               "foo",
+			"codec.format.string": "%{[message]}",
+			"avro": mapstr.M{
+				"schema_registry.url": "http://localhost:8081",
+			},
+		},
+		"avro topic without schema": mapstr.M{
+			"topic": "foo",
+			"avro": mapstr.M{
+				"schema_registry.url": "http://localhost:8081",
+				"topics":              []mapstr.M{{"topic": "foo"}},
+			},
+		},
 	}
 
 	for name, test := range tests {
@@ -73,7 +73,15 @@ func makeKafka(
 		return outputs.Fail(err)
 	}
 
-	client, err := newKafkaClient(observer, hosts, beat.IndexPrefix, kConfig.Key, topic, kConfig.Headers, codec, libCfg, beat.Logger)
+	var avro *avroEncoder
+	if kConfig.Avro.Enabled() {
+		avro, err = newAvroEncoder(log.Named("avro"), kConfig.Avro, kConfig.Backoff)
+		if err != nil {
+			return outputs.Fail(err)
+		}
+	}
+
+	client, err := newKafkaClient(observer, hosts, beat.IndexPrefix, kConfig.Key, topic, kConfig.Headers, codec, avro, libCfg, beat.Logger)
 	if err != nil {
 		return outputs.Fail(err)
 	}
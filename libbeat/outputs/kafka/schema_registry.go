@@ -0,0 +1,155 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const schemaRegistryContentType = "application/vnd.schemaregistry.v1+json"
+
+var errSubjectNotFound = errors.New("subject not found in schema registry")
+
+// registryError is returned when the schema registry could not be reached or
+// failed to answer. Events failing with it can be retried.
+type registryError struct {
+	err error
+}
+
+func (e *registryError) Error() string {
+	return "schema registry request failed: " + e.err.Error()
+}
+
+func (e *registryError) Unwrap() error {
+	return e.err
+}
+
+func isRegistryError(err error) bool {
+	var e *registryError
+	return errors.As(err, &e)
+}
+
+// schemaRegistry is a minimal client for the Confluent schema registry API.
+type schemaRegistry struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+}
+
+type registeredSchema struct {
+	ID     int    `json:"id"`
+	Schema string `json:"schema"`
+}
+
+type registryErrorResponse struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// register registers the schema under subject, returning its id. Registering
+// a schema that already exists returns the existing id.
+func (r *schemaRegistry) register(ctx context.Context, subject, schema string) (int, error) {
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+
+	var resp registeredSchema
+	if err := r.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", body, &resp); err != nil {
+		return 0, err
+	}
+	return resp.ID, nil
+}
+
+// lookup returns the id of schema if it's registered under subject.
+func (r *schemaRegistry) lookup(ctx context.Context, subject, schema string) (int, error) {
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+
+	var resp registeredSchema
+	if err := r.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject), body, &resp); err != nil {
+		return 0, err
+	}
+	return resp.ID, nil
+}
+
+// latest returns the latest schema registered under subject.
+func (r *schemaRegistry) latest(ctx context.Context, subject string) (registeredSchema, error) {
+	var resp registeredSchema
+	err := r.do(ctx, http.MethodGet, "/subjects/"+url.PathEscape(subject)+"/versions/latest", nil, &resp)
+	return resp, err
+}
+
+func (r *schemaRegistry) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(r.url, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", schemaRegistryContentType)
+	if body != nil {
+		req.Header.Set("Content-Type", schemaRegistryContentType)
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return &registryError{err: err}
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &registryError{err: err}
+	}
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		if err := json.Unmarshal(data, out); err != nil {
+			return &registryError{err: fmt.Errorf("invalid response: %w", err)}
+		}
+		return nil
+
+	case resp.StatusCode == http.StatusNotFound:
+		return errSubjectNotFound
+
+	case resp.StatusCode == http.StatusConflict, resp.StatusCode == http.StatusUnprocessableEntity:
+		// The schema is invalid or incompatible with the registered versions.
+		var e registryErrorResponse
+		_ = json.Unmarshal(data, &e)
+		return mismatch("", "schema rejected by registry: %v (error_code=%d)", e.Message, e.ErrorCode)
+	}
+
+	return &registryError{err: fmt.Errorf("%v %v returned %v: %s", method, path, resp.Status, bytes.TrimSpace(data))}
+}
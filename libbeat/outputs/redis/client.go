@@ -45,8 +45,13 @@ var (
 type publishFn func(
 	keys outil.Selector,
 	data []publisher.Event,
+	deadLetter deadLetterFn,
 ) ([]publisher.Event, error)
 
+// deadLetterFn hands an event that can not be published to the dead letter
+// queue.
+type deadLetterFn func(event publisher.Event, reason error)
+
 type client struct {
 	log *logp.Logger
 	*transport.Client
@@ -147,7 +152,9 @@ func (c *client) Publish(_ context.Context, batch publisher.Batch) error {
 
 	events := batch.Events()
 	c.observer.NewBatch(len(events))
-	rest, err := c.publish(c.key, events)
+	rest, err := c.publish(c.key, events, func(event publisher.Event, reason error) {
+		publisher.DeadLetter(batch, []publisher.Event{event}, reason)
+	})
 	if rest != nil {
 		c.observer.RetryableErrors(len(rest))
 		batch.RetryEvents(rest)
@@ -225,11 +232,11 @@ func (c *client) makePublishPUBLISH(conn redis.Conn) (publishFn, error) {
 func (c *client) publishEventsBulk(conn redis.Conn, command string) publishFn {
 	// XXX: requires key.IsConst() == true
 	dest, _ := c.key.Select(&beat.Event{Fields: mapstr.M{}})
-	return func(_ outil.Selector, data []publisher.Event) ([]publisher.Event, error) {
+	return func(_ outil.Selector, data []publisher.Event, deadLetter deadLetterFn) ([]publisher.Event, error) {
 		args := make([]interface{}, 1, len(data)+1)
 		args[0] = dest
 
-		okEvents, args := serializeEvents(c.log, args, 1, data, c.index, c.codec)
+		okEvents, args := serializeEvents(c.log, args, 1, data, c.index, c.codec, deadLetter)
 		c.observer.PermanentErrors(len(data) - len(okEvents))
 		if (len(args) - 1) == 0 {
 			return nil, nil
@@ -252,10 +259,10 @@ func (c *client) publishEventsBulk(conn redis.Conn, command string) publishFn {
 }
 
 func (c *client) publishEventsPipeline(conn redis.Conn, command string) publishFn {
-	return func(key outil.Selector, data []publisher.Event) ([]publisher.Event, error) {
+	return func(key outil.Selector, data []publisher.Event, deadLetter deadLetterFn) ([]publisher.Event, error) {
 		var okEvents []publisher.Event
 		serialized := make([]interface{}, 0, len(data))
-		okEvents, serialized = serializeEvents(c.log, serialized, 0, data, c.index, c.codec)
+		okEvents, serialized = serializeEvents(c.log, serialized, 0, data, c.index, c.codec, deadLetter)
 		c.observer.PermanentErrors(len(data) - len(okEvents))
 		if len(serialized) == 0 {
 			return nil, nil
@@ -267,6 +274,7 @@ func (c *client) publishEventsPipeline(conn redis.Conn, command string) publishF
 			eventKey, err := key.Select(&okEvents[i].Content)
 			if err != nil {
 				c.log.Errorf("Failed to set redis key: %+v", err)
+				deadLetter(okEvents[i], err)
 				dropped++
 				continue
 			}
@@ -315,6 +323,7 @@ func serializeEvents(
 	data []publisher.Event,
 	index string,
 	codec codec.Codec,
+	deadLetter deadLetterFn,
 ) ([]publisher.Event, []interface{}) {
 
 	succeeded := data
@@ -324,6 +333,7 @@ func serializeEvents(
 		if err != nil {
 			log.Errorf("Encoding event failed with error: %+v. Look at the event log file to view the event", err)
 			log.Errorw(fmt.Sprintf("Failed event: %v", d.Content), logp.TypeKey, logp.EventType)
+			deadLetter(d, err)
 			goto failLoop
 		}
 
@@ -343,6 +353,7 @@ failLoop:
 		if err != nil {
 			log.Errorf("Encoding event failed with error: %+v. Look at the event log file to view the event", err)
 			log.Errorw(fmt.Sprintf("Failed event: %v", d.Content), logp.TypeKey, logp.EventType)
+			deadLetter(d, err)
 			i++
 			continue
 		}
@@ -23,6 +23,7 @@ import (
 	"github.com/elastic/beats/v7/libbeat/management"
 	"github.com/elastic/beats/v7/libbeat/publisher/queue"
 	"github.com/elastic/beats/v7/libbeat/publisher/queue/diskqueue"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue/hybridqueue"
 	"github.com/elastic/beats/v7/libbeat/publisher/queue/memqueue"
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
@@ -56,6 +57,16 @@ func Success(cfg config.Namespace, batchSize, retry int, encoderFactory queue.En
 				return Group{}, fmt.Errorf("unable to get disk queue settings: %w", err)
 			}
 			q = diskqueue.FactoryForSettings(settings)
+		case hybridqueue.QueueType:
+			if management.UnderAgent() {
+				logger := logp.NewLogger("output")
+				logger.Warn("Hybrid queue configuration found while running under agent: this configuration is unsupported and in technical preview.")
+			}
+			settings, err := hybridqueue.SettingsForUserConfig(cfg.Config())
+			if err != nil {
+				return Group{}, fmt.Errorf("unable to get hybrid queue settings: %w", err)
+			}
+			q = hybridqueue.FactoryForSettings(settings)
 		default:
 			return Group{}, fmt.Errorf("unknown queue type: %s", cfg.Name())
 		}
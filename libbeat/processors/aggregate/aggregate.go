@@ -0,0 +1,387 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package aggregate
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/libbeat/processors/checks"
+	jsprocessor "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor/registry"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+const (
+	procName = "aggregate"
+	logName  = "processor." + procName
+)
+
+// overflowKey is the key of the group collecting the events of new groups
+// once max_groups is reached.
+const overflowKey = ""
+
+// instanceID is used to assign each instance a unique monitoring namespace.
+var instanceID atomic.Uint32
+
+// processor groups events by the values of the group_by fields over time
+// windows, drops them and emits a summary event per group when a window
+// closes.
+type processor struct {
+	config
+
+	log   *logp.Logger
+	stats processorStats
+	now   func() time.Time
+	slide time.Duration
+
+	warnOnce sync.Once
+
+	mu     sync.Mutex
+	emit   func(beat.Event)
+	groups map[string]*group
+	next   time.Time // end of the next window to close
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// processorStats contains the metrics fields for the aggregate processor.
+type processorStats struct {
+	// Events measures the number of events aggregated.
+	Events *monitoring.Int
+	// Summaries measures the number of summary events emitted.
+	Summaries *monitoring.Int
+	// Overflow measures the number of events aggregated in the overflow
+	// group because max_groups was reached.
+	Overflow *monitoring.Int
+	// Groups is the number of groups currently held in memory.
+	Groups *monitoring.Int
+}
+
+// group holds the panes of a group. Panes last a slide and are merged into
+// windows when they close.
+type group struct {
+	values   []interface{}
+	overflow bool
+	panes    []*pane
+}
+
+type pane struct {
+	start  time.Time
+	count  int64
+	fields []fieldStats
+}
+
+func init() {
+	processors.RegisterPlugin(procName,
+		checks.ConfigChecked(New,
+			checks.AllowedFields(
+				"group_by", "window", "metrics",
+				"target_field", "max_groups", "max_samples",
+				"tag", "when",
+			),
+		),
+	)
+	jsprocessor.RegisterPlugin("Aggregate", New)
+}
+
+// New creates a new aggregate processor from the provided configuration,
+// or an error if the configuration is invalid.
+func New(c *conf.C, log *logp.Logger) (beat.Processor, error) {
+	cfg := defaultConfig()
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("fail to unpack the "+procName+" processor configuration: %w", err)
+	}
+
+	id := int(instanceID.Add(1))
+	log = log.Named(logName).With("instance_id", id)
+	registryName := logName + "." + strconv.Itoa(id)
+
+	if cfg.Tag != "" {
+		log = log.With("tag", cfg.Tag)
+		registryName = logName + "." + cfg.Tag + "-" + strconv.Itoa(id)
+	}
+	registry := monitoring.Default.NewRegistry(registryName, monitoring.DoNotReport)
+
+	return &processor{
+		config: cfg,
+		log:    log,
+		stats: processorStats{
+			Events:    monitoring.NewInt(registry, "events"),
+			Summaries: monitoring.NewInt(registry, "summaries"),
+			Overflow:  monitoring.NewInt(registry, "overflow"),
+			Groups:    monitoring.NewInt(registry, "groups"),
+		},
+		now:    time.Now,
+		slide:  cfg.Window.slide(),
+		groups: map[string]*group{},
+	}, nil
+}
+
+// Run adds the event to the current pane of its group and drops it. The
+// event is returned unchanged if the processor is not connected to a
+// client able to publish the summaries.
+func (p *processor) Run(event *beat.Event) (*beat.Event, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.emit == nil {
+		p.warnOnce.Do(func() {
+			p.log.Warn("The " + procName + " processor is not connected to a pipeline client, events are not aggregated. " +
+				"Configure it in the processors of an input or module.")
+		})
+		return event, nil
+	}
+
+	g := p.group(event)
+	start := p.now().Truncate(p.slide)
+	var pn *pane
+	if n := len(g.panes); n > 0 && g.panes[n-1].start.Equal(start) {
+		pn = g.panes[n-1]
+	} else {
+		pn = &pane{start: start, fields: make([]fieldStats, len(p.Metrics))}
+		g.panes = append(g.panes, pn)
+	}
+
+	pn.count++
+	for i, m := range p.Metrics {
+		if v, err := event.GetValue(m.Field); err == nil {
+			pn.fields[i].add(v, p.MaxSamples)
+		}
+	}
+	p.stats.Events.Inc()
+	if g.overflow {
+		p.stats.Overflow.Inc()
+	}
+	return nil, nil
+}
+
+// group returns the group of the event, creating it if needed.
+func (p *processor) group(event *beat.Event) *group {
+	values := make([]interface{}, len(p.GroupBy))
+	for i, field := range p.GroupBy {
+		if v, err := event.GetValue(field); err == nil {
+			values[i] = v
+		}
+	}
+	key := groupKey(values)
+	if g, ok := p.groups[key]; ok {
+		return g
+	}
+
+	g := &group{values: values}
+	if len(p.groups) >= p.MaxGroups {
+		key = overflowKey
+		if overflow, ok := p.groups[key]; ok {
+			return overflow
+		}
+		g = &group{values: make([]interface{}, len(p.GroupBy)), overflow: true}
+	}
+	p.groups[key] = g
+	p.stats.Groups.Set(int64(len(p.groups)))
+	return g
+}
+
+// groupKey identifies a group by the values of its group_by fields.
+func groupKey(values []interface{}) string {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Sprint(values...)
+	}
+	return string(b)
+}
+
+// StartEmitting starts the goroutine closing the windows.
+func (p *processor) StartEmitting(emit func(beat.Event)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.emit != nil {
+		return
+	}
+	p.emit = emit
+	p.next = p.now().Truncate(p.slide).Add(p.slide)
+	p.done = make(chan struct{})
+	p.wg.Add(1)
+	go p.loop(p.done)
+}
+
+// StopEmitting closes all the windows holding events and emits their
+// summaries.
+func (p *processor) StopEmitting() {
+	p.stop(true)
+}
+
+// Close stops the processor without emitting the pending summaries.
+func (p *processor) Close() error {
+	p.stop(false)
+	return nil
+}
+
+func (p *processor) stop(flush bool) {
+	p.mu.Lock()
+	emit := p.emit
+	if emit == nil {
+		p.mu.Unlock()
+		return
+	}
+	close(p.done)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+
+	p.mu.Lock()
+	var summaries []beat.Event
+	if flush {
+		summaries = p.closeWindows(time.Time{})
+	}
+	p.emit = nil
+	p.groups = map[string]*group{}
+	p.stats.Groups.Set(0)
+	p.mu.Unlock()
+
+	p.publish(emit, summaries)
+}
+
+func (p *processor) loop(done <-chan struct{}) {
+	defer p.wg.Done()
+
+	timer := time.NewTimer(p.untilNext())
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+
+		p.mu.Lock()
+		emit := p.emit
+		summaries := p.closeWindows(p.now())
+		p.mu.Unlock()
+
+		p.publish(emit, summaries)
+		timer.Reset(p.untilNext())
+	}
+}
+
+func (p *processor) untilNext() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.next.Sub(p.now())
+}
+
+func (p *processor) publish(emit func(beat.Event), summaries []beat.Event) {
+	for _, e := range summaries {
+		emit(e)
+	}
+	p.stats.Summaries.Add(int64(len(summaries)))
+}
+
+// closeWindows closes the windows ending before now and returns their
+// summaries. A zero now closes all the windows holding events.
+func (p *processor) closeWindows(now time.Time) []beat.Event {
+	var summaries []beat.Event
+	for ; len(p.groups) > 0 && (now.IsZero() || !p.next.After(now)); p.next = p.next.Add(p.slide) {
+		end := p.next
+		start := end.Add(-p.Window.Size)
+		for key, g := range p.groups {
+			if summary, ok := p.summarize(g, start, end); ok {
+				summaries = append(summaries, summary)
+			}
+
+			// Drop the panes that won't be part of the next window.
+			keep := start.Add(p.slide)
+			i := 0
+			for i < len(g.panes) && g.panes[i].start.Before(keep) {
+				i++
+			}
+			g.panes = g.panes[i:]
+			if len(g.panes) == 0 {
+				delete(p.groups, key)
+			}
+		}
+	}
+	p.stats.Groups.Set(int64(len(p.groups)))
+
+	// Skip the windows that closed while no event was aggregated.
+	if !now.IsZero() && !p.next.After(now) {
+		p.next = now.Truncate(p.slide).Add(p.slide)
+	}
+	return summaries
+}
+
+// summarize merges the panes of the group starting in [start, end) into a
+// summary event. It returns false if the window holds no events.
+func (p *processor) summarize(g *group, start, end time.Time) (beat.Event, bool) {
+	var (
+		count  int64
+		fields = make([]fieldStats, len(p.Metrics))
+	)
+	for _, pn := range g.panes {
+		if pn.start.Before(start) || !pn.start.Before(end) {
+			continue
+		}
+		count += pn.count
+		for i := range fields {
+			fields[i].merge(&pn.fields[i], p.MaxSamples)
+		}
+	}
+	if count == 0 {
+		return beat.Event{}, false
+	}
+
+	summary := mapstr.M{
+		"count": count,
+		"window": mapstr.M{
+			"start": start,
+			"end":   end,
+		},
+	}
+	if g.overflow {
+		summary["overflow"] = true
+	}
+	for i := range p.Metrics {
+		m := &p.Metrics[i]
+		_, _ = summary.Put(m.Field, fields[i].summary(m))
+	}
+
+	event := beat.Event{Timestamp: start, Fields: mapstr.M{}}
+	for i, field := range p.GroupBy {
+		if g.values[i] != nil {
+			_, _ = event.PutValue(field, g.values[i])
+		}
+	}
+	_, _ = event.PutValue(p.TargetField, summary)
+	return event, true
+}
+
+func (p *processor) String() string {
+	data, _ := json.Marshal(p.config)
+
+	return procName + "=" + string(data)
+}
@@ -0,0 +1,243 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package aggregate
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+var t0 = time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// testProcessor is an aggregate processor driven by a fake clock.
+type testProcessor struct {
+	*processor
+
+	mu      sync.Mutex
+	clock   time.Time
+	emitted []beat.Event
+}
+
+func newTestProcessor(t *testing.T, cfg mapstr.M) *testProcessor {
+	t.Helper()
+	p, err := New(conf.MustNewConfigFrom(cfg), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+
+	tp := &testProcessor{processor: p.(*processor), clock: t0}
+	tp.processor.now = func() time.Time {
+		tp.mu.Lock()
+		defer tp.mu.Unlock()
+		return tp.clock
+	}
+	tp.StartEmitting(func(e beat.Event) {
+		tp.mu.Lock()
+		defer tp.mu.Unlock()
+		tp.emitted = append(tp.emitted, e)
+	})
+	t.Cleanup(tp.StopEmitting)
+	return tp
+}
+
+func (tp *testProcessor) run(t *testing.T, at time.Duration, fields mapstr.M) {
+	t.Helper()
+	tp.mu.Lock()
+	tp.clock = t0.Add(at)
+	tp.mu.Unlock()
+
+	out, err := tp.Run(&beat.Event{Timestamp: t0.Add(at), Fields: fields})
+	require.NoError(t, err)
+	assert.Nil(t, out, "aggregated events must be dropped")
+}
+
+// closeWindows closes the windows ending before at, like the timer would.
+func (tp *testProcessor) closeWindows(at time.Duration) []beat.Event {
+	tp.processor.mu.Lock()
+	summaries := tp.processor.closeWindows(t0.Add(at))
+	tp.processor.mu.Unlock()
+	return summaries
+}
+
+func (tp *testProcessor) stop() []beat.Event {
+	tp.StopEmitting()
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.emitted
+}
+
+func byService(summaries []beat.Event) map[string]mapstr.M {
+	out := map[string]mapstr.M{}
+	for _, e := range summaries {
+		service, _ := e.Fields.GetValue("service.name")
+		name, _ := service.(string)
+		out[name] = e.Fields
+	}
+	return out
+}
+
+func TestTumblingWindow(t *testing.T) {
+	p := newTestProcessor(t, mapstr.M{
+		"group_by": []string{"service.name"},
+		"window":   mapstr.M{"size": "1m"},
+		"metrics": []mapstr.M{
+			{"field": "event.duration", "stats": []string{"count", "sum", "min", "max", "avg", "percentiles"}, "percentiles": []float64{37.5, 50}},
+			{"field": "message", "stats": []string{"first", "last"}},
+		},
+	})
+
+	for i, d := range []int64{30, 10, 20} {
+		p.run(t, time.Duration(i)*time.Second, mapstr.M{
+			"service": mapstr.M{"name": "api"},
+			"event":   mapstr.M{"duration": d},
+			"message": "request " + string(rune('a'+i)),
+		})
+	}
+	p.run(t, 10*time.Second, mapstr.M{"service": mapstr.M{"name": "db"}, "message": "query"})
+	p.run(t, 70*time.Second, mapstr.M{"service": mapstr.M{"name": "api"}, "event": mapstr.M{"duration": 5}})
+
+	assert.Empty(t, p.closeWindows(59*time.Second))
+	summaries := byService(p.closeWindows(60 * time.Second))
+	require.Len(t, summaries, 2)
+
+	assert.Equal(t, mapstr.M{
+		"service": mapstr.M{"name": "api"},
+		"aggregate": mapstr.M{
+			"count":  int64(3),
+			"window": mapstr.M{"start": t0, "end": t0.Add(time.Minute)},
+			"event": mapstr.M{"duration": mapstr.M{
+				"count":       int64(3),
+				"sum":         float64(60),
+				"min":         float64(10),
+				"max":         float64(30),
+				"avg":         float64(20),
+				"percentiles": mapstr.M{"37_5": 17.5, "50": float64(20)},
+			}},
+			"message": mapstr.M{"first": "request a", "last": "request c"},
+		},
+	}, summaries["api"])
+
+	db := summaries["db"]
+	count, _ := db.GetValue("aggregate.count")
+	assert.Equal(t, int64(1), count)
+	duration, _ := db.GetValue("aggregate.event.duration")
+	assert.Equal(t, mapstr.M{"count": int64(0)}, duration, "numeric stats are only set for numeric values")
+
+	// The next window is emitted on shutdown.
+	emitted := p.stop()
+	require.Len(t, emitted, 1)
+	assert.Equal(t, t0.Add(time.Minute), emitted[0].Timestamp)
+	count, _ = emitted[0].Fields.GetValue("aggregate.count")
+	assert.Equal(t, int64(1), count)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	p := newTestProcessor(t, mapstr.M{
+		"window":  mapstr.M{"type": "sliding", "size": "3m", "slide": "1m"},
+		"metrics": []mapstr.M{{"field": "bytes", "stats": []string{"sum"}}},
+	})
+
+	p.run(t, 0, mapstr.M{"bytes": 1})
+	p.run(t, 90*time.Second, mapstr.M{"bytes": 10})
+
+	sums := func(summaries []beat.Event) []interface{} {
+		var out []interface{}
+		for _, e := range summaries {
+			v, _ := e.Fields.GetValue("aggregate.bytes.sum")
+			out = append(out, v)
+		}
+		return out
+	}
+	assert.Equal(t, []interface{}{float64(1)}, sums(p.closeWindows(time.Minute)))
+	assert.Equal(t, []interface{}{float64(11)}, sums(p.closeWindows(2*time.Minute)))
+	assert.Equal(t, []interface{}{float64(11)}, sums(p.closeWindows(3*time.Minute)))
+	assert.Equal(t, []interface{}{float64(10)}, sums(p.closeWindows(4*time.Minute)))
+	assert.Empty(t, p.closeWindows(5*time.Minute))
+	assert.Empty(t, p.groups)
+}
+
+func TestMaxGroups(t *testing.T) {
+	p := newTestProcessor(t, mapstr.M{
+		"group_by":   []string{"host"},
+		"max_groups": 2,
+	})
+
+	for _, host := range []string{"a", "b", "c", "d", "a"} {
+		p.run(t, 0, mapstr.M{"host": host})
+	}
+	assert.Len(t, p.groups, 3)
+	assert.Equal(t, int64(2), p.stats.Overflow.Get())
+
+	counts := map[string]interface{}{}
+	for _, e := range p.closeWindows(time.Minute) {
+		host, _ := e.Fields.GetValue("host")
+		name, _ := host.(string)
+		if overflow, _ := e.Fields.GetValue("aggregate.overflow"); overflow == true {
+			name = "overflow"
+		}
+		counts[name], _ = e.Fields.GetValue("aggregate.count")
+	}
+	assert.Equal(t, map[string]interface{}{
+		"a":        int64(2),
+		"b":        int64(1),
+		"overflow": int64(2),
+	}, counts)
+}
+
+func TestNotEmitting(t *testing.T) {
+	p, err := New(conf.MustNewConfigFrom(mapstr.M{}), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+
+	event := &beat.Event{Fields: mapstr.M{"message": "hello"}}
+	out, err := p.Run(event)
+	require.NoError(t, err)
+	assert.Equal(t, event, out)
+}
+
+func TestConfigValidation(t *testing.T) {
+	tests := map[string]mapstr.M{
+		"unknown window type":  {"window": mapstr.M{"type": "hopping"}},
+		"slide on tumbling":    {"window": mapstr.M{"size": "1m", "slide": "10s"}},
+		"slide not a divisor":  {"window": mapstr.M{"type": "sliding", "size": "1m", "slide": "25s"}},
+		"slide missing":        {"window": mapstr.M{"type": "sliding", "size": "1m"}},
+		"unknown stat":         {"metrics": []mapstr.M{{"field": "x", "stats": []string{"median"}}}},
+		"invalid percentile":   {"metrics": []mapstr.M{{"field": "x", "stats": []string{"percentiles"}, "percentiles": []float64{0}}}},
+		"metric without field": {"metrics": []mapstr.M{{"stats": []string{"sum"}}}},
+	}
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(conf.MustNewConfigFrom(cfg), logptest.NewTestingLogger(t, ""))
+			assert.Error(t, err)
+		})
+	}
+
+	p, err := New(conf.MustNewConfigFrom(mapstr.M{
+		"metrics": []mapstr.M{{"field": "x"}, {"field": "y", "stats": []string{"percentiles"}}},
+	}), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	metrics := p.(*processor).Metrics
+	assert.Equal(t, []string{"count", "sum", "min", "max", "avg"}, metrics[0].Stats)
+	assert.Equal(t, []float64{50, 95, 99}, metrics[1].Percentiles)
+}
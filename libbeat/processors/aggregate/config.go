@@ -0,0 +1,135 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package aggregate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	windowTumbling = "tumbling"
+	windowSliding  = "sliding"
+)
+
+// Names of the statistics computed on the metric fields.
+const (
+	statCount       = "count"
+	statSum         = "sum"
+	statMin         = "min"
+	statMax         = "max"
+	statAvg         = "avg"
+	statFirst       = "first"
+	statLast        = "last"
+	statPercentiles = "percentiles"
+)
+
+type config struct {
+	GroupBy     []string       `config:"group_by"`
+	Window      windowConfig   `config:"window"`
+	Metrics     []metricConfig `config:"metrics"`
+	TargetField string         `config:"target_field" validate:"required"`
+	MaxGroups   int            `config:"max_groups" validate:"min=1"`
+	MaxSamples  int            `config:"max_samples" validate:"min=1"`
+	Tag         string         `config:"tag"`
+}
+
+type windowConfig struct {
+	Type  string        `config:"type"`
+	Size  time.Duration `config:"size" validate:"positive"`
+	Slide time.Duration `config:"slide" validate:"min=0"`
+}
+
+type metricConfig struct {
+	Field       string    `config:"field" validate:"required"`
+	Stats       []string  `config:"stats"`
+	Percentiles []float64 `config:"percentiles"`
+}
+
+func defaultConfig() config {
+	return config{
+		Window: windowConfig{
+			Type: windowTumbling,
+			Size: time.Minute,
+		},
+		TargetField: "aggregate",
+		MaxGroups:   10000,
+		MaxSamples:  1000,
+	}
+}
+
+func (c *windowConfig) Validate() error {
+	switch c.Type {
+	case windowTumbling:
+		if c.Slide != 0 && c.Slide != c.Size {
+			return errors.New("slide can only be set for sliding windows")
+		}
+	case windowSliding:
+		if c.Slide <= 0 || c.Slide >= c.Size {
+			return errors.New("slide of sliding windows must be positive and less than the window size")
+		}
+		if c.Size%c.Slide != 0 {
+			return errors.New("size of sliding windows must be a multiple of the slide")
+		}
+	default:
+		return fmt.Errorf("unsupported window type %q, must be %s or %s", c.Type, windowTumbling, windowSliding)
+	}
+	return nil
+}
+
+// slide returns the interval between two window closes.
+func (c *windowConfig) slide() time.Duration {
+	if c.Type == windowSliding {
+		return c.Slide
+	}
+	return c.Size
+}
+
+func (c *metricConfig) Validate() error {
+	if len(c.Stats) == 0 {
+		c.Stats = []string{statCount, statSum, statMin, statMax, statAvg}
+	}
+	for _, s := range c.Stats {
+		switch s {
+		case statCount, statSum, statMin, statMax, statAvg, statFirst, statLast:
+		case statPercentiles:
+			if len(c.Percentiles) == 0 {
+				c.Percentiles = []float64{50, 95, 99}
+			}
+		default:
+			return fmt.Errorf("unsupported stat %q for field %q", s, c.Field)
+		}
+	}
+	for _, p := range c.Percentiles {
+		if p <= 0 || p > 100 {
+			return fmt.Errorf("percentile %v for field %q must be in the range (0, 100]", p, c.Field)
+		}
+	}
+	return nil
+}
+
+// has returns true if the stat is computed for the field.
+func (c *metricConfig) has(stat string) bool {
+	for _, s := range c.Stats {
+		if s == stat {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package aggregate
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// fieldStats holds the statistics of a metric field over a pane, or over a
+// window when panes are merged. Percentiles are computed from a reservoir of
+// at most maxSamples values, they are exact as long as fewer values are
+// aggregated.
+type fieldStats struct {
+	count   int64 // events with the field
+	numeric int64 // numeric values
+	sum     float64
+	min     float64
+	max     float64
+	samples []float64
+
+	first, last interface{}
+}
+
+// add records a value of the field.
+func (s *fieldStats) add(value interface{}, maxSamples int) {
+	if s.count == 0 {
+		s.first = cloneValue(value)
+	}
+	s.last = cloneValue(value)
+	s.count++
+
+	v, ok := toFloat(value)
+	if !ok {
+		return
+	}
+	if s.numeric == 0 || v < s.min {
+		s.min = v
+	}
+	if s.numeric == 0 || v > s.max {
+		s.max = v
+	}
+	s.numeric++
+	s.sum += v
+
+	// Reservoir sampling, every value has the same probability of being
+	// kept.
+	if len(s.samples) < maxSamples {
+		s.samples = append(s.samples, v)
+	} else if i := rand.Int64N(s.numeric); i < int64(maxSamples) {
+		s.samples[i] = v
+	}
+}
+
+// merge adds the statistics of a later pane to s.
+func (s *fieldStats) merge(o *fieldStats, maxSamples int) {
+	if o.count == 0 {
+		return
+	}
+	if s.count == 0 {
+		s.first = o.first
+	}
+	s.last = o.last
+	s.count += o.count
+
+	if o.numeric > 0 {
+		if s.numeric == 0 || o.min < s.min {
+			s.min = o.min
+		}
+		if s.numeric == 0 || o.max > s.max {
+			s.max = o.max
+		}
+		s.numeric += o.numeric
+		s.sum += o.sum
+		s.samples = append(s.samples, o.samples...)
+	}
+
+	if len(s.samples) > maxSamples {
+		rand.Shuffle(len(s.samples), func(i, j int) {
+			s.samples[i], s.samples[j] = s.samples[j], s.samples[i]
+		})
+		s.samples = s.samples[:maxSamples]
+	}
+}
+
+// summary returns the statistics configured for the field.
+func (s *fieldStats) summary(m *metricConfig) mapstr.M {
+	out := mapstr.M{}
+	for _, stat := range m.Stats {
+		switch stat {
+		case statCount:
+			out[statCount] = s.count
+		case statFirst:
+			out[statFirst] = s.first
+		case statLast:
+			out[statLast] = s.last
+		}
+		if s.numeric == 0 {
+			continue
+		}
+		switch stat {
+		case statSum:
+			out[statSum] = s.sum
+		case statMin:
+			out[statMin] = s.min
+		case statMax:
+			out[statMax] = s.max
+		case statAvg:
+			out[statAvg] = s.sum / float64(s.numeric)
+		case statPercentiles:
+			out[statPercentiles] = percentiles(s.samples, m.Percentiles)
+		}
+	}
+	return out
+}
+
+// percentiles computes the percentiles of the samples, interpolating
+// between the closest ranks. The keys are the percentiles with the decimal
+// point replaced by an underscore, e.g. 99_9.
+func percentiles(samples []float64, ps []float64) mapstr.M {
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+
+	out := mapstr.M{}
+	for _, p := range ps {
+		key := strings.ReplaceAll(strconv.FormatFloat(p, 'f', -1, 64), ".", "_")
+		rank := p / 100 * float64(len(sorted)-1)
+		lower := int(math.Floor(rank))
+		upper := int(math.Ceil(rank))
+		out[key] = sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+	}
+	return out
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// cloneValue copies maps, the aggregated events are dropped but may share
+// their fields with other events.
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case mapstr.M:
+		return v.Clone()
+	case map[string]interface{}:
+		return mapstr.M(v).Clone()
+	default:
+		return value
+	}
+}
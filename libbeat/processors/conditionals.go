@@ -85,6 +85,16 @@ func (r *WhenProcessor) Run(event *beat.Event) (*beat.Event, error) {
 	return r.p.Run(event)
 }
 
+// StartEmitting forwards to the conditioned processor if it is an Emitter.
+func (r *WhenProcessor) StartEmitting(emit func(beat.Event)) {
+	StartEmitting(r.p, emit)
+}
+
+// StopEmitting forwards to the conditioned processor if it is an Emitter.
+func (r *WhenProcessor) StopEmitting() {
+	StopEmitting(r.p)
+}
+
 func (r *WhenProcessor) String() string {
 	return fmt.Sprintf("%v, condition=%v", r.p.String(), r.condition.String())
 }
@@ -172,6 +182,22 @@ func (p *IfThenElseProcessor) Run(event *beat.Event) (*beat.Event, error) {
 	return event, nil
 }
 
+// StartEmitting starts the emitting processors of both branches.
+func (p *IfThenElseProcessor) StartEmitting(emit func(beat.Event)) {
+	p.then.StartEmitting(emit)
+	if p.els != nil {
+		p.els.StartEmitting(emit)
+	}
+}
+
+// StopEmitting stops the emitting processors of both branches.
+func (p *IfThenElseProcessor) StopEmitting() {
+	p.then.StopEmitting()
+	if p.els != nil {
+		p.els.StopEmitting()
+	}
+}
+
 func (p *IfThenElseProcessor) String() string {
 	var sb strings.Builder
 	sb.WriteString("if ")
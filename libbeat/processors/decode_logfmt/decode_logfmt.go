@@ -0,0 +1,125 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_logfmt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common/jsontransform"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/libbeat/processors/checks"
+	jsprocessor "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor/registry"
+	"github.com/elastic/beats/v7/libbeat/reader/logfmt"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const (
+	procName = "decode_logfmt"
+	logName  = "processor." + procName
+)
+
+var errFieldIsNotString = errors.New("field value is not a string")
+
+type decodeLogfmt struct {
+	config
+
+	decoder *logfmt.Decoder
+	log     *logp.Logger
+}
+
+func init() {
+	processors.RegisterPlugin(procName,
+		checks.ConfigChecked(New,
+			checks.RequireFields("field"),
+			checks.AllowedFields(
+				"field", "target_field",
+				"pair_separator", "value_separator",
+				"quote_chars", "expand_keys",
+				"infer_types", "overwrite_keys",
+				"ignore_missing", "ignore_failure",
+				"when",
+			)))
+	jsprocessor.RegisterPlugin("DecodeLogfmt", New)
+}
+
+// New constructs a new decode_logfmt processor.
+func New(c *conf.C, log *logp.Logger) (beat.Processor, error) {
+	config := defaultConfig()
+
+	if err := c.Unpack(&config); err != nil {
+		return nil, fmt.Errorf("fail to unpack the "+procName+" processor configuration: %w", err)
+	}
+
+	return &decodeLogfmt{
+		config:  config,
+		decoder: logfmt.NewDecoder(config.DecoderConfig),
+		log:     log.Named(logName),
+	}, nil
+}
+
+// Run decodes the key=value pairs of the configured field. If the field
+// can't be decoded and failures are not ignored, the pairs decoded before
+// the error are still written to the event.
+func (p *decodeLogfmt) Run(event *beat.Event) (*beat.Event, error) {
+	if err := p.run(event); err != nil && !p.IgnoreFailure {
+		err = fmt.Errorf("failed in "+procName+" on the %q field: %w", p.Field, err)
+		_, _ = event.PutValue("error.message", err.Error())
+		return event, err
+	}
+	return event, nil
+}
+
+func (p *decodeLogfmt) run(event *beat.Event) error {
+	data, err := event.GetValue(p.Field)
+	if err != nil {
+		if p.IgnoreMissing && errors.Is(err, mapstr.ErrKeyNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	text, ok := data.(string)
+	if !ok {
+		return errFieldIsNotString
+	}
+
+	fields, decodeErr := p.decoder.Decode(text)
+	if len(fields) > 0 {
+		if p.Target != "" {
+			if _, err = event.PutValue(p.Target, fields); err != nil {
+				return fmt.Errorf("failed to put decoded pairs into field %q: %w", p.Target, err)
+			}
+		} else {
+			jsontransform.WriteJSONKeys(event, fields, false, p.OverwriteKeys, !p.IgnoreFailure)
+		}
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("error decoding logfmt field: %w", decodeErr)
+	}
+	return nil
+}
+
+func (p *decodeLogfmt) String() string {
+	json, _ := json.Marshal(p.config)
+	return procName + "=" + string(json)
+}
@@ -0,0 +1,154 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package decode_logfmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestDecodeLogfmt(t *testing.T) {
+	tests := []struct {
+		description string
+		config      map[string]interface{}
+		input       mapstr.M
+		output      mapstr.M
+		wantErr     string
+	}{
+		{
+			description: "default target",
+			input:       mapstr.M{"message": `level=info msg="request done" status=200`},
+			output: mapstr.M{
+				"message": `level=info msg="request done" status=200`,
+				"logfmt":  mapstr.M{"level": "info", "msg": "request done", "status": "200"},
+			},
+		},
+		{
+			description: "custom field and target with type inference and expanded keys",
+			config: map[string]interface{}{
+				"field":        "kv",
+				"target_field": "app",
+				"infer_types":  true,
+				"expand_keys":  true,
+			},
+			input: mapstr.M{"kv": "http.status=404 http.took=0.5 cached=false"},
+			output: mapstr.M{
+				"kv": "http.status=404 http.took=0.5 cached=false",
+				"app": mapstr.M{
+					"http":   mapstr.M{"status": int64(404), "took": 0.5},
+					"cached": false,
+				},
+			},
+		},
+		{
+			description: "root target without overwriting keys",
+			config: map[string]interface{}{
+				"target_field": "",
+			},
+			input: mapstr.M{"message": "message=replaced user=bob"},
+			output: mapstr.M{
+				"message": "message=replaced user=bob",
+				"user":    "bob",
+			},
+		},
+		{
+			description: "root target overwriting keys",
+			config: map[string]interface{}{
+				"target_field":   "",
+				"overwrite_keys": true,
+			},
+			input: mapstr.M{"message": "message=replaced user=bob"},
+			output: mapstr.M{
+				"message": "replaced",
+				"user":    "bob",
+			},
+		},
+		{
+			description: "invalid message keeps the decoded pairs",
+			input:       mapstr.M{"message": `a=1 b="open`},
+			output: mapstr.M{
+				"message": `a=1 b="open`,
+				"logfmt":  mapstr.M{"a": "1"},
+				"error": mapstr.M{
+					"message": `failed in decode_logfmt on the "message" field: error decoding logfmt field: error at position 6: unterminated quoted string`,
+				},
+			},
+			wantErr: "unterminated quoted string",
+		},
+		{
+			description: "ignore failure",
+			config: map[string]interface{}{
+				"ignore_failure": true,
+			},
+			input:  mapstr.M{"message": 42},
+			output: mapstr.M{"message": 42},
+		},
+		{
+			description: "missing field",
+			input:       mapstr.M{"other": "a=1"},
+			output: mapstr.M{
+				"other": "a=1",
+				"error": mapstr.M{
+					"message": `failed in decode_logfmt on the "message" field: key not found`,
+				},
+			},
+			wantErr: "key not found",
+		},
+		{
+			description: "ignore missing field",
+			config: map[string]interface{}{
+				"ignore_missing": true,
+			},
+			input:  mapstr.M{"other": "a=1"},
+			output: mapstr.M{"other": "a=1"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			cfg := conf.MustNewConfigFrom(tc.config)
+			p, err := New(cfg, logptest.NewTestingLogger(t, ""))
+			require.NoError(t, err)
+
+			event := &beat.Event{Fields: tc.input.Clone()}
+			out, err := p.Run(event)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.output, out.Fields)
+		})
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	cfg := conf.MustNewConfigFrom(map[string]interface{}{
+		"pair_separator":  ",",
+		"value_separator": ",",
+	})
+	_, err := New(cfg, logptest.NewTestingLogger(t, ""))
+	assert.ErrorContains(t, err, "overlap")
+}
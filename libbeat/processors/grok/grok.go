@@ -0,0 +1,310 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grok
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dlclark/regexp2"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// builtinPatterns are the standard pattern definitions, loaded before the
+// user supplied ones.
+//
+//go:embed patterns/*
+var builtinPatterns embed.FS
+
+// patternRef matches the references to patterns: %{SYNTAX},
+// %{SYNTAX:SEMANTIC} and %{SYNTAX:SEMANTIC:TYPE}.
+var patternRef = regexp.MustCompile(`%\{(\w+)(?::([^:{}]+)(?::(\w+))?)?\}`)
+
+// groupPrefix prefixes the names of the regexp groups generated for the
+// semantic captures, whose field names aren't valid group names.
+const groupPrefix = "_grok"
+
+// dataType is the type a captured value is converted to.
+type dataType string
+
+const (
+	typeString  dataType = "string"
+	typeInt     dataType = "int"
+	typeLong    dataType = "long"
+	typeFloat   dataType = "float"
+	typeDouble  dataType = "double"
+	typeBoolean dataType = "boolean"
+)
+
+func parseDataType(s string) (dataType, error) {
+	switch t := dataType(s); t {
+	case "", typeString:
+		return typeString, nil
+	case typeInt, typeLong, typeFloat, typeDouble, typeBoolean:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unsupported data type %q", s)
+	}
+}
+
+func (t dataType) convert(s string) (interface{}, error) {
+	switch t {
+	case typeInt, typeLong:
+		return strconv.ParseInt(s, 10, 64)
+	case typeFloat, typeDouble:
+		return strconv.ParseFloat(s, 64)
+	case typeBoolean:
+		return strconv.ParseBool(s)
+	default:
+		return s, nil
+	}
+}
+
+// capture is a regexp group whose value is written to a field.
+type capture struct {
+	group string
+	field string
+	typ   dataType
+}
+
+// grokPattern is a compiled grok pattern.
+type grokPattern struct {
+	raw      string
+	re       *regexp2.Regexp
+	captures []capture
+}
+
+// compile expands the references to the pattern definitions in pattern and
+// compiles the resulting regular expression. Matches taking longer than
+// timeout fail, a zero timeout disables the limit.
+func compile(pattern string, definitions map[string]string, timeout time.Duration) (*grokPattern, error) {
+	g := &grokPattern{raw: pattern}
+	expanded, err := g.expand(pattern, definitions, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	re, err := regexp2.Compile(expanded, regexp2.ExplicitCapture)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile pattern %q: %w", pattern, err)
+	}
+	if timeout > 0 {
+		re.MatchTimeout = timeout
+	}
+	g.re = re
+
+	// Named groups in the regular expressions, like (?<name>...), are
+	// captured as well.
+	for _, name := range re.GetGroupNames() {
+		if _, err := strconv.Atoi(name); err == nil || strings.HasPrefix(name, groupPrefix) {
+			continue
+		}
+		g.captures = append(g.captures, capture{group: name, field: name, typ: typeString})
+	}
+	return g, nil
+}
+
+// expand replaces the references to patterns in s with their definition.
+// stack holds the patterns being expanded, to detect recursive definitions.
+func (g *grokPattern) expand(s string, definitions map[string]string, stack []string) (string, error) {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range patternRef.FindAllStringSubmatchIndex(s, -1) {
+		sb.WriteString(s[last:loc[0]])
+		last = loc[1]
+
+		name := s[loc[2]:loc[3]]
+		definition, ok := definitions[name]
+		if !ok {
+			return "", fmt.Errorf("pattern %q is not defined", name)
+		}
+		for _, n := range stack {
+			if n == name {
+				return "", fmt.Errorf("pattern %q is defined recursively", name)
+			}
+		}
+
+		if loc[4] < 0 {
+			inner, err := g.expand(definition, definitions, append(stack, name))
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString("(?:" + inner + ")")
+			continue
+		}
+
+		typ := typeString
+		if loc[6] >= 0 {
+			var err error
+			if typ, err = parseDataType(s[loc[6]:loc[7]]); err != nil {
+				return "", err
+			}
+		}
+		group := groupPrefix + strconv.Itoa(len(g.captures))
+		g.captures = append(g.captures, capture{
+			group: group,
+			field: fieldName(s[loc[4]:loc[5]]),
+			typ:   typ,
+		})
+		inner, err := g.expand(definition, definitions, append(stack, name))
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString("(?<" + group + ">" + inner + ")")
+	}
+	sb.WriteString(s[last:])
+	return sb.String(), nil
+}
+
+// match matches s against the pattern. It returns the captured fields, or
+// false if s doesn't match. Empty captures are ignored.
+func (g *grokPattern) match(s string) (mapstr.M, bool, error) {
+	m, err := g.re.FindStringMatch(s)
+	if err != nil || m == nil {
+		return nil, false, err
+	}
+
+	fields := mapstr.M{}
+	for _, c := range g.captures {
+		group := m.GroupByName(c.group)
+		if group == nil || len(group.Captures) == 0 {
+			continue
+		}
+		value := group.String()
+		if value == "" {
+			continue
+		}
+		converted, err := c.typ.convert(value)
+		if err != nil {
+			return nil, true, fmt.Errorf("failed to convert field %q to %s: %w", c.field, c.typ, err)
+		}
+		fields[c.field] = converted
+	}
+	return fields, true, nil
+}
+
+// fieldName converts the Logstash field references, like [client][ip], to
+// dotted field names.
+func fieldName(semantic string) string {
+	if !strings.HasPrefix(semantic, "[") {
+		return semantic
+	}
+	return strings.ReplaceAll(strings.Trim(semantic, "[]"), "][", ".")
+}
+
+// loadPatterns returns the standard pattern definitions, overridden by the
+// definitions in the files matching the patternFiles globs, in order.
+func loadPatterns(patternFiles []string) (map[string]string, error) {
+	definitions := map[string]string{}
+
+	entries, err := fs.ReadDir(builtinPatterns, "patterns")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := path.Join("patterns", entry.Name())
+		f, err := builtinPatterns.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		err = parsePatterns(f, definitions)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", name, err)
+		}
+	}
+
+	for _, glob := range patternFiles {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern file glob %q: %w", glob, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no pattern file matches %q", glob)
+		}
+		sort.Strings(matches)
+		for _, name := range matches {
+			if err := loadPatternFile(name, definitions); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return definitions, nil
+}
+
+func loadPatternFile(name string, definitions map[string]string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to open pattern file: %w", err)
+	}
+	defer f.Close()
+
+	if err := parsePatterns(f, definitions); err != nil {
+		return fmt.Errorf("failed to load pattern file %s: %w", name, err)
+	}
+	return nil
+}
+
+// parsePatterns reads pattern definitions in the Logstash format: one
+// definition per line, made of the name of the pattern, a white space and
+// the pattern. Empty lines and lines starting with # are ignored.
+func parsePatterns(r io.Reader, definitions map[string]string) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, pattern, ok := strings.Cut(text, " ")
+		if !ok {
+			return fmt.Errorf("line %d: missing pattern for %q", line, name)
+		}
+		if !validPatternName(name) {
+			return fmt.Errorf("line %d: invalid pattern name %q", line, name)
+		}
+		definitions[name] = strings.TrimSpace(pattern)
+	}
+	return scanner.Err()
+}
+
+func validPatternName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && !('0' <= r && r <= '9') && !('a' <= r && r <= 'z') && !('A' <= r && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grok
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestBuiltinPatternsCompile(t *testing.T) {
+	definitions, err := loadPatterns(nil)
+	require.NoError(t, err)
+	require.Contains(t, definitions, "IPORHOST")
+	require.Contains(t, definitions, "COMBINEDAPACHELOG")
+
+	for name := range definitions {
+		_, err := compile("%{"+name+"}", definitions, 0)
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	definitions, err := loadPatterns(nil)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		pattern string
+		input   string
+		want    mapstr.M
+		wantErr string
+	}{
+		{
+			name:    "types",
+			pattern: `%{IP:client.ip} %{WORD:method} %{NUMBER:status:int} %{NUMBER:took:float} %{WORD:cached:boolean}`,
+			input:   "10.0.0.1 GET 200 0.25 false",
+			want: mapstr.M{
+				"client.ip": "10.0.0.1",
+				"method":    "GET",
+				"status":    int64(200),
+				"took":      0.25,
+				"cached":    false,
+			},
+		},
+		{
+			name:    "unanchored",
+			pattern: `user=%{USERNAME:user}`,
+			input:   "login ok user=alice from somewhere",
+			want:    mapstr.M{"user": "alice"},
+		},
+		{
+			name:    "logstash field references",
+			pattern: `%{IPV4:[source][ip]}:%{POSINT:[source][port]:long}`,
+			input:   "192.168.0.1:8080",
+			want:    mapstr.M{"source.ip": "192.168.0.1", "source.port": int64(8080)},
+		},
+		{
+			name:    "named groups",
+			pattern: `(?<queue_id>[0-9A-F]{10,11}): %{GREEDYDATA:msg}`,
+			input:   "BEF25A72965: message-id=<20130101142543.5828399CCAF@example.com>",
+			want: mapstr.M{
+				"queue_id": "BEF25A72965",
+				"msg":      "message-id=<20130101142543.5828399CCAF@example.com>",
+			},
+		},
+		{
+			name:    "captures in definitions",
+			pattern: `%{COMBINEDAPACHELOG}`,
+			input:   `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`,
+			want: mapstr.M{
+				"clientip":    "127.0.0.1",
+				"ident":       "-",
+				"auth":        "frank",
+				"timestamp":   "10/Oct/2000:13:55:36 -0700",
+				"verb":        "GET",
+				"request":     "/apache_pb.gif",
+				"httpversion": "1.0",
+				"response":    "200",
+				"bytes":       "2326",
+				"referrer":    `"http://www.example.com/start.html"`,
+				"agent":       `"Mozilla/4.08"`,
+			},
+		},
+		{
+			name:    "optional captures are omitted",
+			pattern: `%{SYSLOGBASE} %{GREEDYDATA:msg}`,
+			input:   "Mar 16 00:01:25 evita postfix/smtpd: connect from camomile.cloud9.net",
+			want: mapstr.M{
+				"timestamp": "Mar 16 00:01:25",
+				"logsource": "evita",
+				"program":   "postfix/smtpd",
+				"msg":       "connect from camomile.cloud9.net",
+			},
+		},
+		{
+			name:    "conversion error",
+			pattern: `%{WORD:n:int}`,
+			input:   "abc",
+			wantErr: `failed to convert field "n" to int`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			g, err := compile(tc.pattern, definitions, 0)
+			require.NoError(t, err)
+
+			fields, matched, err := g.match(tc.input)
+			assert.True(t, matched)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, fields)
+		})
+	}
+
+	g, err := compile(`%{IPV4:ip}`, definitions, 0)
+	require.NoError(t, err)
+	_, matched, err := g.match("no address here")
+	assert.NoError(t, err)
+	assert.False(t, matched)
+}
+
+func TestMatchTimeout(t *testing.T) {
+	g, err := compile(`^(\w+\s?)*$`, nil, time.Millisecond)
+	require.NoError(t, err)
+
+	_, matched, err := g.match(strings.Repeat("word ", 30) + "!")
+	assert.False(t, matched)
+	assert.ErrorContains(t, err, "timeout")
+}
+
+func TestCompileErrors(t *testing.T) {
+	definitions := map[string]string{
+		"WORD": `\w+`,
+		"A":    `%{B}`,
+		"B":    `x%{A}`,
+	}
+
+	tests := map[string]struct {
+		pattern string
+		wantErr string
+	}{
+		"unknown pattern":    {pattern: `%{NOPE:x}`, wantErr: `pattern "NOPE" is not defined`},
+		"recursive patterns": {pattern: `%{A}`, wantErr: `pattern "A" is defined recursively`},
+		"unsupported type":   {pattern: `%{WORD:x:date}`, wantErr: `unsupported data type "date"`},
+		"invalid regexp":     {pattern: `%{WORD:x}(`, wantErr: "failed to compile pattern"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := compile(tc.pattern, definitions, 0)
+			assert.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestLoadPatternFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.patterns"), []byte(`
+# Comments and empty lines are ignored.
+POSTFIX_QUEUEID [0-9A-F]{10,11}
+WORD [a-z]+
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.patterns"), []byte("WORD [a-z]{2,}\n"), 0o644))
+
+	definitions, err := loadPatterns([]string{filepath.Join(dir, "*.patterns")})
+	require.NoError(t, err)
+	assert.Equal(t, "[0-9A-F]{10,11}", definitions["POSTFIX_QUEUEID"])
+	assert.Equal(t, "[a-z]{2,}", definitions["WORD"], "later files override earlier definitions")
+	assert.Contains(t, definitions, "IPORHOST")
+
+	_, err = loadPatterns([]string{filepath.Join(dir, "*.missing")})
+	assert.ErrorContains(t, err, "no pattern file matches")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "invalid"), []byte("NAME-ONLY\n"), 0o644))
+	_, err = loadPatterns([]string{filepath.Join(dir, "invalid")})
+	assert.ErrorContains(t, err, "line 1: missing pattern")
+}
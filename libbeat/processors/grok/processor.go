@@ -0,0 +1,240 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grok
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/libbeat/processors/checks"
+	jsprocessor "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor/registry"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+const (
+	procName = "grok"
+	logName  = "processor." + procName
+
+	flagParsingError = "grok_parsing_error"
+)
+
+var errNoMatch = errors.New("no pattern matched")
+
+// instanceID is used to assign each instance a unique monitoring namespace.
+var instanceID atomic.Uint32
+
+// processor defines a grok processor.
+type processor struct {
+	config
+
+	patterns []*grokPattern
+	log      *logp.Logger
+	stats    processorStats
+}
+
+// processorStats contains the metrics fields for the grok processor.
+type processorStats struct {
+	// Success measures the number of events matched by a pattern.
+	Success *monitoring.Int
+	// Failure measures the number of events not matched by any pattern, or
+	// that couldn't be processed.
+	Failure *monitoring.Int
+	// Missing measures the number of events missing the source field.
+	Missing *monitoring.Int
+	// Patterns contains the metrics of each pattern, in order.
+	Patterns []patternStats
+}
+
+// patternStats contains the metrics of a single pattern.
+type patternStats struct {
+	// Matched measures the number of events matched by the pattern.
+	Matched *monitoring.Int
+	// Failed measures the number of events the pattern was tried on
+	// without matching.
+	Failed *monitoring.Int
+}
+
+func init() {
+	processors.RegisterPlugin(procName,
+		checks.ConfigChecked(New,
+			checks.RequireFields("patterns"),
+			checks.AllowedFields(
+				"field", "patterns",
+				"pattern_definitions", "pattern_files",
+				"target_prefix", "overwrite_keys",
+				"timeout", "ignore_missing",
+				"ignore_failure", "tag",
+				"when",
+			),
+		),
+	)
+	jsprocessor.RegisterPlugin("Grok", New)
+}
+
+// New creates a new grok processor from the provided configuration, or an
+// error if the configuration is invalid.
+func New(c *conf.C, log *logp.Logger) (beat.Processor, error) {
+	cfg := defaultConfig()
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("fail to unpack the "+procName+" processor configuration: %w", err)
+	}
+
+	definitions, err := loadPatterns(cfg.PatternFiles)
+	if err != nil {
+		return nil, fmt.Errorf("fail to load the "+procName+" processor patterns: %w", err)
+	}
+	for name, pattern := range cfg.PatternDefinitions {
+		definitions[name] = pattern
+	}
+
+	patterns := make([]*grokPattern, 0, len(cfg.Patterns))
+	for _, pattern := range cfg.Patterns {
+		g, err := compile(pattern, definitions, cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("fail to compile the "+procName+" processor patterns: %w", err)
+		}
+		patterns = append(patterns, g)
+	}
+
+	id := int(instanceID.Add(1))
+	log = log.Named(logName).With("instance_id", id)
+	registryName := logName + "." + strconv.Itoa(id)
+
+	if cfg.Tag != "" {
+		log = log.With("tag", cfg.Tag)
+		registryName = logName + "." + cfg.Tag + "-" + strconv.Itoa(id)
+	}
+	registry := monitoring.Default.NewRegistry(registryName, monitoring.DoNotReport)
+
+	stats := processorStats{
+		Success: monitoring.NewInt(registry, "success"),
+		Failure: monitoring.NewInt(registry, "failure"),
+		Missing: monitoring.NewInt(registry, "missing"),
+	}
+	patternsRegistry := registry.NewRegistry("patterns")
+	for i := range patterns {
+		r := patternsRegistry.NewRegistry(strconv.Itoa(i))
+		stats.Patterns = append(stats.Patterns, patternStats{
+			Matched: monitoring.NewInt(r, "matched"),
+			Failed:  monitoring.NewInt(r, "failed"),
+		})
+	}
+
+	return &processor{
+		config:   cfg,
+		patterns: patterns,
+		log:      log,
+		stats:    stats,
+	}, nil
+}
+
+// Run tries the patterns in order on the configured field, and writes the
+// captures of the first matching pattern to the event. If no pattern
+// matches, the event is flagged with grok_parsing_error.
+func (p *processor) Run(event *beat.Event) (*beat.Event, error) {
+	if err := p.run(event); err != nil && !p.IgnoreFailure {
+		err = fmt.Errorf(procName+" failed to process field %q: %w", p.Field, err)
+		_, _ = event.PutValue("error.message", err.Error())
+		return event, err
+	}
+	return event, nil
+}
+
+func (p *processor) run(event *beat.Event) error {
+	value, err := event.GetValue(p.Field)
+	if err != nil {
+		if errors.Is(err, mapstr.ErrKeyNotFound) {
+			if p.IgnoreMissing {
+				return nil
+			}
+			p.stats.Missing.Inc()
+		}
+		p.stats.Failure.Inc()
+		return err
+	}
+
+	data, ok := value.(string)
+	if !ok {
+		p.stats.Failure.Inc()
+		return fmt.Errorf("type of field %q is not a string", p.Field)
+	}
+
+	for i, pattern := range p.patterns {
+		fields, matched, err := pattern.match(data)
+		if err != nil {
+			p.stats.Failure.Inc()
+			return err
+		}
+		if !matched {
+			p.stats.Patterns[i].Failed.Inc()
+			continue
+		}
+		p.stats.Patterns[i].Matched.Inc()
+
+		if err := p.write(event, fields); err != nil {
+			p.stats.Failure.Inc()
+			return err
+		}
+		p.stats.Success.Inc()
+		return nil
+	}
+
+	p.stats.Failure.Inc()
+	if err := mapstr.AddTagsWithKey(event.Fields, beat.FlagField, []string{flagParsingError}); err != nil {
+		return fmt.Errorf("cannot add new flag the event: %w", err)
+	}
+	return errNoMatch
+}
+
+// write writes the captured fields to the event. No field is written if
+// one of them already exists and keys can't be overwritten.
+func (p *processor) write(event *beat.Event, fields mapstr.M) error {
+	prefix := ""
+	if p.TargetPrefix != "" {
+		prefix = p.TargetPrefix + "."
+	}
+	if !p.OverwriteKeys {
+		for k := range fields {
+			if _, err := event.GetValue(prefix + k); !errors.Is(err, mapstr.ErrKeyNotFound) {
+				return fmt.Errorf("cannot override existing key with `%s`", prefix+k)
+			}
+		}
+	}
+	for k, v := range fields {
+		if _, err := event.PutValue(prefix+k, v); err != nil {
+			return fmt.Errorf("failed to put value into field %q: %w", prefix+k, err)
+		}
+	}
+	return nil
+}
+
+// String will return a string representation of this processor (the configuration).
+func (p *processor) String() string {
+	data, _ := json.Marshal(p.config)
+
+	return procName + "=" + string(data)
+}
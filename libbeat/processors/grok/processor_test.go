@@ -0,0 +1,194 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package grok
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestProcessor(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		input   mapstr.M
+		want    mapstr.M
+		wantErr string
+	}{
+		{
+			name: "first matching pattern",
+			config: map[string]interface{}{
+				"patterns": []string{
+					`%{IP:client.ip} %{WORD:http.request.method} %{URIPATHPARAM:url.original}`,
+					`%{IP:client.ip} %{GREEDYDATA:rest}`,
+				},
+			},
+			input: mapstr.M{"message": "55.3.244.1 GET /index.html?q=1"},
+			want: mapstr.M{
+				"message": "55.3.244.1 GET /index.html?q=1",
+				"client":  mapstr.M{"ip": "55.3.244.1"},
+				"http":    mapstr.M{"request": mapstr.M{"method": "GET"}},
+				"url":     mapstr.M{"original": "/index.html?q=1"},
+			},
+		},
+		{
+			name: "pattern definitions and target prefix",
+			config: map[string]interface{}{
+				"field":         "log",
+				"target_prefix": "postfix",
+				"patterns":      []string{`%{POSTFIX_QUEUEID:queue_id}: %{GREEDYDATA:text}`},
+				"pattern_definitions": map[string]interface{}{
+					"POSTFIX_QUEUEID": "[0-9A-F]{10,11}",
+				},
+			},
+			input: mapstr.M{"log": "BEF25A72965: removed"},
+			want: mapstr.M{
+				"log":     "BEF25A72965: removed",
+				"postfix": mapstr.M{"queue_id": "BEF25A72965", "text": "removed"},
+			},
+		},
+		{
+			name: "overwrite keys",
+			config: map[string]interface{}{
+				"patterns": []string{`%{WORD:level} %{GREEDYDATA:message}`},
+			},
+			input: mapstr.M{"message": "INFO started"},
+			want:  mapstr.M{"message": "started", "level": "INFO"},
+		},
+		{
+			name: "keep existing keys",
+			config: map[string]interface{}{
+				"patterns":       []string{`%{WORD:level} %{GREEDYDATA:message}`},
+				"overwrite_keys": false,
+			},
+			input: mapstr.M{"message": "INFO started"},
+			want: mapstr.M{
+				"message": "INFO started",
+				"error":   mapstr.M{"message": "grok failed to process field \"message\": cannot override existing key with `message`"},
+			},
+			wantErr: "cannot override existing key",
+		},
+		{
+			name: "no match",
+			config: map[string]interface{}{
+				"patterns": []string{`%{IP:ip}`},
+			},
+			input: mapstr.M{"message": "nothing"},
+			want: mapstr.M{
+				"message": "nothing",
+				"log":     mapstr.M{"flags": []string{flagParsingError}},
+				"error":   mapstr.M{"message": "grok failed to process field \"message\": no pattern matched"},
+			},
+			wantErr: "no pattern matched",
+		},
+		{
+			name: "no match ignoring failures",
+			config: map[string]interface{}{
+				"patterns":       []string{`%{IP:ip}`},
+				"ignore_failure": true,
+			},
+			input: mapstr.M{"message": "nothing"},
+			want: mapstr.M{
+				"message": "nothing",
+				"log":     mapstr.M{"flags": []string{flagParsingError}},
+			},
+		},
+		{
+			name: "ignore missing",
+			config: map[string]interface{}{
+				"patterns":       []string{`%{IP:ip}`},
+				"ignore_missing": true,
+			},
+			input: mapstr.M{"other": "1.2.3.4"},
+			want:  mapstr.M{"other": "1.2.3.4"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := New(conf.MustNewConfigFrom(tc.config), logptest.NewTestingLogger(t, ""))
+			require.NoError(t, err)
+
+			out, err := p.Run(&beat.Event{Fields: tc.input.Clone()})
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.want, out.Fields)
+		})
+	}
+}
+
+func TestProcessorMetrics(t *testing.T) {
+	p, err := New(conf.MustNewConfigFrom(map[string]interface{}{
+		"patterns": []string{`^%{INT:n:int}$`, `^%{WORD:w}$`},
+	}), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+
+	for _, message := range []string{"1", "word", "2", "?"} {
+		_, _ = p.Run(&beat.Event{Fields: mapstr.M{"message": message}})
+	}
+	_, _ = p.Run(&beat.Event{Fields: mapstr.M{}})
+
+	stats := p.(*processor).stats
+	assert.Equal(t, int64(3), stats.Success.Get())
+	assert.Equal(t, int64(2), stats.Failure.Get())
+	assert.Equal(t, int64(1), stats.Missing.Get())
+	assert.Equal(t, int64(2), stats.Patterns[0].Matched.Get())
+	assert.Equal(t, int64(2), stats.Patterns[0].Failed.Get())
+	assert.Equal(t, int64(1), stats.Patterns[1].Matched.Get())
+	assert.Equal(t, int64(1), stats.Patterns[1].Failed.Get())
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	tests := map[string]struct {
+		config  map[string]interface{}
+		wantErr string
+	}{
+		"no patterns": {
+			config:  map[string]interface{}{"field": "message"},
+			wantErr: "missing required field",
+		},
+		"unknown pattern": {
+			config:  map[string]interface{}{"patterns": []string{`%{NOPE:x}`}},
+			wantErr: `pattern "NOPE" is not defined`,
+		},
+		"invalid definition name": {
+			config: map[string]interface{}{
+				"patterns":            []string{`%{WORD:x}`},
+				"pattern_definitions": map[string]interface{}{"NOT-VALID": "x"},
+			},
+			wantErr: "pattern names can only contain letters, digits and underscores",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(conf.MustNewConfigFrom(tc.config), logptest.NewTestingLogger(t, ""))
+			assert.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
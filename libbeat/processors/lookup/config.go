@@ -0,0 +1,100 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package lookup
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formats of the lookup tables.
+const (
+	formatCSV    = "csv"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatMMDB   = "mmdb"
+)
+
+// Matching modes of the source field against the keys of the table.
+const (
+	matchExact  = "exact"
+	matchCIDR   = "cidr"
+	matchPrefix = "prefix"
+)
+
+type config struct {
+	Field          string        `config:"field" validate:"required"`
+	TargetField    string        `config:"target_field" validate:"required"`
+	Table          tableConfig   `config:"table"`
+	Match          string        `config:"match"`
+	Fields         []string      `config:"fields"`
+	ReloadInterval time.Duration `config:"reload_interval" validate:"min=0"`
+	OverwriteKeys  bool          `config:"overwrite_keys"`
+	IgnoreMissing  bool          `config:"ignore_missing"`
+	IgnoreFailure  bool          `config:"ignore_failure"`
+	Tag            string        `config:"tag"`
+}
+
+type tableConfig struct {
+	Path      string `config:"path" validate:"required"`
+	Format    string `config:"format"`
+	Key       string `config:"key"`
+	Separator string `config:"separator"`
+}
+
+func defaultConfig() config {
+	return config{
+		Match:          matchExact,
+		ReloadInterval: 30 * time.Second,
+		Table: tableConfig{
+			Separator: ",",
+		},
+	}
+}
+
+func (c *config) Validate() error {
+	if c.Table.Format == "" {
+		c.Table.Format = strings.TrimPrefix(strings.ToLower(filepath.Ext(c.Table.Path)), ".")
+	}
+	switch c.Table.Format {
+	case formatCSV, formatJSON, formatNDJSON:
+		if c.Table.Key == "" {
+			return fmt.Errorf("table.key is required for %s tables", c.Table.Format)
+		}
+	case formatMMDB:
+		if c.Match != matchExact && c.Match != matchCIDR {
+			return fmt.Errorf("%s tables only support %s matching", formatMMDB, matchCIDR)
+		}
+		c.Match = matchCIDR
+	default:
+		return fmt.Errorf("unsupported table format %q, must be %s, %s, %s or %s",
+			c.Table.Format, formatCSV, formatJSON, formatNDJSON, formatMMDB)
+	}
+
+	switch c.Match {
+	case matchExact, matchCIDR, matchPrefix:
+	default:
+		return fmt.Errorf("unsupported match %q, must be %s, %s or %s", c.Match, matchExact, matchCIDR, matchPrefix)
+	}
+	if len([]rune(c.Table.Separator)) != 1 {
+		return fmt.Errorf("table.separator must be a single character, got %q", c.Table.Separator)
+	}
+	return nil
+}
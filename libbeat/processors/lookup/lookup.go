@@ -0,0 +1,278 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package lookup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/libbeat/processors/checks"
+	jsprocessor "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor/registry"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+const (
+	procName = "lookup"
+	logName  = "processor." + procName
+)
+
+// instanceID is used to assign each instance a unique monitoring namespace.
+var instanceID atomic.Uint32
+
+// processor enriches the events with the records of a lookup table
+// matching the value of a field.
+type processor struct {
+	config
+
+	table atomic.Pointer[table]
+	// modTime and size identify the version of the loaded table file.
+	modTime time.Time
+	size    int64
+
+	log   *logp.Logger
+	stats processorStats
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// processorStats contains the metrics fields for the lookup processor.
+type processorStats struct {
+	// Hits measures the number of events enriched with a record.
+	Hits *monitoring.Int
+	// Misses measures the number of events without a matching record.
+	Misses *monitoring.Int
+	// Failure measures the number of events that couldn't be processed.
+	Failure *monitoring.Int
+	// Reloads measures the number of reloads of the table.
+	Reloads *monitoring.Int
+	// ReloadFailures measures the number of failed reloads of the table.
+	ReloadFailures *monitoring.Int
+	// Records is the number of records of the loaded table.
+	Records *monitoring.Int
+}
+
+func init() {
+	processors.RegisterPlugin(procName,
+		checks.ConfigChecked(New,
+			checks.RequireFields("field", "target_field", "table"),
+			checks.AllowedFields(
+				"field", "target_field", "table", "match", "fields",
+				"reload_interval", "overwrite_keys",
+				"ignore_missing", "ignore_failure",
+				"tag", "when",
+			),
+		),
+	)
+	jsprocessor.RegisterPlugin("Lookup", New)
+}
+
+// New creates a new lookup processor from the provided configuration, or
+// an error if the configuration is invalid or the table can't be loaded.
+func New(c *conf.C, log *logp.Logger) (beat.Processor, error) {
+	cfg := defaultConfig()
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("fail to unpack the "+procName+" processor configuration: %w", err)
+	}
+
+	id := int(instanceID.Add(1))
+	log = log.Named(logName).With("instance_id", id)
+	registryName := logName + "." + strconv.Itoa(id)
+
+	if cfg.Tag != "" {
+		log = log.With("tag", cfg.Tag)
+		registryName = logName + "." + cfg.Tag + "-" + strconv.Itoa(id)
+	}
+
+	p := &processor{
+		config: cfg,
+		log:    log,
+	}
+	if err := p.load(); err != nil {
+		return nil, fmt.Errorf("failed to load the %s table %q: %w", procName, cfg.Table.Path, err)
+	}
+
+	registry := monitoring.Default.NewRegistry(registryName, monitoring.DoNotReport)
+	p.stats = processorStats{
+		Hits:           monitoring.NewInt(registry, "hits"),
+		Misses:         monitoring.NewInt(registry, "misses"),
+		Failure:        monitoring.NewInt(registry, "failure"),
+		Reloads:        monitoring.NewInt(registry, "reloads"),
+		ReloadFailures: monitoring.NewInt(registry, "reload_failures"),
+		Records:        monitoring.NewInt(registry, "records"),
+	}
+	p.stats.Records.Set(int64((*p.table.Load()).len()))
+
+	if cfg.ReloadInterval > 0 {
+		p.done = make(chan struct{})
+		p.wg.Add(1)
+		go p.watch()
+	}
+	return p, nil
+}
+
+// load reads the table file and replaces the current table.
+func (p *processor) load() error {
+	info, err := os.Stat(p.Table.Path)
+	if err != nil {
+		return err
+	}
+	t, err := loadTable(p.Table, p.Match)
+	if err != nil {
+		return err
+	}
+	p.modTime, p.size = info.ModTime(), info.Size()
+	p.table.Store(&t)
+	return nil
+}
+
+// watch reloads the table when the file changes, until the processor is
+// closed.
+func (p *processor) watch() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.reload()
+		}
+	}
+}
+
+// reload reloads the table if the file changed. The current table is kept
+// when the new one can't be loaded.
+func (p *processor) reload() {
+	info, err := os.Stat(p.Table.Path)
+	if err != nil {
+		p.stats.ReloadFailures.Inc()
+		p.log.Errorw("Failed to check the lookup table, keeping the current one.", "path", p.Table.Path, "error", err)
+		return
+	}
+	if info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return
+	}
+
+	if err := p.load(); err != nil {
+		p.stats.ReloadFailures.Inc()
+		p.log.Errorw("Failed to reload the lookup table, keeping the current one.", "path", p.Table.Path, "error", err)
+		return
+	}
+	t := *p.table.Load()
+	p.stats.Reloads.Inc()
+	p.stats.Records.Set(int64(t.len()))
+	p.log.Debugw("Reloaded the lookup table.", "path", p.Table.Path, "records", t.len())
+}
+
+// Run adds the record matching the value of the source field to the event.
+func (p *processor) Run(event *beat.Event) (*beat.Event, error) {
+	if err := p.enrich(event); err != nil {
+		p.stats.Failure.Inc()
+		if !p.IgnoreFailure {
+			err = fmt.Errorf(procName+": %w", err)
+			_, _ = event.PutValue("error.message", err.Error())
+			return event, err
+		}
+	}
+	return event, nil
+}
+
+func (p *processor) enrich(event *beat.Event) error {
+	value, err := event.GetValue(p.Field)
+	if err != nil {
+		if p.IgnoreMissing && errors.Is(err, mapstr.ErrKeyNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get field %q: %w", p.Field, err)
+	}
+
+	record, found, err := (*p.table.Load()).lookup(toString(value))
+	if err != nil {
+		return fmt.Errorf("failed to look up field %q: %w", p.Field, err)
+	}
+	if !found {
+		p.stats.Misses.Inc()
+		return nil
+	}
+	p.stats.Hits.Inc()
+
+	enrichment := p.selectFields(record)
+	if len(enrichment) == 0 {
+		return nil
+	}
+	if !p.OverwriteKeys {
+		if _, err := event.GetValue(p.TargetField); err == nil {
+			return fmt.Errorf("target field %q already exists and overwrite_keys is false", p.TargetField)
+		}
+	}
+	_, err = event.PutValue(p.TargetField, enrichment)
+	return err
+}
+
+// selectFields returns a copy of the configured fields of the record, or of
+// the whole record when no fields are configured.
+func (p *processor) selectFields(record mapstr.M) mapstr.M {
+	if len(p.Fields) == 0 {
+		return record.Clone()
+	}
+	out := mapstr.M{}
+	for _, field := range p.Fields {
+		v, err := record.GetValue(field)
+		if err != nil {
+			continue
+		}
+		if m, ok := v.(mapstr.M); ok {
+			v = m.Clone()
+		}
+		_, _ = out.Put(field, v)
+	}
+	return out
+}
+
+// Close stops the reloading of the table.
+func (p *processor) Close() error {
+	p.closeOnce.Do(func() {
+		if p.done != nil {
+			close(p.done)
+			p.wg.Wait()
+		}
+	})
+	return nil
+}
+
+func (p *processor) String() string {
+	data, _ := json.Marshal(p.config)
+
+	return procName + "=" + string(data)
+}
@@ -0,0 +1,319 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package lookup
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const hostsCSV = `ip,hostname,owner.team
+10.0.0.1,web-1,frontend
+10.0.0.2,db-1,storage
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func newProcessor(t *testing.T, cfg mapstr.M) *processor {
+	t.Helper()
+	p, err := New(conf.MustNewConfigFrom(cfg), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	t.Cleanup(func() { p.(*processor).Close() })
+	return p.(*processor)
+}
+
+func run(t *testing.T, p *processor, fields mapstr.M) mapstr.M {
+	t.Helper()
+	event, err := p.Run(&beat.Event{Fields: fields})
+	require.NoError(t, err)
+	return event.Fields
+}
+
+func TestLookupCSV(t *testing.T) {
+	p := newProcessor(t, mapstr.M{
+		"field":        "source.ip",
+		"target_field": "source.host",
+		"table":        mapstr.M{"path": writeFile(t, "hosts.csv", hostsCSV), "key": "ip"},
+	})
+
+	fields := run(t, p, mapstr.M{"source": mapstr.M{"ip": "10.0.0.2"}})
+	assert.Equal(t, mapstr.M{
+		"source": mapstr.M{
+			"ip":   "10.0.0.2",
+			"host": mapstr.M{"ip": "10.0.0.2", "hostname": "db-1", "owner.team": "storage"},
+		},
+	}, fields)
+
+	fields = run(t, p, mapstr.M{"source": mapstr.M{"ip": "10.0.0.3"}})
+	assert.Equal(t, mapstr.M{"source": mapstr.M{"ip": "10.0.0.3"}}, fields)
+
+	assert.Equal(t, int64(1), p.stats.Hits.Get())
+	assert.Equal(t, int64(1), p.stats.Misses.Get())
+	assert.Equal(t, int64(2), p.stats.Records.Get())
+}
+
+func TestLookupJSON(t *testing.T) {
+	tests := map[string]struct {
+		file, content string
+	}{
+		"array": {
+			file:    "users.json",
+			content: `[{"id": "u1", "name": "Alice", "roles": ["admin"], "quota": {"gb": 10}}]`,
+		},
+		"object": {
+			file:    "users.json",
+			content: `{"u1": {"name": "Alice", "roles": ["admin"], "quota": {"gb": 10}}}`,
+		},
+		"ndjson": {
+			file:    "users.ndjson",
+			content: "{\"id\": \"u1\", \"name\": \"Alice\", \"roles\": [\"admin\"], \"quota\": {\"gb\": 10}}\n\n",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			p := newProcessor(t, mapstr.M{
+				"field":        "user.id",
+				"target_field": "user.details",
+				"table":        mapstr.M{"path": writeFile(t, tc.file, tc.content), "key": "id"},
+				"fields":       []string{"name", "roles", "quota.gb"},
+			})
+			fields := run(t, p, mapstr.M{"user": mapstr.M{"id": "u1"}})
+			assert.Equal(t, mapstr.M{
+				"name":  "Alice",
+				"roles": []interface{}{"admin"},
+				"quota": mapstr.M{"gb": int64(10)},
+			}, fields["user"].(mapstr.M)["details"])
+		})
+	}
+}
+
+func TestLookupCIDR(t *testing.T) {
+	p := newProcessor(t, mapstr.M{
+		"field":        "source.ip",
+		"target_field": "network",
+		"match":        "cidr",
+		"table": mapstr.M{
+			"path": writeFile(t, "networks.csv", "cidr;zone\n10.0.0.0/8;internal\n10.1.0.0/16;lab\n10.1.2.3;printer\n2001:db8::/32;v6\n"),
+			"key":  "cidr", "separator": ";",
+		},
+		"fields": []string{"zone"},
+	})
+
+	tests := map[string]interface{}{
+		"10.9.9.9":    "internal",
+		"10.1.9.9":    "lab",
+		"10.1.2.3":    "printer",
+		"2001:db8::1": "v6",
+		"192.0.2.1":   nil,
+	}
+	for ip, zone := range tests {
+		fields := run(t, p, mapstr.M{"source": mapstr.M{"ip": ip}})
+		got, _ := fields.GetValue("network.zone")
+		assert.Equal(t, zone, got, ip)
+	}
+
+	_, err := p.Run(&beat.Event{Fields: mapstr.M{"source": mapstr.M{"ip": "not-an-ip"}}})
+	assert.Error(t, err)
+}
+
+func TestLookupPrefix(t *testing.T) {
+	p := newProcessor(t, mapstr.M{
+		"field":        "phone",
+		"target_field": "country",
+		"match":        "prefix",
+		"table": mapstr.M{
+			"path": writeFile(t, "codes.csv", "code,name\n+1,US\n+44,UK\n+4420,London\n"),
+			"key":  "code",
+		},
+		"fields": []string{"name"},
+	})
+
+	tests := map[string]interface{}{
+		"+15550100":    "US",
+		"+441632960":   "UK",
+		"+442079460":   "London",
+		"+33123456789": nil,
+	}
+	for phone, name := range tests {
+		fields := run(t, p, mapstr.M{"phone": phone})
+		got, _ := fields.GetValue("country.name")
+		assert.Equal(t, name, got, phone)
+	}
+}
+
+func TestLookupFailures(t *testing.T) {
+	cfg := mapstr.M{
+		"field":        "source.ip",
+		"target_field": "source.host",
+		"table":        mapstr.M{"path": writeFile(t, "hosts.csv", hostsCSV), "key": "ip"},
+	}
+
+	t.Run("missing field", func(t *testing.T) {
+		p := newProcessor(t, cfg)
+		event, err := p.Run(&beat.Event{Fields: mapstr.M{}})
+		require.Error(t, err)
+		msg, _ := event.GetValue("error.message")
+		assert.Contains(t, msg, `failed to get field "source.ip"`)
+
+		cfg := cfg.Clone()
+		cfg["ignore_missing"] = true
+		p = newProcessor(t, cfg)
+		fields := run(t, p, mapstr.M{})
+		assert.Empty(t, fields)
+	})
+
+	t.Run("existing target", func(t *testing.T) {
+		p := newProcessor(t, cfg)
+		event := &beat.Event{Fields: mapstr.M{"source": mapstr.M{"ip": "10.0.0.1", "host": "x"}}}
+		_, err := p.Run(event)
+		assert.ErrorContains(t, err, "already exists")
+
+		cfg := cfg.Clone()
+		cfg["overwrite_keys"] = true
+		p = newProcessor(t, cfg)
+		fields := run(t, p, mapstr.M{"source": mapstr.M{"ip": "10.0.0.1", "host": "x"}})
+		name, _ := fields.GetValue("source.host.hostname")
+		assert.Equal(t, "web-1", name)
+	})
+
+	t.Run("invalid table", func(t *testing.T) {
+		for _, table := range []mapstr.M{
+			{"path": filepath.Join(t.TempDir(), "missing.csv"), "key": "ip"},
+			{"path": writeFile(t, "hosts.csv", hostsCSV)},
+			{"path": writeFile(t, "hosts.txt", hostsCSV), "key": "ip"},
+			{"path": writeFile(t, "hosts.json", "[1, 2]"), "key": "ip"},
+		} {
+			_, err := New(conf.MustNewConfigFrom(mapstr.M{
+				"field": "source.ip", "target_field": "source.host", "table": table,
+			}), logptest.NewTestingLogger(t, ""))
+			assert.Error(t, err, table)
+		}
+	})
+}
+
+func TestReload(t *testing.T) {
+	path := writeFile(t, "hosts.csv", hostsCSV)
+	p := newProcessor(t, mapstr.M{
+		"field":           "source.ip",
+		"target_field":    "source.host",
+		"table":           mapstr.M{"path": path, "key": "ip"},
+		"fields":          []string{"hostname"},
+		"reload_interval": 0,
+	})
+	assert.Nil(t, p.done)
+
+	hostname := func() interface{} {
+		fields := run(t, p, mapstr.M{"source": mapstr.M{"ip": "10.0.0.3"}})
+		v, _ := fields.GetValue("source.host.hostname")
+		return v
+	}
+	assert.Nil(t, hostname())
+
+	p.reload()
+	assert.Equal(t, int64(0), p.stats.Reloads.Get())
+
+	require.NoError(t, os.WriteFile(path, []byte(hostsCSV+"10.0.0.3,cache-1,storage\n"), 0o600))
+	p.reload()
+	assert.Equal(t, int64(1), p.stats.Reloads.Get())
+	assert.Equal(t, int64(3), p.stats.Records.Get())
+	assert.Equal(t, "cache-1", hostname())
+
+	// A broken table keeps the current one.
+	require.NoError(t, os.WriteFile(path, []byte("ip,hostname\n\"10.0.0.3,cache-2\n"), 0o600))
+	p.reload()
+	assert.Equal(t, int64(1), p.stats.ReloadFailures.Get())
+	assert.Equal(t, "cache-1", hostname())
+}
+
+func TestLookupMMDB(t *testing.T) {
+	p := newProcessor(t, mapstr.M{
+		"field":        "source.ip",
+		"target_field": "source.network",
+		"table":        mapstr.M{"path": writeFile(t, "networks.mmdb", string(buildMMDB()))},
+	})
+	assert.Equal(t, matchCIDR, p.Match)
+
+	fields := run(t, p, mapstr.M{"source": mapstr.M{"ip": "10.1.2.200"}})
+	zone, _ := fields.GetValue("source.network.zone")
+	assert.Equal(t, "lab", zone)
+
+	fields = run(t, p, mapstr.M{"source": mapstr.M{"ip": "10.1.3.1"}})
+	_, err := fields.GetValue("source.network")
+	assert.Error(t, err)
+}
+
+// buildMMDB builds an IPv4 MaxMind DB holding {"zone": "lab"} for
+// 10.1.2.0/24.
+func buildMMDB() []byte {
+	const nodeCount = 24
+	network := binary.BigEndian.Uint32([]byte{10, 1, 2, 0})
+
+	var db []byte
+	putRecord := func(v uint32) {
+		db = append(db, byte(v>>16), byte(v>>8), byte(v))
+	}
+	for i := 0; i < nodeCount; i++ {
+		next := uint32(i + 1)
+		if i == nodeCount-1 {
+			// Pointer to the first record of the data section.
+			next = nodeCount + 16
+		}
+		if network&(1<<(31-i)) == 0 {
+			putRecord(next)
+			putRecord(nodeCount)
+		} else {
+			putRecord(nodeCount)
+			putRecord(next)
+		}
+	}
+	db = append(db, make([]byte, 16)...)
+
+	str := func(s string) []byte { return append([]byte{0x40 | byte(len(s))}, s...) }
+	db = append(db, 0xE1)
+	db = append(db, str("zone")...)
+	db = append(db, str("lab")...)
+
+	db = append(db, "\xAB\xCD\xEFMaxMind.com"...)
+	db = append(db, 0xE5)
+	db = append(db, str("node_count")...)
+	db = append(db, 0xC1, nodeCount)
+	db = append(db, str("record_size")...)
+	db = append(db, 0xA1, 24)
+	db = append(db, str("ip_version")...)
+	db = append(db, 0xA1, 4)
+	db = append(db, str("binary_format_major_version")...)
+	db = append(db, 0xA1, 2)
+	db = append(db, str("database_type")...)
+	db = append(db, str("test")...)
+	return db
+}
@@ -0,0 +1,359 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package lookup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// table finds the record matching a value.
+type table interface {
+	lookup(value string) (mapstr.M, bool, error)
+	len() int
+}
+
+// loadTable reads a table file.
+func loadTable(cfg tableConfig, match string) (table, error) {
+	data, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Format == formatMMDB {
+		r, err := maxminddb.FromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MMDB file: %w", err)
+		}
+		return &mmdbTable{reader: r}, nil
+	}
+
+	var records []mapstr.M
+	switch cfg.Format {
+	case formatCSV:
+		records, err = readCSV(data, []rune(cfg.Separator)[0])
+	case formatJSON:
+		records, err = readJSON(data, cfg.Key)
+	case formatNDJSON:
+		records, err = readNDJSON(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var t interface {
+		table
+		add(key string, record mapstr.M) error
+	}
+	switch match {
+	case matchCIDR:
+		t = newCIDRTable()
+	case matchPrefix:
+		t = newPrefixTable()
+	default:
+		t = exactTable{}
+	}
+	for i, record := range records {
+		v, err := record.GetValue(cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("record %d has no key %q", i+1, cfg.Key)
+		}
+		if err := t.add(toString(v), record); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+	}
+	return t, nil
+}
+
+// readCSV reads the records of a CSV file, whose first row holds the
+// column names.
+func readCSV(data []byte, separator rune) ([]mapstr.M, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = separator
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV file: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	header := rows[0]
+	records := make([]mapstr.M, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := mapstr.M{}
+		for i, value := range row {
+			if value != "" {
+				record[header[i]] = value
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// readJSON reads a JSON array of records, or a JSON object of records by
+// key. In the latter case, the keys are added to the records.
+func readJSON(data []byte, key string) ([]mapstr.M, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("failed to read JSON file: %w", err)
+	}
+
+	var records []mapstr.M
+	switch v := v.(type) {
+	case []interface{}:
+		for i, elem := range v {
+			m, ok := elem.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("element %d of the JSON array is not an object", i+1)
+			}
+			records = append(records, toMapStr(m))
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			m, ok := v[k].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("value of %q in the JSON object is not an object", k)
+			}
+			record := toMapStr(m)
+			_, _ = record.Put(key, k)
+			records = append(records, record)
+		}
+	default:
+		return nil, errors.New("JSON file must hold an array or an object")
+	}
+	return records, nil
+}
+
+// readNDJSON reads a JSON object per line. Empty lines are ignored.
+func readNDJSON(data []byte) ([]mapstr.M, error) {
+	var records []mapstr.M
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var m map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.UseNumber()
+		if err := dec.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read line %d: %w", line, err)
+		}
+		records = append(records, toMapStr(m))
+	}
+	return records, scanner.Err()
+}
+
+// exactTable matches the values equal to the keys.
+type exactTable map[string]mapstr.M
+
+func (t exactTable) add(key string, record mapstr.M) error {
+	t[key] = record
+	return nil
+}
+
+func (t exactTable) lookup(value string) (mapstr.M, bool, error) {
+	record, ok := t[value]
+	return record, ok, nil
+}
+
+func (t exactTable) len() int { return len(t) }
+
+// cidrTable matches the IP addresses against the networks of the keys. The
+// most specific network wins.
+type cidrTable struct {
+	networks map[netip.Prefix]mapstr.M
+	// bits are the distinct prefix lengths of the networks, longest first.
+	bits []int
+}
+
+func newCIDRTable() *cidrTable {
+	return &cidrTable{networks: map[netip.Prefix]mapstr.M{}}
+}
+
+func (t *cidrTable) add(key string, record mapstr.M) error {
+	var prefix netip.Prefix
+	if strings.Contains(key, "/") {
+		p, err := netip.ParsePrefix(key)
+		if err != nil {
+			return err
+		}
+		prefix = p.Masked()
+	} else {
+		addr, err := netip.ParseAddr(key)
+		if err != nil {
+			return err
+		}
+		prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+	}
+
+	if _, ok := t.networks[prefix]; !ok {
+		i := sort.Search(len(t.bits), func(i int) bool { return t.bits[i] <= prefix.Bits() })
+		if i == len(t.bits) || t.bits[i] != prefix.Bits() {
+			t.bits = append(t.bits, 0)
+			copy(t.bits[i+1:], t.bits[i:])
+			t.bits[i] = prefix.Bits()
+		}
+	}
+	t.networks[prefix] = record
+	return nil
+}
+
+func (t *cidrTable) lookup(value string) (mapstr.M, bool, error) {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return nil, false, err
+	}
+	addr = addr.Unmap()
+	for _, bits := range t.bits {
+		if bits > addr.BitLen() {
+			continue
+		}
+		prefix, _ := addr.Prefix(bits)
+		if record, ok := t.networks[prefix]; ok {
+			return record, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (t *cidrTable) len() int { return len(t.networks) }
+
+// prefixTable matches the values starting with the keys. The longest key
+// wins.
+type prefixTable struct {
+	keys map[string]mapstr.M
+	// lengths are the distinct lengths of the keys, longest first.
+	lengths []int
+}
+
+func newPrefixTable() *prefixTable {
+	return &prefixTable{keys: map[string]mapstr.M{}}
+}
+
+func (t *prefixTable) add(key string, record mapstr.M) error {
+	if _, ok := t.keys[key]; !ok {
+		i := sort.Search(len(t.lengths), func(i int) bool { return t.lengths[i] <= len(key) })
+		if i == len(t.lengths) || t.lengths[i] != len(key) {
+			t.lengths = append(t.lengths, 0)
+			copy(t.lengths[i+1:], t.lengths[i:])
+			t.lengths[i] = len(key)
+		}
+	}
+	t.keys[key] = record
+	return nil
+}
+
+func (t *prefixTable) lookup(value string) (mapstr.M, bool, error) {
+	for _, n := range t.lengths {
+		if n > len(value) {
+			continue
+		}
+		if record, ok := t.keys[value[:n]]; ok {
+			return record, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (t *prefixTable) len() int { return len(t.keys) }
+
+// mmdbTable looks up IP addresses in a MaxMind DB file.
+type mmdbTable struct {
+	reader *maxminddb.Reader
+}
+
+func (t *mmdbTable) lookup(value string) (mapstr.M, bool, error) {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, false, fmt.Errorf("invalid IP address %q", value)
+	}
+	var record map[string]interface{}
+	_, ok, err := t.reader.LookupNetwork(ip, &record)
+	if err != nil || !ok || record == nil {
+		return nil, false, err
+	}
+	return toMapStr(record), true, nil
+}
+
+func (t *mmdbTable) len() int { return int(t.reader.Metadata.NodeCount) }
+
+// toMapStr converts the nested maps of a decoded record.
+func toMapStr(m map[string]interface{}) mapstr.M {
+	out := make(mapstr.M, len(m))
+	for k, v := range m {
+		out[k] = convert(v)
+	}
+	return out
+}
+
+func convert(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return toMapStr(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			out[i] = convert(elem)
+		}
+		return out
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case net.IP:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
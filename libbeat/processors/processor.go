@@ -52,6 +52,37 @@ func Close(p beat.Processor) error {
 	return nil
 }
 
+// Emitter defines the interface for processors that publish events of their
+// own, outside of the Run call, e.g. summaries computed over a time window.
+// Emitted events are passed through the processors following the emitter
+// before being published by the pipeline client owning the processor.
+type Emitter interface {
+	// StartEmitting is called once the processor is connected to a client.
+	// emit is safe to call from any goroutine.
+	StartEmitting(emit func(beat.Event))
+
+	// StopEmitting is called before the client is closed. The processor
+	// must publish its pending events and must not call emit after
+	// StopEmitting returns.
+	StopEmitting()
+}
+
+// StartEmitting calls StartEmitting on a processor if it implements the
+// Emitter interface.
+func StartEmitting(p beat.Processor, emit func(beat.Event)) {
+	if emitter, ok := p.(Emitter); ok {
+		emitter.StartEmitting(emit)
+	}
+}
+
+// StopEmitting calls StopEmitting on a processor if it implements the
+// Emitter interface.
+func StopEmitting(p beat.Processor) {
+	if emitter, ok := p.(Emitter); ok {
+		emitter.StopEmitting()
+	}
+}
+
 // NewList creates a new empty processor list.
 // Additional processors can be added to the List field.
 func NewList(log *logp.Logger) *Processors {
@@ -160,6 +191,33 @@ func (procs *Processors) Close() error {
 	return errors.Join(errs...)
 }
 
+// StartEmitting starts all emitting processors in the list. Events emitted by
+// a processor are run through the processors following it before being
+// passed to emit.
+func (procs *Processors) StartEmitting(emit func(beat.Event)) {
+	for i, p := range procs.List {
+		rest := procs.List[i+1:]
+		StartEmitting(p, func(event beat.Event) {
+			out, err := (&Processors{List: rest, log: procs.log}).Run(&event)
+			if err != nil {
+				procs.log.Errorf("failed to process emitted event: %v", err)
+			}
+			if out != nil {
+				emit(*out)
+			}
+		})
+	}
+}
+
+// StopEmitting stops all emitting processors in the list, in order, so
+// pending events of a processor can still be picked up by the processors
+// following it.
+func (procs *Processors) StopEmitting() {
+	for _, p := range procs.List {
+		StopEmitting(p)
+	}
+}
+
 // Run executes the all processors serially and returns the event and possibly
 // an error. If the event has been dropped (canceled) by a processor in the
 // list then a nil event is returned.
@@ -26,6 +26,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/conditions"
 	"github.com/elastic/beats/v7/libbeat/processors"
 	_ "github.com/elastic/beats/v7/libbeat/processors/actions"
 	_ "github.com/elastic/beats/v7/libbeat/processors/add_cloud_metadata"
@@ -788,3 +789,46 @@ func generateFields(t require.TestingT, m mapstr.M, count, nesting int) {
 		require.NoError(t, err)
 	}
 }
+
+// emitter is a processor publishing the events passed to its emit function.
+type emitter struct {
+	emit    func(beat.Event)
+	stopped bool
+}
+
+func (p *emitter) String() string                             { return "emitter" }
+func (p *emitter) Run(event *beat.Event) (*beat.Event, error) { return event, nil }
+func (p *emitter) StartEmitting(emit func(beat.Event))        { p.emit = emit }
+func (p *emitter) StopEmitting()                              { p.stopped = true }
+
+func TestEmittingProcessors(t *testing.T) {
+	e := &emitter{}
+	when, err := processors.NewConditionRule(conditions.Config{
+		HasFields: []string{"message"},
+	}, e)
+	require.NoError(t, err)
+
+	list := processors.NewList(logptest.NewTestingLogger(t, ""))
+	list.AddProcessor(when)
+	list.AddProcessors(*GetProcessors(t, []map[string]interface{}{
+		{
+			"add_fields": map[string]interface{}{
+				"target": "",
+				"fields": map[string]interface{}{"after": true},
+			},
+		},
+	}))
+
+	var emitted []beat.Event
+	list.StartEmitting(func(event beat.Event) {
+		emitted = append(emitted, event)
+	})
+	require.NotNil(t, e.emit, "emit must be forwarded through the condition")
+
+	e.emit(beat.Event{Fields: mapstr.M{"message": "summary"}})
+	require.Len(t, emitted, 1)
+	assert.Equal(t, mapstr.M{"message": "summary", "after": true}, emitted[0].Fields)
+
+	list.StopEmitting()
+	assert.True(t, e.stopped)
+}
@@ -0,0 +1,102 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package protect_fields
+
+import (
+	"encoding/base64"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Methods applied to the fields.
+const (
+	methodHMAC    = "hmac"
+	methodMask    = "mask"
+	methodEncrypt = "encrypt"
+)
+
+type config struct {
+	Fields        []fieldConfig `config:"fields" validate:"required"`
+	HMACKey       string        `config:"hmac_key"`
+	HMACHash      string        `config:"hmac_hash"`
+	EncryptionKey string        `config:"encryption_key"`
+	Mask          maskConfig    `config:"mask"`
+	IgnoreMissing bool          `config:"ignore_missing"`
+	IgnoreFailure bool          `config:"ignore_failure"`
+}
+
+type fieldConfig struct {
+	Field       string `config:"field" validate:"required"`
+	Method      string `config:"method" validate:"required"`
+	TargetField string `config:"target_field"`
+}
+
+type maskConfig struct {
+	IPv4Prefix int    `config:"ipv4_prefix" validate:"min=0,max=32"`
+	IPv6Prefix int    `config:"ipv6_prefix" validate:"min=0,max=128"`
+	KeepPrefix int    `config:"keep_prefix" validate:"min=0"`
+	KeepSuffix int    `config:"keep_suffix" validate:"min=0"`
+	Char       string `config:"char"`
+}
+
+func defaultConfig() config {
+	return config{
+		HMACHash: "sha256",
+		Mask: maskConfig{
+			IPv4Prefix: 24,
+			IPv6Prefix: 64,
+			KeepPrefix: 1,
+			Char:       "*",
+		},
+	}
+}
+
+func (c *config) Validate() error {
+	for _, f := range c.Fields {
+		switch f.Method {
+		case methodHMAC:
+			if c.HMACKey == "" {
+				return fmt.Errorf("hmac_key is required to apply %s to field %q", methodHMAC, f.Field)
+			}
+		case methodEncrypt:
+			if c.EncryptionKey == "" {
+				return fmt.Errorf("encryption_key is required to apply %s to field %q", methodEncrypt, f.Field)
+			}
+		case methodMask:
+		default:
+			return fmt.Errorf("unsupported method %q for field %q, must be %s, %s or %s",
+				f.Method, f.Field, methodHMAC, methodMask, methodEncrypt)
+		}
+	}
+	if _, ok := hmacHashes[c.HMACHash]; !ok {
+		return fmt.Errorf("unsupported hmac_hash %q", c.HMACHash)
+	}
+	if c.EncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(c.EncryptionKey)
+		if err != nil {
+			return fmt.Errorf("encryption_key must be base64 encoded: %w", err)
+		}
+		if err := checkKeySize(key); err != nil {
+			return err
+		}
+	}
+	if utf8.RuneCountInString(c.Mask.Char) != 1 {
+		return fmt.Errorf("mask.char must be a single character, got %q", c.Mask.Char)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package protect_fields
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// EncryptedPrefix starts the values encrypted by the processor. The
+// encrypted values are formatted as aesgcm:<key ID>:<base64 data>, where
+// the data is the nonce followed by the sealed JSON encoding of the value.
+const EncryptedPrefix = "aesgcm:"
+
+var hmacHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// ErrUnknownKey is returned when a value was encrypted with a key that
+// wasn't provided.
+var ErrUnknownKey = errors.New("value encrypted with an unknown key")
+
+func checkKeySize(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("encryption key must be 16, 24 or 32 bytes long, got %d", len(key))
+	}
+}
+
+// KeyID identifies an encryption key in the encrypted values, without
+// revealing it.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// encrypter encrypts values with AES-GCM.
+type encrypter struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func newEncrypter(key []byte) (*encrypter, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encrypter{id: KeyID(key), aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if err := checkKeySize(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *encrypter) encrypt(value interface{}) (string, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, e.aead.NonceSize(), e.aead.NonceSize()+len(plaintext)+e.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := e.aead.Seal(nonce, nonce, plaintext, nil)
+	return EncryptedPrefix + e.id + ":" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Decrypter decrypts the values encrypted by the processor.
+type Decrypter struct {
+	keys map[string]cipher.AEAD
+}
+
+// NewDecrypter creates a Decrypter for the values encrypted with one of
+// the keys.
+func NewDecrypter(keys ...[]byte) (*Decrypter, error) {
+	d := &Decrypter{keys: map[string]cipher.AEAD{}}
+	for _, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		d.keys[KeyID(key)] = aead
+	}
+	return d, nil
+}
+
+// Decrypt returns the original value of an encrypted value.
+func (d *Decrypter) Decrypt(s string) (interface{}, error) {
+	parts := strings.SplitN(strings.TrimPrefix(s, EncryptedPrefix), ":", 2)
+	if !strings.HasPrefix(s, EncryptedPrefix) || len(parts) != 2 {
+		return nil, errors.New("not an encrypted value")
+	}
+	aead, ok := d.keys[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, parts[0])
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("invalid encrypted value: too short")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(plaintext))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to decode decrypted value: %w", err)
+	}
+	return value, nil
+}
+
+// DecryptAll replaces the encrypted values found in m, at any depth, with
+// their original value. It returns the number of values decrypted. Values
+// that fail to decrypt are left unchanged and their errors are returned.
+func (d *Decrypter) DecryptAll(m map[string]interface{}) (int, error) {
+	var (
+		count int
+		errs  []error
+	)
+	var walk func(v interface{}) interface{}
+	walk = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case string:
+			if !strings.HasPrefix(v, EncryptedPrefix) {
+				return v
+			}
+			value, err := d.Decrypt(v)
+			if err != nil {
+				errs = append(errs, err)
+				return v
+			}
+			count++
+			return value
+		case map[string]interface{}:
+			for k, child := range v {
+				v[k] = walk(child)
+			}
+		case []interface{}:
+			for i, child := range v {
+				v[i] = walk(child)
+			}
+		}
+		return v
+	}
+	walk(m)
+	return count, errors.Join(errs...)
+}
+
+// hmacer pseudonymizes values with a keyed hash.
+type hmacer struct {
+	key  []byte
+	hash func() hash.Hash
+}
+
+func (h *hmacer) sum(value interface{}) (string, error) {
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return "", err
+		}
+	}
+	mac := hmac.New(h.hash, h.key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
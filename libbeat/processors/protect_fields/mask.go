@@ -0,0 +1,96 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package protect_fields
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// mask hides part of a value while keeping its format. IP addresses keep
+// their network prefix, email addresses keep their domain and the other
+// strings keep their first and last characters.
+func (c *maskConfig) mask(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if addr, err := netip.ParseAddr(v); err == nil {
+			return c.maskIP(addr), nil
+		}
+		if at := strings.LastIndexByte(v, '@'); at > 0 {
+			return c.maskString(v[:at]) + v[at:], nil
+		}
+		return c.maskString(v), nil
+	case net.IP:
+		if addr, ok := netip.AddrFromSlice(v); ok {
+			return c.maskIP(addr.Unmap()), nil
+		}
+	case []string:
+		masked := make([]string, len(v))
+		for i, s := range v {
+			m, err := c.mask(s)
+			if err != nil {
+				return nil, err
+			}
+			masked[i], _ = m.(string)
+		}
+		return masked, nil
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, s := range v {
+			m, err := c.mask(s)
+			if err != nil {
+				return nil, err
+			}
+			masked[i] = m
+		}
+		return masked, nil
+	}
+	return nil, fmt.Errorf("can't mask value of type %T", value)
+}
+
+func (c *maskConfig) maskIP(addr netip.Addr) string {
+	bits := c.IPv6Prefix
+	if addr.Is4() {
+		bits = c.IPv4Prefix
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix.Addr().String()
+}
+
+// maskString replaces the characters of s with the mask character, except
+// the first keep_prefix and last keep_suffix ones. At least half of the
+// characters are always masked.
+func (c *maskConfig) maskString(s string) string {
+	runes := []rune(s)
+	prefix, suffix := c.KeepPrefix, c.KeepSuffix
+	for prefix+suffix > len(runes)/2 {
+		if suffix > 0 {
+			suffix--
+		} else {
+			prefix--
+		}
+	}
+
+	char := []rune(c.Char)[0]
+	for i := prefix; i < len(runes)-suffix; i++ {
+		runes[i] = char
+	}
+	return string(runes)
+}
@@ -0,0 +1,156 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package protect_fields
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/libbeat/processors/checks"
+	jsprocessor "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor/registry"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+const (
+	procName = "protect_fields"
+	logName  = "processor." + procName
+)
+
+func init() {
+	processors.RegisterPlugin(procName,
+		checks.ConfigChecked(New,
+			checks.RequireFields("fields"),
+			checks.AllowedFields(
+				"fields", "hmac_key", "hmac_hash",
+				"encryption_key", "mask",
+				"ignore_missing", "ignore_failure",
+				"when",
+			),
+		),
+	)
+	jsprocessor.RegisterPlugin("ProtectFields", New)
+}
+
+// processor pseudonymizes, masks or encrypts fields.
+type processor struct {
+	config
+
+	hmac    *hmacer
+	encrypt *encrypter
+	log     *logp.Logger
+}
+
+// New creates a new protect_fields processor from the provided
+// configuration, or an error if the configuration is invalid.
+func New(c *conf.C, log *logp.Logger) (beat.Processor, error) {
+	cfg := defaultConfig()
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("fail to unpack the "+procName+" processor configuration: %w", err)
+	}
+
+	p := &processor{
+		config: cfg,
+		log:    log.Named(logName),
+	}
+	if cfg.HMACKey != "" {
+		p.hmac = &hmacer{key: []byte(cfg.HMACKey), hash: hmacHashes[cfg.HMACHash]}
+	}
+	if cfg.EncryptionKey != "" {
+		key, _ := base64.StdEncoding.DecodeString(cfg.EncryptionKey)
+		e, err := newEncrypter(key)
+		if err != nil {
+			return nil, fmt.Errorf("fail to create the "+procName+" processor cipher: %w", err)
+		}
+		p.encrypt = e
+	}
+	return p, nil
+}
+
+// Run replaces the configured fields with their protected value. A field
+// that can't be protected is removed from the event, so its original value
+// is never published.
+func (p *processor) Run(event *beat.Event) (*beat.Event, error) {
+	var errs []error
+	for _, f := range p.Fields {
+		if err := p.protect(event, f); err != nil {
+			_ = event.Delete(f.Field)
+			errs = append(errs, fmt.Errorf("failed to protect field %q: %w", f.Field, err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil && !p.IgnoreFailure {
+		err = fmt.Errorf(procName+": %w", err)
+		_, _ = event.PutValue("error.message", err.Error())
+		return event, err
+	}
+	return event, nil
+}
+
+func (p *processor) protect(event *beat.Event, f fieldConfig) error {
+	value, err := event.GetValue(f.Field)
+	if err != nil {
+		if p.IgnoreMissing && errors.Is(err, mapstr.ErrKeyNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	var protected interface{}
+	switch f.Method {
+	case methodHMAC:
+		protected, err = p.hmac.sum(value)
+	case methodMask:
+		protected, err = p.Mask.mask(value)
+	case methodEncrypt:
+		protected, err = p.encrypt.encrypt(value)
+	}
+	if err != nil {
+		return err
+	}
+
+	if f.TargetField != "" && f.TargetField != f.Field {
+		if err := event.Delete(f.Field); err != nil {
+			return err
+		}
+		_, err = event.PutValue(f.TargetField, protected)
+		return err
+	}
+	_, err = event.PutValue(f.Field, protected)
+	return err
+}
+
+func (p *processor) String() string {
+	cfg := p.config
+	// Never log the keys.
+	if cfg.HMACKey != "" {
+		cfg.HMACKey = "xxxxx"
+	}
+	if cfg.EncryptionKey != "" {
+		cfg.EncryptionKey = "xxxxx"
+	}
+	data, _ := json.Marshal(cfg)
+
+	return procName + "=" + string(data)
+}
@@ -0,0 +1,224 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package protect_fields
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func newProcessor(t *testing.T, cfg mapstr.M) beat.Processor {
+	t.Helper()
+	p, err := New(conf.MustNewConfigFrom(cfg), logptest.NewTestingLogger(t, ""))
+	require.NoError(t, err)
+	return p
+}
+
+func TestProtectFields(t *testing.T) {
+	p := newProcessor(t, mapstr.M{
+		"hmac_key":       "secret",
+		"encryption_key": base64.StdEncoding.EncodeToString(testKey),
+		"fields": []mapstr.M{
+			{"field": "user.name", "method": "hmac"},
+			{"field": "source.ip", "method": "mask"},
+			{"field": "user.email", "method": "encrypt", "target_field": "user.email_encrypted"},
+			{"field": "destination.ip", "method": "mask"},
+		},
+	})
+
+	event := &beat.Event{Fields: mapstr.M{
+		"user": mapstr.M{
+			"name":  "alice",
+			"email": "alice@example.com",
+		},
+		"source":      mapstr.M{"ip": "192.168.12.34"},
+		"destination": mapstr.M{"ip": net.ParseIP("2001:db8:1:2:3:4:5:6")},
+	}}
+	out, err := p.Run(event)
+	require.NoError(t, err)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("alice"))
+	name, _ := out.GetValue("user.name")
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), name)
+
+	ip, _ := out.GetValue("source.ip")
+	assert.Equal(t, "192.168.12.0", ip)
+	ip, _ = out.GetValue("destination.ip")
+	assert.Equal(t, "2001:db8:1:2::", ip)
+
+	_, err = out.GetValue("user.email")
+	assert.ErrorIs(t, err, mapstr.ErrKeyNotFound, "the source field must be removed")
+	encrypted, _ := out.GetValue("user.email_encrypted")
+	require.IsType(t, "", encrypted)
+	assert.True(t, strings.HasPrefix(encrypted.(string), EncryptedPrefix+KeyID(testKey)+":"))
+	assert.NotContains(t, encrypted, "alice")
+
+	d, err := NewDecrypter(testKey)
+	require.NoError(t, err)
+	value, err := d.Decrypt(encrypted.(string))
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", value)
+}
+
+func TestProtectFieldsFailure(t *testing.T) {
+	p := newProcessor(t, mapstr.M{
+		"fields": []mapstr.M{
+			{"field": "user.id", "method": "mask"},
+			{"field": "user.name", "method": "mask"},
+			{"field": "missing", "method": "mask"},
+		},
+	})
+
+	event := &beat.Event{Fields: mapstr.M{"user": mapstr.M{"id": 42, "name": "alice"}}}
+	out, err := p.Run(event)
+	require.Error(t, err)
+
+	_, err = out.GetValue("user.id")
+	assert.ErrorIs(t, err, mapstr.ErrKeyNotFound, "fields failing to be protected must be removed")
+	name, _ := out.GetValue("user.name")
+	assert.Equal(t, "a****", name)
+	msg, _ := out.GetValue("error.message")
+	assert.Contains(t, msg, `"user.id"`)
+	assert.Contains(t, msg, `"missing"`)
+
+	p = newProcessor(t, mapstr.M{
+		"ignore_missing": true,
+		"fields":         []mapstr.M{{"field": "missing", "method": "mask"}},
+	})
+	_, err = p.Run(&beat.Event{Fields: mapstr.M{}})
+	assert.NoError(t, err)
+}
+
+func TestMask(t *testing.T) {
+	cfg := defaultConfig().Mask
+	tests := map[string]interface{}{
+		"10.1.2.3":                 "10.1.2.0",
+		"fe80::1:2:3:4":            "fe80::",
+		"john.doe@example.com":     "j*******@example.com",
+		"secret":                   "s*****",
+		"ab":                       "a*",
+		"a":                        "*",
+		"ünïcödé":                  "ü******",
+		"":                         "",
+		"user@":                    "u***@",
+		"@example.com":             "@***********",
+		"not an ip but has spaces": "n***********************",
+	}
+	for in, want := range tests {
+		got, err := cfg.mask(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	cfg.KeepPrefix, cfg.KeepSuffix, cfg.IPv4Prefix = 2, 2, 16
+	got, err := cfg.mask([]interface{}{"4111111111111111", "10.1.2.3"})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"41************11", "10.1.0.0"}, got)
+
+	_, err = cfg.mask(3.14)
+	assert.Error(t, err)
+}
+
+func TestDecryptAll(t *testing.T) {
+	e, err := newEncrypter(testKey)
+	require.NoError(t, err)
+	otherKey := []byte("fedcba9876543210")
+	other, err := newEncrypter(otherKey)
+	require.NoError(t, err)
+
+	name, err := e.encrypt("alice")
+	require.NoError(t, err)
+	id, err := e.encrypt(42)
+	require.NoError(t, err)
+	unknown, err := other.encrypt("bob")
+	require.NoError(t, err)
+
+	event := map[string]interface{}{
+		"user":    map[string]interface{}{"name": name, "id": id},
+		"related": []interface{}{unknown},
+		"message": "hello",
+	}
+	d, err := NewDecrypter(testKey)
+	require.NoError(t, err)
+	n, err := d.DecryptAll(event)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, map[string]interface{}{
+		"user":    map[string]interface{}{"name": "alice", "id": json.Number("42")},
+		"related": []interface{}{unknown},
+		"message": "hello",
+	}, event)
+
+	tampered := name[:len(name)-4] + "AAA="
+	_, err = d.Decrypt(tampered)
+	assert.Error(t, err)
+}
+
+func TestConfigValidation(t *testing.T) {
+	tests := map[string]mapstr.M{
+		"unknown method":      {"fields": []mapstr.M{{"field": "a", "method": "rot13"}}},
+		"missing hmac key":    {"fields": []mapstr.M{{"field": "a", "method": "hmac"}}},
+		"missing encrypt key": {"fields": []mapstr.M{{"field": "a", "method": "encrypt"}}},
+		"short key": {
+			"encryption_key": base64.StdEncoding.EncodeToString([]byte("short")),
+			"fields":         []mapstr.M{{"field": "a", "method": "encrypt"}},
+		},
+		"bad hash": {
+			"hmac_key":  "k",
+			"hmac_hash": "md5",
+			"fields":    []mapstr.M{{"field": "a", "method": "hmac"}},
+		},
+		"long mask char": {
+			"mask":   mapstr.M{"char": "**"},
+			"fields": []mapstr.M{{"field": "a", "method": "mask"}},
+		},
+	}
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(conf.MustNewConfigFrom(cfg), logptest.NewTestingLogger(t, ""))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestStringHidesKeys(t *testing.T) {
+	p := newProcessor(t, mapstr.M{
+		"hmac_key":       "super-secret",
+		"encryption_key": base64.StdEncoding.EncodeToString(testKey),
+		"fields":         []mapstr.M{{"field": "a", "method": "hmac"}},
+	})
+	assert.NotContains(t, p.String(), "super-secret")
+	assert.NotContains(t, p.String(), base64.StdEncoding.EncodeToString(testKey))
+}
@@ -20,6 +20,7 @@ package ratelimit
 import (
 	"fmt"
 
+	"github.com/elastic/beats/v7/libbeat/beat"
 	cfg "github.com/elastic/elastic-agent-libs/config"
 )
 
@@ -30,6 +31,10 @@ type algoConfig struct {
 	// limit is the rate limit to be enforced by the algorithm.
 	limit rate
 
+	// fields are the fields the keys passed to the algorithm are derived
+	// from.
+	fields []string
+
 	// config is any algorithm-specific additional configuration.
 	config cfg.C
 }
@@ -44,6 +49,16 @@ type algorithm interface {
 	IsAllowed(uint64) bool
 }
 
+// eventAlgorithm is implemented by algorithms that need the event being
+// checked, for example to annotate the events they allow.
+type eventAlgorithm interface {
+	algorithm
+
+	// IsEventAllowed is like IsAllowed, the algorithm may modify the event
+	// if it is allowed.
+	IsEventAllowed(uint64, *beat.Event) bool
+}
+
 type constructor func(algoConfig) (algorithm, error)
 
 func register(id string, ctor constructor) {
@@ -70,6 +70,7 @@ func new(cfg *c.C, log *logp.Logger) (beat.Processor, error) {
 
 	algoConfig := algoConfig{
 		limit:  config.Limit,
+		fields: config.Fields,
 		config: *config.Algorithm.Config(),
 	}
 	algo, err := factory(config.Algorithm.Name(), algoConfig)
@@ -106,7 +107,13 @@ func (p *rateLimit) Run(event *beat.Event) (*beat.Event, error) {
 		return nil, fmt.Errorf("could not make key: %w", err)
 	}
 
-	if p.algorithm.IsAllowed(key) {
+	var allowed bool
+	if a, ok := p.algorithm.(eventAlgorithm); ok {
+		allowed = a.IsEventAllowed(key, event)
+	} else {
+		allowed = p.algorithm.IsAllowed(key)
+	}
+	if allowed {
 		return event, nil
 	}
 
@@ -145,6 +152,28 @@ func (p *rateLimit) makeKey(event *beat.Event) (uint64, error) {
 	return hashstructure.Hash(values, nil)
 }
 
+// StartEmitting forwards to the algorithm if it publishes events of its own.
+func (p *rateLimit) StartEmitting(emit func(beat.Event)) {
+	if e, ok := p.algorithm.(processors.Emitter); ok {
+		e.StartEmitting(emit)
+	}
+}
+
+// StopEmitting forwards to the algorithm if it publishes events of its own.
+func (p *rateLimit) StopEmitting() {
+	if e, ok := p.algorithm.(processors.Emitter); ok {
+		e.StopEmitting()
+	}
+}
+
+// Close closes the algorithm if it holds resources.
+func (p *rateLimit) Close() error {
+	if c, ok := p.algorithm.(processors.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 // setClock allows test code to inject a fake clock
 // TODO: remove this method and move tests that use it to algorithm level.
 func (p *rateLimit) setClock(c clockwork.Clock) {
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/jonboulle/clockwork"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/beats/v7/libbeat/beat"
@@ -48,6 +49,46 @@ func TestNew(t *testing.T) {
 			},
 			"rate limiting algorithm 'foobar' not implemented",
 		},
+		"probabilistic": {
+			mapstr.M{
+				"algorithm": mapstr.M{
+					"probabilistic": mapstr.M{"rate": 0.5},
+				},
+			},
+			"",
+		},
+		"probabilistic_without_rate": {
+			mapstr.M{
+				"algorithm": mapstr.M{
+					"probabilistic": mapstr.M{},
+				},
+			},
+			"probabilistic algorithm requires a rate between 0 and 1",
+		},
+		"consistent_hash_without_fields": {
+			mapstr.M{
+				"algorithm": mapstr.M{
+					"consistent_hash": mapstr.M{"rate": 0.5},
+				},
+			},
+			"consistent_hash algorithm requires fields to sample on",
+		},
+		"first_n_without_count": {
+			mapstr.M{
+				"algorithm": mapstr.M{
+					"first_n": mapstr.M{"window": "1m"},
+				},
+			},
+			"first_n algorithm requires a count",
+		},
+		"dedup_without_fields": {
+			mapstr.M{
+				"algorithm": mapstr.M{
+					"dedup": mapstr.M{},
+				},
+			},
+			"dedup algorithm requires fields identifying duplicates",
+		},
 	}
 
 	for name, test := range cases {
@@ -57,7 +98,7 @@ func TestNew(t *testing.T) {
 			if test.err == "" {
 				require.NoError(t, err)
 			} else {
-				require.Error(t, err, test.err)
+				require.ErrorContains(t, err, test.err)
 			}
 		})
 	}
@@ -141,6 +182,52 @@ func TestRateLimit(t *testing.T) {
 				withField(inEvents[3], "foo", "seger"),
 			},
 		},
+		"first_n": {
+			config: mapstr.M{
+				"fields": []string{"foo"},
+				"algorithm": mapstr.M{
+					"first_n": mapstr.M{"count": 2, "window": "1s"},
+				},
+			},
+			delay: 300 * time.Millisecond,
+			inEvents: []beat.Event{
+				withField(inEvents[0], "foo", "bar"),
+				withField(inEvents[1], "foo", "bar"),
+				withField(inEvents[2], "foo", "bar"),
+				withField(inEvents[3], "foo", "seger"),
+				withField(inEvents[4], "foo", "bar"),
+				withField(inEvents[5], "foo", "bar"),
+			},
+			outEvents: []beat.Event{
+				withField(inEvents[0], "foo", "bar"),
+				withField(inEvents[1], "foo", "bar"),
+				withField(inEvents[3], "foo", "seger"),
+				withField(inEvents[4], "foo", "bar"),
+				withField(inEvents[5], "foo", "bar"),
+			},
+		},
+		"dedup": {
+			config: mapstr.M{
+				"fields": []string{"foo"},
+				"algorithm": mapstr.M{
+					"dedup": mapstr.M{"window": "1s"},
+				},
+			},
+			delay: 300 * time.Millisecond,
+			inEvents: []beat.Event{
+				withField(inEvents[0], "foo", "bar"),
+				withField(inEvents[1], "foo", "bar"),
+				withField(inEvents[2], "foo", "bar"),
+				withField(inEvents[3], "foo", "seger"),
+				withField(inEvents[4], "foo", "bar"),
+				withField(inEvents[5], "foo", "bar"),
+			},
+			outEvents: []beat.Event{
+				withField(inEvents[0], "foo", "bar"),
+				withField(inEvents[3], "foo", "seger"),
+				withField(withField(inEvents[4], "foo", "bar"), "rate_limit.suppressed", 2),
+			},
+		},
 		"with_burst": {
 			config: mapstr.M{
 				"limit":            "2/s",
@@ -179,6 +266,65 @@ func TestRateLimit(t *testing.T) {
 	}
 }
 
+func TestSampling(t *testing.T) {
+	run := func(t *testing.T, config mapstr.M, events []beat.Event) []beat.Event {
+		p, err := new(conf.MustNewConfigFrom(config), logptest.NewTestingLogger(t, ""))
+		require.NoError(t, err)
+
+		var out []beat.Event
+		for _, in := range events {
+			event := in
+			event.Fields = in.Fields.Clone()
+			o, err := p.Run(&event)
+			require.NoError(t, err)
+			if o != nil {
+				out = append(out, *o)
+			}
+		}
+		return out
+	}
+
+	var events []beat.Event
+	for i := 0; i < 10000; i++ {
+		events = append(events, beat.Event{Fields: mapstr.M{"id": i % 1000}})
+	}
+
+	t.Run("probabilistic", func(t *testing.T) {
+		out := run(t, mapstr.M{
+			"algorithm": mapstr.M{"probabilistic": mapstr.M{"rate": 0.1}},
+		}, events)
+		require.InDelta(t, 1000, len(out), 200)
+
+		require.Empty(t, run(t, mapstr.M{
+			"algorithm": mapstr.M{"probabilistic": mapstr.M{"rate": 0}},
+		}, events))
+		require.Len(t, run(t, mapstr.M{
+			"algorithm": mapstr.M{"probabilistic": mapstr.M{"rate": 1}},
+		}, events), len(events))
+	})
+
+	t.Run("consistent_hash", func(t *testing.T) {
+		config := mapstr.M{
+			"fields":    []string{"id"},
+			"algorithm": mapstr.M{"consistent_hash": mapstr.M{"rate": 0.1}},
+		}
+		out := run(t, config, events)
+		require.InDelta(t, 1000, len(out), 300)
+
+		// Every key is either always kept or always dropped.
+		kept := map[interface{}]int{}
+		for _, event := range out {
+			kept[event.Fields["id"]]++
+		}
+		for id, n := range kept {
+			require.Equal(t, 10, n, "id %v", id)
+		}
+
+		// The same keys are kept by another instance.
+		require.Equal(t, out, run(t, config, events))
+	})
+}
+
 func TestAllocs(t *testing.T) {
 	p, err := new(conf.MustNewConfigFrom(mapstr.M{
 		"limit": "100/s",
@@ -206,3 +352,51 @@ func BenchmarkRateLimit(b *testing.B) {
 		p.Run(&event) //nolint:errcheck // ignore
 	}
 }
+
+func TestDedupReportsClosedWindows(t *testing.T) {
+	algo, err := newDedup(algoConfig{
+		fields: []string{"foo"},
+		config: *conf.MustNewConfigFrom(mapstr.M{"window": "1s"}),
+	})
+	require.NoError(t, err)
+	d := algo.(*dedup)
+
+	fakeClock := clockwork.NewFakeClock()
+	d.setClock(fakeClock)
+	start := fakeClock.Now()
+
+	emitted := make(chan beat.Event, 10)
+	d.StartEmitting(func(e beat.Event) { emitted <- e })
+	defer d.Close()
+
+	event := func() *beat.Event {
+		return &beat.Event{Fields: mapstr.M{"foo": "bar", "message": "hello"}}
+	}
+	require.True(t, d.IsEventAllowed(1, event()))
+	require.False(t, d.IsEventAllowed(1, event()))
+	require.False(t, d.IsEventAllowed(1, event()))
+
+	// No other event of the key arrives, the window is reported when it
+	// closes.
+	fakeClock.BlockUntil(1)
+	fakeClock.Advance(time.Second)
+	select {
+	case e := <-emitted:
+		assert.Equal(t, start.Add(time.Second), e.Timestamp)
+		assert.Equal(t, mapstr.M{"foo": "bar", "rate_limit": mapstr.M{"suppressed": 2}}, e.Fields)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the closed window was not reported")
+	}
+
+	// The next event of the key doesn't report the duplicates again.
+	fakeClock.Advance(100 * time.Millisecond)
+	e := event()
+	require.True(t, d.IsEventAllowed(1, e))
+	assert.NotContains(t, e.Fields, "rate_limit")
+
+	// Stopping reports the windows not closed yet.
+	require.False(t, d.IsEventAllowed(1, event()))
+	d.StopEmitting()
+	require.Len(t, emitted, 1)
+	assert.Equal(t, mapstr.M{"foo": "bar", "rate_limit": mapstr.M{"suppressed": 1}}, (<-emitted).Fields)
+}
@@ -0,0 +1,110 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+func init() {
+	register("probabilistic", newProbabilistic)
+	register("consistent_hash", newConsistentHash)
+}
+
+type samplingConfig struct {
+	// Rate is the fraction of events to keep, between 0 and 1.
+	Rate float64 `config:"rate"`
+}
+
+func unpackSamplingConfig(name string, config algoConfig) (samplingConfig, error) {
+	cfg := samplingConfig{Rate: -1}
+	if err := config.config.Unpack(&cfg); err != nil {
+		return cfg, fmt.Errorf("could not unpack %s algorithm configuration: %w", name, err)
+	}
+	if cfg.Rate < 0 || cfg.Rate > 1 {
+		return cfg, fmt.Errorf("%s algorithm requires a rate between 0 and 1", name)
+	}
+	return cfg, nil
+}
+
+// probabilistic keeps each event with a fixed probability, independently of
+// its key.
+type probabilistic struct {
+	rate   float64
+	random func() float64
+}
+
+func newProbabilistic(config algoConfig) (algorithm, error) {
+	cfg, err := unpackSamplingConfig("probabilistic", config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &probabilistic{
+		rate:   cfg.Rate,
+		random: rand.Float64,
+	}, nil
+}
+
+func (p *probabilistic) IsAllowed(uint64) bool {
+	return p.random() < p.rate
+}
+
+// consistentHash keeps all the events of a fraction of the keys. The same key
+// is always either kept or dropped, so related events are sampled together
+// across restarts and Beats.
+type consistentHash struct {
+	threshold uint64
+}
+
+func newConsistentHash(config algoConfig) (algorithm, error) {
+	if len(config.fields) == 0 {
+		return nil, errors.New("consistent_hash algorithm requires fields to sample on")
+	}
+	cfg, err := unpackSamplingConfig("consistent_hash", config)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &consistentHash{threshold: math.MaxUint64}
+	if cfg.Rate < 1 {
+		c.threshold = uint64(cfg.Rate * math.MaxUint64)
+	}
+	return c, nil
+}
+
+func (c *consistentHash) IsAllowed(key uint64) bool {
+	if c.threshold == 0 {
+		return false
+	}
+	return mixKey(key) <= c.threshold
+}
+
+// mixKey spreads the bits of key over the full uint64 range, so keys of
+// similar values are sampled independently.
+func mixKey(key uint64) uint64 {
+	key ^= key >> 30
+	key *= 0xbf58476d1ce4e5b9
+	key ^= key >> 27
+	key *= 0x94d049bb133111eb
+	key ^= key >> 31
+	return key
+}
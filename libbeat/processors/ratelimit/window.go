@@ -0,0 +1,367 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func init() {
+	register("first_n", newFirstN)
+	register("dedup", newDedup)
+}
+
+// windows counts the events of each key in fixed time windows. The window
+// of a key starts with the first event of the key.
+type windows struct {
+	mu     sync.Mutex
+	length time.Duration
+	counts map[uint64]*windowCount
+	lastGC time.Time
+	clock  clockwork.Clock
+}
+
+type windowCount struct {
+	start time.Time
+	count int
+}
+
+func newWindows(length time.Duration) *windows {
+	clock := clockwork.NewRealClock()
+	return &windows{
+		length: length,
+		counts: make(map[uint64]*windowCount),
+		lastGC: clock.Now(),
+		clock:  clock,
+	}
+}
+
+// add counts an event of key. It returns the number of events in the current
+// window of the key, including this one, and the number of events in the
+// previous window if this event starts a new one.
+func (w *windows) add(key uint64) (count, previous int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.clock.Now()
+	w.gc(now)
+
+	c, found := w.counts[key]
+	switch {
+	case !found:
+		c = &windowCount{start: now}
+		w.counts[key] = c
+	case now.Sub(c.start) >= w.length:
+		previous = c.count
+		c.start, c.count = now, 0
+	}
+	c.count++
+	return c.count, previous
+}
+
+// gc removes the keys without events for the last two windows. It runs at
+// most once per window.
+func (w *windows) gc(now time.Time) {
+	if now.Sub(w.lastGC) < w.length {
+		return
+	}
+	w.lastGC = now
+	for key, c := range w.counts {
+		if now.Sub(c.start) >= 2*w.length {
+			delete(w.counts, key)
+		}
+	}
+}
+
+// setClock allows test code to inject a fake clock
+func (w *windows) setClock(c clockwork.Clock) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.clock = c
+	w.lastGC = c.Now()
+}
+
+// firstN keeps the first events of each key in every window, and drops the
+// others.
+type firstN struct {
+	*windows
+	count int
+}
+
+type firstNConfig struct {
+	// Count is the number of events of each key kept per window.
+	Count int `config:"count"`
+	// Window is the duration of the windows.
+	Window time.Duration `config:"window" validate:"positive,nonzero"`
+}
+
+func newFirstN(config algoConfig) (algorithm, error) {
+	cfg := firstNConfig{
+		Window: time.Minute,
+	}
+	if err := config.config.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("could not unpack first_n algorithm configuration: %w", err)
+	}
+	if cfg.Count < 1 {
+		return nil, errors.New("first_n algorithm requires a count")
+	}
+
+	return &firstN{
+		windows: newWindows(cfg.Window),
+		count:   cfg.Count,
+	}, nil
+}
+
+func (f *firstN) IsAllowed(key uint64) bool {
+	count, _ := f.add(key)
+	return count <= f.count
+}
+
+// dedup keeps only the first event of each key in every window. The number of
+// duplicates dropped in a window is added to the first event of the key in
+// the next window. When the processor is connected to a pipeline client,
+// windows closing before another event of their key arrives are reported in
+// events of their own.
+type dedup struct {
+	*windows
+	field  string
+	fields []string
+	logger *logp.Logger
+
+	// emit and pending are protected by the lock of windows.
+	emit    func(beat.Event)
+	pending map[uint64]*suppressedWindow
+	wake    chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// suppressedWindow is a window with suppressed duplicates not reported yet.
+type suppressedWindow struct {
+	start  time.Time
+	values mapstr.M
+}
+
+type dedupConfig struct {
+	// Window is the duration duplicates are suppressed for.
+	Window time.Duration `config:"window" validate:"positive,nonzero"`
+	// SuppressedField is the field the number of suppressed duplicates is
+	// written to.
+	SuppressedField string `config:"suppressed_field" validate:"required"`
+}
+
+func newDedup(config algoConfig) (algorithm, error) {
+	if len(config.fields) == 0 {
+		return nil, errors.New("dedup algorithm requires fields identifying duplicates")
+	}
+
+	cfg := dedupConfig{
+		Window:          time.Minute,
+		SuppressedField: "rate_limit.suppressed",
+	}
+	if err := config.config.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("could not unpack dedup algorithm configuration: %w", err)
+	}
+
+	return &dedup{
+		windows: newWindows(cfg.Window),
+		field:   cfg.SuppressedField,
+		fields:  config.fields,
+		logger:  logp.NewLogger("dedup"),
+		pending: make(map[uint64]*suppressedWindow),
+		wake:    make(chan struct{}, 1),
+	}, nil
+}
+
+func (d *dedup) IsAllowed(key uint64) bool {
+	return d.IsEventAllowed(key, nil)
+}
+
+func (d *dedup) IsEventAllowed(key uint64, event *beat.Event) bool {
+	count, previous := d.add(key)
+	if count > 1 {
+		if count == 2 && event != nil {
+			d.suppress(key, event)
+		}
+		return false
+	}
+
+	if suppressed := previous - 1; suppressed > 0 && event != nil {
+		d.putSuppressed(event, suppressed)
+	}
+	return true
+}
+
+func (d *dedup) putSuppressed(event *beat.Event, suppressed int) {
+	if _, err := event.PutValue(d.field, suppressed); err != nil {
+		d.logger.Debugf("could not add suppressed count to event: %v", err)
+	}
+}
+
+// suppress records the first duplicate suppressed in the current window of
+// the key, so the window is reported when it closes.
+func (d *dedup) suppress(key uint64, event *beat.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c, found := d.counts[key]
+	if d.emit == nil || !found {
+		return
+	}
+	values := mapstr.M{}
+	for _, field := range d.fields {
+		if v, err := event.GetValue(field); err == nil {
+			_, _ = values.Put(field, v)
+		}
+	}
+	d.pending[key] = &suppressedWindow{start: c.start, values: values}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// StartEmitting starts the goroutine reporting the windows closing with
+// suppressed duplicates.
+func (d *dedup) StartEmitting(emit func(beat.Event)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.emit != nil {
+		return
+	}
+	d.emit = emit
+	d.done = make(chan struct{})
+	d.wg.Add(1)
+	go d.loop(d.done)
+}
+
+// StopEmitting reports the suppressed duplicates of all the windows, including
+// the ones not closed yet.
+func (d *dedup) StopEmitting() {
+	d.stop(true)
+}
+
+// Close stops reporting the windows without reporting the pending ones.
+func (d *dedup) Close() error {
+	d.stop(false)
+	return nil
+}
+
+func (d *dedup) stop(flush bool) {
+	d.mu.Lock()
+	emit := d.emit
+	if emit == nil {
+		d.mu.Unlock()
+		return
+	}
+	close(d.done)
+	d.mu.Unlock()
+
+	d.wg.Wait()
+
+	d.mu.Lock()
+	var events []beat.Event
+	if flush {
+		events = d.closeWindows(time.Time{})
+	}
+	d.emit = nil
+	d.pending = make(map[uint64]*suppressedWindow)
+	d.mu.Unlock()
+
+	for _, e := range events {
+		emit(e)
+	}
+}
+
+func (d *dedup) loop(done <-chan struct{}) {
+	defer d.wg.Done()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-d.wake:
+		case <-d.nextClose():
+		}
+
+		d.mu.Lock()
+		emit := d.emit
+		events := d.closeWindows(d.clock.Now())
+		d.mu.Unlock()
+
+		for _, e := range events {
+			emit(e)
+		}
+	}
+}
+
+// nextClose returns a channel receiving when the next pending window closes,
+// or nil if there are no pending windows.
+func (d *dedup) nextClose() <-chan time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.pending) == 0 {
+		return nil
+	}
+	var next time.Time
+	for _, w := range d.pending {
+		if end := w.start.Add(d.length); next.IsZero() || end.Before(next) {
+			next = end
+		}
+	}
+	return d.clock.After(next.Sub(d.clock.Now()))
+}
+
+// closeWindows returns the events reporting the pending windows closed
+// before now. A zero now reports all the pending windows. The windows whose
+// suppressed duplicates were already added to the next event of the key are
+// dropped.
+func (d *dedup) closeWindows(now time.Time) []beat.Event {
+	var events []beat.Event
+	for key, w := range d.pending {
+		end := w.start.Add(d.length)
+		if !now.IsZero() && now.Before(end) {
+			continue
+		}
+		delete(d.pending, key)
+
+		c, found := d.counts[key]
+		if !found || !c.start.Equal(w.start) || c.count < 2 {
+			continue
+		}
+		event := beat.Event{Timestamp: end, Fields: w.values}
+		d.putSuppressed(&event, c.count-1)
+		events = append(events, event)
+		// The duplicates are reported, the next event of the key must not
+		// report them again.
+		c.count = 1
+	}
+	return events
+}
@@ -0,0 +1,87 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Replacement styles of the redacted text.
+const (
+	styleTag  = "tag"
+	styleMask = "mask"
+	styleHash = "hash"
+)
+
+type config struct {
+	Fields        []string        `config:"fields" validate:"required"`
+	Detectors     []string        `config:"detectors"`
+	Patterns      []patternConfig `config:"patterns"`
+	Replacement   string          `config:"replacement"`
+	MaskChar      string          `config:"mask_char"`
+	HashKey       string          `config:"hash_key"`
+	IgnoreMissing bool            `config:"ignore_missing"`
+	IgnoreFailure bool            `config:"ignore_failure"`
+	Tag           string          `config:"tag"`
+}
+
+type patternConfig struct {
+	Name    string         `config:"name" validate:"required"`
+	Pattern *regexp.Regexp `config:"pattern" validate:"required"`
+}
+
+func defaultConfig() config {
+	detectors := make([]string, 0, len(builtinDetectors))
+	for _, d := range builtinDetectors {
+		detectors = append(detectors, d.name)
+	}
+	return config{
+		Fields:        []string{"message"},
+		Detectors:     detectors,
+		Replacement:   styleTag,
+		MaskChar:      "*",
+		IgnoreMissing: true,
+	}
+}
+
+func (c *config) Validate() error {
+	names := map[string]bool{}
+	for _, name := range c.Detectors {
+		if _, ok := builtinDetector(name); !ok {
+			return fmt.Errorf("unknown detector %q", name)
+		}
+		names[name] = true
+	}
+	for _, p := range c.Patterns {
+		if names[p.Name] {
+			return fmt.Errorf("pattern name %q is already used", p.Name)
+		}
+		names[p.Name] = true
+	}
+	switch c.Replacement {
+	case styleTag, styleMask, styleHash:
+	default:
+		return fmt.Errorf("unsupported replacement %q, must be %s, %s or %s", c.Replacement, styleTag, styleMask, styleHash)
+	}
+	if utf8.RuneCountInString(c.MaskChar) != 1 {
+		return fmt.Errorf("mask_char must be a single character, got %q", c.MaskChar)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/processors"
+	"github.com/elastic/beats/v7/libbeat/processors/checks"
+	jsprocessor "github.com/elastic/beats/v7/libbeat/processors/script/javascript/module/processor/registry"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+const (
+	procName = "redact"
+	logName  = "processor." + procName
+)
+
+// instanceID is used to assign each instance a unique monitoring namespace.
+var instanceID atomic.Uint32
+
+// processor replaces the sensitive values found by the detectors in text
+// fields.
+type processor struct {
+	config
+
+	detectors []detector
+	log       *logp.Logger
+	stats     processorStats
+}
+
+// processorStats contains the metrics fields for the redact processor.
+type processorStats struct {
+	// Events measures the number of events with at least one redaction.
+	Events *monitoring.Int
+	// Failure measures the number of events that couldn't be processed.
+	Failure *monitoring.Int
+	// Redactions measures the number of redactions of each detector, in the
+	// order of the detectors.
+	Redactions []*monitoring.Int
+}
+
+func init() {
+	processors.RegisterPlugin(procName,
+		checks.ConfigChecked(New,
+			checks.AllowedFields(
+				"fields", "detectors", "patterns",
+				"replacement", "mask_char", "hash_key",
+				"ignore_missing", "ignore_failure",
+				"tag", "when",
+			),
+		),
+	)
+	jsprocessor.RegisterPlugin("Redact", New)
+}
+
+// New creates a new redact processor from the provided configuration, or
+// an error if the configuration is invalid.
+func New(c *conf.C, log *logp.Logger) (beat.Processor, error) {
+	cfg := defaultConfig()
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("fail to unpack the "+procName+" processor configuration: %w", err)
+	}
+
+	var detectors []detector
+	for _, name := range cfg.Detectors {
+		d, _ := builtinDetector(name)
+		detectors = append(detectors, d)
+	}
+	for _, p := range cfg.Patterns {
+		detectors = append(detectors, detector{name: p.Name, re: p.Pattern})
+	}
+
+	id := int(instanceID.Add(1))
+	log = log.Named(logName).With("instance_id", id)
+	registryName := logName + "." + strconv.Itoa(id)
+
+	if cfg.Tag != "" {
+		log = log.With("tag", cfg.Tag)
+		registryName = logName + "." + cfg.Tag + "-" + strconv.Itoa(id)
+	}
+	registry := monitoring.Default.NewRegistry(registryName, monitoring.DoNotReport)
+
+	stats := processorStats{
+		Events:  monitoring.NewInt(registry, "events"),
+		Failure: monitoring.NewInt(registry, "failure"),
+	}
+	redactions := registry.NewRegistry("redactions")
+	for _, d := range detectors {
+		stats.Redactions = append(stats.Redactions, monitoring.NewInt(redactions, d.name))
+	}
+
+	return &processor{
+		config:    cfg,
+		detectors: detectors,
+		log:       log,
+		stats:     stats,
+	}, nil
+}
+
+// Run redacts the sensitive values of the configured fields.
+func (p *processor) Run(event *beat.Event) (*beat.Event, error) {
+	counts := make([]int, len(p.detectors))
+	var errs []error
+	for _, field := range p.Fields {
+		if err := p.redactField(event, field, counts); err != nil {
+			errs = append(errs, fmt.Errorf("failed to redact field %q: %w", field, err))
+		}
+	}
+
+	total := 0
+	for i, n := range counts {
+		p.stats.Redactions[i].Add(int64(n))
+		total += n
+	}
+	if total > 0 {
+		p.stats.Events.Inc()
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		p.stats.Failure.Inc()
+		if !p.IgnoreFailure {
+			err = fmt.Errorf(procName+": %w", err)
+			_, _ = event.PutValue("error.message", err.Error())
+			return event, err
+		}
+	}
+	return event, nil
+}
+
+func (p *processor) redactField(event *beat.Event, field string, counts []int) error {
+	value, err := event.GetValue(field)
+	if err != nil {
+		if p.IgnoreMissing && errors.Is(err, mapstr.ErrKeyNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	switch v := value.(type) {
+	case string:
+		value = p.redact(v, counts)
+	case []string:
+		redacted := make([]string, len(v))
+		for i, s := range v {
+			redacted[i] = p.redact(s, counts)
+		}
+		value = redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, elem := range v {
+			s, ok := elem.(string)
+			if !ok {
+				return fmt.Errorf("unexpected type %T in array, expected strings", elem)
+			}
+			redacted[i] = p.redact(s, counts)
+		}
+		value = redacted
+	default:
+		return fmt.Errorf("unexpected type %T, expected a string", value)
+	}
+	_, err = event.PutValue(field, value)
+	return err
+}
+
+// redact applies the detectors in order to s, adding the number of
+// redactions of each detector to counts.
+func (p *processor) redact(s string, counts []int) string {
+	for i, d := range p.detectors {
+		matches := d.re.FindAllStringSubmatchIndex(s, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var sb strings.Builder
+		last := 0
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			if len(m) >= 4 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+			if d.valid != nil && !d.valid(s[start:end]) {
+				continue
+			}
+			sb.WriteString(s[last:start])
+			sb.WriteString(p.replace(d.name, s[start:end]))
+			last = end
+			counts[i]++
+		}
+		sb.WriteString(s[last:])
+		s = sb.String()
+	}
+	return s
+}
+
+// replace returns the replacement of a sensitive value.
+func (p *processor) replace(name, value string) string {
+	switch p.Replacement {
+	case styleMask:
+		return strings.Repeat(p.MaskChar, utf8.RuneCountInString(value))
+	case styleHash:
+		var h hash.Hash
+		if p.HashKey != "" {
+			h = hmac.New(sha256.New, []byte(p.HashKey))
+		} else {
+			h = sha256.New()
+		}
+		h.Write([]byte(value))
+		return "[" + name + ":" + hex.EncodeToString(h.Sum(nil))[:16] + "]"
+	default:
+		return "[REDACTED:" + name + "]"
+	}
+}
+
+func (p *processor) String() string {
+	cfg := p.config
+	// Never log the key.
+	if cfg.HashKey != "" {
+		cfg.HashKey = "xxxxx"
+	}
+	data, _ := json.Marshal(cfg)
+
+	return procName + "=" + string(data)
+}
@@ -50,6 +50,16 @@ func (p *SafeProcessor) Close() (err error) {
 	return nil
 }
 
+// StartEmitting forwards to the underlying processor if it is an Emitter.
+func (p *SafeProcessor) StartEmitting(emit func(beat.Event)) {
+	StartEmitting(p.Processor, emit)
+}
+
+// StopEmitting forwards to the underlying processor if it is an Emitter.
+func (p *SafeProcessor) StopEmitting() {
+	StopEmitting(p.Processor)
+}
+
 // SafeWrap makes sure that the processor handles all the required edge-cases.
 //
 // Each processor might end up in multiple processor groups.
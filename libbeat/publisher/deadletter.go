@@ -0,0 +1,39 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package publisher
+
+// DeadLetterer is implemented by batches of pipelines configured with a dead
+// letter queue.
+type DeadLetterer interface {
+	// DeadLetter stores events the output permanently failed to publish,
+	// along with the reason of the failure. It returns false if no dead
+	// letter queue is configured.
+	DeadLetter(events []Event, reason error) bool
+}
+
+// DeadLetter hands events an output gave up on to the dead letter queue of
+// the pipeline the batch comes from. Outputs call it in addition to reporting
+// the events as dropped. It returns false if the pipeline has no dead letter
+// queue, in which case the events are lost.
+func DeadLetter(batch Batch, events []Event, reason error) bool {
+	dl, ok := batch.(DeadLetterer)
+	if !ok || len(events) == 0 {
+		return false
+	}
+	return dl.DeadLetter(events, reason)
+}
@@ -29,6 +29,10 @@ import (
 	"github.com/elastic/elastic-agent-libs/logp"
 )
 
+// emitStopTimeout is how long Close waits for the emitting processors to
+// publish their pending events.
+const emitStopTimeout = time.Second
+
 // client connects a beat with the processors and pipeline queue.
 type client struct {
 	logger     *logp.Logger
@@ -37,6 +41,10 @@ type client struct {
 	mutex      sync.Mutex
 	waiter     *clientCloseWaiter
 
+	// emitsReleased is closed when Close stops waiting for the events
+	// emitted by the processors.
+	emitsReleased chan struct{}
+
 	eventFlags publisher.EventFlags
 	canDrop    bool
 
@@ -109,14 +117,52 @@ func (c *client) publish(e beat.Event) {
 		return
 	}
 
-	e = *event
+	c.push(*event, c.canDrop)
+}
+
+// startEmitting connects the emitting processors of the client, if any, so
+// the events they publish on their own go through the client.
+func (c *client) startEmitting() {
+	if c.processors != nil {
+		processors.StartEmitting(c.processors, c.emit)
+	}
+}
+
+// emit publishes an event emitted by one of the client processors, outside
+// of a Publish call. The event already went through the processors
+// following the emitting processor.
+func (c *client) emit(e beat.Event) {
+	// The event is pushed from its own goroutine, so Close can release the
+	// emitting processors blocked by a full queue.
+	pushed := make(chan struct{})
+	go func() {
+		defer close(pushed)
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		c.onNewEvent()
+		c.eventListener.AddEvent(e, true)
+		// Processors emit their pending events while the client closes, they
+		// are dropped if the queue is full.
+		c.push(e, c.canDrop || !c.isOpen.Load())
+	}()
+
+	select {
+	case <-pushed:
+	case <-c.emitsReleased:
+	}
+}
+
+// push hands a processed event to the queue producer. If canDrop is set, the
+// event is dropped instead of waiting for the queue.
+func (c *client) push(e beat.Event, canDrop bool) {
 	pubEvent := publisher.Event{
 		Content: e,
 		Flags:   c.eventFlags,
 	}
 
 	var published bool
-	if c.canDrop {
+	if canDrop {
 		_, published = c.producer.TryPublish(pubEvent)
 	} else {
 		_, published = c.producer.Publish(pubEvent)
@@ -134,6 +180,12 @@ func (c *client) Close() error {
 		// Only do shutdown handling the first time Close is called
 		c.onClosing()
 
+		// Emitting processors publish their pending events before the
+		// acker waits for the outstanding events.
+		if c.processors != nil {
+			c.stopEmitting()
+		}
+
 		c.logger.Debug("client: closing acker")
 		c.waiter.signalClose()
 		c.waiter.wait()
@@ -158,6 +210,26 @@ func (c *client) Close() error {
 	return nil
 }
 
+// stopEmitting stops the emitting processors, publishing their pending
+// events. If they are still blocked by a full queue after emitStopTimeout,
+// they are released, and the events they were publishing are published or
+// dropped by the queue later on.
+func (c *client) stopEmitting() {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		processors.StopEmitting(c.processors)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(emitStopTimeout):
+		c.logger.Warn("client: emitting processors are blocked by a full queue, not waiting for their pending events")
+		close(c.emitsReleased)
+		<-stopped
+	}
+}
+
 func (c *client) onClosing() {
 	c.clientListener.Closing()
 }
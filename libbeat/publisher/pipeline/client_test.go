@@ -189,6 +189,92 @@ func TestClient(t *testing.T) {
 		<-done
 		require.Equal(t, expected, received)
 	})
+
+	t.Run("events emitted by processors are published", func(t *testing.T) {
+		l := logptest.NewTestingLogger(t, "")
+		q := memqueue.NewQueue(l, nil, memqueue.Settings{
+			Events:        5,
+			MaxGetRequest: 1,
+			FlushTimeout:  time.Millisecond,
+		}, 5, nil)
+
+		p := &emittingProcessor{}
+		pipeline := makePipeline(t, Settings{
+			Processors: testProcessorSupporter{Processor: p},
+		}, q)
+		defer pipeline.Close()
+
+		listener := &mockClientListener{}
+		client, err := pipeline.ConnectWith(beat.ClientConfig{ClientListener: listener})
+		require.NoError(t, err)
+
+		client.PublishAll([]beat.Event{
+			{Fields: mapstr.M{"number": 1}},
+			{Fields: mapstr.M{"number": 2}},
+		})
+		require.NoError(t, client.Close())
+
+		batch, err := q.Get(1)
+		require.NoError(t, err)
+		require.Equal(t, 1, batch.Count())
+		//nolint:errcheck // it always succeeds
+		e := batch.Entry(0).(publisher.Event)
+		assert.Equal(t, mapstr.M{"count": 2}, e.Content.Fields)
+		batch.Done()
+
+		assert.Equal(t, 3, listener.eventsTotal)
+		assert.Equal(t, 2, listener.eventsFiltered)
+		assert.Equal(t, 1, listener.eventsPublished)
+	})
+}
+
+func TestClientCloseWithFullQueue(t *testing.T) {
+	l := logptest.NewTestingLogger(t, "")
+	// Nothing consumes the queue, so it stays full.
+	q := memqueue.NewQueue(l, nil, memqueue.Settings{
+		Events:        2,
+		MaxGetRequest: 1,
+		FlushTimeout:  time.Millisecond,
+	}, 0, nil)
+
+	p := &loopingEmitter{}
+	pipeline := makePipeline(t, Settings{
+		Processors: testProcessorSupporter{Processor: p},
+	}, q)
+
+	listener := &syncClientListener{}
+	client, err := pipeline.ConnectWith(beat.ClientConfig{ClientListener: listener})
+	require.NoError(t, err)
+
+	// The emitting processor fills the queue and blocks, and so does an
+	// input publishing events, holding the lock of the client.
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < 10; i++ {
+			client.Publish(beat.Event{Fields: mapstr.M{"number": i}})
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		assert.NoError(t, client.Close())
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("closing the client blocked on the full queue")
+	}
+
+	// The events still waiting for the queue are dropped when it closes.
+	require.NoError(t, pipeline.Close())
+	<-published
+	assert.Eventually(t, func() bool {
+		l := listener.counts()
+		return l.eventsDroppedOnPublish > 0 && l.eventsTotal == l.eventsPublished+l.eventsDroppedOnPublish
+	}, 10*time.Second, 10*time.Millisecond, "all the events must be published or dropped")
 }
 
 func TestClientWaitClose(t *testing.T) {
@@ -444,6 +530,62 @@ func (p *testProcessor) Run(in *beat.Event) (event *beat.Event, err error) {
 	return p.processorFn(in)
 }
 
+// emittingProcessor drops the events and emits their count when it is
+// stopped.
+type emittingProcessor struct {
+	count int
+	emit  func(beat.Event)
+}
+
+func (p *emittingProcessor) String() string { return "emittingProcessor" }
+
+func (p *emittingProcessor) Run(in *beat.Event) (*beat.Event, error) {
+	p.count++
+	return nil, nil
+}
+
+func (p *emittingProcessor) StartEmitting(emit func(beat.Event)) {
+	p.emit = emit
+}
+
+func (p *emittingProcessor) StopEmitting() {
+	p.emit(beat.Event{Fields: mapstr.M{"count": p.count}})
+}
+
+// loopingEmitter emits events until it is stopped, then emits a last one.
+type loopingEmitter struct {
+	emittingProcessor
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (p *loopingEmitter) Run(in *beat.Event) (*beat.Event, error) {
+	return in, nil
+}
+
+func (p *loopingEmitter) StartEmitting(emit func(beat.Event)) {
+	p.emit = emit
+	p.done = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for {
+			select {
+			case <-p.done:
+				return
+			default:
+				emit(beat.Event{Fields: mapstr.M{"emitted": true}})
+			}
+		}
+	}()
+}
+
+func (p *loopingEmitter) StopEmitting() {
+	close(p.done)
+	p.wg.Wait()
+	p.emittingProcessor.StopEmitting()
+}
+
 type processorList struct {
 	processors []beat.Processor
 }
@@ -508,3 +650,39 @@ func (m *mockClientListener) Published() {
 func (m *mockClientListener) DroppedOnPublish(beat.Event) {
 	m.eventsDroppedOnPublish++
 }
+
+// syncClientListener is a mockClientListener safe for concurrent use.
+type syncClientListener struct {
+	mu sync.Mutex
+	mockClientListener
+}
+
+func (m *syncClientListener) counts() mockClientListener {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mockClientListener
+}
+
+func (m *syncClientListener) NewEvent() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mockClientListener.NewEvent()
+}
+
+func (m *syncClientListener) Filtered() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mockClientListener.Filtered()
+}
+
+func (m *syncClientListener) Published() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mockClientListener.Published()
+}
+
+func (m *syncClientListener) DroppedOnPublish(e beat.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mockClientListener.DroppedOnPublish(e)
+}
@@ -35,6 +35,9 @@ type Config struct {
 
 	// Event queue
 	Queue config.Namespace `config:"queue"`
+
+	// Storage of the events outputs failed to publish
+	DeadLetterQueue *config.C `config:"dead_letter_queue"`
 }
 
 // validateClientConfig checks a ClientConfig can be used with (*Pipeline).ConnectWith.
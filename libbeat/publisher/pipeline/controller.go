@@ -69,6 +69,10 @@ type outputController struct {
 	// configuration reloading which doesn't have access to this
 	// setting.
 	inputQueueSize int
+
+	// deadLetter is the dead letter queue of the pipeline, nil if disabled.
+	// It's shared by all outputs the controller is set with.
+	deadLetter *deadLetterQueue
 }
 
 type producerRequest struct {
@@ -88,6 +92,7 @@ func newOutputController(
 	retryObserver retryObserver,
 	queueFactory queue.QueueFactory,
 	inputQueueSize int,
+	deadLetter *deadLetterQueue,
 ) (*outputController, error) {
 	controller := &outputController{
 		beat:           beat,
@@ -96,6 +101,7 @@ func newOutputController(
 		workerChan:     make(chan publisher.Batch),
 		consumer:       newEventConsumer(monitors.Logger, retryObserver),
 		inputQueueSize: inputQueueSize,
+		deadLetter:     deadLetter,
 	}
 
 	return controller, nil
@@ -117,6 +123,12 @@ func (c *outputController) WaitClose(timeout time.Duration) error {
 		out.Close()
 	}
 
+	if c.deadLetter != nil {
+		if err := c.deadLetter.Close(); err != nil {
+			c.monitors.Logger.Errorf("Failed to close the dead letter queue: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -158,6 +170,7 @@ func (c *outputController) Set(outGrp outputs.Group) {
 			ch:         targetChan,
 			batchSize:  outGrp.BatchSize,
 			timeToLive: outGrp.Retry + 1,
+			deadLetter: c.deadLetter,
 		})
 }
 
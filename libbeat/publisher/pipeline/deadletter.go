@@ -0,0 +1,603 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common/cfgtype"
+	"github.com/elastic/beats/v7/libbeat/idxmgmt"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/file"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/paths"
+)
+
+const deadLetterFilename = "dead_letter"
+
+var errRetriesExhausted = errors.New("maximum number of retries reached")
+
+// DeadLetterConfig configures the dead letter queue, storing the events
+// outputs permanently failed to publish.
+type DeadLetterConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// Path of the directory storing dead letters. Defaults to "dead_letter"
+	// in the data directory.
+	Path          string           `config:"path"`
+	MaxFileSize   cfgtype.ByteSize `config:"max_file_size" validate:"min=1"`
+	NumberOfFiles uint             `config:"number_of_files"`
+
+	// Output, if set, publishes dead letters to a secondary output instead
+	// of storing them on disk.
+	Output     conf.Namespace `config:"output"`
+	BufferSize int            `config:"buffer_size" validate:"min=1"`
+	BulkSize   int            `config:"bulk_max_size" validate:"min=1"`
+	Backoff    struct {
+		Init time.Duration `config:"init" validate:"nonzero"`
+		Max  time.Duration `config:"max" validate:"nonzero"`
+	} `config:"backoff"`
+}
+
+// DeadLetterRecord is an event stored in the dead letter queue.
+type DeadLetterRecord struct {
+	// Timestamp is the time the event was dead lettered.
+	Timestamp time.Time       `json:"@timestamp"`
+	Reason    string          `json:"reason"`
+	Event     DeadLetterEvent `json:"event"`
+}
+
+// DeadLetterEvent is the original event of a DeadLetterRecord.
+type DeadLetterEvent struct {
+	Timestamp time.Time `json:"@timestamp"`
+	Meta      mapstr.M  `json:"@metadata,omitempty"`
+	Fields    mapstr.M  `json:"fields"`
+}
+
+// BeatEvent returns the event to publish it again.
+func (e DeadLetterEvent) BeatEvent() beat.Event {
+	return beat.Event{Timestamp: e.Timestamp, Meta: e.Meta, Fields: e.Fields}
+}
+
+// DefaultDeadLetterConfig returns the default dead letter queue settings.
+func DefaultDeadLetterConfig() DeadLetterConfig {
+	c := DeadLetterConfig{
+		MaxFileSize:   10 * 1024 * 1024,
+		NumberOfFiles: 100,
+		BufferSize:    4096,
+		BulkSize:      50,
+	}
+	c.Backoff.Init = time.Second
+	c.Backoff.Max = time.Minute
+	return c
+}
+
+// ReadDeadLetterConfig unpacks the `dead_letter_queue` settings.
+func ReadDeadLetterConfig(cfg *conf.C) (DeadLetterConfig, error) {
+	c := DefaultDeadLetterConfig()
+	if cfg == nil {
+		return c, nil
+	}
+	if err := cfg.Unpack(&c); err != nil {
+		return c, fmt.Errorf("invalid dead_letter_queue settings: %w", err)
+	}
+	return c, nil
+}
+
+func (c *DeadLetterConfig) Validate() error {
+	if c.NumberOfFiles < 2 || c.NumberOfFiles > file.MaxBackupsLimit {
+		return fmt.Errorf("dead_letter_queue.number_of_files must be between 2 and %v", file.MaxBackupsLimit)
+	}
+	if c.Output.IsSet() && c.Path != "" {
+		return errors.New("dead_letter_queue.path and dead_letter_queue.output are mutually exclusive")
+	}
+	return nil
+}
+
+// DirectoryPath returns the directory storing dead letters on disk.
+func (c DeadLetterConfig) DirectoryPath() string {
+	if c.Path == "" {
+		return paths.Resolve(paths.Data, deadLetterFilename)
+	}
+	return c.Path
+}
+
+// DeadLetterFiles returns the files of the dead letter queue in dir, oldest
+// first.
+func DeadLetterFiles(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, deadLetterFilename+"-*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Files are named after their creation date, with an increasing suffix
+	// for files created the same day.
+	type deadLetterFileName struct {
+		path  string
+		date  string
+		index int
+	}
+	names := make([]deadLetterFileName, 0, len(paths))
+	for _, path := range paths {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), deadLetterFilename+"-"), ".ndjson")
+		date, index, _ := strings.Cut(name, "-")
+		if _, err := time.Parse(file.DateFormat, date); err != nil {
+			continue
+		}
+		f := deadLetterFileName{path: path, date: date}
+		if index != "" {
+			if f.index, err = strconv.Atoi(index); err != nil {
+				continue
+			}
+		}
+		names = append(names, f)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].date != names[j].date {
+			return names[i].date < names[j].date
+		}
+		return names[i].index < names[j].index
+	})
+
+	files := make([]string, 0, len(names))
+	for _, f := range names {
+		files = append(files, f.path)
+	}
+	return files, nil
+}
+
+// ReadDeadLetterFile calls fn for every record of a dead letter file.
+func ReadDeadLetterFile(path string, fn func(DeadLetterRecord) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 100*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record DeadLetterRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("invalid dead letter in %v at line %d: %w", path, line, err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ReplayDeadLetterFile publishes the events of a dead letter file to client,
+// bulkSize events at a time, waiting for each batch to be acknowledged. Batches
+// the output asks to retry are retried up to maxAttempts times. It returns the
+// number of events acknowledged, rejected and dropped by the output.
+func ReplayDeadLetterFile(ctx context.Context, client outputs.Client, path string, bulkSize, maxAttempts int, backoff time.Duration) (acked, rejected, dropped int, err error) {
+	var pending []publisher.Event
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		events := pending
+		for attempt := 1; ; attempt++ {
+			batch := &deadLetterBatch{events: events, signal: make(chan []publisher.Event, 1)}
+			if err := client.Publish(ctx, batch); err != nil {
+				batch.Retry()
+			}
+
+			var retry []publisher.Event
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case retry = <-batch.signal:
+			}
+			deadLettered := len(batch.deadLettered)
+			rejected += deadLettered
+			if batch.dropped {
+				dropped += len(events) - deadLettered
+				break
+			}
+			acked += len(events) - len(retry) - deadLettered
+			if len(retry) == 0 {
+				break
+			}
+			if attempt >= maxAttempts {
+				return fmt.Errorf("output failed to publish %d events after %d attempts", len(retry), attempt)
+			}
+			events = retry
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+		pending = pending[:0]
+		return nil
+	}
+
+	err = ReadDeadLetterFile(path, func(r DeadLetterRecord) error {
+		pending = append(pending, publisher.Event{Content: r.Event.BeatEvent()})
+		if len(pending) < bulkSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	return acked, rejected, dropped, err
+}
+
+// deadLetterQueue receives the events outputs give up on, and stores them
+// on disk or publishes them to a secondary output.
+type deadLetterQueue struct {
+	log      *logp.Logger
+	observer deadLetterObserver
+	store    deadLetterStore
+	now      func() time.Time
+}
+
+type deadLetterStore interface {
+	store(records []DeadLetterRecord, events []publisher.Event) (int, error)
+	Close() error
+}
+
+type deadLetterObserver interface {
+	// Events were stored in the dead letter queue.
+	eventsDeadLettered(int)
+	// Events could not be stored in the dead letter queue and were lost.
+	deadLetterFailed(int)
+}
+
+func newDeadLetterQueue(beatInfo beat.Info, im outputs.IndexManager, log *logp.Logger, observer deadLetterObserver, cfg *conf.C) (*deadLetterQueue, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	c, err := ReadDeadLetterConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Enabled {
+		return nil, nil
+	}
+
+	log = log.Named("dead_letter")
+	q := &deadLetterQueue{log: log, observer: observer, now: time.Now}
+	if c.Output.IsSet() {
+		q.store, err = newDeadLetterOutput(beatInfo, im, log, c)
+	} else {
+		q.store, err = newDeadLetterFile(log, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// DeadLetter stores the events, it implements publisher.DeadLetterer for the
+// batches of the pipeline.
+func (q *deadLetterQueue) DeadLetter(events []publisher.Event, reason error) {
+	if len(events) == 0 {
+		return
+	}
+
+	if reason == nil {
+		reason = errors.New("unknown error")
+	}
+
+	now := q.now().UTC()
+	records := make([]DeadLetterRecord, len(events))
+	for i, e := range events {
+		records[i] = DeadLetterRecord{
+			Timestamp: now,
+			Reason:    reason.Error(),
+			Event: DeadLetterEvent{
+				Timestamp: e.Content.Timestamp,
+				Meta:      e.Content.Meta,
+				Fields:    e.Content.Fields,
+			},
+		}
+	}
+
+	stored, err := q.store.store(records, events)
+	q.observer.eventsDeadLettered(stored)
+	if failed := len(events) - stored; failed > 0 {
+		q.observer.deadLetterFailed(failed)
+		q.log.Errorf("Failed to store %d events in the dead letter queue: %v", failed, err)
+	}
+}
+
+func (q *deadLetterQueue) Close() error {
+	return q.store.Close()
+}
+
+// deadLetterFile stores dead letters as NDJSON in rotated files.
+type deadLetterFile struct {
+	mu      sync.Mutex
+	rotator *file.Rotator
+}
+
+func newDeadLetterFile(log *logp.Logger, c DeadLetterConfig) (*deadLetterFile, error) {
+	dir := c.DirectoryPath()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create dead letter queue directory: %w", err)
+	}
+	rotator, err := file.NewFileRotator(
+		filepath.Join(dir, deadLetterFilename),
+		file.MaxSizeBytes(uint(c.MaxFileSize)),
+		file.MaxBackups(c.NumberOfFiles),
+		file.Permissions(0o600),
+		file.RotateOnStartup(false),
+		file.WithLogger(log.Named("rotator").With(logp.Namespace("rotator"))),
+	)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Storing dead letters in %v", dir)
+	return &deadLetterFile{rotator: rotator}, nil
+}
+
+func (f *deadLetterFile) store(records []DeadLetterRecord, _ []publisher.Event) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var errs []error
+	stored := 0
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if _, err := f.rotator.Write(append(line, '\n')); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		stored++
+	}
+	if err := f.rotator.Sync(); err != nil {
+		// Nothing written can be considered stored if it isn't synced.
+		errs = append(errs, fmt.Errorf("failed to sync dead letter file: %w", err))
+		return 0, errors.Join(errs...)
+	}
+	return stored, errors.Join(errs...)
+}
+
+func (f *deadLetterFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rotator.Close()
+}
+
+// deadLetterOutput publishes dead letters to a secondary output. The events
+// are buffered in memory until the output acknowledges them.
+type deadLetterOutput struct {
+	log       *logp.Logger
+	client    outputs.Client
+	events    chan publisher.Event
+	bulkSize  int
+	backoffFn func() time.Duration
+	reset     func()
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newDeadLetterOutput(beatInfo beat.Info, im outputs.IndexManager, log *logp.Logger, c DeadLetterConfig) (*deadLetterOutput, error) {
+	if im == nil {
+		supporter, err := idxmgmt.MakeDefaultSupport(nil, log)(log, beatInfo, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dead letter queue output: %w", err)
+		}
+		im = supporter
+	}
+	group, err := outputs.Load(im, beatInfo, nil, c.Output.Name(), c.Output.Config())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dead letter queue output: %w", err)
+	}
+	if len(group.Clients) == 0 {
+		return nil, errors.New("dead letter queue output has no clients")
+	}
+
+	o := &deadLetterOutput{
+		log:      log,
+		client:   group.Clients[0],
+		events:   make(chan publisher.Event, c.BufferSize),
+		bulkSize: c.BulkSize,
+	}
+	o.ctx, o.cancel = context.WithCancel(context.Background())
+	backoff := c.Backoff.Init
+	o.backoffFn = func() time.Duration {
+		d := backoff
+		backoff = min(2*backoff, c.Backoff.Max)
+		return d
+	}
+	o.reset = func() { backoff = c.Backoff.Init }
+
+	log.Infof("Publishing dead letters to the %v output", c.Output.Name())
+	o.wg.Add(1)
+	go o.run()
+	return o, nil
+}
+
+func (o *deadLetterOutput) store(records []DeadLetterRecord, events []publisher.Event) (int, error) {
+	for i, e := range events {
+		fields := e.Content.Fields.Clone()
+		if fields == nil {
+			fields = mapstr.M{}
+		}
+		fields["dead_letter"] = mapstr.M{
+			"reason":    records[i].Reason,
+			"timestamp": records[i].Timestamp,
+		}
+		e.Content.Fields = fields
+		e.Content.Private = nil
+
+		select {
+		case o.events <- e:
+		default:
+			return i, errors.New("dead letter queue buffer is full")
+		}
+	}
+	return len(events), nil
+}
+
+func (o *deadLetterOutput) Close() error {
+	o.cancel()
+	o.wg.Wait()
+	if n := len(o.events); n > 0 {
+		o.log.Warnf("Dropping %d dead letters not yet published", n)
+	}
+	return o.client.Close()
+}
+
+func (o *deadLetterOutput) run() {
+	defer o.wg.Done()
+
+	connected := false
+	var pending []publisher.Event
+	for {
+		if len(pending) == 0 {
+			select {
+			case <-o.ctx.Done():
+				return
+			case e := <-o.events:
+				pending = append(pending, e)
+			}
+		}
+		for len(pending) < o.bulkSize && len(o.events) > 0 {
+			pending = append(pending, <-o.events)
+		}
+
+		if !connected {
+			if err := o.connect(); err != nil {
+				o.log.Errorf("Failed to connect to the dead letter queue output: %v", err)
+				if !o.wait() {
+					return
+				}
+				continue
+			}
+			connected = true
+		}
+
+		batch := &deadLetterBatch{events: pending, signal: make(chan []publisher.Event, 1)}
+		if err := o.client.Publish(o.ctx, batch); err != nil {
+			o.log.Errorf("Failed to publish dead letters: %v", err)
+			if _, ok := o.client.(outputs.NetworkClient); ok {
+				_ = o.client.Close()
+				connected = false
+			}
+		}
+
+		select {
+		case <-o.ctx.Done():
+			return
+		case pending = <-batch.signal:
+		}
+		if n := len(batch.deadLettered); n > 0 {
+			o.log.Errorf("Dead letter queue output rejected %d events", n)
+		}
+		if len(pending) > 0 {
+			if !o.wait() {
+				return
+			}
+		} else {
+			o.reset()
+		}
+	}
+}
+
+func (o *deadLetterOutput) connect() error {
+	if c, ok := o.client.(outputs.Connectable); ok {
+		return c.Connect(o.ctx)
+	}
+	return nil
+}
+
+// wait waits for the backoff duration, it returns false if the output is
+// closed in the meantime.
+func (o *deadLetterOutput) wait() bool {
+	select {
+	case <-o.ctx.Done():
+		return false
+	case <-time.After(o.backoffFn()):
+		return true
+	}
+}
+
+// deadLetterBatch is the batch published to the dead letter queue output. It
+// sends the events to retry, if any, on signal. The events the output rejects
+// are collected in deadLettered, to be read once signaled.
+type deadLetterBatch struct {
+	events  []publisher.Event
+	signal  chan []publisher.Event
+	once    sync.Once
+	dropped bool
+
+	mu           sync.Mutex
+	deadLettered []publisher.Event
+}
+
+func (b *deadLetterBatch) send(retry []publisher.Event) {
+	b.once.Do(func() { b.signal <- retry })
+}
+
+func (b *deadLetterBatch) Events() []publisher.Event { return b.events }
+func (b *deadLetterBatch) ACK()                      { b.send(nil) }
+
+func (b *deadLetterBatch) Drop() {
+	b.once.Do(func() {
+		b.dropped = true
+		b.signal <- nil
+	})
+}
+
+func (b *deadLetterBatch) Retry()           { b.send(b.events) }
+func (b *deadLetterBatch) Cancelled()       { b.send(b.events) }
+func (b *deadLetterBatch) SplitRetry() bool { return false }
+
+func (b *deadLetterBatch) RetryEvents(events []publisher.Event) {
+	b.send(events)
+}
+
+// DeadLetter records the events the output permanently failed to publish.
+func (b *deadLetterBatch) DeadLetter(events []publisher.Event, _ error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deadLettered = append(b.deadLettered, events...)
+	return true
+}
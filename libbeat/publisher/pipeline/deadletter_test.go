@@ -0,0 +1,287 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func newTestDeadLetterQueue(t *testing.T, settings mapstr.M) *deadLetterQueue {
+	t.Helper()
+	settings["enabled"] = true
+	q, err := newDeadLetterQueue(beat.Info{Beat: "test"}, nil, logptest.NewTestingLogger(t, ""), nilObserver, conf.MustNewConfigFrom(settings))
+	require.NoError(t, err)
+	require.NotNil(t, q)
+	return q
+}
+
+func testEvent(message string) publisher.Event {
+	return publisher.Event{Content: beat.Event{
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Meta:      mapstr.M{"index": "logs"},
+		Fields:    mapstr.M{"message": message},
+	}}
+}
+
+func TestDeadLetterQueueDisabled(t *testing.T) {
+	for name, cfg := range map[string]*conf.C{
+		"no settings": nil,
+		"disabled":    conf.MustNewConfigFrom(mapstr.M{"enabled": false}),
+		"not enabled": conf.MustNewConfigFrom(mapstr.M{"path": t.TempDir()}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			q, err := newDeadLetterQueue(beat.Info{}, nil, logptest.NewTestingLogger(t, ""), nilObserver, cfg)
+			require.NoError(t, err)
+			assert.Nil(t, q)
+
+			batch := &ttlBatch{}
+			assert.False(t, publisher.DeadLetter(batch, []publisher.Event{testEvent("a")}, errors.New("failed")))
+		})
+	}
+}
+
+func TestDeadLetterFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	q := newTestDeadLetterQueue(t, mapstr.M{"path": dir})
+	q.now = func() time.Time { return time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC) }
+
+	batch := &ttlBatch{deadLetter: q}
+	require.True(t, publisher.DeadLetter(batch, []publisher.Event{testEvent("a"), testEvent("b")}, errors.New("mapping conflict")))
+	require.NoError(t, q.Close())
+
+	files, err := DeadLetterFiles(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	var records []DeadLetterRecord
+	require.NoError(t, ReadDeadLetterFile(files[0], func(r DeadLetterRecord) error {
+		records = append(records, r)
+		return nil
+	}))
+	require.Len(t, records, 2)
+	assert.Equal(t, "mapping conflict", records[0].Reason)
+	assert.Equal(t, time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC), records[0].Timestamp)
+
+	event := records[1].Event.BeatEvent()
+	assert.Equal(t, testEvent("b").Content.Timestamp, event.Timestamp)
+	assert.Equal(t, mapstr.M{"index": "logs"}, event.Meta)
+	assert.Equal(t, mapstr.M{"message": "b"}, event.Fields)
+}
+
+func TestDeadLetterFilesOrder(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"dead_letter-20240102-10.ndjson", "dead_letter-20240102-2.ndjson", "dead_letter-20240102.ndjson", "dead_letter-20240101-3.ndjson", "dead_letter-20240103.ndjson", "other.ndjson"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+
+	files, err := DeadLetterFiles(dir)
+	require.NoError(t, err)
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	assert.Equal(t, []string{
+		"dead_letter-20240101-3.ndjson",
+		"dead_letter-20240102.ndjson",
+		"dead_letter-20240102-2.ndjson",
+		"dead_letter-20240102-10.ndjson",
+		"dead_letter-20240103.ndjson",
+	}, files)
+}
+
+func TestReduceTTLDeadLettersDroppedEvents(t *testing.T) {
+	dir := t.TempDir()
+	q := newTestDeadLetterQueue(t, mapstr.M{"path": dir})
+
+	guaranteed := testEvent("guaranteed")
+	guaranteed.Flags = publisher.GuaranteedSend
+	batch := &ttlBatch{
+		ttl:        1,
+		events:     []publisher.Event{testEvent("a"), guaranteed, testEvent("b")},
+		deadLetter: q,
+	}
+	assert.True(t, batch.reduceTTL())
+	assert.Equal(t, []publisher.Event{guaranteed}, batch.events)
+	require.NoError(t, q.Close())
+
+	files, err := DeadLetterFiles(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	var messages []interface{}
+	require.NoError(t, ReadDeadLetterFile(files[0], func(r DeadLetterRecord) error {
+		assert.Equal(t, errRetriesExhausted.Error(), r.Reason)
+		messages = append(messages, r.Event.Fields["message"])
+		return nil
+	}))
+	assert.Equal(t, []interface{}{"a", "b"}, messages)
+}
+
+// recordingClient is an output client recording the events it receives, and
+// signaling the batches with the next signal in its list.
+type recordingClient struct {
+	mu      sync.Mutex
+	events  []publisher.Event
+	signals []func(publisher.Batch)
+}
+
+func (c *recordingClient) Close() error   { return nil }
+func (c *recordingClient) String() string { return "recording" }
+
+func (c *recordingClient) Publish(_ context.Context, batch publisher.Batch) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	signal := publisher.Batch.ACK
+	if len(c.signals) > 0 {
+		signal, c.signals = c.signals[0], c.signals[1:]
+	}
+	c.events = append(c.events, batch.Events()...)
+	signal(batch)
+	return nil
+}
+
+func (c *recordingClient) published() []publisher.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]publisher.Event(nil), c.events...)
+}
+
+func TestDeadLetterOutput(t *testing.T) {
+	client := &recordingClient{signals: []func(publisher.Batch){publisher.Batch.Retry}}
+	outputs.RegisterType("dead_letter_test", func(outputs.IndexManager, beat.Info, outputs.Observer, *conf.C) (outputs.Group, error) {
+		return outputs.Success(conf.Namespace{}, 0, 0, nil, client)
+	})
+
+	q := newTestDeadLetterQueue(t, mapstr.M{
+		"output.dead_letter_test": mapstr.M{},
+		"backoff.init":            "1ms",
+	})
+	q.DeadLetter([]publisher.Event{testEvent("a")}, errors.New("rejected"))
+
+	// The first attempt is retried.
+	require.Eventually(t, func() bool { return len(client.published()) == 2 }, 5*time.Second, time.Millisecond)
+	require.NoError(t, q.Close())
+
+	event := client.published()[1].Content
+	assert.Equal(t, "a", event.Fields["message"])
+	reason, err := event.Fields.GetValue("dead_letter.reason")
+	require.NoError(t, err)
+	assert.Equal(t, "rejected", reason)
+}
+
+func TestDeadLetterOutputIndexManager(t *testing.T) {
+	var loadedWith outputs.IndexManager
+	outputs.RegisterType("dead_letter_index_test", func(im outputs.IndexManager, _ beat.Info, _ outputs.Observer, _ *conf.C) (outputs.Group, error) {
+		loadedWith = im
+		return outputs.Success(conf.Namespace{}, 0, 0, nil, &recordingClient{})
+	})
+	cfg := conf.MustNewConfigFrom(mapstr.M{"enabled": true, "output.dead_letter_index_test": mapstr.M{}})
+
+	t.Run("default", func(t *testing.T) {
+		q, err := newDeadLetterQueue(beat.Info{Beat: "test"}, nil, logptest.NewTestingLogger(t, ""), nilObserver, cfg)
+		require.NoError(t, err)
+		defer q.Close()
+		assert.NotNil(t, loadedWith)
+	})
+
+	t.Run("beat", func(t *testing.T) {
+		im := &testIndexManager{}
+		q, err := newDeadLetterQueue(beat.Info{Beat: "test"}, im, logptest.NewTestingLogger(t, ""), nilObserver, cfg)
+		require.NoError(t, err)
+		defer q.Close()
+		assert.Same(t, im, loadedWith)
+	})
+}
+
+type testIndexManager struct{}
+
+func (*testIndexManager) BuildSelector(*conf.C) (outputs.IndexSelector, error) { return nil, nil }
+
+func TestReplayDeadLetterFile(t *testing.T) {
+	dir := t.TempDir()
+	q := newTestDeadLetterQueue(t, mapstr.M{"path": dir})
+	var events []publisher.Event
+	for _, m := range []string{"a", "b", "c", "d", "e"} {
+		events = append(events, testEvent(m))
+	}
+	q.DeadLetter(events, errors.New("rejected"))
+	require.NoError(t, q.Close())
+	files, err := DeadLetterFiles(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	t.Run("acked after retry", func(t *testing.T) {
+		client := &recordingClient{signals: []func(publisher.Batch){
+			func(b publisher.Batch) { b.RetryEvents(b.Events()[1:]) },
+		}}
+		acked, rejected, dropped, err := ReplayDeadLetterFile(t.Context(), client, files[0], 2, 3, time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, 5, acked)
+		assert.Equal(t, 0, rejected)
+		assert.Equal(t, 0, dropped)
+
+		var messages []interface{}
+		for _, e := range client.published() {
+			messages = append(messages, e.Content.Fields["message"])
+		}
+		assert.Equal(t, []interface{}{"a", "b", "b", "c", "d", "e"}, messages)
+	})
+
+	t.Run("dropped", func(t *testing.T) {
+		client := &recordingClient{signals: []func(publisher.Batch){publisher.Batch.Drop}}
+		acked, rejected, dropped, err := ReplayDeadLetterFile(t.Context(), client, files[0], 2, 3, time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, 3, acked)
+		assert.Equal(t, 0, rejected)
+		assert.Equal(t, 2, dropped)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		client := &recordingClient{signals: []func(publisher.Batch){
+			func(b publisher.Batch) {
+				publisher.DeadLetter(b, b.Events()[:1], errors.New("mapping conflict"))
+				b.ACK()
+			},
+		}}
+		acked, rejected, dropped, err := ReplayDeadLetterFile(t.Context(), client, files[0], 2, 3, time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, 4, acked)
+		assert.Equal(t, 1, rejected)
+		assert.Equal(t, 0, dropped)
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		client := &recordingClient{signals: []func(publisher.Batch){publisher.Batch.Retry, publisher.Batch.Retry}}
+		_, _, _, err := ReplayDeadLetterFile(t.Context(), client, files[0], 2, 2, time.Millisecond)
+		assert.ErrorContains(t, err, "failed to publish 2 events after 2 attempts")
+	})
+}
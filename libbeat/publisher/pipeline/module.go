@@ -92,6 +92,10 @@ func LoadWithSettings(
 
 	name := beatInfo.Name
 
+	if settings.DeadLetterQueue == nil {
+		settings.DeadLetterQueue = config.DeadLetterQueue
+	}
+
 	out, err := loadOutput(monitors, makeOutput)
 	if err != nil {
 		return nil, err
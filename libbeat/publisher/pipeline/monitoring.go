@@ -25,6 +25,7 @@ type observer interface {
 	pipelineObserver
 	clientObserver
 	retryObserver
+	deadLetterObserver
 
 	cleanup()
 }
@@ -75,6 +76,9 @@ type metricsObserverVars struct {
 
 	eventsDropped, eventsRetry *monitoring.Uint // (retryer) drop/retry counters
 	activeEvents               *monitoring.Uint
+
+	// dead letter queue counters
+	eventsDeadLettered, deadLetterFailed *monitoring.Uint
 }
 
 func newMetricsObserver(metrics *monitoring.Registry) *metricsObserver {
@@ -114,6 +118,14 @@ func newMetricsObserver(metrics *monitoring.Registry) *metricsObserver {
 			// events.dropped counts events that were dropped because errors from
 			// the output workers exceeded the configured maximum retry count.
 			eventsDropped: monitoring.NewUint(reg, "events.dropped"),
+
+			// events.dead_lettered counts events that outputs gave up on and
+			// that were stored in the dead letter queue.
+			eventsDeadLettered: monitoring.NewUint(reg, "events.dead_lettered"),
+
+			// dead_letter.failed counts events that could not be stored in the
+			// dead letter queue.
+			deadLetterFailed: monitoring.NewUint(reg, "dead_letter.failed"),
 		},
 	}
 }
@@ -180,17 +192,29 @@ func (o *metricsObserver) eventsRetry(n int) {
 	o.vars.eventsRetry.Add(uint64(n))
 }
 
+// (dead letter queue) number of events stored in the dead letter queue
+func (o *metricsObserver) eventsDeadLettered(n int) {
+	o.vars.eventsDeadLettered.Add(uint64(n))
+}
+
+// (dead letter queue) number of events the dead letter queue failed to store
+func (o *metricsObserver) deadLetterFailed(n int) {
+	o.vars.deadLetterFailed.Add(uint64(n))
+}
+
 type emptyObserver struct{}
 
 var nilObserver observer = (*emptyObserver)(nil)
 
-func (*emptyObserver) cleanup()            {}
-func (*emptyObserver) clientConnected()    {}
-func (*emptyObserver) clientClosed()       {}
-func (*emptyObserver) newEvent()           {}
-func (*emptyObserver) filteredEvent()      {}
-func (*emptyObserver) publishedEvent()     {}
-func (*emptyObserver) failedPublishEvent() {}
-func (*emptyObserver) eventsACKed(n int)   {}
-func (*emptyObserver) eventsDropped(int)   {}
-func (*emptyObserver) eventsRetry(int)     {}
+func (*emptyObserver) cleanup()               {}
+func (*emptyObserver) clientConnected()       {}
+func (*emptyObserver) clientClosed()          {}
+func (*emptyObserver) newEvent()              {}
+func (*emptyObserver) filteredEvent()         {}
+func (*emptyObserver) publishedEvent()        {}
+func (*emptyObserver) failedPublishEvent()    {}
+func (*emptyObserver) eventsACKed(n int)      {}
+func (*emptyObserver) eventsDropped(int)      {}
+func (*emptyObserver) eventsRetry(int)        {}
+func (*emptyObserver) eventsDeadLettered(int) {}
+func (*emptyObserver) deadLetterFailed(int)   {}
@@ -32,6 +32,7 @@ import (
 	"github.com/elastic/beats/v7/libbeat/publisher/processing"
 	"github.com/elastic/beats/v7/libbeat/publisher/queue"
 	"github.com/elastic/beats/v7/libbeat/publisher/queue/diskqueue"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue/hybridqueue"
 	"github.com/elastic/beats/v7/libbeat/publisher/queue/memqueue"
 	conf "github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
@@ -80,6 +81,14 @@ type Settings struct {
 	Processors processing.Supporter
 
 	InputQueueSize int
+
+	// DeadLetterQueue configures where events the outputs give up on are
+	// stored. Disabled if nil.
+	DeadLetterQueue *conf.C
+
+	// IndexManager is passed to the dead letter queue output, if any. The
+	// default index management support is used if nil.
+	IndexManager outputs.IndexManager
 }
 
 // WaitCloseMode enumerates the possible behaviors of WaitClose in a pipeline.
@@ -146,7 +155,12 @@ func New(
 		return nil, err
 	}
 
-	output, err := newOutputController(beat, monitors, p.observer, queueFactory, settings.InputQueueSize)
+	deadLetter, err := newDeadLetterQueue(beat, settings.IndexManager, monitors.Logger, p.observer, settings.DeadLetterQueue)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := newOutputController(beat, monitors, p.observer, queueFactory, settings.InputQueueSize, deadLetter)
 	if err != nil {
 		return nil, err
 	}
@@ -220,6 +234,7 @@ func (p *Pipeline) ConnectWith(cfg beat.ClientConfig) (beat.Client, error) {
 		eventFlags:     eventFlags,
 		canDrop:        canDrop,
 		observer:       p.observer,
+		emitsReleased:  make(chan struct{}),
 	}
 
 	client.isOpen.Store(true)
@@ -258,6 +273,8 @@ func (p *Pipeline) ConnectWith(cfg beat.ClientConfig) (beat.Client, error) {
 		return nil, fmt.Errorf("client failed to connect because the pipeline is shutting down")
 	}
 
+	client.startEmitting()
+
 	p.observer.clientConnected()
 	return client, nil
 }
@@ -292,6 +309,12 @@ func queueFactoryForUserConfig(queueType string, userConfig *conf.C) (queue.Queu
 			return nil, err
 		}
 		return diskqueue.FactoryForSettings(settings), nil
+	case hybridqueue.QueueType:
+		settings, err := hybridqueue.SettingsForUserConfig(userConfig)
+		if err != nil {
+			return nil, err
+		}
+		return hybridqueue.FactoryForSettings(settings), nil
 	default:
 		return nil, fmt.Errorf("unrecognized queue type '%v'", queueType)
 	}
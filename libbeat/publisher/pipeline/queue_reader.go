@@ -35,6 +35,7 @@ type queueReaderRequest struct {
 	retryer    retryer
 	batchSize  int
 	timeToLive int
+	deadLetter *deadLetterQueue
 }
 
 func makeQueueReader() queueReader {
@@ -58,6 +59,7 @@ func (qr *queueReader) run(logger *logp.Logger) {
 		var batch *ttlBatch
 		if queueBatch != nil {
 			batch = newBatch(req.retryer, queueBatch, req.timeToLive)
+			batch.deadLetter = req.deadLetter
 		}
 		select {
 		case qr.resp <- batch:
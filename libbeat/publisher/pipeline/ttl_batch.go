@@ -49,6 +49,10 @@ type ttlBatch struct {
 	// all split batches descending from the same original batch will
 	// point to the same metadata.
 	split *batchSplitData
+
+	// deadLetter stores the events the output gives up on, if the pipeline
+	// has a dead letter queue.
+	deadLetter *deadLetterQueue
 }
 
 type batchSplitData struct {
@@ -126,18 +130,20 @@ func (b *ttlBatch) SplitRetry() bool {
 	events1 := b.events[:splitIndex]
 	events2 := b.events[splitIndex:]
 	b.retryer.retry(&ttlBatch{
-		events:  events1,
-		done:    splitData.doneCallback(len(events1)),
-		retryer: b.retryer,
-		ttl:     b.ttl,
-		split:   splitData,
+		events:     events1,
+		done:       splitData.doneCallback(len(events1)),
+		retryer:    b.retryer,
+		ttl:        b.ttl,
+		split:      splitData,
+		deadLetter: b.deadLetter,
 	}, false)
 	b.retryer.retry(&ttlBatch{
-		events:  events2,
-		done:    splitData.doneCallback(len(events2)),
-		retryer: b.retryer,
-		ttl:     b.ttl,
-		split:   splitData,
+		events:     events2,
+		done:       splitData.doneCallback(len(events2)),
+		retryer:    b.retryer,
+		ttl:        b.ttl,
+		split:      splitData,
+		deadLetter: b.deadLetter,
 	}, false)
 	return true
 }
@@ -166,6 +172,15 @@ func (b *ttlBatch) RetryEvents(events []publisher.Event) {
 	b.Retry()
 }
 
+// DeadLetter implements publisher.DeadLetterer.
+func (b *ttlBatch) DeadLetter(events []publisher.Event, reason error) bool {
+	if b.deadLetter == nil {
+		return false
+	}
+	b.deadLetter.DeadLetter(events, reason)
+	return true
+}
+
 // reduceTTL reduces the time to live for all events that have no 'guaranteed'
 // sending requirements.  reduceTTL returns true if the batch is still alive.
 func (b *ttlBatch) reduceTTL() bool {
@@ -179,13 +194,17 @@ func (b *ttlBatch) reduceTTL() bool {
 	}
 
 	// filter for events with guaranteed send flags
+	var dropped []publisher.Event
 	events := b.events[:0]
 	for _, event := range b.events {
 		if event.Guaranteed() {
 			events = append(events, event)
+		} else if b.deadLetter != nil {
+			dropped = append(dropped, event)
 		}
 	}
 	b.events = events
+	b.DeadLetter(dropped, errRetriesExhausted)
 
 	if len(b.events) > 0 {
 		b.ttl = -1 // we need infinite retry for all events left in this batch
@@ -116,6 +116,37 @@ func (p *group) All() []beat.Processor {
 	return p.list
 }
 
+// StartEmitting starts the emitting processors of the group. Emitted events
+// are run through the processors following the emitter before being passed
+// to emit.
+func (p *group) StartEmitting(emit func(beat.Event)) {
+	if p == nil {
+		return
+	}
+	for i, sub := range p.list {
+		rest := &group{log: p.log, title: p.title, list: p.list[i+1:]}
+		processors.StartEmitting(sub, func(event beat.Event) {
+			out, err := rest.Run(&event)
+			if err != nil {
+				p.log.Errorf("Failed to publish emitted event: %v", err)
+			}
+			if out != nil {
+				emit(*out)
+			}
+		})
+	}
+}
+
+// StopEmitting stops the emitting processors of the group in order.
+func (p *group) StopEmitting() {
+	if p == nil {
+		return
+	}
+	for _, sub := range p.list {
+		processors.StopEmitting(sub)
+	}
+}
+
 func (p *group) Run(event *beat.Event) (*beat.Event, error) {
 	if p == nil || len(p.list) == 0 {
 		return event, nil
@@ -66,6 +66,14 @@ type Settings struct {
 
 	// UseCompression enables or disables LZ4 compression
 	UseCompression bool
+
+	// EncryptionKey, if set, enables AES-GCM encryption of the frames
+	// written to new segments. Its id is stored in the segment header.
+	EncryptionKey *EncryptionKey
+
+	// PreviousEncryptionKeys are used to read segments that were encrypted
+	// with an earlier key, so keys can be rotated without losing data.
+	PreviousEncryptionKeys []EncryptionKey
 }
 
 // userConfig holds the parameters for a disk queue that are configurable
@@ -80,6 +88,8 @@ type userConfig struct {
 
 	RetryInterval    *time.Duration `config:"retry_interval" validate:"positive"`
 	MaxRetryInterval *time.Duration `config:"max_retry_interval" validate:"positive"`
+
+	Encryption *encryptionConfig `config:"encryption"`
 }
 
 func (c *userConfig) Validate() error {
@@ -163,6 +173,10 @@ func SettingsForUserConfig(config *config.C) (Settings, error) {
 		settings.MaxRetryInterval = *userConfig.MaxRetryInterval
 	}
 
+	if err := userConfig.Encryption.apply(&settings); err != nil {
+		return Settings{}, err
+	}
+
 	return settings, nil
 }
 
@@ -190,7 +204,22 @@ func (settings Settings) segmentPath(segmentID segmentID) string {
 // maxValidFrameSize returns the size of the largest possible frame that
 // can be stored with the current queue settings.
 func (settings Settings) maxValidFrameSize() uint64 {
-	return settings.MaxSegmentSize - segmentHeaderSize
+	return settings.MaxSegmentSize - settings.segmentHeaderSize()
+}
+
+// segmentVersion returns the schema version of the segments written with
+// these settings.
+func (settings Settings) segmentVersion() uint32 {
+	if settings.EncryptionKey != nil {
+		return encryptedSegmentVersion
+	}
+	return currentSegmentVersion
+}
+
+// segmentHeaderSize returns the header size of the segments written with
+// these settings.
+func (settings Settings) segmentHeaderSize() uint64 {
+	return headerSizeForVersion(settings.segmentVersion())
 }
 
 // Given a retry interval, nextRetryInterval returns the next higher level
@@ -310,6 +310,10 @@ func (dq *diskQueue) handleShutdown() {
 		dq.handleDeleterLoopResponse(response)
 	}
 	close(dq.deleterLoop.requestChan)
+
+	// Everything written to the queue is now persisted and the final read
+	// position is saved, so the queue is done.
+	close(dq.done)
 }
 
 // If the pendingFrames list is nonempty, and there are no outstanding
@@ -441,12 +445,13 @@ func (dq *diskQueue) enqueueWriteFrame(frame *writeFrame) {
 	// we need to create a new writing segment.
 	if segment == nil ||
 		newSegmentSize > dq.settings.MaxSegmentSize {
-		segment = &queueSegment{id: dq.segments.nextID}
+		version := dq.settings.segmentVersion()
+		segment = &queueSegment{id: dq.segments.nextID, schemaVersion: &version}
 		dq.segments.writing = append(dq.segments.writing, segment)
 		dq.segments.nextID++
 		// Reset the on-disk size to its initial value, the file's header size
 		// with no frame data.
-		newSegmentSize = segmentHeaderSize
+		newSegmentSize = segment.headerSize()
 	}
 
 	dq.segments.writingSegmentSize = newSegmentSize
@@ -0,0 +1,218 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The segment header reserves keyIDSize bytes for the id of the key used to
+// encrypt the segment's frames. Shorter ids are padded with zeros.
+const keyIDSize = 16
+
+// EncryptionKey is an AES key used to encrypt or decrypt the frames of
+// a segment, along with the id that is stored in the segment header.
+type EncryptionKey struct {
+	ID  string
+	Key []byte
+}
+
+// encryptionConfig holds the user configurable encryption settings of the
+// disk queue.
+type encryptionConfig struct {
+	Enabled bool   `config:"enabled"`
+	Key     string `config:"key"`
+	KeyFile string `config:"key_file"`
+	KeyID   string `config:"key_id"`
+
+	// PreviousKeys are only used to decrypt segments that were written
+	// before the key was rotated.
+	PreviousKeys []encryptionKeyConfig `config:"previous_keys"`
+}
+
+// encryptionKeyConfig is a single key, given either as a base64 string
+// (which can reference the keystore) or as a file containing it.
+type encryptionKeyConfig struct {
+	Key     string `config:"key"`
+	KeyFile string `config:"key_file"`
+	KeyID   string `config:"key_id"`
+}
+
+func (c *encryptionConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Key == "" && c.KeyFile == "" {
+		return errors.New("disk queue encryption requires key or key_file")
+	}
+	return nil
+}
+
+// load decodes the configured key, generating its id from the key if
+// none was given.
+func (c encryptionKeyConfig) load() (EncryptionKey, error) {
+	if c.Key != "" && c.KeyFile != "" {
+		return EncryptionKey{}, errors.New("key and key_file can't be used together")
+	}
+	encoded := c.Key
+	if c.KeyFile != "" {
+		raw, err := os.ReadFile(c.KeyFile)
+		if err != nil {
+			return EncryptionKey{}, fmt.Errorf("couldn't read key_file: %w", err)
+		}
+		encoded = string(raw)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return EncryptionKey{}, fmt.Errorf("key is not valid base64: %w", err)
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return EncryptionKey{}, fmt.Errorf(
+			"key must be 16, 24 or 32 bytes long, got %d", len(key))
+	}
+
+	id := c.KeyID
+	if id == "" {
+		id = keyFingerprint(key)
+	}
+	if len(id) > keyIDSize {
+		return EncryptionKey{}, fmt.Errorf(
+			"key_id %q is longer than %d bytes", id, keyIDSize)
+	}
+	return EncryptionKey{ID: id, Key: key}, nil
+}
+
+// keyFingerprint returns the default id of a key, the hex encoded prefix
+// of its SHA-256 hash.
+func keyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:keyIDSize/2])
+}
+
+// apply sets the encryption keys of the queue settings from the user
+// configuration.
+func (c *encryptionConfig) apply(settings *Settings) error {
+	if c == nil || !c.Enabled {
+		return nil
+	}
+	keyConfig := encryptionKeyConfig{Key: c.Key, KeyFile: c.KeyFile, KeyID: c.KeyID}
+	key, err := keyConfig.load()
+	if err != nil {
+		return fmt.Errorf("invalid disk queue encryption key: %w", err)
+	}
+	settings.EncryptionKey = &key
+
+	ids := map[string]bool{key.ID: true}
+	for i, previousConfig := range c.PreviousKeys {
+		previous, err := previousConfig.load()
+		if err != nil {
+			return fmt.Errorf("invalid disk queue previous_keys.%d: %w", i, err)
+		}
+		if ids[previous.ID] {
+			return fmt.Errorf(
+				"disk queue previous_keys.%d: duplicate key_id %q", i, previous.ID)
+		}
+		ids[previous.ID] = true
+		settings.PreviousEncryptionKeys = append(
+			settings.PreviousEncryptionKeys, previous)
+	}
+	return nil
+}
+
+// encryptionKey returns the key with the given id, or nil if no
+// configured key matches it.
+func (settings Settings) encryptionKey(id string) *EncryptionKey {
+	if settings.EncryptionKey != nil && settings.EncryptionKey.ID == id {
+		return settings.EncryptionKey
+	}
+	for i := range settings.PreviousEncryptionKeys {
+		if settings.PreviousEncryptionKeys[i].ID == id {
+			return &settings.PreviousEncryptionKeys[i]
+		}
+	}
+	return nil
+}
+
+// frameCipher encrypts and authenticates frame data with AES-GCM. Encrypted
+// frame data is the random nonce followed by the sealed event.
+type frameCipher struct {
+	aead cipher.AEAD
+}
+
+func newFrameCipher(key []byte) (*frameCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &frameCipher{aead: aead}, nil
+}
+
+// seal returns the encrypted form of plaintext in a newly allocated buffer.
+func (c *frameCipher) seal(plaintext []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	out := make([]byte, nonceSize, nonceSize+len(plaintext)+c.aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		return nil, fmt.Errorf("couldn't generate nonce: %w", err)
+	}
+	return c.aead.Seal(out, out, plaintext, nil), nil
+}
+
+// open decrypts data in place and returns the plaintext, which is moved to
+// the start of data so its whole capacity can be reused.
+func (c *frameCipher) open(data []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(data) < nonceSize+c.aead.Overhead() {
+		return nil, fmt.Errorf(
+			"encrypted frame is too short (%d bytes)", len(data))
+	}
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+	plaintext, err := c.aead.Open(ciphertext[:0], nonce, ciphertext, nil)
+	if err != nil {
+		// The GCM error doesn't tell more than authentication failed.
+		return nil, errors.New(
+			"authentication failed, the frame is corrupted or the key is wrong")
+	}
+	// GCM can't decrypt into data[:0] directly, the output can't partially
+	// overlap the ciphertext.
+	return data[:copy(data, plaintext)], nil
+}
+
+// encodeKeyID pads the key id to the fixed size stored in segment headers.
+func encodeKeyID(id string) [keyIDSize]byte {
+	var encoded [keyIDSize]byte
+	copy(encoded[:], id)
+	return encoded
+}
+
+// decodeKeyID strips the padding from a key id read from a segment header.
+func decodeKeyID(encoded [keyIDSize]byte) string {
+	return strings.TrimRight(string(encoded[:]), "\x00")
+}
@@ -0,0 +1,332 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+var (
+	testKey1 = []byte("0123456789abcdef0123456789abcdef")
+	testKey2 = []byte("fedcba9876543210")
+)
+
+func TestEncryptionSettingsForUserConfig(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "queue.key")
+	require.NoError(t, os.WriteFile(
+		keyFile, []byte(base64.StdEncoding.EncodeToString(testKey2)+"\n"), 0600))
+
+	tests := map[string]struct {
+		config       map[string]interface{}
+		key          *EncryptionKey
+		previousKeys []EncryptionKey
+		err          string
+	}{
+		"disabled": {
+			config: map[string]interface{}{
+				"key": base64.StdEncoding.EncodeToString(testKey1),
+			},
+		},
+		"key with default id": {
+			config: map[string]interface{}{
+				"enabled": true,
+				"key":     base64.StdEncoding.EncodeToString(testKey1),
+			},
+			key: &EncryptionKey{ID: keyFingerprint(testKey1), Key: testKey1},
+		},
+		"key file and previous key": {
+			config: map[string]interface{}{
+				"enabled":  true,
+				"key_file": keyFile,
+				"key_id":   "2024-06",
+				"previous_keys": []map[string]interface{}{
+					{"key": base64.StdEncoding.EncodeToString(testKey1), "key_id": "2024-01"},
+				},
+			},
+			key:          &EncryptionKey{ID: "2024-06", Key: testKey2},
+			previousKeys: []EncryptionKey{{ID: "2024-01", Key: testKey1}},
+		},
+		"missing key": {
+			config: map[string]interface{}{"enabled": true},
+			err:    "requires key or key_file",
+		},
+		"invalid base64": {
+			config: map[string]interface{}{"enabled": true, "key": "not base64!"},
+			err:    "not valid base64",
+		},
+		"invalid key length": {
+			config: map[string]interface{}{
+				"enabled": true,
+				"key":     base64.StdEncoding.EncodeToString([]byte("short")),
+			},
+			err: "must be 16, 24 or 32 bytes long, got 5",
+		},
+		"key id too long": {
+			config: map[string]interface{}{
+				"enabled": true,
+				"key":     base64.StdEncoding.EncodeToString(testKey1),
+				"key_id":  "a-key-id-longer-than-16-bytes",
+			},
+			err: "longer than 16 bytes",
+		},
+		"duplicate key id": {
+			config: map[string]interface{}{
+				"enabled": true,
+				"key":     base64.StdEncoding.EncodeToString(testKey1),
+				"key_id":  "current",
+				"previous_keys": []map[string]interface{}{
+					{"key": base64.StdEncoding.EncodeToString(testKey2), "key_id": "current"},
+				},
+			},
+			err: `duplicate key_id "current"`,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.MustNewConfigFrom(map[string]interface{}{
+				"max_size":   "1GB",
+				"encryption": tc.config,
+			})
+			settings, err := SettingsForUserConfig(cfg)
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.key, settings.EncryptionKey)
+			assert.Equal(t, tc.previousKeys, settings.PreviousEncryptionKeys)
+		})
+	}
+}
+
+func TestEncryptedSegmentRoundTrip(t *testing.T) {
+	key1 := EncryptionKey{ID: "key1", Key: testKey1}
+	key2 := EncryptionKey{ID: "key2", Key: testKey2}
+
+	tests := map[string]struct {
+		readKey      *EncryptionKey
+		previousKeys []EncryptionKey
+		compress     bool
+		err          string
+	}{
+		"same key": {
+			readKey: &key1,
+		},
+		"same key with compression": {
+			readKey:  &key1,
+			compress: true,
+		},
+		"rotated key": {
+			readKey:      &key2,
+			previousKeys: []EncryptionKey{key1},
+		},
+		"unknown key id": {
+			readKey: &key2,
+			err:     `segment 0 is encrypted with unknown key id "key1"`,
+		},
+		"no key": {
+			err: `segment 0 is encrypted with unknown key id "key1"`,
+		},
+		"wrong key": {
+			readKey: &EncryptionKey{ID: "key1", Key: testKey2},
+			err:     "couldn't decrypt data frame: authentication failed",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			settings := DefaultSettings()
+			settings.Path = t.TempDir()
+			settings.UseCompression = tc.compress
+			settings.EncryptionKey = &key1
+
+			segment := &queueSegment{id: 0}
+			events := []string{"first", "second", "third"}
+			byteCount := writeTestSegment(t, settings, segment, events)
+
+			settings.EncryptionKey = tc.readKey
+			settings.PreviousEncryptionKeys = tc.previousKeys
+			rl := newReaderLoop(settings, nil)
+			response := rl.processRequest(readerLoopRequest{
+				segment:       segment,
+				startPosition: encryptedSegmentHeaderSize,
+				endPosition:   byteCount,
+			})
+			if tc.err != "" {
+				require.ErrorContains(t, response.err, tc.err)
+				assert.Zero(t, response.frameCount)
+				return
+			}
+			require.NoError(t, response.err)
+			require.Equal(t, uint64(len(events)), response.frameCount)
+			for _, message := range events {
+				frame := <-rl.output
+				event, ok := frame.event.(publisher.Event)
+				require.True(t, ok)
+				assert.Equal(t, message, event.Content.Fields["message"])
+			}
+		})
+	}
+}
+
+func TestUnencryptedSegmentsKeepV2Header(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+
+	segment := &queueSegment{id: 0}
+	byteCount := writeTestSegment(t, settings, segment, []string{"message"})
+
+	header, err := readSegmentHeaderWithFrameCount(settings.segmentPath(segment.id))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), header.version)
+	assert.Zero(t, header.options&ENABLE_ENCRYPTION)
+	assert.Equal(t, uint64(segmentHeaderSize), segment.headerSize())
+
+	rl := newReaderLoop(settings, nil)
+	response := rl.processRequest(readerLoopRequest{
+		segment:       segment,
+		startPosition: segmentHeaderSize,
+		endPosition:   byteCount,
+	})
+	require.NoError(t, response.err)
+	assert.Equal(t, uint64(1), response.frameCount)
+}
+
+func TestEncryptedFramesDontContainPlaintext(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+	settings.EncryptionKey = &EncryptionKey{ID: "key1", Key: testKey1}
+
+	segment := &queueSegment{id: 0}
+	writeTestSegment(t, settings, segment, []string{"secret message"})
+
+	data, err := os.ReadFile(settings.segmentPath(segment.id))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret message")
+
+	header, err := readSegmentHeaderWithFrameCount(settings.segmentPath(segment.id))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(encryptedSegmentVersion), header.version)
+	assert.Equal(t, ENABLE_ENCRYPTION, header.options&ENABLE_ENCRYPTION)
+	assert.Equal(t, "key1", decodeKeyID(header.keyID))
+	assert.Equal(t, uint32(1), header.frameCount)
+}
+
+func TestOpenReusesFrameBuffer(t *testing.T) {
+	cipher, err := newFrameCipher(testKey1)
+	require.NoError(t, err)
+	sealed, err := cipher.seal([]byte("secret message"))
+	require.NoError(t, err)
+
+	plaintext, err := cipher.open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "secret message", string(plaintext))
+	// The plaintext starts the buffer, so reading the next frame can reuse
+	// all of it.
+	assert.Same(t, &sealed[0], &plaintext[0])
+	assert.Equal(t, cap(sealed), cap(plaintext))
+}
+
+func TestEncryptedQueue(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+	settings.EncryptionKey = &EncryptionKey{ID: "key1", Key: testKey1}
+
+	q, err := NewQueue(logptest.NewTestingLogger(t, ""), nil, settings, nil)
+	require.NoError(t, err)
+	defer q.Close()
+
+	producer := q.Producer(queue.ProducerConfig{})
+	for _, message := range []string{"first", "second"} {
+		_, ok := producer.Publish(publisher.Event{
+			Content: beat.Event{Fields: mapstr.M{"message": message}},
+		})
+		require.True(t, ok)
+	}
+
+	// Get returns the events that were read from disk so far, which may
+	// not include both yet.
+	var messages []interface{}
+	for len(messages) < 2 {
+		batch, err := q.Get(2)
+		require.NoError(t, err)
+		for i := 0; i < batch.Count(); i++ {
+			event, ok := batch.Entry(i).(publisher.Event)
+			require.True(t, ok)
+			messages = append(messages, event.Content.Fields["message"])
+		}
+		batch.Done()
+	}
+	assert.Equal(t, []interface{}{"first", "second"}, messages)
+}
+
+// writeTestSegment writes the given messages to the segment the same way
+// the queue does, and returns the resulting size of the segment.
+func writeTestSegment(
+	t *testing.T, settings Settings, segment *queueSegment, messages []string,
+) uint64 {
+	var cipher *frameCipher
+	if settings.EncryptionKey != nil {
+		var err error
+		cipher, err = newFrameCipher(settings.EncryptionKey.Key)
+		require.NoError(t, err)
+	}
+	if segment.schemaVersion == nil {
+		// Like the core loop, record the version the segment is written with.
+		version := settings.segmentVersion()
+		segment.schemaVersion = &version
+	}
+	encoder := newEventEncoder(SerializationCBOR)
+	request := writerLoopRequest{}
+	for _, message := range messages {
+		serialized, err := encoder.encode(publisher.Event{
+			Content: beat.Event{Fields: mapstr.M{"message": message}},
+		})
+		require.NoError(t, err)
+		if cipher != nil {
+			serialized, err = cipher.seal(serialized)
+			require.NoError(t, err)
+		}
+		request.frames = append(request.frames, segmentedFrame{
+			frame: &writeFrame{
+				serialized: serialized,
+				producer:   &diskQueueProducer{},
+			},
+			segment: segment,
+		})
+	}
+
+	wl := newWriterLoop(logptest.NewTestingLogger(t, ""), settings)
+	response := wl.processRequest(request)
+	require.Len(t, response.segments, 1)
+	require.NoError(t, wl.outputFile.UpdateCount(response.segments[0].framesWritten))
+	require.NoError(t, wl.outputFile.Close())
+	return response.segments[0].bytesWritten
+}
@@ -0,0 +1,325 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/v7/libbeat/publisher"
+)
+
+// ErrCorruptFrame is reported in SegmentInfo.Err when a segment contains
+// invalid or truncated frames, which TruncateSegment can remove.
+var ErrCorruptFrame = errors.New("corrupt data frame")
+
+// SegmentInfo describes a segment file found in the queue directory, as
+// reported by InspectSegments and ScanSegment.
+type SegmentInfo struct {
+	ID   uint64
+	Path string
+
+	// Size is the size of the segment file on disk.
+	Size int64
+
+	// Version, Compressed, Encrypted and KeyID describe the segment header.
+	Version    uint32
+	Compressed bool
+	Encrypted  bool
+	KeyID      string
+
+	// HeaderFrameCount is the frame count stored in the header. It is 0 if
+	// the segment was not closed cleanly.
+	HeaderFrameCount uint32
+
+	// Frames is the number of valid frames found in the segment, and
+	// ValidSize the position right after the last of them.
+	Frames    int
+	ValidSize int64
+
+	// Err is set if the segment can't be read completely. It wraps
+	// ErrCorruptFrame if the segment has invalid data after its last
+	// valid frame.
+	Err error
+}
+
+// Frame is a data frame read by ScanSegment.
+type Frame struct {
+	SegmentID uint64
+
+	// Index is the position of the frame in its segment, starting at 0.
+	Index int
+
+	// Offset is the position of the frame in the segment. If the segment
+	// is compressed, this is the position in the uncompressed data.
+	Offset int64
+
+	// Size is the size of the frame, including its header and footer.
+	Size int
+
+	Event publisher.Event
+}
+
+// QueueState is the position of the oldest event that hasn't been
+// acknowledged yet, as stored in the queue's state file.
+type QueueState struct {
+	SegmentID  uint64
+	ByteIndex  uint64
+	FrameIndex uint64
+}
+
+// DirectoryPath returns the directory the queue with the given settings
+// stores its segments in.
+func DirectoryPath(settings Settings) string {
+	return settings.directoryPath()
+}
+
+// ReadQueueState reads the queue's state file.
+func ReadQueueState(settings Settings) (QueueState, error) {
+	position, err := queuePositionFromPath(settings.stateFilePath())
+	if err != nil {
+		return QueueState{}, fmt.Errorf("couldn't read queue state: %w", err)
+	}
+	return QueueState{
+		SegmentID:  uint64(position.segmentID),
+		ByteIndex:  position.byteIndex,
+		FrameIndex: position.frameIndex,
+	}, nil
+}
+
+// InspectSegments reads and validates all the segments of the queue, in
+// ascending id order. Problems found in a segment are reported in its
+// SegmentInfo.Err, the returned error is only set if the directory can't
+// be read.
+func InspectSegments(settings Settings) ([]SegmentInfo, error) {
+	ids, err := segmentIDs(settings)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]SegmentInfo, 0, len(ids))
+	for _, id := range ids {
+		info, _ := ScanSegment(settings, id, nil)
+		segments = append(segments, info)
+	}
+	return segments, nil
+}
+
+// segmentIDs returns the ids of the segment files in the queue directory,
+// in ascending order.
+func segmentIDs(settings Settings) ([]uint64, error) {
+	dirEntries, err := os.ReadDir(settings.directoryPath())
+	if err != nil {
+		return nil, fmt.Errorf(
+			"could not read queue directory '%s': %w", settings.directoryPath(), err)
+	}
+	ids := []uint64{}
+	for _, dirEntry := range dirEntries {
+		name, ok := strings.CutSuffix(strings.ToLower(dirEntry.Name()), ".seg")
+		if !ok || dirEntry.IsDir() {
+			continue
+		}
+		if id, err := strconv.ParseUint(name, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// ScanSegment reads all the frames of a segment, validating their checksums
+// and decoding their events. If fn is not nil it is called for every valid
+// frame, and an error returned by fn stops the scan and is returned as is.
+// Invalid data in the segment is reported in the returned SegmentInfo.Err,
+// and stops the scan.
+func ScanSegment(settings Settings, id uint64, fn func(Frame) error) (SegmentInfo, error) {
+	segment := &queueSegment{id: segmentID(id)}
+	info := SegmentInfo{ID: id, Path: settings.segmentPath(segment.id)}
+
+	stat, err := os.Stat(info.Path)
+	if err != nil {
+		info.Err = err
+		return info, nil
+	}
+	info.Size = stat.Size()
+
+	header, err := readSegmentHeaderFromPath(info.Path)
+	if err != nil {
+		info.Err = fmt.Errorf("couldn't read header: %w", err)
+		return info, nil
+	}
+	info.Version = header.version
+	info.HeaderFrameCount = header.frameCount
+	info.Compressed = header.options&ENABLE_COMPRESSION != 0
+	info.Encrypted = header.options&ENABLE_ENCRYPTION != 0
+	if info.Encrypted {
+		info.KeyID = decodeKeyID(header.keyID)
+	}
+	info.ValidSize = int64(headerSizeForVersion(header.version))
+
+	handle, err := segment.getReader(settings)
+	if err != nil {
+		info.Err = err
+		return info, nil
+	}
+	defer handle.Close()
+	if _, err := handle.Seek(info.ValidSize, io.SeekStart); err != nil {
+		info.Err = err
+		return info, nil
+	}
+
+	rl := newReaderLoop(settings, nil)
+	rl.decoder.serializationFormat = handle.serializationFormat
+	for {
+		// The size of compressed data isn't known, its end is found when
+		// reading the next frame fails with io.EOF.
+		remaining := uint64(math.MaxUint64)
+		if !info.Compressed {
+			if info.ValidSize >= info.Size {
+				return info, nil
+			}
+			remaining = uint64(info.Size - info.ValidSize)
+		}
+
+		frame, err := rl.nextFrame(handle, remaining)
+		if err != nil {
+			switch {
+			case info.Compressed && errors.Is(err, io.EOF):
+				// The end of the compressed data.
+			case errors.Is(err, errDecryptFrame), errors.Is(err, errDecodeFrame):
+				// The frame passed its checksum, the data isn't corrupt.
+				info.Err = fmt.Errorf(
+					"frame %d at offset %d: %w", info.Frames, info.ValidSize, err)
+			default:
+				info.Err = fmt.Errorf(
+					"%w %d at offset %d: %w", ErrCorruptFrame, info.Frames, info.ValidSize, err)
+			}
+			return info, nil
+		}
+
+		event, _ := frame.event.(publisher.Event)
+		if fn != nil {
+			err := fn(Frame{
+				SegmentID: id,
+				Index:     info.Frames,
+				Offset:    info.ValidSize,
+				Size:      int(frame.bytesOnDisk),
+				Event:     event,
+			})
+			if err != nil {
+				return info, err
+			}
+		}
+		info.Frames++
+		info.ValidSize += int64(frame.bytesOnDisk)
+	}
+}
+
+func readSegmentHeaderFromPath(path string) (*segmentHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readSegmentHeader(autoRetryReader{file})
+}
+
+// TruncateSegment removes the invalid data after the last valid frame of
+// a segment, and updates the frame count in its header. The queue must not
+// be running. Compressed segments can't be truncated.
+func TruncateSegment(settings Settings, id uint64) (SegmentInfo, error) {
+	info, err := ScanSegment(settings, id, nil)
+	if err != nil {
+		return info, err
+	}
+	if info.Err == nil {
+		return info, nil
+	}
+	if !errors.Is(info.Err, ErrCorruptFrame) {
+		return info, fmt.Errorf("segment %d can't be truncated: %w", id, info.Err)
+	}
+	if info.Compressed {
+		return info, fmt.Errorf("segment %d is compressed and can't be truncated", id)
+	}
+
+	file, err := os.OpenFile(info.Path, os.O_WRONLY, 0600)
+	if err != nil {
+		return info, err
+	}
+	defer file.Close()
+	if err := file.Truncate(info.ValidSize); err != nil {
+		return info, fmt.Errorf("couldn't truncate segment %d: %w", id, err)
+	}
+	if info.Version >= 1 {
+		// The frame count is right after the version in the header.
+		if _, err := file.Seek(4, io.SeekStart); err != nil {
+			return info, err
+		}
+		if err := binary.Write(file, binary.LittleEndian, uint32(info.Frames)); err != nil {
+			return info, fmt.Errorf("couldn't update frame count of segment %d: %w", id, err)
+		}
+		info.HeaderFrameCount = uint32(info.Frames)
+	}
+	if err := file.Sync(); err != nil {
+		return info, err
+	}
+	info.Size = info.ValidSize
+	info.Err = nil
+	return info, nil
+}
+
+// ScanPendingEvents calls fn for every event in the queue that hasn't
+// been acknowledged yet, in queue order. Segments with invalid data are
+// read up to their last valid frame, and reported in the returned list.
+func ScanPendingEvents(settings Settings, fn func(Frame) error) ([]SegmentInfo, error) {
+	state, err := ReadQueueState(settings)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ids, err := segmentIDs(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var invalid []SegmentInfo
+	for _, id := range ids {
+		if id < state.SegmentID {
+			// Fully acknowledged segments that weren't deleted yet.
+			continue
+		}
+		info, err := ScanSegment(settings, id, func(frame Frame) error {
+			if id == state.SegmentID && uint64(frame.Index) < state.FrameIndex {
+				return nil
+			}
+			return fn(frame)
+		})
+		if err != nil {
+			return invalid, err
+		}
+		if info.Err != nil {
+			invalid = append(invalid, info)
+		}
+	}
+	return invalid, nil
+}
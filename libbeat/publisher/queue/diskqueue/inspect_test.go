@@ -0,0 +1,118 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package diskqueue
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectSegments(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+
+	size := writeTestSegment(t, settings, &queueSegment{id: 1}, []string{"a", "b", "c"})
+	writeTestSegment(t, settings, &queueSegment{id: 2}, []string{"d"})
+	appendToSegment(t, settings, 2, []byte{1, 2, 3, 4, 5, 6})
+	require.NoError(t, os.WriteFile(settings.segmentPath(3), []byte{1}, 0600))
+
+	segments, err := InspectSegments(settings)
+	require.NoError(t, err)
+	require.Len(t, segments, 3)
+
+	assert.Equal(t, uint64(1), segments[0].ID)
+	assert.Equal(t, uint32(currentSegmentVersion), segments[0].Version)
+	assert.Equal(t, uint32(3), segments[0].HeaderFrameCount)
+	assert.Equal(t, 3, segments[0].Frames)
+	assert.Equal(t, int64(size), segments[0].ValidSize)
+	assert.Equal(t, int64(size), segments[0].Size)
+	assert.NoError(t, segments[0].Err)
+
+	assert.Equal(t, 1, segments[1].Frames)
+	assert.Equal(t, segments[1].Size-6, segments[1].ValidSize)
+	assert.ErrorIs(t, segments[1].Err, ErrCorruptFrame)
+
+	assert.ErrorContains(t, segments[2].Err, "couldn't read header")
+	assert.NotErrorIs(t, segments[2].Err, ErrCorruptFrame)
+}
+
+func TestTruncateSegment(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+	settings.EncryptionKey = &EncryptionKey{ID: "key1", Key: testKey1}
+
+	writeTestSegment(t, settings, &queueSegment{id: 0}, []string{"a", "b"})
+	appendToSegment(t, settings, 0, []byte{200, 0, 0, 0, 1, 2})
+
+	info, err := TruncateSegment(settings, 0)
+	require.NoError(t, err)
+	assert.NoError(t, info.Err)
+	assert.Equal(t, 2, info.Frames)
+	assert.Equal(t, uint32(2), info.HeaderFrameCount)
+
+	info, err = ScanSegment(settings, 0, nil)
+	require.NoError(t, err)
+	assert.NoError(t, info.Err)
+	assert.Equal(t, info.ValidSize, info.Size)
+
+	// A segment that can't be decrypted must not be truncated.
+	settings.EncryptionKey = &EncryptionKey{ID: "key1", Key: testKey2}
+	_, err = TruncateSegment(settings, 0)
+	require.ErrorContains(t, err, "can't be truncated")
+	info, err = ScanSegment(settings, 0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, info.ValidSize, int64(encryptedSegmentHeaderSize))
+	assert.Greater(t, info.Size, info.ValidSize)
+}
+
+func TestScanPendingEvents(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+
+	writeTestSegment(t, settings, &queueSegment{id: 0}, []string{"a", "b"})
+	writeTestSegment(t, settings, &queueSegment{id: 1}, []string{"c", "d", "e"})
+	writeTestSegment(t, settings, &queueSegment{id: 2}, []string{"f"})
+
+	// The first segment and the first frame of the second were acknowledged.
+	file, err := os.Create(settings.stateFilePath())
+	require.NoError(t, err)
+	require.NoError(t, writeQueuePositionToHandle(file, queuePosition{
+		segmentID: 1, frameIndex: 1,
+	}))
+	require.NoError(t, file.Close())
+
+	var messages []interface{}
+	invalid, err := ScanPendingEvents(settings, func(frame Frame) error {
+		messages = append(messages, frame.Event.Content.Fields["message"])
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Empty(t, invalid)
+	assert.Equal(t, []interface{}{"d", "e", "f"}, messages)
+}
+
+func appendToSegment(t *testing.T, settings Settings, id segmentID, data []byte) {
+	file, err := os.OpenFile(settings.segmentPath(id), os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = file.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+}
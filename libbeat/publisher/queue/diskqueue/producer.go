@@ -69,6 +69,14 @@ func (producer *diskQueueProducer) publish(
 			"Couldn't serialize incoming event: %v", err)
 		return false
 	}
+	if producer.queue.cipher != nil {
+		serialized, err = producer.queue.cipher.seal(serialized)
+		if err != nil {
+			producer.queue.logger.Errorf(
+				"Couldn't encrypt incoming event: %v", err)
+			return false
+		}
+	}
 	request := producerWriteRequest{
 		frame: &writeFrame{
 			serialized: serialized,
@@ -39,6 +39,13 @@ type diskQueue struct {
 	// Metadata related to the segment files.
 	segments diskQueueSegments
 
+	// The number of events waiting to be read when the queue was opened.
+	restoredEvents int
+
+	// If encryption is enabled, cipher encrypts the frames of new events
+	// with settings.EncryptionKey.
+	cipher *frameCipher
+
 	// Metadata related to consumer acks / positions of the oldest remaining
 	// frame.
 	acks *diskQueueACKs
@@ -126,6 +133,18 @@ func NewQueue(
 	}
 	observer.MaxBytes(int(settings.MaxBufferSize))
 
+	var cipher *frameCipher
+	if settings.EncryptionKey != nil {
+		var err error
+		cipher, err = newFrameCipher(settings.EncryptionKey.Key)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"couldn't set up disk queue encryption: %w", err)
+		}
+		logger.Infof(
+			"Disk queue encryption enabled with key id %q", settings.EncryptionKey.ID)
+	}
+
 	// Create the given directory path if it doesn't exist.
 	err := os.MkdirAll(settings.directoryPath(), os.ModePerm)
 	if err != nil {
@@ -216,6 +235,9 @@ func NewQueue(
 		observer: observer,
 		settings: settings,
 
+		restoredEvents: max(activeFrameCount, 0),
+		cipher:         cipher,
+
 		segments: diskQueueSegments{
 			reading:          initialSegments,
 			acked:            ackedSegments,
@@ -268,6 +290,12 @@ func (dq *diskQueue) BufferConfig() queue.BufferConfig {
 	return queue.BufferConfig{MaxEvents: 0}
 }
 
+// RestoredEvents returns the number of events from a previous run that were
+// waiting to be read when the queue was opened.
+func (dq *diskQueue) RestoredEvents() int {
+	return dq.restoredEvents
+}
+
 func (dq *diskQueue) Producer(cfg queue.ProducerConfig) queue.Producer {
 	return &diskQueueProducer{
 		queue:   dq,
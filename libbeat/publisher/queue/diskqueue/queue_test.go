@@ -89,3 +89,19 @@ func (t testQueue) Close() error {
 	err := t.diskQueue.Close()
 	return err
 }
+
+func TestDoneAfterClose(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Path = t.TempDir()
+	q, err := NewQueue(logptest.NewTestingLogger(t, ""), nil, settings, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = q.Close()
+	select {
+	case <-q.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("disk queue did not shut down")
+	}
+}
@@ -19,12 +19,20 @@ package diskqueue
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/elastic/beats/v7/libbeat/publisher/queue"
 )
 
+// Errors in frames whose checksum is valid, the frame data is intact but
+// can't be turned into an event.
+var (
+	errDecryptFrame = errors.New("couldn't decrypt data frame")
+	errDecodeFrame  = errors.New("couldn't decode data frame")
+)
+
 // startPosition and endPosition are absolute byte offsets into the segment
 // file on disk, and must point to frame boundaries.
 type readerLoopRequest struct {
@@ -244,6 +252,16 @@ func (rl *readerLoop) nextFrame(handle *segmentReader, maxLength uint64) (*readF
 			frameLength, duplicateLength)
 	}
 
+	if handle.cipher != nil {
+		// Frames are decrypted in place, the decoder reads the plaintext
+		// from the start of its buffer.
+		plaintext, err := handle.cipher.open(bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errDecryptFrame, err)
+		}
+		rl.decoder.buf = plaintext
+	}
+
 	event, err := rl.decoder.Decode()
 	if err != nil {
 		// Unlike errors in the segment or frame metadata, this is entirely
@@ -252,7 +270,7 @@ func (rl *readerLoop) nextFrame(handle *segmentReader, maxLength uint64) (*readF
 		// TODO: Rather than pass this error back to the read request, which
 		// discards the rest of the segment, we should just log the error and
 		// advance to the next frame, which is likely still valid.
-		return nil, fmt.Errorf("couldn't decode data frame: %w", err)
+		return nil, fmt.Errorf("%w: %w", errDecodeFrame, err)
 	}
 
 	frame := &readFrame{
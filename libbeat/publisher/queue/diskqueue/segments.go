@@ -91,7 +91,8 @@ type queueSegment struct {
 	id segmentID
 
 	// If this segment was loaded from a previous session, schemaVersion
-	// points to the file schema version that was read from its header.
+	// points to the file schema version that was read from its header,
+	// otherwise to the version it is written with by this session.
 	// This is only used by queueSegment.headerSize(), which is used in
 	// maybeReadPending to calculate the position of the first data frame.
 	schemaVersion *uint32
@@ -121,7 +122,8 @@ type queueSegment struct {
 }
 
 type segmentHeader struct {
-	// The schema version for this segment file. Current schema version is 2.
+	// The schema version for this segment file. Current schema version is 2,
+	// or 3 for encrypted segments.
 	version uint32
 
 	// If the segment file has been completely written, this field contains
@@ -133,6 +135,10 @@ type segmentHeader struct {
 
 	// options holds flags to enable features, for example compression.
 	options uint32
+
+	// keyID is the id of the key the frames are encrypted with, if
+	// ENABLE_ENCRYPTION is set. Only present in schema version >= 3.
+	keyID [keyIDSize]byte
 }
 
 type WriteCloseSyncer interface {
@@ -143,16 +149,25 @@ type WriteCloseSyncer interface {
 
 const currentSegmentVersion = 2
 
+// Encrypted segments use schema version 3, which adds the encryption key id
+// to the header. Unencrypted segments keep using version 2 so that older
+// versions can still read the queue.
+const encryptedSegmentVersion = 3
+
 // Segment headers are currently a 4-byte version, a 4-byte frame count and 1-byte options.
 // In contexts where the segment may have been created by an earlier version,
 // instead use (queueSegment).headerSize() which accounts for the schema
 // version of the target segment.
 const segmentHeaderSize = 12
 
+// Encrypted segment headers are followed by a 16-byte encryption key id.
+const encryptedSegmentHeaderSize = segmentHeaderSize + keyIDSize
+
 const (
 	_                  uint32 = 1 << iota // 0x1
 	ENABLE_COMPRESSION                    // 0x2
 	ENABLE_PROTOBUF                       // 0x4
+	ENABLE_ENCRYPTION                     // 0x8
 )
 
 // Sort order: we store loaded segments in ascending order by their id.
@@ -214,11 +229,22 @@ func scanExistingSegments(logger *logp.Logger, pathStr string) ([]*queueSegment,
 // been written to disk yet) of this segment file's header region. The
 // segment's first data frame begins immediately after the header.
 func (segment *queueSegment) headerSize() uint64 {
-	if segment.schemaVersion != nil && *segment.schemaVersion < 1 {
+	if segment.schemaVersion == nil {
+		return segmentHeaderSize
+	}
+	return headerSizeForVersion(*segment.schemaVersion)
+}
+
+func headerSizeForVersion(version uint32) uint64 {
+	switch {
+	case version < 1:
 		// Schema 0 had nothing except the 4-byte version.
 		return 4
+	case version < encryptedSegmentVersion:
+		return segmentHeaderSize
+	default:
+		return encryptedSegmentHeaderSize
 	}
-	return segmentHeaderSize
 }
 
 // getReader sets up the segmentReader.  The order of encryption and
@@ -244,6 +270,7 @@ func (segment *queueSegment) getReader(queueSettings Settings) (*segmentReader,
 
 	sr := &segmentReader{}
 	sr.src = file
+	sr.headerSize = int64(headerSizeForVersion(header.version))
 
 	if header.version == 0 {
 		sr.serializationFormat = SerializationJSON
@@ -255,6 +282,24 @@ func (segment *queueSegment) getReader(queueSettings Settings) (*segmentReader,
 		sr.serializationFormat = SerializationCBOR
 	}
 
+	if (header.options & ENABLE_ENCRYPTION) == ENABLE_ENCRYPTION {
+		keyID := decodeKeyID(header.keyID)
+		key := queueSettings.encryptionKey(keyID)
+		if key == nil {
+			file.Close()
+			return nil, fmt.Errorf(
+				"segment %d is encrypted with unknown key id %q, add the key to "+
+					"the disk queue encryption settings to read it", segment.id, keyID)
+		}
+		sr.cipher, err = newFrameCipher(key.Key)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf(
+				"couldn't set up decryption of segment %d with key id %q: %w",
+				segment.id, keyID, err)
+		}
+	}
+
 	if (header.options & ENABLE_COMPRESSION) == ENABLE_COMPRESSION {
 		sr.cr = NewCompressionReader(sr.src)
 	}
@@ -275,11 +320,18 @@ func (segment *queueSegment) getWriter(queueSettings Settings) (*segmentWriter,
 	if queueSettings.UseCompression {
 		options = options | ENABLE_COMPRESSION
 	}
+	var keyID [keyIDSize]byte
+	if queueSettings.EncryptionKey != nil {
+		// The frames themselves are encrypted by the producer, the header
+		// records which key was used.
+		options = options | ENABLE_ENCRYPTION
+		keyID = encodeKeyID(queueSettings.EncryptionKey.ID)
+	}
 
 	sw := &segmentWriter{}
 	sw.dst = file
 
-	if err := sw.WriteHeader(options); err != nil {
+	if err := sw.WriteHeader(options, keyID); err != nil {
 		return nil, err
 	}
 
@@ -394,7 +446,7 @@ func readSegmentHeader(in io.Reader) (*segmentHeader, error) {
 		return nil, fmt.Errorf("could not read segment version: %w", err)
 	}
 
-	if header.version > currentSegmentVersion {
+	if header.version > encryptedSegmentVersion {
 		return nil, fmt.Errorf("unrecognized schema version %d", header.version)
 	}
 
@@ -410,6 +462,12 @@ func readSegmentHeader(in io.Reader) (*segmentHeader, error) {
 			return nil, fmt.Errorf("could not read segment options: %w", err)
 		}
 	}
+	if header.version >= encryptedSegmentVersion {
+		_, err = io.ReadFull(in, header.keyID[:])
+		if err != nil {
+			return nil, fmt.Errorf("could not read segment key id: %w", err)
+		}
+	}
 
 	return header, nil
 }
@@ -445,6 +503,13 @@ type segmentReader struct {
 	src                 io.ReadSeekCloser
 	cr                  *CompressionReader
 	serializationFormat SerializationFormat
+
+	// headerSize is the size of this segment's header, which depends on
+	// its schema version.
+	headerSize int64
+
+	// If the segment is encrypted, cipher decrypts its frames.
+	cipher *frameCipher
 }
 
 func (r *segmentReader) Read(p []byte) (int, error) {
@@ -464,17 +529,17 @@ func (r *segmentReader) Close() error {
 func (r *segmentReader) Seek(offset int64, whence int) (int64, error) {
 	if r.cr != nil {
 		//can't seek before segment header
-		if (offset + int64(whence)) < segmentHeaderSize {
+		if (offset + int64(whence)) < r.headerSize {
 			return 0, fmt.Errorf("illegal seek offset %d, whence %d", offset, whence)
 		}
-		if _, err := r.src.Seek(segmentHeaderSize, io.SeekStart); err != nil {
+		if _, err := r.src.Seek(r.headerSize, io.SeekStart); err != nil {
 			return 0, fmt.Errorf("could not seek past segment header: %w", err)
 		}
 		if err := r.cr.Reset(); err != nil {
 			return 0, fmt.Errorf("could not reset compression: %w", err)
 		}
-		written, err := io.CopyN(io.Discard, r.cr, (offset+int64(whence))-segmentHeaderSize)
-		return written + segmentHeaderSize, err
+		written, err := io.CopyN(io.Discard, r.cr, (offset+int64(whence))-r.headerSize)
+		return written + r.headerSize, err
 	}
 	return r.src.Seek(offset, whence)
 }
@@ -512,14 +577,20 @@ func (w *segmentWriter) Sync() error {
 	return w.dst.Sync()
 }
 
-func (w *segmentWriter) WriteHeader(options uint32) error {
+func (w *segmentWriter) WriteHeader(options uint32, keyID [keyIDSize]byte) error {
 	_, err := w.dst.Seek(0, io.SeekStart)
 	if err != nil {
 		return fmt.Errorf("could not seek to beginning of segment: %w", err)
 	}
 
+	encrypted := (options & ENABLE_ENCRYPTION) == ENABLE_ENCRYPTION
+	version := uint32(currentSegmentVersion)
+	if encrypted {
+		version = encryptedSegmentVersion
+	}
+
 	//write version
-	err = binary.Write(w.dst, binary.LittleEndian, uint32(2))
+	err = binary.Write(w.dst, binary.LittleEndian, version)
 	if err != nil {
 		return fmt.Errorf("could not write version to segment: %w", err)
 	}
@@ -536,6 +607,14 @@ func (w *segmentWriter) WriteHeader(options uint32) error {
 		return fmt.Errorf("could not write options to segment: %w", err)
 	}
 
+	//write encryption key id
+	if encrypted {
+		_, err = w.dst.Write(keyID[:])
+		if err != nil {
+			return fmt.Errorf("could not write key id to segment: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,180 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package hybridqueue
+
+import (
+	"sync"
+
+	"github.com/elastic/beats/v7/libbeat/publisher/queue"
+)
+
+type producer struct {
+	queue *hybridQueue
+	mem   queue.Producer
+	disk  queue.Producer
+
+	// acks is nil if the producer doesn't report acknowledgments.
+	acks *ackOrder
+}
+
+func newProducer(q *hybridQueue, cfg queue.ProducerConfig) *producer {
+	p := &producer{queue: q}
+
+	var diskACK func(int)
+	if cfg.ACK != nil {
+		p.acks = &ackOrder{callback: cfg.ACK}
+		diskACK = func(count int) { p.acks.acked(true, count) }
+	}
+	p.mem = q.mem.Producer(queue.ProducerConfig{
+		// The memory queue acknowledgments are always needed to know how
+		// many events it holds.
+		ACK: func(count int) {
+			q.memACKed(count)
+			if p.acks != nil {
+				p.acks.acked(false, count)
+			}
+		},
+	})
+	p.disk = q.disk.Producer(queue.ProducerConfig{ACK: diskACK})
+	return p
+}
+
+func (p *producer) Publish(entry queue.Entry) (queue.EntryID, bool) {
+	return p.publish(entry, true)
+}
+
+func (p *producer) TryPublish(entry queue.Entry) (queue.EntryID, bool) {
+	return p.publish(entry, false)
+}
+
+func (p *producer) publish(entry queue.Entry, block bool) (queue.EntryID, bool) {
+	spill := p.queue.reserve()
+	if p.acks != nil {
+		p.acks.add(spill)
+	}
+
+	target := p.mem
+	if spill {
+		target = p.disk
+	}
+	var id queue.EntryID
+	var ok bool
+	if block {
+		id, ok = target.Publish(entry)
+	} else {
+		id, ok = target.TryPublish(entry)
+	}
+
+	if !ok {
+		p.queue.cancel(spill)
+		if p.acks != nil {
+			p.acks.cancel(spill)
+		}
+		return 0, false
+	}
+	p.queue.published(spill)
+	return id, true
+}
+
+func (p *producer) Close() {
+	p.mem.Close()
+	p.disk.Close()
+}
+
+// ackOrder reports the acknowledgments of the memory and disk queues to the
+// producer in the order its events were published. Each queue acknowledges
+// its own events in order, but spilled events may be acknowledged before
+// older events still in memory.
+type ackOrder struct {
+	mu       sync.Mutex
+	callback func(int)
+
+	// runs are the consecutive events published to the same queue that are
+	// not acknowledged yet, oldest first.
+	runs []ackRun
+
+	// ackedCount is the number of events acknowledged by the memory (0) and disk
+	// (1) queues not reported yet.
+	ackedCount [2]int
+}
+
+type ackRun struct {
+	spill bool
+	count int
+}
+
+func runIndex(spill bool) int {
+	if spill {
+		return 1
+	}
+	return 0
+}
+
+func (o *ackOrder) add(spill bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if n := len(o.runs); n > 0 && o.runs[n-1].spill == spill {
+		o.runs[n-1].count++
+		return
+	}
+	o.runs = append(o.runs, ackRun{spill: spill, count: 1})
+}
+
+func (o *ackOrder) cancel(spill bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := len(o.runs) - 1; i >= 0; i-- {
+		if o.runs[i].spill == spill {
+			o.runs[i].count--
+			if o.runs[i].count == 0 {
+				o.runs = append(o.runs[:i], o.runs[i+1:]...)
+			}
+			break
+		}
+	}
+	o.release()
+}
+
+func (o *ackOrder) acked(spill bool, count int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ackedCount[runIndex(spill)] += count
+	o.release()
+}
+
+// release removes the acknowledged events at the start of runs, and reports
+// them. The callback is called with the lock held, as producers expect
+// acknowledgments to be reported from a single goroutine.
+func (o *ackOrder) release() {
+	released := 0
+	for len(o.runs) > 0 {
+		run := &o.runs[0]
+		idx := runIndex(run.spill)
+		n := min(run.count, o.ackedCount[idx])
+		run.count -= n
+		o.ackedCount[idx] -= n
+		released += n
+		if run.count > 0 {
+			break
+		}
+		o.runs = o.runs[1:]
+	}
+	if released > 0 {
+		o.callback(released)
+	}
+}
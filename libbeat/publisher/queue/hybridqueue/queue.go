@@ -0,0 +1,300 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package hybridqueue implements a queue keeping events in memory under
+// normal load, and spilling them to disk when the memory buffer is full,
+// for example while the output is unavailable.
+//
+// Events are always handed to the output in the order they were published:
+// once the queue starts spilling, new events are written to disk until all
+// the events in memory and then all the spilled events have been read.
+package hybridqueue
+
+import (
+	"io"
+	"sync"
+
+	"github.com/elastic/beats/v7/libbeat/publisher/queue"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue/diskqueue"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue/memqueue"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/paths"
+)
+
+// QueueType is the name of the hybrid queue in the queue configuration.
+const QueueType = "hybrid"
+
+// diskQueue is the part of the disk queue API the hybrid queue relies on.
+type diskQueue interface {
+	queue.Queue
+	RestoredEvents() int
+}
+
+type hybridQueue struct {
+	logger   *logp.Logger
+	observer queue.SpillObserver
+
+	mem  queue.Queue
+	disk diskQueue
+
+	// memCapacity is the number of events the memory queue can hold.
+	memCapacity int
+
+	mu sync.Mutex
+
+	// memBuffered is the number of events published to the memory queue that
+	// it has not acknowledged yet.
+	memBuffered int
+
+	// inMemory and spilled are the number of events published to the memory
+	// and disk queues that have not been read by Get yet.
+	inMemory int
+	spilled  int
+
+	// spilling is true while new events are written to disk.
+	spilling bool
+
+	// wake is signaled when events are published, for Get to check the
+	// queue it should read from.
+	wake chan struct{}
+
+	close     chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// FactoryForSettings is a simple wrapper around NewQueue so a concrete
+// Settings object can be wrapped in a queue-agnostic interface for
+// later use by the pipeline.
+func FactoryForSettings(settings Settings) queue.QueueFactory {
+	return func(
+		logger *logp.Logger,
+		observer queue.Observer,
+		inputQueueSize int,
+		encoderFactory queue.EncoderFactory,
+	) (queue.Queue, error) {
+		return NewQueue(logger, observer, settings, inputQueueSize, encoderFactory)
+	}
+}
+
+// NewQueue returns a hybrid queue configured with the given logger and
+// settings. Events spilled to disk by a previous run are read first.
+func NewQueue(
+	logger *logp.Logger,
+	observer queue.Observer,
+	settings Settings,
+	inputQueueSize int,
+	encoderFactory queue.EncoderFactory,
+) (queue.Queue, error) {
+	logger = logger.Named("hybridqueue")
+	if observer == nil {
+		observer = queue.NewQueueObserver(nil)
+	}
+	if settings.Disk.Path == "" {
+		settings.Disk.Path = paths.Resolve(paths.Data, "hybridqueue")
+	}
+
+	disk, err := diskqueue.NewQueue(logger, observer, settings.Disk, encoderFactory)
+	if err != nil {
+		return nil, err
+	}
+	mem := memqueue.NewQueue(logger, observer, settings.Mem, inputQueueSize, encoderFactory)
+
+	return newQueue(logger, observer, mem, disk, settings.Mem.Events), nil
+}
+
+func newQueue(
+	logger *logp.Logger,
+	observer queue.Observer,
+	mem queue.Queue,
+	disk diskQueue,
+	memCapacity int,
+) *hybridQueue {
+	spillObserver, ok := observer.(queue.SpillObserver)
+	if !ok {
+		spillObserver = nilSpillObserver{}
+	}
+
+	q := &hybridQueue{
+		logger:      logger,
+		observer:    spillObserver,
+		mem:         mem,
+		disk:        disk,
+		memCapacity: memCapacity,
+		wake:        make(chan struct{}, 1),
+		close:       make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	// Events left on disk by a previous run are older than any new event, so
+	// we keep spilling until they are read.
+	if restored := disk.RestoredEvents(); restored > 0 {
+		logger.Infof("Draining %v events spilled to disk by a previous run", restored)
+		q.spilled = restored
+		q.spilling = true
+		q.observer.Spilling(true)
+		q.observer.SpillEvents(restored)
+	}
+
+	// The queue is done once all the events in memory are acknowledged and
+	// the disk queue has persisted or acknowledged everything spilled to it.
+	go func() {
+		<-mem.Done()
+		<-disk.Done()
+		close(q.done)
+	}()
+	return q
+}
+
+func (q *hybridQueue) Close() error {
+	q.closeOnce.Do(func() {
+		close(q.close)
+		q.mem.Close()
+		q.disk.Close()
+	})
+	return nil
+}
+
+func (q *hybridQueue) Done() <-chan struct{} {
+	return q.done
+}
+
+func (q *hybridQueue) QueueType() string {
+	return QueueType
+}
+
+func (q *hybridQueue) BufferConfig() queue.BufferConfig {
+	// Like the disk queue, the hybrid queue has no fixed event limit.
+	return queue.BufferConfig{MaxEvents: 0}
+}
+
+func (q *hybridQueue) Producer(cfg queue.ProducerConfig) queue.Producer {
+	return newProducer(q, cfg)
+}
+
+// Get reads events from memory, unless the queue is spilling and all the
+// events in memory have been read, in which case they are read from disk.
+func (q *hybridQueue) Get(eventCount int) (queue.Batch, error) {
+	for {
+		q.mu.Lock()
+		switch {
+		case q.inMemory > 0:
+			q.mu.Unlock()
+			batch, err := q.mem.Get(eventCount)
+			if err != nil {
+				return nil, err
+			}
+			q.mu.Lock()
+			q.inMemory -= batch.Count()
+			q.mu.Unlock()
+			return batch, nil
+
+		case q.spilled > 0:
+			q.mu.Unlock()
+			batch, err := q.disk.Get(eventCount)
+			if err != nil {
+				return nil, err
+			}
+			q.observer.DrainSpilledEvents(batch.Count())
+			q.mu.Lock()
+			q.spilled = max(q.spilled-batch.Count(), 0)
+			q.stopSpilling()
+			q.mu.Unlock()
+			return batch, nil
+
+		case q.spilling:
+			q.stopSpilling()
+			q.mu.Unlock()
+
+		default:
+			q.mu.Unlock()
+			select {
+			case <-q.wake:
+			case <-q.close:
+				return nil, io.EOF
+			}
+		}
+	}
+}
+
+// stopSpilling queues new events in memory again once all the events in
+// memory and on disk have been read. It must be called with the lock held.
+func (q *hybridQueue) stopSpilling() {
+	if !q.spilling || q.inMemory > 0 || q.spilled > 0 {
+		return
+	}
+	q.spilling = false
+	q.observer.Spilling(false)
+	q.logger.Info("All spilled events were read, queueing events in memory")
+}
+
+// reserve selects the queue a new event is published to, memory if it has
+// room and the queue isn't spilling, and disk otherwise.
+func (q *hybridQueue) reserve() (spill bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.spilling && q.memBuffered < q.memCapacity {
+		q.memBuffered++
+		q.inMemory++
+		return false
+	}
+	if !q.spilling {
+		q.spilling = true
+		q.observer.Spilling(true)
+		q.logger.Info("Memory buffer is full, spilling events to disk")
+	}
+	q.spilled++
+	return true
+}
+
+// published signals Get that a reserved event was published.
+func (q *hybridQueue) published(spill bool) {
+	if spill {
+		q.observer.SpillEvents(1)
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// cancel releases an event reserved but that could not be published.
+func (q *hybridQueue) cancel(spill bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if spill {
+		q.spilled--
+	} else {
+		q.memBuffered--
+		q.inMemory--
+	}
+}
+
+// memACKed is called when the memory queue acknowledges events, freeing
+// room for new ones.
+func (q *hybridQueue) memACKed(count int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.memBuffered -= count
+}
+
+type nilSpillObserver struct{}
+
+func (nilSpillObserver) Spilling(bool)          {}
+func (nilSpillObserver) SpillEvents(int)        {}
+func (nilSpillObserver) DrainSpilledEvents(int) {}
@@ -0,0 +1,302 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package hybridqueue
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue/diskqueue"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue/memqueue"
+	"github.com/elastic/beats/v7/libbeat/publisher/queue/queuetest"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func testSettings(t *testing.T, dir string, memEvents int) Settings {
+	t.Helper()
+	disk := diskqueue.DefaultSettings()
+	disk.Path = dir
+	return Settings{
+		Mem:  memqueue.Settings{Events: memEvents, MaxGetRequest: memEvents},
+		Disk: disk,
+	}
+}
+
+func newTestQueue(t *testing.T, settings Settings, observer queue.Observer) queue.Queue {
+	t.Helper()
+	q, err := NewQueue(logptest.NewTestingLogger(t, ""), observer, settings, 0, nil)
+	require.NoError(t, err)
+	return q
+}
+
+func closeQueue(t *testing.T, q queue.Queue) {
+	t.Helper()
+	require.NoError(t, q.Close())
+	select {
+	case <-q.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("queue did not shut down")
+	}
+}
+
+func TestProduceConsumer(t *testing.T) {
+	events := 500
+	batchSize := 16
+
+	factory := func(t *testing.T) queue.Queue {
+		// A small memory buffer so events are spilled.
+		return newTestQueue(t, testSettings(t, t.TempDir(), 8), nil)
+	}
+
+	t.Run("single", func(t *testing.T) {
+		queuetest.TestSingleProducerConsumer(t, events, batchSize, factory)
+	})
+	t.Run("multi", func(t *testing.T) {
+		queuetest.TestMultiProducerConsumer(t, events, batchSize, factory)
+	})
+}
+
+func TestSpillKeepsOrder(t *testing.T) {
+	observer := &spillObserver{}
+	q := newTestQueue(t, testSettings(t, t.TempDir(), 4), observer)
+	defer closeQueue(t, q)
+
+	var mu sync.Mutex
+	acked := 0
+	p := q.Producer(queue.ProducerConfig{ACK: func(count int) {
+		mu.Lock()
+		defer mu.Unlock()
+		acked += count
+	}})
+
+	// Nothing is read, so all the events after the fourth are spilled.
+	publish := func(from, to int) {
+		for i := from; i < to; i++ {
+			_, ok := p.Publish(queuetest.MakeEvent(mapstr.M{"id": fmt.Sprint(i)}))
+			require.True(t, ok)
+		}
+	}
+	publish(0, 20)
+	assert.True(t, observer.isSpilling())
+	assert.Equal(t, 16, observer.spilledCount())
+
+	read := func(count int) []string {
+		var ids []string
+		for len(ids) < count {
+			batch, err := q.Get(count - len(ids))
+			require.NoError(t, err)
+			for i := 0; i < batch.Count(); i++ {
+				event, ok := batch.Entry(i).(publisher.Event)
+				require.True(t, ok)
+				ids = append(ids, fmt.Sprint(event.Content.Fields["id"]))
+			}
+			batch.Done()
+		}
+		return ids
+	}
+
+	var expected []string
+	for i := 0; i < 20; i++ {
+		expected = append(expected, fmt.Sprint(i))
+	}
+	assert.Equal(t, expected, read(20))
+	assert.Equal(t, 16, observer.drainedCount())
+
+	assert.False(t, observer.isSpilling())
+
+	waitACKed := func(count int) {
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return acked == count
+		}, 10*time.Second, 10*time.Millisecond)
+	}
+	waitACKed(20)
+
+	// Once the spilled events are read and the memory buffer has room, new
+	// events are kept in memory.
+	publish(20, 22)
+	assert.Equal(t, []string{"20", "21"}, read(2))
+	assert.Equal(t, 16, observer.spilledCount())
+	waitACKed(22)
+}
+
+func TestRestoreSpilledEvents(t *testing.T) {
+	logger := logptest.NewTestingLogger(t, "")
+	settings := testSettings(t, t.TempDir(), 2)
+
+	// Events left on disk by a previous run.
+	disk, err := diskqueue.NewQueue(logger, nil, settings.Disk, nil)
+	require.NoError(t, err)
+	written := make(chan int, 3)
+	p := disk.Producer(queue.ProducerConfig{ACK: func(count int) { written <- count }})
+	for i := 0; i < 3; i++ {
+		_, ok := p.Publish(queuetest.MakeEvent(mapstr.M{"id": fmt.Sprint(i)}))
+		require.True(t, ok)
+	}
+	for n := 0; n < 3; {
+		n += <-written
+	}
+
+	observer := &spillObserver{}
+	mem := memqueue.NewQueue(logger, observer, settings.Mem, 0, nil)
+	q := newQueue(logger, observer, mem, restoredDisk{disk, 3}, settings.Mem.Events)
+	defer closeQueue(t, q)
+	assert.True(t, observer.isSpilling())
+
+	p = q.Producer(queue.ProducerConfig{})
+	_, ok := p.Publish(queuetest.MakeEvent(mapstr.M{"id": "new"}))
+	require.True(t, ok)
+
+	var ids []string
+	for len(ids) < 4 {
+		batch, err := q.Get(10)
+		require.NoError(t, err)
+		for i := 0; i < batch.Count(); i++ {
+			event, ok := batch.Entry(i).(publisher.Event)
+			require.True(t, ok)
+			ids = append(ids, fmt.Sprint(event.Content.Fields["id"]))
+		}
+		batch.Done()
+	}
+	assert.Equal(t, []string{"0", "1", "2", "new"}, ids)
+	assert.Equal(t, 4, observer.drainedCount())
+}
+
+// restoredDisk simulates a disk queue opened with events from a previous run.
+type restoredDisk struct {
+	diskQueue
+	restored int
+}
+
+func (d restoredDisk) RestoredEvents() int { return d.restored }
+
+func TestDoneWaitsForDisk(t *testing.T) {
+	logger := logptest.NewTestingLogger(t, "")
+	settings := testSettings(t, t.TempDir(), 2)
+
+	inner, err := diskqueue.NewQueue(logger, nil, settings.Disk, nil)
+	require.NoError(t, err)
+	disk := &pendingDisk{diskQueue: inner, done: make(chan struct{})}
+	mem := memqueue.NewQueue(logger, nil, settings.Mem, 0, nil)
+	q := newQueue(logger, nil, mem, disk, settings.Mem.Events)
+
+	require.NoError(t, q.Close())
+	select {
+	case <-mem.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("memory queue did not shut down")
+	}
+	select {
+	case <-q.Done():
+		t.Fatal("queue done before the disk queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(disk.done)
+	select {
+	case <-q.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("queue did not shut down")
+	}
+}
+
+// pendingDisk simulates a disk queue still persisting events after Close.
+type pendingDisk struct {
+	diskQueue
+	done chan struct{}
+}
+
+func (d *pendingDisk) Done() <-chan struct{} { return d.done }
+
+func TestACKOrder(t *testing.T) {
+	var reported []int
+	o := &ackOrder{callback: func(count int) { reported = append(reported, count) }}
+
+	// 2 events in memory, 3 spilled, 1 in memory.
+	for _, spill := range []bool{false, false, true, true, true, false} {
+		o.add(spill)
+	}
+
+	// Spilled events are acknowledged first, but can't be reported before
+	// the older events in memory.
+	o.acked(true, 3)
+	assert.Empty(t, reported)
+	o.acked(false, 1)
+	assert.Equal(t, []int{1}, reported)
+	o.acked(false, 2)
+	assert.Equal(t, []int{1, 5}, reported)
+}
+
+type spillObserver struct {
+	mu       sync.Mutex
+	spilling bool
+	spilled  int
+	drained  int
+}
+
+func (o *spillObserver) MaxEvents(int)          {}
+func (o *spillObserver) MaxBytes(int)           {}
+func (o *spillObserver) Restore(int, int)       {}
+func (o *spillObserver) AddEvent(int)           {}
+func (o *spillObserver) ConsumeEvents(int, int) {}
+func (o *spillObserver) RemoveEvents(int, int)  {}
+
+func (o *spillObserver) Spilling(active bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.spilling = active
+}
+
+func (o *spillObserver) SpillEvents(count int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.spilled += count
+}
+
+func (o *spillObserver) DrainSpilledEvents(count int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.drained += count
+}
+
+func (o *spillObserver) isSpilling() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.spilling
+}
+
+func (o *spillObserver) spilledCount() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.spilled
+}
+
+func (o *spillObserver) drainedCount() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.drained
+}
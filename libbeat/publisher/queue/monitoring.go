@@ -38,6 +38,17 @@ type Observer interface {
 	RemoveEvents(eventCount int, byteCount int)
 }
 
+// SpillObserver is implemented by observers tracking the events a queue
+// spills from memory to disk.
+type SpillObserver interface {
+	// Spilling reports whether new events are written to disk.
+	Spilling(active bool)
+	// SpillEvents reports events written to disk instead of memory.
+	SpillEvents(eventCount int)
+	// DrainSpilledEvents reports spilled events read back from disk.
+	DrainSpilledEvents(eventCount int)
+}
+
 type queueObserver struct {
 	maxEvents *monitoring.Uint // gauge
 	maxBytes  *monitoring.Uint // gauge
@@ -53,6 +64,11 @@ type queueObserver struct {
 	filledBytes  *monitoring.Uint  // gauge
 	filledPct    *monitoring.Float // gauge
 
+	spillActive        *monitoring.Bool // gauge
+	spilledEvents      *monitoring.Uint
+	drainedEvents      *monitoring.Uint
+	spillPendingEvents *monitoring.Uint // gauge
+
 	// backwards compatibility: the metric "acked" is the old name for
 	// "removed.events". Ideally we would like to define an alias in the
 	// monitoring API, but until that's possible we shadow it with this
@@ -93,6 +109,11 @@ func NewQueueObserver(metrics *monitoring.Registry) Observer {
 		filledBytes:  monitoring.NewUint(queueMetrics, "filled.bytes"),  // gauge
 		filledPct:    monitoring.NewFloat(queueMetrics, "filled.pct"),   // gauge
 
+		spillActive:        monitoring.NewBool(queueMetrics, "spill.active"), // gauge
+		spilledEvents:      monitoring.NewUint(queueMetrics, "spill.spilled.events"),
+		drainedEvents:      monitoring.NewUint(queueMetrics, "spill.drained.events"),
+		spillPendingEvents: monitoring.NewUint(queueMetrics, "spill.pending.events"), // gauge
+
 		// backwards compatibility: "acked" is an alias for "removed.events".
 		acked: monitoring.NewUint(queueMetrics, "acked"),
 	}
@@ -137,6 +158,20 @@ func (ob *queueObserver) RemoveEvents(eventCount int, byteCount int) {
 	ob.updateFilledPct()
 }
 
+func (ob *queueObserver) Spilling(active bool) {
+	ob.spillActive.Set(active)
+}
+
+func (ob *queueObserver) SpillEvents(eventCount int) {
+	ob.spilledEvents.Add(uint64(eventCount))
+	ob.spillPendingEvents.Add(uint64(eventCount))
+}
+
+func (ob *queueObserver) DrainSpilledEvents(eventCount int) {
+	ob.drainedEvents.Add(uint64(eventCount))
+	ob.spillPendingEvents.Sub(uint64(eventCount))
+}
+
 func (ob *queueObserver) updateFilledPct() {
 	if maxBytes := ob.maxBytes.Get(); maxBytes > 0 {
 		ob.filledPct.Set(float64(ob.filledBytes.Get()) / float64(maxBytes))
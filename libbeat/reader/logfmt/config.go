@@ -0,0 +1,91 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package logfmt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DecoderConfig holds the options to decode the key=value pairs of a message.
+type DecoderConfig struct {
+	// PairSeparator separates the pairs. If it is white space, any run of
+	// white space separates the pairs.
+	PairSeparator string `config:"pair_separator"`
+	// ValueSeparator separates the key from the value of a pair.
+	ValueSeparator string `config:"value_separator"`
+	// QuoteChars are the characters that can enclose keys and values.
+	QuoteChars string `config:"quote_chars"`
+	// ExpandKeys expands the dotted keys into nested objects.
+	ExpandKeys bool `config:"expand_keys"`
+	// InferTypes converts the unquoted values that are numbers or booleans.
+	InferTypes bool `config:"infer_types"`
+}
+
+// Config holds the options of the logfmt parser.
+type Config struct {
+	DecoderConfig `config:",inline"`
+	// Target is the field the decoded pairs are written to. If it is empty,
+	// the pairs are written to the root of the event.
+	Target string `config:"target"`
+	// OverwriteKeys allows the decoded pairs to overwrite existing fields
+	// when Target is empty.
+	OverwriteKeys bool `config:"overwrite_keys"`
+	// IgnoreDecodingError disables logging errors for invalid messages.
+	IgnoreDecodingError bool `config:"ignore_decoding_error"`
+	// AddErrorKey adds an error.message field to invalid messages.
+	AddErrorKey bool `config:"add_error_key"`
+}
+
+// DefaultDecoderConfig returns the default options to decode logfmt
+// messages.
+func DefaultDecoderConfig() DecoderConfig {
+	return DecoderConfig{
+		PairSeparator:  " ",
+		ValueSeparator: "=",
+		QuoteChars:     `"`,
+	}
+}
+
+// DefaultConfig returns the default configuration of the logfmt parser.
+func DefaultConfig() Config {
+	return Config{
+		DecoderConfig: DefaultDecoderConfig(),
+		Target:        "logfmt",
+	}
+}
+
+// Validate validates the options to decode the pairs.
+func (c *DecoderConfig) Validate() error {
+	if c.PairSeparator == "" {
+		return errors.New("pair_separator cannot be empty")
+	}
+	if strings.TrimSpace(c.ValueSeparator) == "" {
+		return errors.New("value_separator cannot be empty or white space")
+	}
+	if strings.Contains(c.PairSeparator, c.ValueSeparator) || strings.Contains(c.ValueSeparator, c.PairSeparator) {
+		return fmt.Errorf("pair_separator %q and value_separator %q overlap", c.PairSeparator, c.ValueSeparator)
+	}
+	for _, q := range c.QuoteChars {
+		if q == '\\' || strings.ContainsRune(c.PairSeparator, q) || strings.ContainsRune(c.ValueSeparator, q) {
+			return fmt.Errorf("%q cannot be used as a quote character", q)
+		}
+	}
+	return nil
+}
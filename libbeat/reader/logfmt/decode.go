@@ -0,0 +1,249 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package logfmt decodes messages made of key=value pairs, like the logfmt
+// format. It implements the logfmt parser and is used by the decode_logfmt
+// processor.
+package logfmt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/safemapstr"
+)
+
+var (
+	errEmptyKey          = errors.New("empty key")
+	errUnterminatedQuote = errors.New("unterminated quoted string")
+	errMissingSeparator  = errors.New("missing pair separator after quoted string")
+)
+
+// DecodeError is an error decoding a message at a given position.
+type DecodeError struct {
+	// The underlying error.
+	Err error
+	// The position of the error.
+	Pos int
+}
+
+// Error provides a descriptive error string.
+func (e DecodeError) Error() string {
+	return fmt.Sprintf("error at position %d: %v", e.Pos, e.Err)
+}
+
+// Unwrap provides the underlying error.
+func (e DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder decodes the key=value pairs of messages.
+type Decoder struct {
+	cfg        DecoderConfig
+	whitespace bool
+}
+
+// NewDecoder creates a Decoder. The configuration must be valid.
+func NewDecoder(cfg DecoderConfig) *Decoder {
+	return &Decoder{
+		cfg:        cfg,
+		whitespace: strings.TrimSpace(cfg.PairSeparator) == "",
+	}
+}
+
+// Decode decodes the pairs of data. Keys without a value are decoded as an
+// empty string, or as true if types are inferred. When the same key is
+// repeated, the last value wins. If an error is returned, fields holds the
+// pairs decoded before the error.
+func (d *Decoder) Decode(data string) (mapstr.M, error) {
+	fields := mapstr.M{}
+	pos := 0
+	for {
+		pos = d.skipSeparators(data, pos)
+		if pos >= len(data) {
+			return fields, nil
+		}
+
+		start := pos
+		key, _, next, err := d.token(data, pos, true)
+		if err != nil {
+			return fields, DecodeError{Err: err, Pos: pos}
+		}
+		if key == "" {
+			return fields, DecodeError{Err: errEmptyKey, Pos: start}
+		}
+		pos = next
+
+		var value interface{} = ""
+		if d.cfg.InferTypes {
+			value = true
+		}
+		if strings.HasPrefix(data[pos:], d.cfg.ValueSeparator) {
+			pos += len(d.cfg.ValueSeparator)
+			if !d.whitespace {
+				pos = skipSpace(data, pos)
+			}
+			raw, quoted, next, err := d.token(data, pos, false)
+			if err != nil {
+				return fields, DecodeError{Err: err, Pos: pos}
+			}
+			pos = next
+			value = raw
+			if d.cfg.InferTypes && !quoted {
+				value = inferType(raw)
+			}
+		}
+
+		if d.cfg.ExpandKeys {
+			_ = safemapstr.Put(fields, key, value)
+		} else {
+			fields[key] = value
+		}
+	}
+}
+
+// token reads a key or a value starting at pos. It returns the token, if it
+// was quoted and the position after it.
+func (d *Decoder) token(data string, pos int, isKey bool) (string, bool, int, error) {
+	if pos < len(data) {
+		q, size := utf8.DecodeRuneInString(data[pos:])
+		if strings.ContainsRune(d.cfg.QuoteChars, q) {
+			s, next, err := unquote(data, pos+size, q)
+			if err != nil {
+				return "", true, next, err
+			}
+			if next < len(data) && !d.atSeparator(data, next) && !(isKey && strings.HasPrefix(data[next:], d.cfg.ValueSeparator)) {
+				if d.whitespace || !isSpace(data, next) {
+					return "", true, next, errMissingSeparator
+				}
+			}
+			return s, true, next, nil
+		}
+	}
+
+	end := pos
+	for end < len(data) && !d.atSeparator(data, end) {
+		if isKey && strings.HasPrefix(data[end:], d.cfg.ValueSeparator) {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(data[end:])
+		end += size
+	}
+	return strings.TrimRightFunc(data[pos:end], unicode.IsSpace), false, end, nil
+}
+
+// atSeparator reports if a pair separator starts at pos.
+func (d *Decoder) atSeparator(data string, pos int) bool {
+	if d.whitespace {
+		return isSpace(data, pos)
+	}
+	return strings.HasPrefix(data[pos:], d.cfg.PairSeparator)
+}
+
+// skipSeparators returns the position of the first character at or after
+// pos that is neither white space nor a pair separator.
+func (d *Decoder) skipSeparators(data string, pos int) int {
+	for pos < len(data) {
+		switch {
+		case isSpace(data, pos):
+			_, size := utf8.DecodeRuneInString(data[pos:])
+			pos += size
+		case !d.whitespace && strings.HasPrefix(data[pos:], d.cfg.PairSeparator):
+			pos += len(d.cfg.PairSeparator)
+		default:
+			return pos
+		}
+	}
+	return pos
+}
+
+// unquote reads the quoted string starting at pos, right after the opening
+// quote q. It returns the unescaped string and the position after the
+// closing quote.
+func unquote(data string, pos int, q rune) (string, int, error) {
+	var sb strings.Builder
+	for pos < len(data) {
+		r, size := utf8.DecodeRuneInString(data[pos:])
+		pos += size
+		switch r {
+		case q:
+			return sb.String(), pos, nil
+		case '\\':
+			if pos >= len(data) {
+				return "", pos, errUnterminatedQuote
+			}
+			r, size = utf8.DecodeRuneInString(data[pos:])
+			pos += size
+			switch r {
+			case 'n':
+				sb.WriteRune('\n')
+			case 'r':
+				sb.WriteRune('\r')
+			case 't':
+				sb.WriteRune('\t')
+			case '\\', q:
+				sb.WriteRune(r)
+			default:
+				// Unknown escape sequences are kept as they are.
+				sb.WriteRune('\\')
+				sb.WriteRune(r)
+			}
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return "", pos, errUnterminatedQuote
+}
+
+// inferType converts s to an int64, a float64 or a bool if possible.
+func inferType(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	// ParseFloat also accepts special values like "inf" or "nan" and
+	// hexadecimal numbers, that are kept as strings.
+	if strings.TrimLeft(s, "+-0123456789.eE") == "" {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+func isSpace(data string, pos int) bool {
+	r, _ := utf8.DecodeRuneInString(data[pos:])
+	return unicode.IsSpace(r)
+}
+
+func skipSpace(data string, pos int) int {
+	for pos < len(data) && isSpace(data, pos) {
+		_, size := utf8.DecodeRuneInString(data[pos:])
+		pos += size
+	}
+	return pos
+}
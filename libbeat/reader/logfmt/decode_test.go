@@ -0,0 +1,181 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package logfmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	conf "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestDecode(t *testing.T) {
+	tests := map[string]struct {
+		config  map[string]interface{}
+		data    string
+		want    mapstr.M
+		wantErr error
+	}{
+		"logfmt": {
+			data: `level=info msg="Stopping all fetchers" tag=stopping_fetchers id=ConsumerFetcherManager-1382721708341 module=kafka.consumer.ConsumerFetcherManager`,
+			want: mapstr.M{
+				"level":  "info",
+				"msg":    "Stopping all fetchers",
+				"tag":    "stopping_fetchers",
+				"id":     "ConsumerFetcherManager-1382721708341",
+				"module": "kafka.consumer.ConsumerFetcherManager",
+			},
+		},
+		"white space runs and bare keys": {
+			data: "  a=1 \t debug  b=  c=x ",
+			want: mapstr.M{"a": "1", "debug": "", "b": "", "c": "x"},
+		},
+		"escapes in quoted values": {
+			data: `msg="say \"hi\"\n\tand \\ leave" path="C:\Temp"`,
+			want: mapstr.M{"msg": "say \"hi\"\n\tand \\ leave", "path": `C:\Temp`},
+		},
+		"quoted keys": {
+			data: `"user name"=bob`,
+			want: mapstr.M{"user name": "bob"},
+		},
+		"repeated keys": {
+			data: "a=1 a=2",
+			want: mapstr.M{"a": "2"},
+		},
+		"custom separators": {
+			config: map[string]interface{}{
+				"pair_separator":  ",",
+				"value_separator": ":",
+				"quote_chars":     `"'`,
+			},
+			data: `user: bob , role:'site admin',, team : "a, b" `,
+			want: mapstr.M{"user": "bob", "role": "site admin", "team": "a, b"},
+		},
+		"query string": {
+			config: map[string]interface{}{
+				"pair_separator": "&",
+			},
+			data: "q=beats&page=2&lang",
+			want: mapstr.M{"q": "beats", "page": "2", "lang": ""},
+		},
+		"infer types": {
+			config: map[string]interface{}{
+				"infer_types": true,
+			},
+			data: `int=42 neg=-7 float=0.25 exp=1e3 yes=true no=false quoted="42" nan=NaN hex=0x1f version=1.2.3 flag`,
+			want: mapstr.M{
+				"int":     int64(42),
+				"neg":     int64(-7),
+				"float":   0.25,
+				"exp":     float64(1000),
+				"yes":     true,
+				"no":      false,
+				"quoted":  "42",
+				"nan":     "NaN",
+				"hex":     "0x1f",
+				"version": "1.2.3",
+				"flag":    true,
+			},
+		},
+		"dotted keys are kept by default": {
+			data: "http.status=200 http.method=GET",
+			want: mapstr.M{"http.status": "200", "http.method": "GET"},
+		},
+		"expand keys": {
+			config: map[string]interface{}{
+				"expand_keys": true,
+			},
+			data: "http.status=200 http.method=GET http=1",
+			want: mapstr.M{"http": mapstr.M{"status": "200", "method": "GET", "value": "1"}},
+		},
+		"empty key": {
+			data:    "a=1 =2",
+			want:    mapstr.M{"a": "1"},
+			wantErr: errEmptyKey,
+		},
+		"unterminated quote": {
+			data:    `a=1 msg="never closed`,
+			want:    mapstr.M{"a": "1"},
+			wantErr: errUnterminatedQuote,
+		},
+		"garbage after quoted value": {
+			data:    `msg="x"y b=2`,
+			want:    mapstr.M{},
+			wantErr: errMissingSeparator,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := DefaultDecoderConfig()
+			if tc.config != nil {
+				require.NoError(t, conf.MustNewConfigFrom(tc.config).Unpack(&cfg))
+			}
+
+			got, err := NewDecoder(cfg).Decode(tc.data)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestDecoderConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		config  map[string]interface{}
+		wantErr string
+	}{
+		"default": {
+			config: map[string]interface{}{},
+		},
+		"empty value separator": {
+			config:  map[string]interface{}{"value_separator": ""},
+			wantErr: "value_separator cannot be empty",
+		},
+		"same separators": {
+			config:  map[string]interface{}{"pair_separator": "=", "value_separator": "="},
+			wantErr: "overlap",
+		},
+		"backslash quote": {
+			config:  map[string]interface{}{"quote_chars": `\`},
+			wantErr: "cannot be used as a quote character",
+		},
+		"separator quote": {
+			config:  map[string]interface{}{"pair_separator": "|", "quote_chars": `"|`},
+			wantErr: "cannot be used as a quote character",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			err := conf.MustNewConfigFrom(tc.config).Unpack(&cfg)
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+}
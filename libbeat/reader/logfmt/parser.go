@@ -0,0 +1,93 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package logfmt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/v7/libbeat/reader"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// Parser is a logfmt parser that implements parser.Parser.
+type Parser struct {
+	reader  reader.Reader
+	cfg     *Config
+	decoder *Decoder
+	logger  *logp.Logger
+}
+
+// NewParser creates a new logfmt parser.
+func NewParser(r reader.Reader, cfg *Config) *Parser {
+	return &Parser{
+		reader:  r,
+		cfg:     cfg,
+		decoder: NewDecoder(cfg.DecoderConfig),
+		logger:  logp.NewLogger("reader_logfmt"),
+	}
+}
+
+// Next reads the next message and decodes its key=value pairs. The content
+// of the message is left unchanged.
+func (p *Parser) Next() (reader.Message, error) {
+	msg, err := p.reader.Next()
+	if err != nil {
+		return msg, err
+	}
+
+	fields, err := p.decoder.Decode(strings.TrimRight(string(msg.Content), "\r\n"))
+	if err != nil {
+		if !p.cfg.IgnoreDecodingError {
+			p.logger.Errorf("Error decoding logfmt message: %v", err)
+		}
+		if p.cfg.AddErrorKey {
+			msg.AddFields(mapstr.M{"error": mapstr.M{
+				"message": fmt.Sprintf("Error decoding logfmt message: %v", err),
+				"type":    "logfmt",
+			}})
+		}
+	}
+	if len(fields) == 0 {
+		return msg, nil
+	}
+
+	if p.cfg.Target != "" {
+		m := mapstr.M{}
+		_, _ = m.Put(p.cfg.Target, fields)
+		msg.AddFields(m)
+		return msg, nil
+	}
+
+	if msg.Fields == nil {
+		msg.Fields = mapstr.M{}
+	}
+	for k, v := range fields {
+		if _, exists := msg.Fields[k]; exists && !p.cfg.OverwriteKeys {
+			continue
+		}
+		msg.Fields[k] = v
+	}
+	return msg, nil
+}
+
+// Close closes the underlying reader.
+func (p *Parser) Close() error {
+	return p.reader.Close()
+}
@@ -0,0 +1,117 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package logfmt
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/reader"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+type messageReader struct {
+	messages []reader.Message
+}
+
+func (r *messageReader) Next() (reader.Message, error) {
+	if len(r.messages) == 0 {
+		return reader.Message{}, io.EOF
+	}
+	msg := r.messages[0]
+	r.messages = r.messages[1:]
+	return msg, nil
+}
+
+func (r *messageReader) Close() error { return nil }
+
+func TestParser(t *testing.T) {
+	tests := map[string]struct {
+		config func(*Config)
+		in     reader.Message
+		want   mapstr.M
+	}{
+		"default target": {
+			in:   reader.Message{Content: []byte("level=warn msg=\"disk full\"\n")},
+			want: mapstr.M{"logfmt": mapstr.M{"level": "warn", "msg": "disk full"}},
+		},
+		"nested target": {
+			config: func(c *Config) { c.Target = "app.kv" },
+			in:     reader.Message{Content: []byte("a=1")},
+			want:   mapstr.M{"app": mapstr.M{"kv": mapstr.M{"a": "1"}}},
+		},
+		"root target keeps existing fields": {
+			config: func(c *Config) { c.Target = "" },
+			in: reader.Message{
+				Content: []byte("a=1 b=2"),
+				Fields:  mapstr.M{"a": "old"},
+			},
+			want: mapstr.M{"a": "old", "b": "2"},
+		},
+		"root target overwrites existing fields": {
+			config: func(c *Config) {
+				c.Target = ""
+				c.OverwriteKeys = true
+			},
+			in: reader.Message{
+				Content: []byte("a=1 b=2"),
+				Fields:  mapstr.M{"a": "old"},
+			},
+			want: mapstr.M{"a": "1", "b": "2"},
+		},
+		"no pairs": {
+			in:   reader.Message{Content: []byte("\n")},
+			want: nil,
+		},
+		"error key": {
+			config: func(c *Config) {
+				c.AddErrorKey = true
+				c.IgnoreDecodingError = true
+			},
+			in: reader.Message{Content: []byte(`a=1 b="open`)},
+			want: mapstr.M{
+				"logfmt": mapstr.M{"a": "1"},
+				"error": mapstr.M{
+					"message": "Error decoding logfmt message: error at position 6: unterminated quoted string",
+					"type":    "logfmt",
+				},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			if tc.config != nil {
+				tc.config(&cfg)
+			}
+			p := NewParser(&messageReader{messages: []reader.Message{tc.in}}, &cfg)
+
+			msg, err := p.Next()
+			require.NoError(t, err)
+			assert.Equal(t, tc.in.Content, msg.Content)
+			assert.Equal(t, tc.want, msg.Fields)
+
+			_, err = p.Next()
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
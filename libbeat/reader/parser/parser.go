@@ -27,7 +27,9 @@ import (
 	"github.com/elastic/beats/v7/libbeat/common/cfgtype"
 	"github.com/elastic/beats/v7/libbeat/reader"
 	"github.com/elastic/beats/v7/libbeat/reader/filter"
+	"github.com/elastic/beats/v7/libbeat/reader/logfmt"
 	"github.com/elastic/beats/v7/libbeat/reader/multiline"
+	"github.com/elastic/beats/v7/libbeat/reader/readcsv"
 	"github.com/elastic/beats/v7/libbeat/reader/readfile"
 	"github.com/elastic/beats/v7/libbeat/reader/readjson"
 	"github.com/elastic/beats/v7/libbeat/reader/syslog"
@@ -50,6 +52,12 @@ type CommonConfig struct {
 	LineTerminator readfile.LineTerminator `config:"line_terminator"`
 }
 
+// State is the state of the parsers for a single source, that inputs
+// persist to resume reading the source.
+type State struct {
+	CSV readcsv.State
+}
+
 type Config struct {
 	Suffix string
 
@@ -128,6 +136,20 @@ func NewConfig(pCfg CommonConfig, parsers []config.Namespace) (*Config, error) {
 			if err != nil {
 				return nil, fmt.Errorf("error while parsing include_message parser config: %w", err)
 			}
+		case "csv":
+			config := readcsv.DefaultConfig()
+			cfg := ns.Config()
+			err := cfg.Unpack(&config)
+			if err != nil {
+				return nil, fmt.Errorf("error while parsing csv parser config: %w", err)
+			}
+		case "logfmt":
+			config := logfmt.DefaultConfig()
+			cfg := ns.Config()
+			err := cfg.Unpack(&config)
+			if err != nil {
+				return nil, fmt.Errorf("error while parsing logfmt parser config: %w", err)
+			}
 		default:
 			return nil, fmt.Errorf("%s: %w", name, ErrNoSuchParser)
 		}
@@ -142,6 +164,13 @@ func NewConfig(pCfg CommonConfig, parsers []config.Namespace) (*Config, error) {
 }
 
 func (c *Config) Create(in reader.Reader) Parser {
+	return c.CreateWithState(in, nil)
+}
+
+// CreateWithState creates the parsers like Create, restoring their state
+// from s. The parsers update s while reading, so the input can persist it
+// with the position in the source.
+func (c *Config) CreateWithState(in reader.Reader, s *State) Parser {
 	p := in
 	for _, ns := range c.parsers {
 		name := ns.Name()
@@ -189,6 +218,26 @@ func (c *Config) Create(in reader.Reader) Parser {
 				return p
 			}
 			p = filter.NewParser(p, &config)
+		case "csv":
+			config := readcsv.DefaultConfig()
+			cfg := ns.Config()
+			err := cfg.Unpack(&config)
+			if err != nil {
+				return p
+			}
+			var state *readcsv.State
+			if s != nil {
+				state = &s.CSV
+			}
+			p = readcsv.NewParser(p, &config, int(c.pCfg.MaxBytes), state)
+		case "logfmt":
+			config := logfmt.DefaultConfig()
+			cfg := ns.Config()
+			err := cfg.Unpack(&config)
+			if err != nil {
+				return p
+			}
+			p = logfmt.NewParser(p, &config)
 		default:
 			return p
 		}
@@ -301,6 +301,61 @@ func TestParsersConfigAndReading(t *testing.T) {
 `,
 			},
 		},
+		"csv parser with a multiline record": {
+			parsers: map[string]interface{}{
+				"parsers": []map[string]interface{}{
+					{
+						"csv": map[string]interface{}{
+							"header": true,
+						},
+					},
+				},
+			},
+			lines: "id,text\n1,\"first\n\nsecond\"\n2,third\n",
+			expectedMessages: []string{
+				"1,\"first\n\nsecond\"\n",
+				"2,third\n",
+			},
+		},
+		"csv parser with invalid separator": {
+			parsers: map[string]interface{}{
+				"parsers": []map[string]interface{}{
+					{
+						"csv": map[string]interface{}{
+							"separator": ";;",
+						},
+					},
+				},
+			},
+			expectedError: "separator must be a single character",
+		},
+		"logfmt parser": {
+			parsers: map[string]interface{}{
+				"parsers": []map[string]interface{}{
+					{
+						"logfmt": map[string]interface{}{
+							"pair_separator": "&",
+						},
+					},
+				},
+			},
+			lines: "a=1&b=2\n",
+			expectedMessages: []string{
+				"a=1&b=2\n",
+			},
+		},
+		"logfmt parser with invalid separators": {
+			parsers: map[string]interface{}{
+				"parsers": []map[string]interface{}{
+					{
+						"logfmt": map[string]interface{}{
+							"value_separator": " ",
+						},
+					},
+				},
+			},
+			expectedError: "value_separator cannot be empty or white space",
+		},
 	}
 
 	for name, test := range tests {
@@ -768,6 +823,36 @@ func TestParserIncludeMessages(t *testing.T) {
 	require.Equal(t, expectedMessages, readMsgs, "fii")
 }
 
+func TestCSVParserState(t *testing.T) {
+	parserConfig := map[string]interface{}{
+		"parsers": []map[string]interface{}{
+			{
+				"csv": map[string]interface{}{
+					"header": true,
+				},
+			},
+		},
+	}
+
+	cfg := config.MustNewConfigFrom(parserConfig)
+	var c inputParsersConfig
+	err := cfg.Unpack(&c)
+	require.NoError(t, err)
+
+	var state State
+	p := c.Parsers.CreateWithState(testReader("id,name\n1,foo\n"), &state)
+	msg, err := p.Next()
+	require.NoError(t, err)
+	require.Equal(t, []string{"id", "name"}, state.CSV.Header)
+	require.Equal(t, mapstr.M{"id": "1", "name": "foo"}, msg.Fields["csv"])
+
+	// A parser resuming after the header uses the persisted header.
+	p = c.Parsers.CreateWithState(testReader("2,bar\n"), &state)
+	msg, err = p.Next()
+	require.NoError(t, err)
+	require.Equal(t, mapstr.M{"id": "2", "name": "bar"}, msg.Fields["csv"])
+}
+
 type testParsersConfig struct {
 	Parsers []config.Namespace `struct:"parsers"`
 }
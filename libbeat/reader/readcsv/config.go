@@ -0,0 +1,85 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package readcsv
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Config holds the options of the csv parser.
+type Config struct {
+	// Separator is the character separating the fields, for example "\t" for
+	// TSV files.
+	Separator string `config:"separator"`
+	// Comment is the character starting comment lines, that are dropped.
+	Comment string `config:"comment"`
+	// TrimLeadingSpace ignores the white space at the start of the fields.
+	TrimLeadingSpace bool `config:"trim_leading_space"`
+	// Header uses the first record of each file as the names of the fields.
+	Header bool `config:"header"`
+	// Columns are the names of the fields. They take precedence over the
+	// header row.
+	Columns []string `config:"columns"`
+	// Target is the field the decoded fields are written to. If it is empty,
+	// the fields are written to the root of the event.
+	Target string `config:"target"`
+	// OverwriteKeys allows the decoded fields to overwrite existing fields
+	// when Target is empty.
+	OverwriteKeys bool `config:"overwrite_keys"`
+	// IgnoreDecodingError disables logging errors for invalid records.
+	IgnoreDecodingError bool `config:"ignore_decoding_error"`
+	// AddErrorKey adds an error.message field to invalid records.
+	AddErrorKey bool `config:"add_error_key"`
+}
+
+// DefaultConfig returns the default configuration of the csv parser.
+func DefaultConfig() Config {
+	return Config{
+		Separator: ",",
+		Target:    "csv",
+	}
+}
+
+// Validate validates the configuration of the csv parser.
+func (c *Config) Validate() error {
+	if utf8.RuneCountInString(c.Separator) != 1 {
+		return fmt.Errorf("separator must be a single character, got %q", c.Separator)
+	}
+	if utf8.RuneCountInString(c.Comment) > 1 {
+		return fmt.Errorf("comment must be a single character, got %q", c.Comment)
+	}
+	if c.Comment != "" && c.Comment == c.Separator {
+		return errors.New("comment and separator must be different characters")
+	}
+	if c.Separator == `"` || c.Comment == `"` {
+		return errors.New("the double quote cannot be used as separator or comment character")
+	}
+	seen := make(map[string]struct{}, len(c.Columns))
+	for _, column := range c.Columns {
+		if column == "" {
+			return errors.New("column names cannot be empty")
+		}
+		if _, ok := seen[column]; ok {
+			return fmt.Errorf("duplicated column name %q", column)
+		}
+		seen[column] = struct{}{}
+	}
+	return nil
+}
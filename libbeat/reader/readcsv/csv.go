@@ -0,0 +1,282 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package readcsv implements the csv parser, which decodes CSV and TSV
+// records, including quoted fields spanning multiple lines.
+package readcsv
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/elastic/beats/v7/libbeat/reader"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// State is the state of the parser for a single source. Inputs persist it,
+// so the header row is known when they resume reading a source past it.
+type State struct {
+	Header []string
+}
+
+// Parser decodes CSV records. A record is made of the lines read until all
+// the quoted fields are closed, so quoted fields can contain line breaks.
+type Parser struct {
+	reader   reader.Reader
+	cfg      Config
+	state    *State
+	maxBytes int
+	logger   *logp.Logger
+
+	separator rune
+	comment   rune
+}
+
+// NewParser creates a csv parser. Records larger than maxBytes are not
+// decoded. If state is nil, the header row is only kept in memory.
+func NewParser(r reader.Reader, cfg *Config, maxBytes int, state *State) *Parser {
+	if state == nil {
+		state = &State{}
+	}
+	p := &Parser{
+		reader:   r,
+		cfg:      *cfg,
+		state:    state,
+		maxBytes: maxBytes,
+		logger:   logp.NewLogger("parser_csv"),
+	}
+	p.separator, _ = utf8.DecodeRuneInString(cfg.Separator)
+	if cfg.Comment != "" {
+		p.comment, _ = utf8.DecodeRuneInString(cfg.Comment)
+	}
+	return p
+}
+
+// Next returns the next record, with the decoded fields added to it.
+func (p *Parser) Next() (reader.Message, error) {
+	// discarded accounts for the bytes of the comments and the header row, so
+	// the inputs can correctly track the offset of the source.
+	var discarded int
+
+	for {
+		message, size, err := p.readRecord()
+		if err != nil {
+			return message, err
+		}
+		message.Offset += discarded
+
+		content := bytes.TrimRight(message.Content, "\r\n")
+		if len(content) == 0 {
+			return message, nil
+		}
+		if p.comment != 0 && p.isComment(content) {
+			discarded = message.Offset + message.Bytes
+			continue
+		}
+
+		if size > p.maxBytes {
+			p.reportError(&message, fmt.Errorf("record of %d bytes exceeds the limit of %d bytes", size, p.maxBytes))
+			return message, nil
+		}
+
+		values, err := p.decode(content)
+		if err != nil {
+			p.reportError(&message, err)
+			return message, nil
+		}
+
+		if p.cfg.Header && p.state.Header == nil {
+			p.state.Header = values
+			discarded = message.Offset + message.Bytes
+			continue
+		}
+
+		p.addFields(&message, values)
+		return message, nil
+	}
+}
+
+// readRecord reads lines until the quotes are balanced. If the source ends
+// in the middle of a record, the lines of the record are dropped, and they
+// are read again when the input resumes reading the source.
+// A record larger than maxBytes is still read up to its end, so its
+// remaining lines are not decoded as records, but the lines past the limit
+// are discarded. size is the size of the whole record, without the final
+// line break.
+func (p *Parser) readRecord() (record reader.Message, size int, err error) {
+	record, err = p.reader.Next()
+	if err != nil {
+		return record, 0, err
+	}
+	if p.comment != 0 && p.isComment(record.Content) {
+		return record, len(record.Content), nil
+	}
+
+	quotes := quoteState{fieldStart: true}
+	p.scanQuotes(&quotes, record.Content)
+	size = len(record.Content)
+	last := record.Content
+	for quotes.inQuotes {
+		line, err := p.reader.Next()
+		if err != nil {
+			return line, 0, err
+		}
+		record.Bytes += line.Offset + line.Bytes
+
+		newline := !bytes.HasSuffix(last, []byte("\n"))
+		if newline {
+			p.scanQuotes(&quotes, []byte("\n"))
+			size++
+		}
+		p.scanQuotes(&quotes, line.Content)
+		size += len(line.Content)
+		last = line.Content
+
+		if size > p.maxBytes {
+			continue
+		}
+		content := make([]byte, 0, size)
+		content = append(content, record.Content...)
+		if newline {
+			content = append(content, '\n')
+		}
+		record.Content = append(content, line.Content...)
+	}
+
+	size -= len(last) - len(bytes.TrimRight(last, "\r\n"))
+	return record, size, nil
+}
+
+// quoteState tracks the quoted fields of a record while its lines are read.
+type quoteState struct {
+	inQuotes, fieldStart, closed bool
+}
+
+// quotesOpen returns true if a quoted field is not closed, in which case the
+// record continues on the next line.
+func (p *Parser) quotesOpen(content []byte) bool {
+	quotes := quoteState{fieldStart: true}
+	p.scanQuotes(&quotes, content)
+	return quotes.inQuotes
+}
+
+// scanQuotes updates the state with the content. Only a quote at the start
+// of a field opens a quoted field, quotes inside quoted fields are doubled.
+func (p *Parser) scanQuotes(quotes *quoteState, content []byte) {
+	for _, r := range string(content) {
+		if quotes.inQuotes {
+			if r == '"' {
+				quotes.inQuotes, quotes.closed = false, true
+			}
+			continue
+		}
+
+		switch {
+		case r == '"' && (quotes.fieldStart || quotes.closed):
+			// Opening quote, or the second quote of a doubled quote.
+			quotes.inQuotes = true
+		case r == p.separator || r == '\n':
+			quotes.fieldStart = true
+		case quotes.fieldStart && p.cfg.TrimLeadingSpace && unicode.IsSpace(r):
+		default:
+			quotes.fieldStart = false
+		}
+		quotes.closed = false
+	}
+}
+
+func (p *Parser) isComment(content []byte) bool {
+	r, _ := utf8.DecodeRune(content)
+	return r == p.comment
+}
+
+func (p *Parser) decode(content []byte) ([]string, error) {
+	if p.quotesOpen(content) {
+		return nil, errors.New("unterminated quoted field")
+	}
+
+	r := csv.NewReader(bytes.NewReader(content))
+	r.Comma = p.separator
+	r.TrimLeadingSpace = p.cfg.TrimLeadingSpace
+	r.FieldsPerRecord = -1
+	return r.Read()
+}
+
+func (p *Parser) addFields(message *reader.Message, values []string) {
+	names := p.cfg.Columns
+	if len(names) == 0 {
+		names = p.state.Header
+	}
+
+	var decoded interface{} = values
+	if len(names) > 0 {
+		fields := make(mapstr.M, len(values))
+		for i, v := range values {
+			name := "column" + strconv.Itoa(i+1)
+			if i < len(names) {
+				name = names[i]
+			}
+			fields[name] = v
+		}
+		decoded = fields
+	}
+
+	if p.cfg.Target != "" {
+		fields := mapstr.M{}
+		_, _ = fields.Put(p.cfg.Target, decoded)
+		message.AddFields(fields)
+		return
+	}
+
+	fields, ok := decoded.(mapstr.M)
+	if !ok {
+		// Without names, the values can't be written to the root of the event.
+		fields = mapstr.M{"csv": values}
+	}
+	if message.Fields == nil {
+		message.Fields = mapstr.M{}
+	}
+	for k, v := range fields {
+		if _, exists := message.Fields[k]; exists && !p.cfg.OverwriteKeys {
+			continue
+		}
+		message.Fields[k] = v
+	}
+}
+
+func (p *Parser) reportError(message *reader.Message, err error) {
+	if !p.cfg.IgnoreDecodingError {
+		p.logger.Errorf("Error decoding CSV: %v", err)
+	}
+	if p.cfg.AddErrorKey {
+		message.AddFields(mapstr.M{"error": mapstr.M{
+			"message": fmt.Sprintf("Error decoding CSV: %v", err),
+			"type":    "csv",
+		}})
+	}
+}
+
+// Close closes the underlying reader.
+func (p *Parser) Close() error {
+	return p.reader.Close()
+}
@@ -0,0 +1,302 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package readcsv
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/reader"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// lineReader returns one message per line, like the line reader of the
+// inputs after the new lines have been stripped.
+type lineReader struct {
+	lines []string
+}
+
+func newLineReader(content string) *lineReader {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return &lineReader{lines: lines}
+}
+
+func (r *lineReader) Next() (reader.Message, error) {
+	if len(r.lines) == 0 {
+		return reader.Message{}, io.EOF
+	}
+	line := r.lines[0]
+	r.lines = r.lines[1:]
+	return reader.Message{
+		Content: []byte(strings.TrimSuffix(line, "\n")),
+		Bytes:   len(line),
+		Fields:  mapstr.M{},
+	}, nil
+}
+
+func (r *lineReader) Close() error { return nil }
+
+func readAll(t *testing.T, p *Parser) []reader.Message {
+	t.Helper()
+
+	var messages []reader.Message
+	for {
+		msg, err := p.Next()
+		if err == io.EOF {
+			return messages
+		}
+		require.NoError(t, err)
+		messages = append(messages, msg)
+	}
+}
+
+func TestParser(t *testing.T) {
+	testCases := map[string]struct {
+		config   func(*Config)
+		input    string
+		expected []mapstr.M
+		content  []string
+	}{
+		"no header": {
+			input: "a,b,c\n1,2,3\n",
+			expected: []mapstr.M{
+				{"csv": []string{"a", "b", "c"}},
+				{"csv": []string{"1", "2", "3"}},
+			},
+		},
+		"header": {
+			config: func(c *Config) { c.Header = true },
+			input:  "name,age\nalice,30\nbob,40\n",
+			expected: []mapstr.M{
+				{"csv": mapstr.M{"name": "alice", "age": "30"}},
+				{"csv": mapstr.M{"name": "bob", "age": "40"}},
+			},
+		},
+		"columns": {
+			config: func(c *Config) { c.Columns = []string{"name", "age"} },
+			input:  "alice,30,extra\nbob\n",
+			expected: []mapstr.M{
+				{"csv": mapstr.M{"name": "alice", "age": "30", "column3": "extra"}},
+				{"csv": mapstr.M{"name": "bob"}},
+			},
+		},
+		"columns take precedence over the header": {
+			config: func(c *Config) {
+				c.Header = true
+				c.Columns = []string{"user", "years"}
+			},
+			input: "name,age\nalice,30\n",
+			expected: []mapstr.M{
+				{"csv": mapstr.M{"user": "alice", "years": "30"}},
+			},
+		},
+		"quoted fields with new lines": {
+			config: func(c *Config) { c.Header = true },
+			input:  "id,text\n1,\"first line\nsecond \"\"quoted\"\" line\n\"\n2,single\n",
+			expected: []mapstr.M{
+				{"csv": mapstr.M{"id": "1", "text": "first line\nsecond \"quoted\" line\n"}},
+				{"csv": mapstr.M{"id": "2", "text": "single"}},
+			},
+			content: []string{
+				"1,\"first line\nsecond \"\"quoted\"\" line\n\"",
+				"2,single",
+			},
+		},
+		"tsv": {
+			config: func(c *Config) { c.Separator = "\t" },
+			input:  "a b\tc\n",
+			expected: []mapstr.M{
+				{"csv": []string{"a b", "c"}},
+			},
+		},
+		"comments": {
+			config: func(c *Config) {
+				c.Comment = "#"
+				c.Header = true
+			},
+			input: "# exported \"users\nname\n# a comment\nalice\n",
+			expected: []mapstr.M{
+				{"csv": mapstr.M{"name": "alice"}},
+			},
+		},
+		"trim leading space": {
+			config: func(c *Config) { c.TrimLeadingSpace = true },
+			input:  "a,  b\n",
+			expected: []mapstr.M{
+				{"csv": []string{"a", "b"}},
+			},
+		},
+		"root target": {
+			config: func(c *Config) {
+				c.Target = ""
+				c.Columns = []string{"name"}
+			},
+			input: "alice\n",
+			expected: []mapstr.M{
+				{"name": "alice"},
+			},
+		},
+		"nested target": {
+			config: func(c *Config) {
+				c.Target = "users.csv"
+				c.Columns = []string{"name"}
+			},
+			input: "alice\n",
+			expected: []mapstr.M{
+				{"users": mapstr.M{"csv": mapstr.M{"name": "alice"}}},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			if tc.config != nil {
+				tc.config(&cfg)
+			}
+			require.NoError(t, cfg.Validate())
+
+			messages := readAll(t, NewParser(newLineReader(tc.input), &cfg, 1024, nil))
+			require.Len(t, messages, len(tc.expected))
+			for i, msg := range messages {
+				assert.Equal(t, tc.expected[i], msg.Fields)
+				if tc.content != nil {
+					assert.Equal(t, tc.content[i], string(msg.Content))
+				}
+			}
+		})
+	}
+}
+
+func TestParserOffsets(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Header = true
+	cfg.Comment = "#"
+	input := "# comment\nid,text\n1,\"multi\nline\"\n2,single\n"
+
+	messages := readAll(t, NewParser(newLineReader(input), &cfg, 1024, nil))
+	require.Len(t, messages, 2)
+
+	// The comment and the header row are accounted in the offset of the
+	// first record.
+	assert.Equal(t, len("# comment\nid,text\n"), messages[0].Offset)
+	assert.Equal(t, len("1,\"multi\nline\"\n"), messages[0].Bytes)
+	assert.Equal(t, 0, messages[1].Offset)
+	assert.Equal(t, len("2,single\n"), messages[1].Bytes)
+
+	total := 0
+	for _, msg := range messages {
+		total += msg.Offset + msg.Bytes
+	}
+	assert.Equal(t, len(input), total)
+}
+
+func TestParserState(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Header = true
+
+	state := &State{}
+	messages := readAll(t, NewParser(newLineReader("name,age\nalice,30\n"), &cfg, 1024, state))
+	require.Len(t, messages, 1)
+	assert.Equal(t, []string{"name", "age"}, state.Header)
+
+	// Resuming past the header row.
+	messages = readAll(t, NewParser(newLineReader("bob,40\n"), &cfg, 1024, state))
+	require.Len(t, messages, 1)
+	assert.Equal(t, mapstr.M{"csv": mapstr.M{"name": "bob", "age": "40"}}, messages[0].Fields)
+}
+
+func TestParserIncompleteRecord(t *testing.T) {
+	cfg := DefaultConfig()
+	p := NewParser(newLineReader("1,\"not closed\nyet\n"), &cfg, 1024, nil)
+
+	_, err := p.Next()
+	require.ErrorIs(t, err, io.EOF, "an incomplete record at the end of the source is not returned")
+}
+
+func TestParserErrors(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AddErrorKey = true
+	cfg.IgnoreDecodingError = true
+	p := NewParser(newLineReader("1,a\"b\n2,"+strings.Repeat("x", 20)+"\n"), &cfg, 16, nil)
+
+	messages := readAll(t, p)
+	require.Len(t, messages, 2)
+
+	for i, msg := range messages {
+		errMsg, err := msg.Fields.GetValue("error.message")
+		require.NoError(t, err, "message %d must have an error", i)
+		assert.Contains(t, errMsg, "Error decoding CSV")
+		assert.NotContains(t, msg.Fields, "csv")
+	}
+	assert.Equal(t, `1,a"b`, string(messages[0].Content), "a quote inside a field doesn't start a quoted field")
+}
+
+func TestConfigValidate(t *testing.T) {
+	testCases := map[string]struct {
+		config Config
+		err    string
+	}{
+		"default":            {config: DefaultConfig()},
+		"tab":                {config: Config{Separator: "\t"}},
+		"no separator":       {config: Config{}, err: "separator must be a single character"},
+		"long separator":     {config: Config{Separator: ",,"}, err: "separator must be a single character"},
+		"long comment":       {config: Config{Separator: ",", Comment: "//"}, err: "comment must be a single character"},
+		"same characters":    {config: Config{Separator: ",", Comment: ","}, err: "must be different"},
+		"quote separator":    {config: Config{Separator: `"`}, err: "double quote"},
+		"empty column":       {config: Config{Separator: ",", Columns: []string{"a", ""}}, err: "cannot be empty"},
+		"duplicated columns": {config: Config{Separator: ",", Columns: []string{"a", "a"}}, err: "duplicated column"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestParserOversizedRecord(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AddErrorKey = true
+	input := "1,\"" + strings.Repeat("x", 20) + "\n" + strings.Repeat("y", 20) + "\n\"\"z\n" + strings.Repeat("w", 20) + "\"\n2,ok\n"
+	p := NewParser(newLineReader(input), &cfg, 16, nil)
+
+	messages := readAll(t, p)
+	require.Len(t, messages, 2, "the lines of the oversized record must not be decoded as records")
+
+	errMsg, err := messages[0].Fields.GetValue("error.message")
+	require.NoError(t, err)
+	assert.Contains(t, errMsg, "record of 70 bytes exceeds the limit of 16 bytes")
+	assert.NotContains(t, messages[0].Fields, "csv")
+	assert.Equal(t, "1,\""+strings.Repeat("x", 20), string(messages[0].Content), "the lines past the limit are discarded")
+	assert.Equal(t, len(input)-len("2,ok\n"), messages[0].Offset+messages[0].Bytes)
+
+	assert.Equal(t, mapstr.M{"csv": []string{"2", "ok"}}, messages[1].Fields)
+}
@@ -0,0 +1,82 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package boltstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/elastic/beats/v7/libbeat/statestore/backend"
+	"github.com/elastic/beats/v7/libbeat/statestore/backend/memlog"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// importMemlogStore copies the key-value pairs of the memlog store with the
+// given name, if one exists in the registry root, into the database. All
+// pairs are written in a single transaction, so a failed import leaves the
+// database empty.
+func importMemlogStore(log *logp.Logger, db *bolt.DB, settings Settings, name string) error {
+	home := filepath.Join(settings.Root, name)
+	if _, err := os.Stat(filepath.Join(home, "meta.json")); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to check for memlog store '%v': %w", home, err)
+	}
+
+	reg, err := memlog.New(log, memlog.Settings{Root: settings.Root, FileMode: settings.FileMode})
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+	memStore, err := reg.Access(name)
+	if err != nil {
+		return fmt.Errorf("failed to open memlog store '%v' for migration: %w", home, err)
+	}
+	defer memStore.Close()
+
+	count := 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		states := tx.Bucket(statesBucket)
+		err := memStore.Each(func(key string, dec backend.ValueDecoder) (bool, error) {
+			var value map[string]interface{}
+			if err := dec.Decode(&value); err != nil {
+				return false, fmt.Errorf("failed to decode key '%v': %w", key, err)
+			}
+			encoded, err := encodeValue(value)
+			if err != nil {
+				return false, fmt.Errorf("failed to encode key '%v': %w", key, err)
+			}
+			count++
+			return true, states.Put([]byte(key), encoded)
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucket).Put(migratedKey, []byte(home))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import memlog store '%v': %w", home, err)
+	}
+
+	log.Infof("Imported %d entries from memlog store '%v'", count, home)
+	return nil
+}
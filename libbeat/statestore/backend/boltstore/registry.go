@@ -0,0 +1,136 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package boltstore implements a statestore backend keeping the key-value
+// pairs in a bbolt database on disk. Unlike memlog, the store does not hold
+// all pairs in memory and does not need to rewrite the full state in
+// checkpoints: every update is written to the database in its own
+// transaction.
+//
+// Each store is kept in a single database file named `<store>.db` in the
+// registry root directory, with the key-value pairs in the `states` bucket
+// encoded as JSON, and store metadata in the `meta` bucket.
+//
+// When a database is created and a memlog store with the same name exists in
+// the registry root directory, its key-value pairs are imported into the new
+// database. The memlog files are left in place, but are not read anymore.
+package boltstore
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/statestore/backend"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// Registry configures access to bbolt based stores.
+type Registry struct {
+	log *logp.Logger
+
+	mu     sync.Mutex
+	active bool
+
+	settings Settings
+
+	wg sync.WaitGroup
+}
+
+// Settings configures a new Registry.
+type Settings struct {
+	// Registry root directory. Stores are database files in this directory.
+	Root string
+
+	// FileMode is used to configure the file mode of the database files.
+	// File mode 0600 will be used if this field is not set.
+	FileMode os.FileMode
+
+	// Timeout is how long to wait for the lock of a database file held by
+	// another process. Defaults to 5 seconds.
+	Timeout time.Duration
+
+	// If set, the key-value pairs of an existing memlog store are not
+	// imported when a database is created.
+	DisableMigration bool
+}
+
+const defaultFileMode os.FileMode = 0600
+
+const defaultTimeout = 5 * time.Second
+
+// New configures a bbolt Registry that can be used to open stores.
+func New(log *logp.Logger, settings Settings) (*Registry, error) {
+	if settings.FileMode == 0 {
+		settings.FileMode = defaultFileMode
+	}
+	if settings.Timeout == 0 {
+		settings.Timeout = defaultTimeout
+	}
+
+	root, err := filepath.Abs(settings.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	settings.Root = root
+	return &Registry{
+		log:      log,
+		active:   true,
+		settings: settings,
+	}, nil
+}
+
+// Access creates or opens the database of a store. If the database is
+// created, the key-value pairs of a memlog store with the same name are
+// imported into it.
+func (r *Registry) Access(name string) (backend.Store, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.active {
+		return nil, errRegClosed
+	}
+
+	logger := r.log.With("store", name)
+	store, err := openStore(logger, r.settings, name)
+	if err != nil {
+		return nil, err
+	}
+
+	r.wg.Add(1)
+	store.onClose = r.wg.Done
+	return store, nil
+}
+
+// Close closes the registry. No new store can be accessed during close.
+// Close blocks until all stores have been closed.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	r.active = false
+	r.mu.Unlock()
+
+	// block until all stores have been closed
+	r.wg.Wait()
+	return nil
+}
+
+// DatabasePath returns the path of the database file of a store.
+func DatabasePath(root, name string) string {
+	return filepath.Join(root, name+".db")
+}
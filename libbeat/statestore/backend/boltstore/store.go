@@ -0,0 +1,290 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package boltstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/elastic/beats/v7/libbeat/common/transform/typeconv"
+	"github.com/elastic/beats/v7/libbeat/statestore/backend"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// store is a key-value store backed by a bbolt database. bbolt allows
+// one writer and multiple concurrent readers, so the store needs no
+// additional locking.
+type store struct {
+	log     *logp.Logger
+	db      *bolt.DB
+	path    string
+	options *bolt.Options
+	mode    os.FileMode
+
+	closeOnce sync.Once
+	onClose   func()
+}
+
+// entry decodes a value read from the database. It is only valid within
+// the transaction that read it.
+type entry []byte
+
+var (
+	statesBucket = []byte("states")
+	metaBucket   = []byte("meta")
+
+	versionKey  = []byte("version")
+	migratedKey = []byte("migrated_from")
+)
+
+// The version of the database layout, stored in the meta bucket.
+const storeVersion = "1"
+
+// openStore opens or creates the database of the store with the given name,
+// importing the memlog store with the same name if the database is new.
+func openStore(log *logp.Logger, settings Settings, name string) (*store, error) {
+	if err := os.MkdirAll(settings.Root, os.ModeDir|0770); err != nil {
+		return nil, err
+	}
+
+	path := DatabasePath(settings.Root, name)
+	options := &bolt.Options{Timeout: settings.Timeout}
+	db, err := bolt.Open(path, settings.FileMode, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store database '%v': %w", path, err)
+	}
+	if err := os.Chmod(path, settings.FileMode); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to update database file permissions: %w", err)
+	}
+
+	var created bool
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if version := meta.Get(versionKey); version != nil {
+			if string(version) != storeVersion {
+				return fmt.Errorf("%w: version %s", errInvalidFormat, version)
+			}
+		} else {
+			created = true
+			if err := meta.Put(versionKey, []byte(storeVersion)); err != nil {
+				return err
+			}
+		}
+		_, err = tx.CreateBucketIfNotExists(statesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize store database '%v': %w", path, err)
+	}
+
+	if created && !settings.DisableMigration {
+		if err := importMemlogStore(log, db, settings, name); err != nil {
+			db.Close()
+			// Remove the new database so the import is tried again on the
+			// next start.
+			os.Remove(path)
+			return nil, err
+		}
+	}
+
+	return &store{
+		log:     log,
+		db:      db,
+		path:    path,
+		options: options,
+		mode:    settings.FileMode,
+	}, nil
+}
+
+// Close closes the database.
+func (s *store) Close() error {
+	err := s.db.Close()
+	s.closeOnce.Do(func() {
+		if s.onClose != nil {
+			s.onClose()
+		}
+	})
+	return err
+}
+
+// Has checks if the key is known.
+func (s *store) Has(key string) (bool, error) {
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(statesBucket).Get([]byte(key)) != nil
+		return nil
+	})
+	return found, err
+}
+
+// Get retrieves and decodes the key-value pair into to.
+func (s *store) Get(key string, to interface{}) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(statesBucket).Get([]byte(key))
+		if value == nil {
+			return errKeyUnknown
+		}
+		return entry(value).Decode(to)
+	})
+}
+
+// Set inserts or overwrites a key-value pair. The value is written to the
+// database before Set returns.
+func (s *store) Set(key string, value interface{}) error {
+	encoded, err := encodeValue(value)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(statesBucket).Put([]byte(key), encoded)
+	})
+}
+
+// Remove removes a key from the store. The operation does not check if the
+// key exists.
+func (s *store) Remove(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(statesBucket).Delete([]byte(key))
+	})
+}
+
+// Each iterates over all key-value pairs in the store, in key order.
+func (s *store) Each(fn func(string, backend.ValueDecoder) (bool, error)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(statesBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			cont, err := fn(string(k), entry(v))
+			if !cont || err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Compact rewrites the database into a new file, releasing the space of
+// removed key-value pairs, which the database only reuses otherwise. The
+// store must not be used concurrently.
+func (s *store) Compact() error {
+	tmpPath := s.path + ".compact"
+	dst, err := bolt.Open(tmpPath, s.mode, s.options)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted database: %w", err)
+	}
+	if err := bolt.Compact(dst, s.db, 0); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact database: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	// Reopen the original database if the compacted one can't replace it,
+	// so the store stays usable.
+	renameErr := os.Rename(tmpPath, s.path)
+	if renameErr != nil {
+		os.Remove(tmpPath)
+		renameErr = fmt.Errorf("failed to replace the database with the compacted one: %w", renameErr)
+	}
+	db, err := bolt.Open(s.path, s.mode, s.options)
+	if err != nil {
+		return errors.Join(renameErr, fmt.Errorf("failed to reopen store database '%v': %w", s.path, err))
+	}
+	s.db = db
+	return renameErr
+}
+
+func (s *store) SetID(_ string) {
+	// NOOP
+}
+
+// encodeValue converts the value into a map of primitive types, like memlog
+// does, and encodes it as JSON.
+func encodeValue(value interface{}) ([]byte, error) {
+	var tmp mapstr.M
+	if err := typeconv.Convert(&tmp, value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(tmp)
+}
+
+func (e entry) Decode(to interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(e))
+	dec.UseNumber()
+	var value map[string]interface{}
+	if err := dec.Decode(&value); err != nil {
+		return err
+	}
+	if err := decodeNumbers(value); err != nil {
+		return err
+	}
+	return typeconv.Convert(to, value)
+}
+
+// decodeNumbers replaces the json.Number values in v by integers if they
+// are, so values above 2^53 keep their exact value, and by floats otherwise.
+func decodeNumbers(v interface{}) error {
+	var err error
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if v[key], err = decodeNumber(value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			if v[i], err = decodeNumber(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func decodeNumber(v interface{}) (interface{}, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v, decodeNumbers(v)
+	}
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return u, nil
+	}
+	return n.Float64()
+}
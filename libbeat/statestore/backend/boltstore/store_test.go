@@ -0,0 +1,187 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package boltstore
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/statestore/backend"
+	"github.com/elastic/beats/v7/libbeat/statestore/backend/memlog"
+	"github.com/elastic/beats/v7/libbeat/statestore/internal/storecompliance"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+)
+
+func TestCompliance(t *testing.T) {
+	storecompliance.TestBackendCompliance(t, func(testPath string) (backend.Registry, error) {
+		logger := logptest.NewTestingLogger(t, "")
+		return New(logger.Named("test"), Settings{Root: testPath})
+	})
+}
+
+func TestFileMode(t *testing.T) {
+	root := t.TempDir()
+	reg, err := New(logptest.NewTestingLogger(t, ""), Settings{Root: root, FileMode: 0640})
+	require.NoError(t, err)
+	store, err := reg.Access("test")
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	require.NoError(t, reg.Close())
+
+	info, err := os.Stat(DatabasePath(root, "test"))
+	require.NoError(t, err)
+	if os.PathSeparator == '/' {
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	}
+}
+
+func TestMigrateMemlog(t *testing.T) {
+	root := t.TempDir()
+	logger := logptest.NewTestingLogger(t, "")
+
+	type state struct {
+		Offset int64  `struct:"offset"`
+		Source string `struct:"source"`
+	}
+
+	memReg, err := memlog.New(logger, memlog.Settings{Root: root})
+	require.NoError(t, err)
+	memStore, err := memReg.Access("test")
+	require.NoError(t, err)
+	require.NoError(t, memStore.Set("a", state{Offset: 10, Source: "/var/log/a.log"}))
+	require.NoError(t, memStore.Set("b", state{Offset: 20, Source: "/var/log/b.log"}))
+	require.NoError(t, memStore.Set("c", state{Offset: 30}))
+	require.NoError(t, memStore.Remove("c"))
+	require.NoError(t, memStore.Close())
+	require.NoError(t, memReg.Close())
+
+	reg, err := New(logger, Settings{Root: root})
+	require.NoError(t, err)
+	store, err := reg.Access("test")
+	require.NoError(t, err)
+
+	got := map[string]state{}
+	err = store.Each(func(key string, dec backend.ValueDecoder) (bool, error) {
+		var st state
+		err := dec.Decode(&st)
+		got[key] = st
+		return true, err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]state{
+		"a": {Offset: 10, Source: "/var/log/a.log"},
+		"b": {Offset: 20, Source: "/var/log/b.log"},
+	}, got)
+
+	// Changes after the migration are kept, the memlog store is not
+	// imported again.
+	require.NoError(t, store.Remove("a"))
+	require.NoError(t, store.Close())
+	store, err = reg.Access("test")
+	require.NoError(t, err)
+	has, err := store.Has("a")
+	require.NoError(t, err)
+	assert.False(t, has)
+	has, err = store.Has("b")
+	require.NoError(t, err)
+	assert.True(t, has)
+	require.NoError(t, store.Close())
+	require.NoError(t, reg.Close())
+}
+
+func TestMigrationDisabled(t *testing.T) {
+	root := t.TempDir()
+	logger := logptest.NewTestingLogger(t, "")
+
+	memReg, err := memlog.New(logger, memlog.Settings{Root: root})
+	require.NoError(t, err)
+	memStore, err := memReg.Access("test")
+	require.NoError(t, err)
+	require.NoError(t, memStore.Set("a", map[string]interface{}{"offset": 10}))
+	require.NoError(t, memStore.Close())
+	require.NoError(t, memReg.Close())
+
+	reg, err := New(logger, Settings{Root: root, DisableMigration: true})
+	require.NoError(t, err)
+	store, err := reg.Access("test")
+	require.NoError(t, err)
+	has, err := store.Has("a")
+	require.NoError(t, err)
+	assert.False(t, has)
+	require.NoError(t, store.Close())
+	require.NoError(t, reg.Close())
+}
+
+func TestCompact(t *testing.T) {
+	root := t.TempDir()
+	reg, err := New(logptest.NewTestingLogger(t, ""), Settings{Root: root})
+	require.NoError(t, err)
+	defer reg.Close()
+	s, err := reg.Access("test")
+	require.NoError(t, err)
+	defer s.Close()
+
+	value := map[string]interface{}{"data": strings.Repeat("x", 1024)}
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, s.Set(fmt.Sprintf("key-%d", i), value))
+	}
+	for i := 1; i < 1000; i++ {
+		require.NoError(t, s.Remove(fmt.Sprintf("key-%d", i)))
+	}
+	before, err := os.Stat(DatabasePath(root, "test"))
+	require.NoError(t, err)
+
+	require.NoError(t, s.(*store).Compact())
+
+	after, err := os.Stat(DatabasePath(root, "test"))
+	require.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size())
+
+	var got map[string]interface{}
+	require.NoError(t, s.Get("key-0", &got))
+	assert.Equal(t, value, got)
+	has, err := s.Has("key-1")
+	require.NoError(t, err)
+	assert.False(t, has)
+}
+
+func TestLargeIntegersRoundTrip(t *testing.T) {
+	reg, err := New(logptest.NewTestingLogger(t, ""), Settings{Root: t.TempDir()})
+	require.NoError(t, err)
+	defer reg.Close()
+	s, err := reg.Access("test")
+	require.NoError(t, err)
+	defer s.Close()
+
+	type state struct {
+		Offset uint64            `struct:"offset"`
+		Inode  int64             `struct:"inode"`
+		Meta   map[string]uint64 `struct:"meta"`
+	}
+	want := state{Offset: 1<<60 + 1, Inode: -(1<<60 + 1), Meta: map[string]uint64{"device": 1<<63 + 1}}
+	require.NoError(t, s.Set("key", want))
+
+	var got state
+	require.NoError(t, s.Get("key", &got))
+	assert.Equal(t, want, got)
+}
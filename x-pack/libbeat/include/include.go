@@ -19,4 +19,5 @@ import (
 
 	// register outputs
 	_ "github.com/elastic/beats/v7/x-pack/libbeat/outputs/otelconsumer"
+	_ "github.com/elastic/beats/v7/x-pack/libbeat/outputs/s3archive"
 )
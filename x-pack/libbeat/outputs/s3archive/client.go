@@ -0,0 +1,358 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package s3archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // MD5 is required by S3 for the Content-MD5 integrity header.
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/gzip"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/common/backoff"
+	"github.com/elastic/beats/v7/libbeat/common/fmtstr"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	"github.com/elastic/beats/v7/libbeat/publisher"
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// uploader is the subset of the S3 API used by the output.
+type uploader interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// client buffers events into one object per key prefix and uploads the
+// objects once they reach the configured size or age. Batches are ACKed only
+// once every object holding one of their events has been written.
+type client struct {
+	log      *logp.Logger
+	observer outputs.Observer
+	uploader uploader
+	codec    codec.Codec
+
+	beat             string
+	ephemeralID      string
+	bucket           string
+	keyFormat        *fmtstr.EventFormatString
+	compressionLevel int
+	flushSize        int
+	flushInterval    time.Duration
+	timeout          time.Duration
+
+	mu      sync.Mutex
+	objects map[string]*object
+	seq     uint64
+
+	// uploadMu serializes the uploads of Publish and of the flush loop,
+	// which share the backoff.
+	uploadMu sync.Mutex
+	backoff  backoff.Backoff
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// object is an archive object being assembled in memory.
+type object struct {
+	prefix  string
+	created time.Time
+	buf     bytes.Buffer
+	w       io.Writer
+	gz      *gzip.Writer
+	size    int
+	events  int
+	batches map[*pendingBatch][]publisher.Event
+}
+
+// pendingBatch tracks a batch until all objects holding its events have been
+// uploaded.
+type pendingBatch struct {
+	batch   publisher.Batch
+	pending int
+	failed  []publisher.Event
+}
+
+func newClient(
+	svc uploader,
+	beat beat.Info,
+	observer outputs.Observer,
+	config s3ArchiveConfig,
+	enc codec.Codec,
+) *client {
+	done := make(chan struct{})
+	c := &client{
+		log:              beat.Logger.Named(logSelector),
+		observer:         observer,
+		uploader:         svc,
+		codec:            enc,
+		beat:             beat.Beat,
+		ephemeralID:      beat.EphemeralID.String(),
+		bucket:           config.Bucket,
+		keyFormat:        config.KeyFormat,
+		compressionLevel: config.CompressionLevel,
+		flushSize:        int(config.FlushSize),
+		flushInterval:    config.FlushInterval,
+		timeout:          config.Timeout,
+		objects:          map[string]*object{},
+		backoff:          backoff.NewEqualJitterBackoff(done, config.Backoff.Init, config.Backoff.Max),
+		done:             done,
+	}
+
+	c.wg.Add(1)
+	go c.flushLoop()
+	return c
+}
+
+func (c *client) String() string {
+	return "s3(" + c.bucket + ")"
+}
+
+// Close stops the age based flushing and uploads all buffered objects.
+func (c *client) Close() error {
+	close(c.done)
+	c.wg.Wait()
+
+	c.mu.Lock()
+	objects := c.takeObjects(func(*object) bool { return true })
+	c.mu.Unlock()
+
+	for _, obj := range objects {
+		c.upload(obj)
+	}
+	return nil
+}
+
+func (c *client) Publish(_ context.Context, batch publisher.Batch) error {
+	events := batch.Events()
+	c.observer.NewBatch(len(events))
+
+	pb := &pendingBatch{batch: batch}
+	dropped := 0
+
+	c.mu.Lock()
+	for i := range events {
+		event := &events[i]
+		prefix, err := c.keyFormat.Run(&event.Content)
+		if err != nil {
+			c.log.Errorf("Failed to select object key: %+v", err)
+			dropped++
+			continue
+		}
+
+		serialized, err := c.codec.Encode(c.beat, &event.Content)
+		if err != nil {
+			c.log.Errorf("Failed to encode event: %+v", err)
+			c.log.Errorw(fmt.Sprintf("Failed event: %v", event.Content), logp.TypeKey, logp.EventType)
+			dropped++
+			continue
+		}
+
+		obj, err := c.objectFor(prefix)
+		if err != nil {
+			c.log.Errorf("Failed to create object buffer: %+v", err)
+			dropped++
+			continue
+		}
+		if err := obj.write(serialized); err != nil {
+			c.log.Errorf("Failed to buffer event: %+v", err)
+			dropped++
+			continue
+		}
+
+		if _, ok := obj.batches[pb]; !ok {
+			pb.pending++
+		}
+		obj.batches[pb] = append(obj.batches[pb], *event)
+	}
+
+	full := c.takeObjects(func(obj *object) bool { return obj.size >= c.flushSize })
+	c.mu.Unlock()
+
+	c.observer.PermanentErrors(dropped)
+	if pb.pending == 0 {
+		batch.ACK()
+	}
+
+	for _, obj := range full {
+		c.upload(obj)
+	}
+	return nil
+}
+
+func (c *client) flushLoop() {
+	defer c.wg.Done()
+
+	period := c.flushInterval
+	if period > time.Second {
+		period = time.Second
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			expired := c.takeObjects(func(obj *object) bool {
+				return now.Sub(obj.created) >= c.flushInterval
+			})
+			c.mu.Unlock()
+
+			for _, obj := range expired {
+				c.upload(obj)
+			}
+		}
+	}
+}
+
+// objectFor returns the object currently assembled for the key prefix,
+// creating a new one if required. Must be called with c.mu held.
+func (c *client) objectFor(prefix string) (*object, error) {
+	if obj, ok := c.objects[prefix]; ok {
+		return obj, nil
+	}
+
+	obj := &object{
+		prefix:  strings.Trim(prefix, "/"),
+		created: time.Now(),
+		batches: map[*pendingBatch][]publisher.Event{},
+	}
+	obj.w = &obj.buf
+	if c.compressionLevel > 0 {
+		gz, err := gzip.NewWriterLevel(&obj.buf, c.compressionLevel)
+		if err != nil {
+			return nil, err
+		}
+		obj.gz = gz
+		obj.w = gz
+	}
+	c.objects[prefix] = obj
+	return obj, nil
+}
+
+// takeObjects removes and returns all objects matching the predicate. Must be
+// called with c.mu held.
+func (c *client) takeObjects(pred func(*object) bool) []*object {
+	var taken []*object
+	for prefix, obj := range c.objects {
+		if pred(obj) {
+			taken = append(taken, obj)
+			delete(c.objects, prefix)
+		}
+	}
+	return taken
+}
+
+func (c *client) nextKey(obj *object) string {
+	c.mu.Lock()
+	c.seq++
+	seq := c.seq
+	c.mu.Unlock()
+
+	ext := ".ndjson"
+	if obj.gz != nil {
+		ext += ".gz"
+	}
+	name := fmt.Sprintf("%s-%s-%s-%06d%s",
+		c.beat, obj.created.UTC().Format("20060102T150405Z"), c.ephemeralID, seq, ext)
+	if obj.prefix == "" {
+		return name
+	}
+	return obj.prefix + "/" + name
+}
+
+// upload writes the object to the bucket and signals every batch that has no
+// more objects pending.
+func (c *client) upload(obj *object) {
+	c.uploadMu.Lock()
+	err := c.putObject(obj)
+	if err != nil {
+		c.log.Errorf("Failed to upload %d events to bucket %s: %+v", obj.events, c.bucket, err)
+		c.observer.RetryableErrors(obj.events)
+	} else {
+		c.observer.AckedEvents(obj.events)
+	}
+	backoff.WaitOnError(c.backoff, err)
+	c.uploadMu.Unlock()
+
+	c.mu.Lock()
+	var completed []*pendingBatch
+	for pb, events := range obj.batches {
+		if err != nil {
+			pb.failed = append(pb.failed, events...)
+		}
+		pb.pending--
+		if pb.pending == 0 {
+			completed = append(completed, pb)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, pb := range completed {
+		if len(pb.failed) > 0 {
+			pb.batch.RetryEvents(pb.failed)
+		} else {
+			pb.batch.ACK()
+		}
+	}
+}
+
+func (c *client) putObject(obj *object) error {
+	if obj.gz != nil {
+		if err := obj.gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	body := obj.buf.Bytes()
+	sum := md5.Sum(body) //nolint:gosec // See import.
+	input := &s3.PutObjectInput{
+		Bucket:        awssdk.String(c.bucket),
+		Key:           awssdk.String(c.nextKey(obj)),
+		Body:          bytes.NewReader(body),
+		ContentLength: awssdk.Int64(int64(len(body))),
+		ContentMD5:    awssdk.String(base64.StdEncoding.EncodeToString(sum[:])),
+		ContentType:   awssdk.String("application/x-ndjson"),
+	}
+	if obj.gz != nil {
+		input.ContentEncoding = awssdk.String("gzip")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	begin := time.Now()
+	_, err := c.uploader.PutObject(ctx, input)
+	c.observer.ReportLatency(time.Since(begin))
+	if err != nil {
+		c.observer.WriteError(err)
+		return err
+	}
+	c.observer.WriteBytes(len(body))
+	return nil
+}
+
+func (obj *object) write(serialized []byte) error {
+	if _, err := obj.w.Write(serialized); err != nil {
+		return err
+	}
+	if _, err := obj.w.Write([]byte("\n")); err != nil {
+		return err
+	}
+	obj.size += len(serialized) + 1
+	obj.events++
+	return nil
+}
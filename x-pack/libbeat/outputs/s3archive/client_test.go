@@ -0,0 +1,262 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+//go:build !integration
+
+package s3archive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	_ "github.com/elastic/beats/v7/libbeat/outputs/codec/json"
+	"github.com/elastic/beats/v7/libbeat/outputs/outest"
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp/logptest"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+// objectStore is a minimal S3-compatible stand-in accepting path style
+// PutObject requests.
+type objectStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+}
+
+func newObjectStore(t *testing.T) (*objectStore, *httptest.Server) {
+	store := &objectStore{objects: map[string][]byte{}, headers: map[string]http.Header{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		store.objects[r.URL.Path] = body
+		store.headers[r.URL.Path] = r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return store, server
+}
+
+func (s *objectStore) snapshot() map[string][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := make(map[string][]byte, len(s.objects))
+	for k, v := range s.objects {
+		objects[k] = v
+	}
+	return objects
+}
+
+func makeTestClient(t *testing.T, endpoint string, settings map[string]interface{}) *client {
+	t.Helper()
+
+	base := map[string]interface{}{
+		"bucket":            "archive",
+		"endpoint":          endpoint,
+		"path_style":        true,
+		"access_key_id":     "key",
+		"secret_access_key": "secret",
+		"key_format":        "%{[service]}/%{+yyyy}",
+		"backoff.init":      "1ms",
+		"backoff.max":       "1ms",
+	}
+	for k, v := range settings {
+		base[k] = v
+	}
+	cfg, err := config.NewConfigFrom(base)
+	require.NoError(t, err)
+
+	info := beat.Info{Beat: "testbeat", Logger: logptest.NewTestingLogger(t, "")}
+	group, err := makeS3Archive(nil, info, outputs.NewNilObserver(), cfg)
+	require.NoError(t, err)
+	require.Len(t, group.Clients, 1)
+	return group.Clients[0].(*client) //nolint:errcheck // This is a test file
+}
+
+func testEvents(service string, n int) []beat.Event {
+	events := make([]beat.Event, n)
+	for i := range events {
+		events[i] = beat.Event{
+			Timestamp: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+			Fields:    mapstr.M{"service": service, "n": i},
+		}
+	}
+	return events
+}
+
+func readLines(t *testing.T, body []byte) []string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	var lines []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+	return lines
+}
+
+func TestMakeS3ArchiveRequiresBucket(t *testing.T) {
+	cfg := config.MustNewConfigFrom(map[string]interface{}{})
+	info := beat.Info{Beat: "testbeat", Logger: logptest.NewTestingLogger(t, "")}
+	_, err := makeS3Archive(nil, info, outputs.NewNilObserver(), cfg)
+	assert.Error(t, err)
+}
+
+func TestFlushOnSize(t *testing.T) {
+	store, server := newObjectStore(t)
+	c := makeTestClient(t, server.URL, map[string]interface{}{
+		"flush_size":     "1",
+		"flush_interval": "1h",
+	})
+	defer c.Close()
+
+	batch := outest.NewBatch(append(testEvents("web", 2), testEvents("db", 1)...)...)
+	require.NoError(t, c.Publish(t.Context(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+
+	objects := store.snapshot()
+	require.Len(t, objects, 2)
+	counts := map[string]int{}
+	for key, body := range objects {
+		assert.True(t, strings.HasPrefix(key, "/archive/"), key)
+		assert.True(t, strings.HasSuffix(key, ".ndjson.gz"), key)
+		if strings.HasPrefix(key, "/archive/web/2024/testbeat-") {
+			counts["web"] += len(readLines(t, body))
+		} else if strings.HasPrefix(key, "/archive/db/2024/testbeat-") {
+			counts["db"] += len(readLines(t, body))
+		}
+		assert.Equal(t, "gzip", store.headers[key].Get("Content-Encoding"))
+	}
+	assert.Equal(t, map[string]int{"web": 2, "db": 1}, counts)
+}
+
+func TestACKAfterAgeFlush(t *testing.T) {
+	store, server := newObjectStore(t)
+	c := makeTestClient(t, server.URL, map[string]interface{}{
+		"flush_interval": "50ms",
+	})
+	defer c.Close()
+
+	acked := make(chan struct{})
+	batch := outest.NewBatch(testEvents("web", 3)...)
+	batch.OnSignal = func(sig outest.BatchSignal) {
+		if sig.Tag == outest.BatchACK {
+			close(acked)
+		}
+	}
+	require.NoError(t, c.Publish(t.Context(), batch))
+	assert.Empty(t, store.snapshot(), "object must not be written before the flush interval")
+
+	select {
+	case <-acked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch was not ACKed after the flush interval")
+	}
+	assert.Len(t, store.snapshot(), 1)
+}
+
+func TestFlushOnClose(t *testing.T) {
+	store, server := newObjectStore(t)
+	c := makeTestClient(t, server.URL, map[string]interface{}{
+		"flush_interval":    "1h",
+		"compression_level": 0,
+	})
+
+	batch := outest.NewBatch(testEvents("web", 2)...)
+	require.NoError(t, c.Publish(t.Context(), batch))
+	assert.Empty(t, batch.Signals)
+
+	require.NoError(t, c.Close())
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchACK, batch.Signals[0].Tag)
+
+	objects := store.snapshot()
+	require.Len(t, objects, 1)
+	for key, body := range objects {
+		assert.True(t, strings.HasSuffix(key, ".ndjson"), key)
+		assert.Equal(t, 2, strings.Count(string(body), "\n"))
+	}
+}
+
+type failingUploader struct{}
+
+func (failingUploader) PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return nil, errors.New("service unavailable")
+}
+
+func TestRetryOnUploadFailure(t *testing.T) {
+	store, server := newObjectStore(t)
+	c := makeTestClient(t, server.URL, map[string]interface{}{
+		"flush_size":     "1",
+		"flush_interval": "1h",
+	})
+	defer c.Close()
+	c.uploader = failingUploader{}
+
+	events := append(testEvents("web", 2), testEvents("db", 1)...)
+	batch := outest.NewBatch(events...)
+	require.NoError(t, c.Publish(t.Context(), batch))
+	require.Len(t, batch.Signals, 1)
+	assert.Equal(t, outest.BatchRetryEvents, batch.Signals[0].Tag)
+	assert.Len(t, batch.Signals[0].Events, len(events))
+	assert.Empty(t, store.snapshot())
+}
+
+func TestConcurrentUploads(t *testing.T) {
+	_, server := newObjectStore(t)
+	c := makeTestClient(t, server.URL, map[string]interface{}{
+		"flush_size":        "1KiB",
+		"flush_interval":    "1ms",
+		"compression_level": 0,
+	})
+	defer c.Close()
+	c.uploader = failingUploader{}
+
+	// Large events are uploaded by Publish, small ones by the flush loop,
+	// both backing off on the failed uploads.
+	large := testEvents("large", 1)
+	large[0].Fields["message"] = strings.Repeat("x", 2048)
+	var retried sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, events := range [][]beat.Event{large, testEvents("small", 1)} {
+			retried.Add(1)
+			batch := outest.NewBatch(events...)
+			batch.OnSignal = func(sig outest.BatchSignal) {
+				assert.Equal(t, outest.BatchRetryEvents, sig.Tag)
+				retried.Done()
+			}
+			require.NoError(t, c.Publish(t.Context(), batch))
+		}
+	}
+	retried.Wait()
+}
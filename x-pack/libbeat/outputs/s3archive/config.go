@@ -0,0 +1,60 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package s3archive
+
+import (
+	"errors"
+	"time"
+
+	"github.com/elastic/beats/v7/libbeat/common/cfgtype"
+	"github.com/elastic/beats/v7/libbeat/common/fmtstr"
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	awscommon "github.com/elastic/beats/v7/x-pack/libbeat/common/aws"
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+type s3ArchiveConfig struct {
+	AWSConfig        awscommon.ConfigAWS       `config:",inline"`
+	Bucket           string                    `config:"bucket"`
+	PathStyle        bool                      `config:"path_style"`
+	KeyFormat        *fmtstr.EventFormatString `config:"key_format"`
+	Codec            codec.Config              `config:"codec"`
+	CompressionLevel int                       `config:"compression_level" validate:"min=0, max=9"`
+	FlushSize        cfgtype.ByteSize          `config:"flush_size" validate:"min=1"`
+	FlushInterval    time.Duration             `config:"flush_interval" validate:"positive"`
+	Timeout          time.Duration             `config:"timeout" validate:"positive"`
+	BulkMaxSize      int                       `config:"bulk_max_size"`
+	MaxRetries       int                       `config:"max_retries"`
+	Backoff          backoffConfig             `config:"backoff"`
+	Queue            config.Namespace          `config:"queue"`
+}
+
+type backoffConfig struct {
+	Init time.Duration
+	Max  time.Duration
+}
+
+func defaultConfig() s3ArchiveConfig {
+	return s3ArchiveConfig{
+		KeyFormat:        fmtstr.MustCompileEvent("%{[agent.type]}/%{+yyyy}/%{+MM}/%{+dd}/%{+HH}"),
+		CompressionLevel: 5,
+		FlushSize:        16 * 1024 * 1024,
+		FlushInterval:    time.Minute,
+		Timeout:          time.Minute,
+		BulkMaxSize:      2048,
+		MaxRetries:       3,
+		Backoff: backoffConfig{
+			Init: 1 * time.Second,
+			Max:  60 * time.Second,
+		},
+	}
+}
+
+func (c *s3ArchiveConfig) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("bucket is required")
+	}
+	return nil
+}
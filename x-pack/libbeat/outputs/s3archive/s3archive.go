@@ -0,0 +1,61 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package s3archive
+
+import (
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/beats/v7/libbeat/outputs"
+	"github.com/elastic/beats/v7/libbeat/outputs/codec"
+	awscommon "github.com/elastic/beats/v7/x-pack/libbeat/common/aws"
+	"github.com/elastic/elastic-agent-libs/config"
+)
+
+func init() {
+	outputs.RegisterType("s3", makeS3Archive)
+}
+
+const logSelector = "s3"
+
+func makeS3Archive(
+	_ outputs.IndexManager,
+	beat beat.Info,
+	observer outputs.Observer,
+	cfg *config.C,
+) (outputs.Group, error) {
+	archiveConfig := defaultConfig()
+	if err := cfg.Unpack(&archiveConfig); err != nil {
+		return outputs.Fail(err)
+	}
+
+	awsConfig, err := awscommon.InitializeAWSConfig(archiveConfig.AWSConfig)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	enc, err := codec.CreateEncoder(beat, archiveConfig.Codec)
+	if err != nil {
+		return outputs.Fail(err)
+	}
+
+	svc := s3.NewFromConfig(awsConfig, archiveConfig.s3ConfigModifier)
+	c := newClient(svc, beat, observer, archiveConfig, enc)
+
+	return outputs.Success(archiveConfig.Queue, archiveConfig.BulkMaxSize, archiveConfig.MaxRetries, nil, c)
+}
+
+// s3ConfigModifier applies the output configuration's settings to the S3
+// client options. Should be provided as a parameter to s3.NewFromConfig.
+func (c s3ArchiveConfig) s3ConfigModifier(o *s3.Options) {
+	if c.AWSConfig.FIPSEnabled {
+		o.EndpointOptions.UseFIPSEndpoint = awssdk.FIPSEndpointStateEnabled
+	}
+	if c.AWSConfig.Endpoint != "" {
+		o.BaseEndpoint = awssdk.String(c.AWSConfig.Endpoint)
+	}
+	o.UsePathStyle = c.PathStyle
+}